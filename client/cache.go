@@ -0,0 +1,160 @@
+package client
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+// CachedWho remembers users recognised by wrapped core.Who, keyed by session
+// cookie. Concurrent lookups of the same session share a single call.
+// Requests with Authorization header are not cached, as the header takes
+// precedence over the cookie
+type CachedWho struct {
+	// NegativeTTL is how long "not logged in" answers are cached. Defaults to TTL
+	NegativeTTL time.Duration
+	// CookieName has to match AUTH_SESSION_KEY of the service
+	CookieName string
+
+	who  core.Who
+	ttl  time.Duration
+	size int
+
+	lock     sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	inFlight map[string]*whoCall
+}
+
+type cacheEntry struct {
+	sessionId string
+	user      *core.User
+	err       error
+	expires   time.Time
+}
+
+type whoCall struct {
+	done chan struct{}
+	user *core.User
+	err  error
+}
+
+// NewCachedWho wraps who, caching up to size users for ttl
+func NewCachedWho(who core.Who, ttl time.Duration, size int) *CachedWho {
+	return &CachedWho{
+		NegativeTTL: ttl,
+		CookieName:  SessionCookieName,
+		who:         who,
+		ttl:         ttl,
+		size:        size,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		inFlight:    make(map[string]*whoCall),
+	}
+}
+
+func (c *CachedWho) Who(req *http.Request) (*core.User, error) {
+	cookie, err := req.Cookie(c.CookieName)
+	if err != nil || cookie.Value == "" || req.Header.Get("Authorization") != "" {
+		return c.who.Who(req)
+	}
+	sessionId := cookie.Value
+
+	c.lock.Lock()
+	if entry, ok := c.get(sessionId); ok {
+		c.lock.Unlock()
+		return copyUser(entry.user), entry.err
+	}
+
+	if call, ok := c.inFlight[sessionId]; ok {
+		c.lock.Unlock()
+		<-call.done
+		return copyUser(call.user), call.err
+	}
+
+	call := &whoCall{done: make(chan struct{})}
+	c.inFlight[sessionId] = call
+	c.lock.Unlock()
+
+	call.user, call.err = c.who.Who(req)
+
+	c.lock.Lock()
+	delete(c.inFlight, sessionId)
+	if call.err == nil {
+		c.add(sessionId, call.user, nil, c.ttl)
+	} else if call.err == core.ErrUserNotLoggedIn {
+		c.add(sessionId, nil, call.err, c.NegativeTTL)
+	}
+	c.lock.Unlock()
+	close(call.done)
+
+	return copyUser(call.user), call.err
+}
+
+// Forget removes cached answer for the session, e.g. after logout
+func (c *CachedWho) Forget(sessionId string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[sessionId]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *CachedWho) get(sessionId string) (*cacheEntry, bool) {
+	element, ok := c.entries[sessionId]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	return entry, true
+}
+
+func (c *CachedWho) add(sessionId string, user *core.User, err error, ttl time.Duration) {
+	if ttl <= 0 || c.size <= 0 {
+		return
+	}
+
+	entry := &cacheEntry{
+		sessionId: sessionId,
+		user:      user,
+		err:       err,
+		expires:   time.Now().Add(ttl),
+	}
+
+	if element, ok := c.entries[sessionId]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[sessionId] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *CachedWho) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.sessionId)
+}
+
+func copyUser(user *core.User) *core.User {
+	if user == nil {
+		return nil
+	}
+	userCopy := *user
+	userCopy.Roles = append([]string(nil), user.Roles...)
+	userCopy.Permissions = append([]string(nil), user.Permissions...)
+	return &userCopy
+}
@@ -0,0 +1,171 @@
+package client_test
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/client"
+	"github.com/hashtock/auth/core"
+)
+
+type countingWho struct {
+	calls int32
+	delay time.Duration
+	Err   error
+}
+
+func (c *countingWho) Who(req *http.Request) (*core.User, error) {
+	atomic.AddInt32(&c.calls, 1)
+	time.Sleep(c.delay)
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	return &core.User{Name: "Bob", Email: "bob@example.com", Roles: []string{"trader"}, Permissions: []string{"billing:read"}}, nil
+}
+
+func (c *countingWho) Calls() int {
+	return int(atomic.LoadInt32(&c.calls))
+}
+
+func sessionRequest(sessionId string) *http.Request {
+	req, _ := http.NewRequest("GET", "/some/url", nil)
+	req.AddCookie(&http.Cookie{Name: client.SessionCookieName, Value: sessionId})
+	return req
+}
+
+func TestCachedWhoMiddleware(t *testing.T) {
+	cached := client.NewCachedWho(&countingWho{}, time.Minute, 10)
+	assert.Implements(t, (*core.Who)(nil), cached)
+	assert.Implements(t, (*negroni.Handler)(nil), client.NewAuthMiddleware(cached))
+}
+
+func TestCachedWhoCachesUser(t *testing.T) {
+	who := &countingWho{}
+	cached := client.NewCachedWho(who, time.Minute, 10)
+
+	user1, err1 := cached.Who(sessionRequest("session-1"))
+	user2, err2 := cached.Who(sessionRequest("session-1"))
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.EqualValues(t, user1, user2)
+	assert.Equal(t, 1, who.Calls())
+
+	// Callers can't change cached user
+	user1.Admin = true
+	user1.Roles[0] = "admin"
+	user1.Permissions[0] = "billing:write"
+	user3, _ := cached.Who(sessionRequest("session-1"))
+	assert.False(t, user3.Admin)
+	assert.Equal(t, []string{"trader"}, user3.Roles)
+	assert.Equal(t, []string{"billing:read"}, user3.Permissions)
+
+	cached.Who(sessionRequest("session-2"))
+	assert.Equal(t, 2, who.Calls())
+
+	cached.Forget("session-1")
+	cached.Who(sessionRequest("session-1"))
+	assert.Equal(t, 3, who.Calls())
+}
+
+func TestCachedWhoExpires(t *testing.T) {
+	who := &countingWho{}
+	cached := client.NewCachedWho(who, 10*time.Millisecond, 10)
+
+	cached.Who(sessionRequest("session-1"))
+	time.Sleep(20 * time.Millisecond)
+	cached.Who(sessionRequest("session-1"))
+
+	assert.Equal(t, 2, who.Calls())
+}
+
+func TestCachedWhoNegativeCaching(t *testing.T) {
+	who := &countingWho{Err: core.ErrUserNotLoggedIn}
+	cached := client.NewCachedWho(who, time.Minute, 10)
+
+	for i := 0; i < 3; i++ {
+		_, err := cached.Who(sessionRequest("session-1"))
+		assert.Equal(t, core.ErrUserNotLoggedIn, err)
+	}
+	assert.Equal(t, 1, who.Calls())
+
+	cached.NegativeTTL = 0
+	cached.Forget("session-1")
+	cached.Who(sessionRequest("session-1"))
+	cached.Who(sessionRequest("session-1"))
+	assert.Equal(t, 3, who.Calls())
+}
+
+func TestCachedWhoDoesNotCacheErrors(t *testing.T) {
+	who := &countingWho{Err: errors.New("Error!")}
+	cached := client.NewCachedWho(who, time.Minute, 10)
+
+	cached.Who(sessionRequest("session-1"))
+	cached.Who(sessionRequest("session-1"))
+	assert.Equal(t, 2, who.Calls())
+}
+
+func TestCachedWhoWithoutCookie(t *testing.T) {
+	who := &countingWho{}
+	cached := client.NewCachedWho(who, time.Minute, 10)
+
+	req, _ := http.NewRequest("GET", "/some/url", nil)
+	cached.Who(req)
+	cached.Who(req)
+	assert.Equal(t, 2, who.Calls())
+}
+
+func TestCachedWhoSizeBound(t *testing.T) {
+	who := &countingWho{}
+	cached := client.NewCachedWho(who, time.Minute, 2)
+
+	cached.Who(sessionRequest("session-1"))
+	cached.Who(sessionRequest("session-2"))
+	cached.Who(sessionRequest("session-1")) // session-2 is now least recently used
+	cached.Who(sessionRequest("session-3"))
+	assert.Equal(t, 3, who.Calls())
+
+	cached.Who(sessionRequest("session-1"))
+	assert.Equal(t, 3, who.Calls())
+
+	cached.Who(sessionRequest("session-2"))
+	assert.Equal(t, 4, who.Calls())
+}
+
+func TestCachedWhoSingleFlight(t *testing.T) {
+	who := &countingWho{delay: 20 * time.Millisecond}
+	cached := client.NewCachedWho(who, time.Minute, 10)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, err := cached.Who(sessionRequest("session-1"))
+			assert.NoError(t, err)
+			assert.NotNil(t, user)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, who.Calls())
+}
+
+func TestCachedWhoSkipsAuthorizedRequests(t *testing.T) {
+	who := &countingWho{}
+	cached := client.NewCachedWho(who, time.Minute, 10)
+
+	cached.Who(sessionRequest("session-1"))
+
+	req := sessionRequest("session-1")
+	req.Header.Set("Authorization", "Bearer pat_secret")
+	cached.Who(req)
+	cached.Who(req)
+	assert.Equal(t, 3, who.Calls())
+}
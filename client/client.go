@@ -42,6 +42,10 @@ func (c Client) Who(req *http.Request) (*core.User, error) {
 	for _, cookie := range req.Cookies() {
 		whoReq.AddCookie(cookie)
 	}
+	// Machine clients authenticate with access tokens instead of cookies
+	if authorization := req.Header.Get("Authorization"); authorization != "" {
+		whoReq.Header.Set("Authorization", authorization)
+	}
 	resp, err := c.HttpClient.Do(whoReq)
 
 	if err != nil {
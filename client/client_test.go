@@ -0,0 +1,54 @@
+package client_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/client"
+	"github.com/hashtock/auth/core"
+)
+
+func TestClientForwardsCookiesAndAuthorization(t *testing.T) {
+	var seen *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		seen = req
+		json.NewEncoder(rw).Encode(core.User{ID: "5f1d7b", Email: "bob@example.com"})
+	}))
+	defer server.Close()
+
+	authClient, err := client.NewClient(server.URL + "/")
+	assert.NoError(t, err)
+
+	req := sessionRequest("session-1")
+	req.Header.Set("Authorization", "Bearer pat_secret")
+
+	user, err := authClient.Who(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "5f1d7b", user.ID)
+	assert.Equal(t, "bob@example.com", user.Email)
+	if assert.NotNil(t, seen) {
+		assert.Equal(t, "/who/", seen.URL.Path)
+		assert.Equal(t, "Bearer pat_secret", seen.Header.Get("Authorization"))
+		cookie, err := seen.Cookie(client.SessionCookieName)
+		assert.NoError(t, err)
+		assert.Equal(t, "session-1", cookie.Value)
+	}
+}
+
+func TestClientWithoutCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Empty(t, req.Header.Get("Authorization"))
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	authClient, _ := client.NewClient(server.URL + "/")
+	req, _ := http.NewRequest("GET", "/some/url", nil)
+
+	_, err := authClient.Who(req)
+	assert.Equal(t, core.ErrUserNotLoggedIn, err)
+}
@@ -0,0 +1,22 @@
+package client
+
+import (
+	"context"
+
+	"github.com/hashtock/auth/core"
+)
+
+type contextKey int
+
+const userKey contextKey = 0
+
+// WithUser returns copy of the context carrying the user
+func WithUser(ctx context.Context, user *core.User) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// UserFromContext returns user stored in the context by auth middleware
+func UserFromContext(ctx context.Context) (*core.User, bool) {
+	user, ok := ctx.Value(userKey).(*core.User)
+	return user, ok && user != nil
+}
@@ -4,11 +4,13 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/gorilla/context"
+	gcontext "github.com/gorilla/context"
 
 	"github.com/hashtock/auth/core"
 )
 
+// UserContextKey is the key user is stored under in gorilla/context.
+// Kept for backward compatibility, prefer UserFromContext
 const UserContextKey = "user"
 
 type whoMiddleware struct {
@@ -21,6 +23,17 @@ func NewAuthMiddleware(who core.Who) *whoMiddleware {
 	}
 }
 
+// Middleware wraps handler so it is called only for logged in users,
+// available with UserFromContext
+func Middleware(who core.Who) func(http.Handler) http.Handler {
+	w := NewAuthMiddleware(who)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			w.ServeHTTP(rw, req, next.ServeHTTP)
+		})
+	}
+}
+
 func (w whoMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
 	user, err := w.client.Who(req)
 	if err == core.ErrUserNotLoggedIn {
@@ -29,9 +42,15 @@ func (w whoMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request, next
 		log.Println(err)
 		rw.WriteHeader(http.StatusInternalServerError)
 	} else {
-		context.Set(req, UserContextKey, user)
-		next(rw, req)
-		// Gorilla Mux/Pat routers will remove all keys automatically
-		context.Delete(req, UserContextKey)
+		withUser := req.WithContext(WithUser(req.Context(), user))
+		// gorilla/context is keyed by request, so values set by routers before
+		// us, like mux.Vars, have to be copied to the new one
+		for key, value := range gcontext.GetAll(req) {
+			gcontext.Set(withUser, key, value)
+		}
+		gcontext.Set(withUser, UserContextKey, user)
+		next(rw, withUser)
+		// Routers clear only the request they got, so new one is cleared here
+		gcontext.Clear(withUser)
 	}
 }
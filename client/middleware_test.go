@@ -1,6 +1,7 @@
 package client_test
 
 import (
+	stdcontext "context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/codegangsta/negroni"
 	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/hashtock/auth/client"
@@ -19,6 +21,7 @@ type WhoMock struct {
 }
 
 var testUser = &core.User{
+	ID:     "5f1d7b",
 	Name:   "Bob",
 	Email:  "bob@example.com",
 	Avatar: "http://example.com/bob.png",
@@ -49,6 +52,10 @@ func TestWhoSuccess(t *testing.T) {
 			assert.EqualValues(t, testUser, user)
 		}
 
+		ctxUser, ok := client.UserFromContext(r.Context())
+		assert.True(t, ok)
+		assert.EqualValues(t, testUser, ctxUser)
+
 		rw.WriteHeader(http.StatusTeapot)
 	})
 	n.ServeHTTP(w, req)
@@ -83,3 +90,63 @@ func TestWhoGenericError(t *testing.T) {
 
 	assert.EqualValues(t, http.StatusInternalServerError, w.Code)
 }
+
+func TestHandlerMiddlewareSuccess(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/some/url", nil)
+
+	handler := client.Middleware(WhoMock{Err: nil})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := client.UserFromContext(r.Context())
+		if assert.True(t, ok) {
+			assert.EqualValues(t, testUser, user)
+		}
+
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+	handler.ServeHTTP(w, req)
+
+	assert.EqualValues(t, http.StatusTeapot, w.Code)
+}
+
+func TestMiddlewareKeepsRouteVars(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/bob", nil)
+
+	router := mux.NewRouter()
+	router.Handle("/users/{name}", client.Middleware(WhoMock{Err: nil})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "bob", mux.Vars(r)["name"])
+		assert.NotNil(t, context.Get(r, client.UserContextKey))
+		rw.WriteHeader(http.StatusTeapot)
+	})))
+	router.ServeHTTP(w, req)
+
+	assert.EqualValues(t, http.StatusTeapot, w.Code)
+	assert.Empty(t, context.GetAll(req))
+}
+
+func TestHandlerMiddlewareNotLoggedIn(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/some/url", nil)
+
+	handler := client.Middleware(WhoMock{Err: core.ErrUserNotLoggedIn})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Fail(t, "Handler should not be called")
+	}))
+	handler.ServeHTTP(w, req)
+
+	assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestUserFromContext(t *testing.T) {
+	_, ok := client.UserFromContext(stdcontext.Background())
+	assert.False(t, ok)
+
+	ctx := client.WithUser(stdcontext.Background(), testUser)
+	user, ok := client.UserFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, testUser, user)
+
+	// Plain string key used by gorilla/context does not collide
+	ctx = stdcontext.WithValue(stdcontext.Background(), client.UserContextKey, testUser)
+	_, ok = client.UserFromContext(ctx)
+	assert.False(t, ok)
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"net/http"
+
+	"github.com/hashtock/auth/core"
+)
+
+// RequireRole wraps handler so it is called only for users with the role.
+// User has to be put in request context first, e.g. by Middleware
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return require(func(user *core.User) bool {
+		return user.HasRole(role)
+	})
+}
+
+// RequirePermission wraps handler so it is called only for users with the permission.
+// User has to be put in request context first, e.g. by Middleware
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return require(func(user *core.User) bool {
+		return user.HasPermission(permission)
+	})
+}
+
+func require(allowed func(user *core.User) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			user, ok := UserFromContext(req.Context())
+			if !ok {
+				rw.WriteHeader(http.StatusUnauthorized)
+			} else if !allowed(user) {
+				rw.WriteHeader(http.StatusForbidden)
+			} else {
+				next.ServeHTTP(rw, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package client_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/client"
+	"github.com/hashtock/auth/core"
+)
+
+var trader = &core.User{
+	Name:        "Alice",
+	Email:       "alice@example.com",
+	Roles:       []string{"trader"},
+	Permissions: []string{"billing:read"},
+}
+
+type userWho struct {
+	user *core.User
+}
+
+func (u userWho) Who(req *http.Request) (*core.User, error) {
+	return u.user, nil
+}
+
+func teapot(rw http.ResponseWriter, req *http.Request) {
+	rw.WriteHeader(http.StatusTeapot)
+}
+
+func serveWith(who core.Who, require func(http.Handler) http.Handler) int {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/some/url", nil)
+
+	handler := client.Middleware(who)(require(http.HandlerFunc(teapot)))
+	handler.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRequireRole(t *testing.T) {
+	assert.Equal(t, http.StatusTeapot, serveWith(userWho{trader}, client.RequireRole("trader")))
+	assert.Equal(t, http.StatusForbidden, serveWith(userWho{trader}, client.RequireRole("moderator")))
+	assert.Equal(t, http.StatusForbidden, serveWith(userWho{testUser}, client.RequireRole("trader")))
+}
+
+func TestRequirePermission(t *testing.T) {
+	assert.Equal(t, http.StatusTeapot, serveWith(userWho{trader}, client.RequirePermission("billing:read")))
+	assert.Equal(t, http.StatusForbidden, serveWith(userWho{trader}, client.RequirePermission("billing:write")))
+}
+
+func TestRequireWithoutUser(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/some/url", nil)
+
+	client.RequireRole("trader")(http.HandlerFunc(teapot)).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRequireRoleWithNegroni(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/some/url", nil)
+
+	n := negroni.New(client.NewAuthMiddleware(userWho{trader}))
+	n.UseHandler(client.RequireRole("moderator")(http.HandlerFunc(teapot)))
+	n.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/jwt"
+)
+
+// SessionCookieName is the name of cookie auth service keeps session in,
+// unless it runs with AUTH_SESSION_KEY set. Then CookieName of TokenVerifier
+// and CachedWho has to be set to the same name
+const SessionCookieName = core.SessionCookieName
+
+// KeyRefreshInterval is the least time between fetches of service keys,
+// so tokens with made up key ids can't make clients flood the service
+var KeyRefreshInterval = time.Minute
+
+// TokenVerifier recognises users by session tokens signed by auth service
+// running with token sessions enabled. Tokens are verified locally,
+// without a call to the service
+type TokenVerifier struct {
+	Issuer string
+	// CookieName has to match AUTH_SESSION_KEY of the service
+	CookieName string
+	HttpClient *http.Client
+
+	secret    []byte
+	jwksURL   string
+	keysLock  sync.Mutex
+	keys      jwt.KeySet
+	refreshed time.Time
+}
+
+// NewTokenVerifier creates verifier for tokens signed with RSA or Ed25519
+// keys. Keys are fetched from service's JWKS endpoint
+func NewTokenVerifier(authServiceLocation string) (*TokenVerifier, error) {
+	serviceURL, err := url.Parse(authServiceLocation)
+	if err != nil {
+		return nil, err
+	}
+	jwksURL, err := serviceURL.Parse("./.well-known/jwks.json")
+	if err != nil {
+		return nil, err
+	}
+
+	v := &TokenVerifier{
+		Issuer:     authServiceLocation,
+		CookieName: SessionCookieName,
+		HttpClient: http.DefaultClient,
+		jwksURL:    jwksURL.String(),
+	}
+
+	if err := v.refreshKeys(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// NewHMACTokenVerifier creates verifier for tokens signed with shared secret
+func NewHMACTokenVerifier(authServiceLocation string, secret []byte) *TokenVerifier {
+	return &TokenVerifier{
+		Issuer:     authServiceLocation,
+		CookieName: SessionCookieName,
+		HttpClient: http.DefaultClient,
+		secret:     secret,
+	}
+}
+
+func (v *TokenVerifier) Who(req *http.Request) (*core.User, error) {
+	cookie, err := req.Cookie(v.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, core.ErrUserNotLoggedIn
+	}
+
+	token, err := jwt.Parse(cookie.Value)
+	if err != nil {
+		return nil, core.ErrUserNotLoggedIn
+	}
+
+	keys := jwt.KeySet{}
+	if v.secret == nil && token.Header.Algorithm != jwt.AlgHS256 {
+		// Keys which can't be fetched are outage of the service, not
+		// something users can fix by logging in again
+		if keys, err = v.keysFor(token.Header.KeyID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := v.verify(token, keys); err != nil {
+		return nil, core.ErrUserNotLoggedIn
+	}
+
+	// ID tokens the service issues to OAuth clients are signed with
+	// published keys too, but can't be used as session
+	if err := token.Claims.ValidateSession(v.Issuer, time.Now()); err != nil {
+		return nil, core.ErrUserNotLoggedIn
+	}
+
+	user := new(core.User)
+	err = token.Claims.Decode(user)
+	return user, err
+}
+
+func (v *TokenVerifier) verify(token *jwt.Token, keys jwt.KeySet) error {
+	if v.secret != nil {
+		if token.Header.Algorithm != jwt.AlgHS256 {
+			return jwt.ErrInvalidSignature
+		}
+		return token.Verify(v.secret)
+	}
+
+	// Shared secret must not be accepted when expecting public keys
+	if token.Header.Algorithm == jwt.AlgHS256 {
+		return jwt.ErrInvalidSignature
+	}
+
+	return token.VerifyWithKeySet(keys)
+}
+
+// keysFor returns known keys, refreshing them if key with given id is not
+// among them, as service might have rotated its keys. They are refreshed
+// at most once every KeyRefreshInterval
+func (v *TokenVerifier) keysFor(keyID string) (jwt.KeySet, error) {
+	v.keysLock.Lock()
+	keys := v.keys
+	_, known := keys.Key(keyID)
+	refresh := !known && time.Since(v.refreshed) >= KeyRefreshInterval
+	if refresh {
+		// Failed fetch waits for the interval too
+		v.refreshed = time.Now()
+	}
+	v.keysLock.Unlock()
+
+	if !refresh {
+		return keys, nil
+	}
+
+	if err := v.refreshKeys(); err != nil {
+		return jwt.KeySet{}, err
+	}
+
+	v.keysLock.Lock()
+	defer v.keysLock.Unlock()
+	return v.keys, nil
+}
+
+func (v *TokenVerifier) refreshKeys() error {
+	resp, err := v.HttpClient.Get(v.jwksURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Problem with request: %s", resp.Status)
+	}
+
+	keys := jwt.KeySet{}
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return err
+	}
+
+	v.keysLock.Lock()
+	v.keys = keys
+	v.refreshed = time.Now()
+	v.keysLock.Unlock()
+	return nil
+}
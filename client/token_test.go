@@ -0,0 +1,170 @@
+package client_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/client"
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/jwt"
+)
+
+func userToken(t *testing.T, signer *jwt.Signer, issuer string, expires time.Time) string {
+	claims, _ := jwt.NewClaims(testUser)
+	claims["iss"] = issuer
+	claims["exp"] = expires.Unix()
+	claims["token_use"] = jwt.SessionTokenUse
+
+	token, err := signer.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func requestWithToken(token string) *http.Request {
+	req, _ := http.NewRequest("GET", "/some/url", nil)
+	req.AddCookie(&http.Cookie{Name: client.SessionCookieName, Value: token})
+	return req
+}
+
+func jwksServer(signers ...*jwt.Signer) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		keys := jwt.KeySet{}
+		for _, signer := range signers {
+			keys.Keys = append(keys.Keys, signer.KeySet().Keys...)
+		}
+		json.NewEncoder(rw).Encode(keys)
+	}))
+}
+
+func TestTokenVerifierHMAC(t *testing.T) {
+	signer := jwt.NewHMACSigner([]byte("secret"))
+	verifier := client.NewHMACTokenVerifier("http://auth.example.com/", []byte("secret"))
+	assert.Implements(t, (*core.Who)(nil), verifier)
+
+	token := userToken(t, signer, "http://auth.example.com/", time.Now().Add(time.Hour))
+	user, err := verifier.Who(requestWithToken(token))
+	assert.NoError(t, err)
+	assert.EqualValues(t, testUser, user)
+}
+
+func TestTokenVerifierRejectsInvalidTokens(t *testing.T) {
+	signer := jwt.NewHMACSigner([]byte("secret"))
+	verifier := client.NewHMACTokenVerifier("http://auth.example.com/", []byte("secret"))
+
+	tokens := map[string]string{
+		"malformed":    "not-a-token",
+		"expired":      userToken(t, signer, "http://auth.example.com/", time.Now().Add(-time.Hour)),
+		"wrong issuer": userToken(t, signer, "http://evil.example.com/", time.Now().Add(time.Hour)),
+		"wrong secret": userToken(t, jwt.NewHMACSigner([]byte("other")), "http://auth.example.com/", time.Now().Add(time.Hour)),
+	}
+
+	for name, token := range tokens {
+		_, err := verifier.Who(requestWithToken(token))
+		assert.Equal(t, core.ErrUserNotLoggedIn, err, name)
+	}
+
+	req, _ := http.NewRequest("GET", "/some/url", nil)
+	_, err := verifier.Who(req)
+	assert.Equal(t, core.ErrUserNotLoggedIn, err)
+}
+
+func TestTokenVerifierPublicKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaSigner, _ := jwt.NewSigner(rsaKey)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edSigner, _ := jwt.NewSigner(edKey)
+
+	server := jwksServer(rsaSigner, edSigner)
+	defer server.Close()
+
+	verifier, err := client.NewTokenVerifier(server.URL + "/")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, signer := range []*jwt.Signer{rsaSigner, edSigner} {
+		token := userToken(t, signer, server.URL+"/", time.Now().Add(time.Hour))
+		user, err := verifier.Who(requestWithToken(token))
+		assert.NoError(t, err, signer.Algorithm)
+		assert.EqualValues(t, testUser, user)
+	}
+
+	// ID tokens issued to OAuth clients are not sessions
+	claims, _ := jwt.NewClaims(testUser)
+	claims["iss"] = server.URL + "/"
+	claims["aud"] = "partner-app"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	idToken, _ := edSigner.Sign(claims)
+	_, err = verifier.Who(requestWithToken(idToken))
+	assert.Equal(t, core.ErrUserNotLoggedIn, err)
+
+	// Shared secret is not accepted when service publishes keys
+	hmacToken := userToken(t, jwt.NewHMACSigner([]byte("")), server.URL+"/", time.Now().Add(time.Hour))
+	_, err = verifier.Who(requestWithToken(hmacToken))
+	assert.Equal(t, core.ErrUserNotLoggedIn, err)
+
+	// Key unknown even after refresh
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := jwt.NewSigner(otherKey)
+	otherToken := userToken(t, otherSigner, server.URL+"/", time.Now().Add(time.Hour))
+	_, err = verifier.Who(requestWithToken(otherToken))
+	assert.Equal(t, core.ErrUserNotLoggedIn, err)
+}
+
+func TestTokenVerifierKeyRefresh(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := jwt.NewSigner(key)
+	_, rotatedKey, _ := ed25519.GenerateKey(rand.Reader)
+	rotated, _ := jwt.NewSigner(rotatedKey)
+
+	fetches := 0
+	published := []*jwt.Signer{signer}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fetches++
+		keys := jwt.KeySet{}
+		for _, signer := range published {
+			keys.Keys = append(keys.Keys, signer.KeySet().Keys...)
+		}
+		json.NewEncoder(rw).Encode(keys)
+	}))
+	defer server.Close()
+
+	verifier, err := client.NewTokenVerifier(server.URL + "/")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Keys were just fetched, so unknown key ids don't fetch them again
+	published = append(published, rotated)
+	token := userToken(t, rotated, server.URL+"/", time.Now().Add(time.Hour))
+	for i := 0; i < 3; i++ {
+		_, err = verifier.Who(requestWithToken(token))
+		assert.Equal(t, core.ErrUserNotLoggedIn, err)
+	}
+	assert.Equal(t, 1, fetches)
+
+	defer func(interval time.Duration) { client.KeyRefreshInterval = interval }(client.KeyRefreshInterval)
+	client.KeyRefreshInterval = 0
+	user, err := verifier.Who(requestWithToken(token))
+	assert.NoError(t, err)
+	assert.EqualValues(t, testUser, user)
+	assert.Equal(t, 2, fetches)
+
+	// Service which can't be reached is not reported as logged out user
+	server.Close()
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := jwt.NewSigner(otherKey)
+	_, err = verifier.Who(requestWithToken(userToken(t, otherSigner, server.URL+"/", time.Now().Add(time.Hour))))
+	assert.Error(t, err)
+	assert.NotEqual(t, core.ErrUserNotLoggedIn, err)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+const (
+	CommandMakeAdmin            = "make_admin"
+	CommandRevokeAdmin          = "revoke_admin"
+	CommandGrantRole            = "grant_role"
+	CommandRevokeRole           = "revoke_role"
+	CommandGrantPermission      = "grant_permission"
+	CommandRevokePermission     = "revoke_permission"
+	CommandListUsers            = "list_users"
+	CommandShowUser             = "show_user"
+	CommandListSessions         = "list_sessions"
+	CommandRevokeSessions       = "revoke_sessions"
+	CommandDisableUser          = "disable_user"
+	CommandDeleteUser           = "delete_user"
+	CommandPurgeExpiredSessions = "purge_expired_sessions"
+	CommandRehashSessions       = "rehash_sessions"
+)
+
+// Exit codes of admin commands
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitUsage = 2
+)
+
+// adminCommand takes positional args named in args. setup defines command's
+// flags and returns function running the command once flags are parsed
+type adminCommand struct {
+	args  []string
+	help  string
+	setup func(fs *flag.FlagSet) func(args []string, out io.Writer) error
+}
+
+// simpleCommand wraps commands without own flags
+func simpleCommand(args []string, help string, run func(args []string, out io.Writer) error) adminCommand {
+	return adminCommand{
+		args: args,
+		help: help,
+		setup: func(fs *flag.FlagSet) func(args []string, out io.Writer) error {
+			return run
+		},
+	}
+}
+
+// sessionRehasher is storage keeping hashes of session ids, which can
+// migrate sessions stored with raw ids
+type sessionRehasher interface {
+	RehashSessions() (int, error)
+}
+
+func adminCommands(adminStorage core.Administrator) map[string]adminCommand {
+	commands := storageCommands(adminStorage)
+
+	if rehasher, ok := adminStorage.(sessionRehasher); ok {
+		commands[CommandRehashSessions] = simpleCommand(nil, "hash ids of sessions stored before ids were hashed, and move sessions from user documents, keeping users logged in", func(args []string, out io.Writer) error {
+			migrated, err := rehasher.RehashSessions()
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(out, "Rehashed %d session(s)\n", migrated)
+			return err
+		})
+	}
+
+	return commands
+}
+
+// storageCommands are supported by all storages
+func storageCommands(adminStorage core.Administrator) map[string]adminCommand {
+	email := []string{"email"}
+
+	return map[string]adminCommand{
+		CommandMakeAdmin: simpleCommand(email, "mark user with given email as an admin", func(args []string, out io.Writer) error {
+			return adminStorage.MakeUserAnAdmin(args[0])
+		}),
+		CommandRevokeAdmin: simpleCommand(email, "remove admin flag from user with given email", func(args []string, out io.Writer) error {
+			return adminStorage.RevokeAdmin(args[0])
+		}),
+		CommandGrantRole: simpleCommand([]string{"email", "role"}, "grant role to user with given email", func(args []string, out io.Writer) error {
+			return adminStorage.GrantRole(args[0], args[1])
+		}),
+		CommandRevokeRole: simpleCommand([]string{"email", "role"}, "revoke role from user with given email", func(args []string, out io.Writer) error {
+			return adminStorage.RevokeRole(args[0], args[1])
+		}),
+		CommandGrantPermission: simpleCommand([]string{"email", "permission"}, "grant permission to user with given email", func(args []string, out io.Writer) error {
+			return adminStorage.GrantPermission(args[0], args[1])
+		}),
+		CommandRevokePermission: simpleCommand([]string{"email", "permission"}, "revoke permission from user with given email", func(args []string, out io.Writer) error {
+			return adminStorage.RevokePermission(args[0], args[1])
+		}),
+		CommandListUsers: {
+			help: "list users, optionally only matching query",
+			setup: func(fs *flag.FlagSet) func(args []string, out io.Writer) error {
+				query := fs.String("q", "", "show only users with email or name containing `query`")
+				offset := countFlag(fs, "offset", 0, "`number` of users to skip")
+				limit := countFlag(fs, "limit", 50, "maximum `number` of users to show")
+				asJSON := fs.Bool("json", false, "print JSON instead of table")
+
+				return func(args []string, out io.Writer) error {
+					list, err := adminStorage.ListUsers(*query, int(*offset), int(*limit))
+					if err != nil {
+						return err
+					}
+					if *asJSON {
+						return writeJSON(out, list)
+					}
+
+					w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(w, "EMAIL\tNAME\tADMIN\tDISABLED\tROLES")
+					for _, user := range list.Users {
+						fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%s\n", user.Email, user.Name, user.Admin, user.Disabled, strings.Join(user.Roles, ","))
+					}
+					if err := w.Flush(); err != nil {
+						return err
+					}
+					_, err = fmt.Fprintf(out, "\nShowing %d of %d user(s) from offset %d\n", len(list.Users), list.Total, list.Offset)
+					return err
+				}
+			},
+		},
+		CommandShowUser: {
+			args: email,
+			help: "show details of user with given email",
+			setup: func(fs *flag.FlagSet) func(args []string, out io.Writer) error {
+				asJSON := fs.Bool("json", false, "print JSON instead of text")
+
+				return func(args []string, out io.Writer) error {
+					user, err := adminStorage.GetUser(args[0])
+					if err != nil {
+						return err
+					}
+					if *asJSON {
+						return writeJSON(out, user)
+					}
+
+					w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+					fmt.Fprintf(w, "Email:\t%s\n", user.Email)
+					fmt.Fprintf(w, "Name:\t%s\n", user.Name)
+					fmt.Fprintf(w, "Avatar:\t%s\n", user.Avatar)
+					fmt.Fprintf(w, "Admin:\t%v\n", user.Admin)
+					fmt.Fprintf(w, "Disabled:\t%v\n", user.Disabled)
+					fmt.Fprintf(w, "Roles:\t%s\n", strings.Join(user.Roles, ", "))
+					fmt.Fprintf(w, "Permissions:\t%s\n", strings.Join(user.Permissions, ", "))
+					return w.Flush()
+				}
+			},
+		},
+		CommandListSessions: {
+			args: email,
+			help: "list active sessions of user with given email",
+			setup: func(fs *flag.FlagSet) func(args []string, out io.Writer) error {
+				asJSON := fs.Bool("json", false, "print JSON instead of table")
+
+				return func(args []string, out io.Writer) error {
+					sessions, err := adminStorage.ListSessions(args[0])
+					if err != nil {
+						return err
+					}
+					if *asJSON {
+						return writeJSON(out, sessions)
+					}
+
+					w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(w, "ID\tCREATED\tLAST USED\tEXPIRES\tPROVIDER\tIP\tUSER AGENT")
+					for _, session := range sessions {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+							session.PublicID,
+							session.Created.Format(time.RFC3339),
+							session.LastUsed.Format(time.RFC3339),
+							session.Expires.Format(time.RFC3339),
+							session.Provider,
+							session.IP,
+							session.UserAgent,
+						)
+					}
+					return w.Flush()
+				}
+			},
+		},
+		CommandRevokeSessions: simpleCommand(email, "log user with given email out of all sessions", func(args []string, out io.Writer) error {
+			return adminStorage.RevokeSessions(args[0])
+		}),
+		CommandDisableUser: simpleCommand(email, "prevent user with given email from logging in and end user's sessions", func(args []string, out io.Writer) error {
+			return adminStorage.DisableUser(args[0])
+		}),
+		CommandDeleteUser: simpleCommand(email, "remove user with given email and user's sessions", func(args []string, out io.Writer) error {
+			return adminStorage.DeleteUser(args[0])
+		}),
+		CommandPurgeExpiredSessions: simpleCommand(nil, "remove expired sessions of all users", func(args []string, out io.Writer) error {
+			removed, err := adminStorage.PurgeExpiredSessions()
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(out, "Removed %d expired session(s)\n", removed)
+			return err
+		}),
+	}
+}
+
+// AdminCommands runs admin command given in args, if any. When command was
+// recognised (or was not valid) handled is true and process should exit with
+// returned code instead of serving the web app
+func AdminCommands(adminStorage core.Administrator, args []string, stdout io.Writer, stderr io.Writer) (handled bool, exitCode int) {
+	if len(args) == 0 {
+		return false, ExitOK
+	}
+
+	commands := adminCommands(adminStorage)
+
+	cmd := args[0]
+	switch cmd {
+	case "help", "-h", "-help", "--help":
+		PrintUsage(stdout, commands)
+		return true, ExitOK
+	}
+
+	command, found := commands[cmd]
+	if !found {
+		fmt.Fprintf(stderr, "Command %#v not recognised\n\n", cmd)
+		PrintUsage(stderr, commands)
+		return true, ExitUsage
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s %s\n\n%s\n", appName(), command.synopsis(cmd), command.help)
+		fs.PrintDefaults()
+	}
+	run := command.setup(fs)
+
+	if err := fs.Parse(args[1:]); err == flag.ErrHelp {
+		return true, ExitOK
+	} else if err != nil {
+		return true, ExitUsage
+	}
+
+	if fs.NArg() != len(command.args) {
+		fmt.Fprintf(stderr, "Command %#v accept exactly %v argument(s). %v given\n", cmd, len(command.args), fs.NArg())
+		fs.Usage()
+		return true, ExitUsage
+	}
+
+	if err := run(fs.Args(), stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return true, ExitError
+	}
+
+	return true, ExitOK
+}
+
+// count is int flag refusing negative values, so they end up as usage errors
+type count int
+
+func countFlag(fs *flag.FlagSet, name string, value int, usage string) *count {
+	c := count(value)
+	fs.Var(&c, name, usage)
+	return &c
+}
+
+func (c *count) String() string {
+	return strconv.Itoa(int(*c))
+}
+
+func (c *count) Set(value string) error {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if number < 0 {
+		return errors.New("can't be negative")
+	}
+	*c = count(number)
+	return nil
+}
+
+func (c adminCommand) synopsis(name string) string {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	c.setup(fs)
+
+	parts := []string{name}
+	fs.VisitAll(func(f *flag.Flag) {
+		parts = append(parts, fmt.Sprintf("[-%s]", f.Name))
+	})
+	return strings.Join(append(parts, c.args...), " ")
+}
+
+func writeJSON(out io.Writer, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
+
+func appName() string {
+	_, name := filepath.Split(os.Args[0])
+	return name
+}
+
+func PrintUsage(out io.Writer, commands map[string]adminCommand) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(out, "Usage of %s:\n\n", appName())
+	fmt.Fprintf(out, "        %s [command [flags] [arguments]]\n\n", appName())
+	fmt.Fprintf(out, "The commands are:\n\n")
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "    [no command]\trun web app\n")
+	for _, name := range names {
+		command := commands[name]
+		fmt.Fprintf(w, "    %s\t%s\n", command.synopsis(name), command.help)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nUse \"%s command -h\" for command's flags.\n", appName())
+	fmt.Fprintf(out, "Exit status is %d on success, %d when command failed and %d on wrong usage.\n", ExitOK, ExitError, ExitUsage)
+}
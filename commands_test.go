@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+func newCommandStorage() *storage.MemStorage {
+	memStorage := storage.NewMemStorage()
+
+	now := time.Now()
+	for _, email := range []string{"bob@example.com", "alice@example.com"} {
+		session := core.Session{ID: email, Created: now, LastUsed: now, Expires: now.Add(time.Hour)}
+		memStorage.AddUserToSession(session, &core.User{Name: email, Email: email})
+	}
+	expired := core.Session{ID: "expired", Created: now, LastUsed: now, Expires: now.Add(-time.Hour)}
+	memStorage.AddUserToSession(expired, &core.User{Email: "bob@example.com"})
+
+	return memStorage
+}
+
+func runCommand(adminStorage core.Administrator, args ...string) (bool, int, string, string) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	handled, exitCode := AdminCommands(adminStorage, args, stdout, stderr)
+	return handled, exitCode, stdout.String(), stderr.String()
+}
+
+func TestNoCommandRunsWebApp(t *testing.T) {
+	handled, _, _, _ := runCommand(newCommandStorage())
+	assert.False(t, handled)
+}
+
+func TestUnknownCommand(t *testing.T) {
+	handled, exitCode, _, stderr := runCommand(newCommandStorage(), "drop_everything")
+	assert.True(t, handled)
+	assert.Equal(t, ExitUsage, exitCode)
+	assert.Contains(t, stderr, "not recognised")
+	assert.Contains(t, stderr, CommandPurgeExpiredSessions)
+}
+
+func TestWrongNumberOfArguments(t *testing.T) {
+	_, exitCode, _, stderr := runCommand(newCommandStorage(), CommandGrantRole, "bob@example.com")
+	assert.Equal(t, ExitUsage, exitCode)
+	assert.Contains(t, stderr, "grant_role email role")
+}
+
+func TestUnknownFlag(t *testing.T) {
+	_, exitCode, _, _ := runCommand(newCommandStorage(), CommandListUsers, "-format", "xml")
+	assert.Equal(t, ExitUsage, exitCode)
+}
+
+func TestNegativeListBounds(t *testing.T) {
+	_, exitCode, _, stderr := runCommand(newCommandStorage(), CommandListUsers, "-offset", "-1")
+	assert.Equal(t, ExitUsage, exitCode)
+	assert.Contains(t, stderr, "can't be negative")
+
+	_, exitCode, _, _ = runCommand(newCommandStorage(), CommandListUsers, "-limit", "-5")
+	assert.Equal(t, ExitUsage, exitCode)
+}
+
+func TestCommandFailure(t *testing.T) {
+	_, exitCode, _, stderr := runCommand(newCommandStorage(), CommandMakeAdmin, "nobody@example.com")
+	assert.Equal(t, ExitError, exitCode)
+	assert.Contains(t, stderr, core.ErrUserNotFound.Error())
+}
+
+func TestMakeAndRevokeAdmin(t *testing.T) {
+	memStorage := newCommandStorage()
+
+	_, exitCode, _, _ := runCommand(memStorage, CommandMakeAdmin, "bob@example.com")
+	assert.Equal(t, ExitOK, exitCode)
+	user, _ := memStorage.GetUser("bob@example.com")
+	assert.True(t, user.Admin)
+
+	_, exitCode, _, _ = runCommand(memStorage, CommandRevokeAdmin, "bob@example.com")
+	assert.Equal(t, ExitOK, exitCode)
+	user, _ = memStorage.GetUser("bob@example.com")
+	assert.False(t, user.Admin)
+}
+
+func TestListUsers(t *testing.T) {
+	memStorage := newCommandStorage()
+
+	_, exitCode, stdout, _ := runCommand(memStorage, CommandListUsers)
+	assert.Equal(t, ExitOK, exitCode)
+	assert.Contains(t, stdout, "EMAIL")
+	assert.Contains(t, stdout, "alice@example.com")
+	assert.Contains(t, stdout, "Showing 2 of 2")
+
+	list := core.UserList{}
+	_, exitCode, stdout, _ = runCommand(memStorage, CommandListUsers, "-json", "-q", "bob", "-limit", "1")
+	assert.Equal(t, ExitOK, exitCode)
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &list))
+	assert.Equal(t, 1, list.Total)
+	if assert.Len(t, list.Users, 1) {
+		assert.Equal(t, "bob@example.com", list.Users[0].Email)
+	}
+}
+
+func TestShowUser(t *testing.T) {
+	memStorage := newCommandStorage()
+	memStorage.GrantRole("bob@example.com", "trader")
+
+	_, exitCode, stdout, _ := runCommand(memStorage, CommandShowUser, "bob@example.com")
+	assert.Equal(t, ExitOK, exitCode)
+	assert.Contains(t, stdout, "trader")
+
+	user := core.User{}
+	_, _, stdout, _ = runCommand(memStorage, CommandShowUser, "-json", "bob@example.com")
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &user))
+	assert.Equal(t, []string{"trader"}, user.Roles)
+}
+
+func TestSessionCommands(t *testing.T) {
+	memStorage := newCommandStorage()
+
+	sessions := []core.Session{}
+	_, exitCode, stdout, _ := runCommand(memStorage, CommandListSessions, "-json", "bob@example.com")
+	assert.Equal(t, ExitOK, exitCode)
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &sessions))
+	assert.Len(t, sessions, 1)
+
+	_, exitCode, stdout, _ = runCommand(memStorage, CommandPurgeExpiredSessions)
+	assert.Equal(t, ExitOK, exitCode)
+	assert.Contains(t, stdout, "Removed 1 expired session(s)")
+
+	_, exitCode, _, _ = runCommand(memStorage, CommandRevokeSessions, "bob@example.com")
+	assert.Equal(t, ExitOK, exitCode)
+	_, err := memStorage.GetUserBySession("bob@example.com")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+}
+
+func TestDisableAndDeleteUser(t *testing.T) {
+	memStorage := newCommandStorage()
+
+	_, exitCode, _, _ := runCommand(memStorage, CommandDisableUser, "bob@example.com")
+	assert.Equal(t, ExitOK, exitCode)
+	user, _ := memStorage.GetUser("bob@example.com")
+	assert.True(t, user.Disabled)
+
+	_, exitCode, _, _ = runCommand(memStorage, CommandDeleteUser, "bob@example.com")
+	assert.Equal(t, ExitOK, exitCode)
+	_, err := memStorage.GetUser("bob@example.com")
+	assert.Equal(t, core.ErrUserNotFound, err)
+}
+
+func TestRehashSessionsNeedsHashingStorage(t *testing.T) {
+	_, exitCode, _, stderr := runCommand(newCommandStorage(), CommandRehashSessions)
+	assert.Equal(t, ExitUsage, exitCode)
+	assert.Contains(t, stderr, "not recognised")
+}
@@ -1,20 +1,79 @@
 package conf
 
 import (
+	"net/http"
 	"net/url"
+	"time"
 )
 
+// Supported storage backends
+const (
+	StorageMongo  = "mongo"
+	StorageMemory = "memory"
+)
+
+// Providers which need no credentials
+const (
+	// LocalProvider lets users register and log in with email and password
+	LocalProvider = "local"
+	// EmailProvider logs users in with single use links sent by email
+	EmailProvider = "email"
+	// WebAuthnProvider logs users in with passkeys they registered
+	WebAuthnProvider = "webauthn"
+)
+
+// Provider holds credentials of single auth provider.
+// Providers with Issuer set are generic OpenID Connect ones.
+// TrustEmail treats emails reported by provider as verified.
+// AllowedDomains, when set, limit who can log in with the provider
+type Provider struct {
+	Name           string
+	ClientID       string
+	Secret         string
+	Scopes         []string
+	Issuer         string
+	TrustEmail     bool
+	AllowedDomains []string
+}
+
 // Config struct holds information vital for correctly wroking service
 // Providing all of them is mandatory
 type Config struct {
-	AppAddress         *url.URL
-	ServeAddress       string
-	DB                 string
-	DBName             string
-	SessionName        string
-	SessionSecret      string
-	GoogleClientID     string
-	GoogleClientSecret string
+	AppAddress      *url.URL
+	ServeAddress    string
+	Storage         string
+	DB              string
+	DBName          string
+	SessionName     string
+	SessionSecret   string
+	SessionHashKey  string
+	SessionTimeout  time.Duration
+	SessionSliding  bool
+	TokenAlgorithm  string
+	TokenKey        string
+	IDTokenKey      string
+	Providers       []Provider
+	RedirectOrigins []string
+	CookieSecure    bool
+	CookieSameSite  http.SameSite
+	CookieDomain    string
+	CookiePath      string
+	LogoutWithGet   bool
+	TrustProxy      bool
+	AdminTwoFactor  bool
+	WebAuthnRPID    string
+	// Who can log in, everyone when all are empty
+	AllowedDomains []string
+	AllowedEmails  []string
+	DeniedEmails   []string
+	InviteOnly     bool
+	// Login links are sent through SMTPAddr, or saved to MailDir when
+	// there is no SMTP server
+	SMTPAddr     string
+	SMTPUser     string
+	SMTPPassword string
+	MailFrom     string
+	MailDir      string
 }
 
 var cfg *Config
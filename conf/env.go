@@ -2,38 +2,143 @@ package conf
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	confTool "github.com/hashtock/service-tools/conf"
+
+	"github.com/hashtock/auth/core"
 )
 
 var (
-	keyAppAddress         = "APP_ADDRESS"
-	keyServeAddress       = "SERVE_ADDRESS"
-	keyDB                 = "DB"
-	keyDBName             = "DBName"
-	keySessionName        = "SESSION_KEY"
-	keySessionSecret      = "SESSION_SECRET"
-	keyGoogleClientID     = "GOOGLE_CLIENT_ID"
-	keyGoogleClientSecret = "GOOGLE_CLIENT_SECRET"
+	keyAppAddress    = "APP_ADDRESS"
+	keyServeAddress  = "SERVE_ADDRESS"
+	keyStorage       = "STORAGE"
+	keyDB            = "DB"
+	keyDBName        = "DBName"
+	keySessionName   = "SESSION_KEY"
+	keySessionSecret = "SESSION_SECRET"
+	keySessionHash   = "SESSION_HASH_KEY"
+	keySessionTTL    = "SESSION_TIMEOUT"
+	keySessionSlide  = "SESSION_SLIDING"
+	keyTokenAlg      = "TOKEN_ALGORITHM"
+	keyTokenKey      = "TOKEN_KEY"
+	keyIDTokenKey    = "ID_TOKEN_KEY"
+	keyProviders     = "PROVIDERS"
+	keyRedirects     = "REDIRECT_ORIGINS"
+	keyCookieSecure  = "COOKIE_SECURE"
+	keyCookieSite    = "COOKIE_SAMESITE"
+	keyCookieDomain  = "COOKIE_DOMAIN"
+	keyCookiePath    = "COOKIE_PATH"
+	keyLogoutGet     = "LOGOUT_GET"
+	keyTrustProxy    = "TRUST_PROXY"
+	keyAdmin2FA      = "ADMIN_2FA"
+	keyWebAuthnRPID  = "WEBAUTHN_RP_ID"
+	keyAllowDomains  = "ALLOWED_DOMAINS"
+	keyAllowEmails   = "ALLOWED_EMAILS"
+	keyDenyEmails    = "DENIED_EMAILS"
+	keyInviteOnly    = "INVITE_ONLY"
+	keySMTPAddr      = "SMTP_ADDR"
+	keySMTPUser      = "SMTP_USER"
+	keySMTPPassword  = "SMTP_PASSWORD"
+	keyMailFrom      = "MAIL_FROM"
+	keyMailDir       = "MAIL_DIR"
+
+	// Suffixes of keys holding provider specific settings,
+	// e.g. GITHUB_CLIENT_ID for "github" provider
+	keySuffixClientID     = "_CLIENT_ID"
+	keySuffixClientSecret = "_CLIENT_SECRET"
+	keySuffixScopes       = "_SCOPES"
+	keySuffixIssuer       = "_ISSUER"
+	keySuffixTrustEmail   = "_TRUST_EMAIL"
+	keySuffixAllowDomains = "_ALLOWED_DOMAINS"
 )
 
+// cookieSecureAuto makes cookie secure when app is served over HTTPS
+const cookieSecureAuto = "auto"
+
+var sameSiteModes = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// providerKeyPrefix maps provider names to prefixes of their keys
+// where those differ from upper cased provider name
+var providerKeyPrefix = map[string]string{
+	"gplus": "GOOGLE",
+}
+
 func init() {
 	confTool.EnvPrefix = "AUTH_"
 	confTool.EnvVariableHelp = map[string]string{
-		keyAppAddress:         "External address to application",
-		keyServeAddress:       "Host and port for the service",
-		keyDB:                 "Location of DB",
-		keyDBName:             "Name of DB to use",
-		keySessionName:        "Session key name",
-		keySessionSecret:      "Session secret used for encrypting",
-		keyGoogleClientID:     "Google app client id",
-		keyGoogleClientSecret: "Google app shared secret",
+		keyAppAddress:    "External address to application",
+		keyServeAddress:  "Host and port for the service",
+		keyStorage:       "Storage backend to use: mongo or memory",
+		keyDB:            "Location of DB",
+		keyDBName:        "Name of DB to use",
+		keySessionName:   "Name of session cookie",
+		keySessionSecret: "Session secret used for encrypting",
+		keySessionHash:   "Secret session ids are hashed with before they are stored. Empty to use SESSION_SECRET",
+		keySessionTTL:    "How long session stays valid, e.g. 168h",
+		keySessionSlide:  "Extend session every time user is recognised: true or false",
+		keyTokenAlg:      "Issue signed session tokens using HS256, RS256 or EdDSA. Empty for plain session ids. Tokens stay valid after logout until SESSION_TIMEOUT passes",
+		keyTokenKey:      "Shared secret for HS256 or path to PEM encoded private key for RS256 and EdDSA",
+		keyIDTokenKey:    "Path to PEM encoded RSA or Ed25519 private key signing OpenID Connect ID tokens. Has to differ from TOKEN_KEY",
+		keyProviders:     "Comma separated list of enabled auth providers. local enables email and password accounts, email login links",
+		keyRedirects:     "Comma separated origins, besides app address, users may return to after login, e.g. https://app.example.com",
+		keyCookieSecure:  "Send session cookie only over HTTPS: true, false or auto to follow app address scheme",
+		keyCookieSite:    "SameSite attribute of session cookie: lax, strict or none",
+		keyCookieDomain:  "Domain of session cookie, to share it with subdomains. Empty for app host only",
+		keyCookiePath:    "Path of session cookie",
+		keyLogoutGet:     "Allow logging out with GET, unsafe as any site can log users out: true or false",
+		keyTrustProxy:    "Take client IP from X-Forwarded-For, when service is only reachable through proxy: true or false",
+		keyAdmin2FA:      "Make admins set up two-factor authentication: true or false",
+		keyWebAuthnRPID:  "Domain passkeys are registered for, app host when empty. Has to be app host or its parent domain",
+		keyAllowDomains:  "Comma separated email domains users have to be in to log in, e.g. example.com. Empty for any",
+		keyAllowEmails:   "Comma separated emails which can log in regardless of ALLOWED_DOMAINS and INVITE_ONLY",
+		keyDenyEmails:    "Comma separated emails, or domains prefixed with @, which can't log in",
+		keyInviteOnly:    "Let only existing users and ALLOWED_EMAILS log in, so no new users are created: true or false",
+		keySMTPAddr:      "Host and port of SMTP server sending login links",
+		keySMTPUser:      "User name for SMTP server. Empty when it needs no authentication",
+		keySMTPPassword:  "Password for SMTP server",
+		keyMailFrom:      "Sender of login links, e.g. Example <auth@example.com>",
+		keyMailDir:       "Directory login links are saved to, when there is no SMTP server. For development",
 	}
 	confTool.Defaults = map[string]interface{}{
-		keyDB:     "localhost",
-		keyDBName: "auth",
+		keyStorage:      StorageMongo,
+		keySessionTTL:   7 * 24 * time.Hour,
+		keySessionHash:  "",
+		keySessionSlide: "false",
+		keyTokenAlg:     "",
+		keyTokenKey:     "",
+		keyIDTokenKey:   "",
+		keyDB:           "localhost",
+		keyDBName:       "auth",
+		keyProviders:    "gplus",
+		keyRedirects:    "",
+		keySessionName:  core.SessionCookieName,
+		keyCookieSecure: cookieSecureAuto,
+		keyCookieSite:   "lax",
+		keyCookieDomain: "",
+		keyCookiePath:   "/",
+		keyLogoutGet:    "false",
+		keyTrustProxy:   "false",
+		keyAdmin2FA:     "false",
+		keyWebAuthnRPID: "",
+		keyAllowDomains: "",
+		keyAllowEmails:  "",
+		keyDenyEmails:   "",
+		keyInviteOnly:   "false",
+		keySMTPAddr:     "",
+		keySMTPUser:     "",
+		keySMTPPassword: "",
+		keyMailFrom:     "",
+		keyMailDir:      "",
 	}
 }
 
@@ -43,19 +148,129 @@ func loadConfig() {
 	}
 
 	cfg.SessionSecret = confTool.StringValue(keySessionSecret)
-	cfg.GoogleClientID = confTool.StringValue(keyGoogleClientID)
-	cfg.GoogleClientSecret = confTool.StringValue(keyGoogleClientSecret)
+	cfg.SessionHashKey = confTool.StringValue(keySessionHash)
+	if cfg.SessionHashKey == "" {
+		cfg.SessionHashKey = cfg.SessionSecret
+	}
 	cfg.SessionName = confTool.StringValue(keySessionName)
+	cfg.SessionTimeout = confTool.DurationValue(keySessionTTL)
+	cfg.SessionSliding = boolValue(keySessionSlide)
+	cfg.TokenAlgorithm = confTool.StringValue(keyTokenAlg)
+	cfg.TokenKey = confTool.StringValue(keyTokenKey)
+	cfg.IDTokenKey = confTool.StringValue(keyIDTokenKey)
 	cfg.ServeAddress = confTool.StringValue(keyServeAddress)
+	cfg.Storage = confTool.StringValue(keyStorage)
 	cfg.DB = confTool.StringValue(keyDB)
 	cfg.DBName = confTool.StringValue(keyDBName)
+	cfg.Providers = loadProviders(confTool.StringValue(keyProviders))
+	cfg.RedirectOrigins = splitList(confTool.StringValue(keyRedirects))
+	cfg.CookieSameSite = sameSiteValue(keyCookieSite)
+	cfg.CookieDomain = confTool.StringValue(keyCookieDomain)
+	cfg.CookiePath = confTool.StringValue(keyCookiePath)
+	cfg.LogoutWithGet = boolValue(keyLogoutGet)
+	cfg.TrustProxy = boolValue(keyTrustProxy)
+	cfg.AdminTwoFactor = boolValue(keyAdmin2FA)
+	cfg.WebAuthnRPID = confTool.StringValue(keyWebAuthnRPID)
+	cfg.AllowedDomains = splitList(confTool.StringValue(keyAllowDomains))
+	cfg.AllowedEmails = splitList(confTool.StringValue(keyAllowEmails))
+	cfg.DeniedEmails = splitList(confTool.StringValue(keyDenyEmails))
+	cfg.InviteOnly = boolValue(keyInviteOnly)
+	cfg.SMTPAddr = confTool.StringValue(keySMTPAddr)
+	cfg.SMTPUser = confTool.StringValue(keySMTPUser)
+	cfg.SMTPPassword = confTool.StringValue(keySMTPPassword)
+	cfg.MailFrom = confTool.StringValue(keyMailFrom)
+	cfg.MailDir = confTool.StringValue(keyMailDir)
 
 	appAddress := confTool.StringValue(keyAppAddress)
 	appURL, err := url.Parse(appAddress)
 	if err != nil {
-		fmt.Println("Could not parse app url: %v. Err: %v", appAddress, err)
+		fmt.Printf("Could not parse app url: %v. Err: %v\n", appAddress, err)
 		os.Exit(1)
 	}
 
 	cfg.AppAddress = appURL
+
+	if confTool.StringValue(keyCookieSecure) == cookieSecureAuto {
+		cfg.CookieSecure = appURL.Scheme == "https"
+	} else {
+		cfg.CookieSecure = boolValue(keyCookieSecure)
+	}
+}
+
+func loadProviders(names string) []Provider {
+	providers := []Provider{}
+	for _, name := range splitList(names) {
+		prefix, ok := providerKeyPrefix[name]
+		if !ok {
+			prefix = strings.ToUpper(name)
+		}
+
+		keyAllowDomains := prefix + keySuffixAllowDomains
+		confTool.EnvVariableHelp[keyAllowDomains] = fmt.Sprintf("Comma separated email domains users logging in with %v have to be in. Empty for any", name)
+		confTool.Defaults[keyAllowDomains] = ""
+		allowedDomains := splitList(confTool.StringValue(keyAllowDomains))
+
+		if name == LocalProvider || name == EmailProvider || name == WebAuthnProvider {
+			// Needs no credentials
+			providers = append(providers, Provider{Name: name, AllowedDomains: allowedDomains})
+			continue
+		}
+
+		keyClientID := prefix + keySuffixClientID
+		keyClientSecret := prefix + keySuffixClientSecret
+		keyScopes := prefix + keySuffixScopes
+		keyIssuer := prefix + keySuffixIssuer
+		keyTrustEmail := prefix + keySuffixTrustEmail
+
+		confTool.EnvVariableHelp[keyClientID] = fmt.Sprintf("Client id of %v app", name)
+		confTool.EnvVariableHelp[keyClientSecret] = fmt.Sprintf("Shared secret of %v app", name)
+		confTool.EnvVariableHelp[keyScopes] = fmt.Sprintf("Comma separated list of %v scopes to request", name)
+		confTool.EnvVariableHelp[keyIssuer] = fmt.Sprintf("OpenID Connect issuer URL, if %v is not a built-in provider", name)
+		confTool.EnvVariableHelp[keyTrustEmail] = fmt.Sprintf("Treat emails reported by %v as verified, letting them log into existing accounts: true or false", name)
+		confTool.Defaults[keyScopes] = ""
+		confTool.Defaults[keyIssuer] = ""
+		confTool.Defaults[keyTrustEmail] = "false"
+
+		providers = append(providers, Provider{
+			Name:           name,
+			ClientID:       confTool.StringValue(keyClientID),
+			Secret:         confTool.StringValue(keyClientSecret),
+			Scopes:         splitList(confTool.StringValue(keyScopes)),
+			Issuer:         confTool.StringValue(keyIssuer),
+			TrustEmail:     boolValue(keyTrustEmail),
+			AllowedDomains: allowedDomains,
+		})
+	}
+
+	return providers
+}
+
+// splitList splits comma separated values skipping empty ones
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func sameSiteValue(key string) http.SameSite {
+	value := confTool.StringValue(key)
+	mode, ok := sameSiteModes[strings.ToLower(value)]
+	if !ok {
+		fmt.Printf("Could not get SameSite mode using environment key: %v. Got: %#v\n", confTool.MakeKey(key), value)
+		os.Exit(1)
+	}
+	return mode
+}
+
+func boolValue(key string) bool {
+	value, err := strconv.ParseBool(confTool.StringValue(key))
+	if err != nil {
+		fmt.Printf("Could not get bool using environment key: %v. Error: %v\n", confTool.MakeKey(key), err)
+		os.Exit(1)
+	}
+	return value
 }
@@ -4,6 +4,10 @@ import (
 	"net/http"
 )
 
+// SessionCookieName is default name of cookie auth service keeps session in.
+// Service and its clients share it, so their defaults can't drift apart
+const SessionCookieName = "auth_session_id"
+
 type Who interface {
 	Who(req *http.Request) (*User, error)
 }
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdentityNotFound error = errors.New("Identity not found")
+	ErrIdentityLinked   error = errors.New("Identity already linked to another user")
+	ErrLastIdentity     error = errors.New("The only identity of the user can't be unlinked")
+	// ErrEmailNotVerified is returned for unknown identity with email of
+	// existing user, which provider did not verify. Such identity has to be
+	// linked by the user, after logging in with already linked one
+	ErrEmailNotVerified error = errors.New("Email not verified by provider. Log in with linked provider and link this one")
+)
+
+// Identity is user's account at auth provider. UserID is provider's id of
+// the account, which unlike email can't be changed by its owner
+type Identity struct {
+	Provider      string    `json:"provider"`
+	UserID        string    `json:"user_id"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Linked        time.Time `json:"linked"`
+}
+
+type IdentityStorer interface {
+	// AddIdentityToSession stores the session for user the identity is linked
+	// to. Unknown identity is linked to user with the same email when provider
+	// verified it, or to newly created user. Returns stored user,
+	// ErrUserDisabled for disabled users and ErrEmailNotVerified when email
+	// of existing user was not verified or is missing
+	AddIdentityToSession(session Session, identity Identity, user *User) (*User, error)
+	ListIdentities(email string) ([]Identity, error)
+	// LinkIdentity adds identity to the user. Returns ErrIdentityLinked when
+	// it is linked to another user
+	LinkIdentity(email string, identity Identity) error
+	// UnlinkIdentity removes identity from the user, unless it is the last one
+	UnlinkIdentity(email string, provider string, userId string) error
+}
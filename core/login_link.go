@@ -0,0 +1,29 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrLoginLinkNotFound error = errors.New("Login link not found or expired")
+
+// LoginLink is short lived, single use link emailed to user, which logs
+// the user in. Only hash of its token is stored
+type LoginLink struct {
+	Hash  string
+	Email string
+	// Target is where user goes once logged in
+	Target  string
+	Expires time.Time
+}
+
+// Expired checks if link is no longer valid at given time
+func (l LoginLink) Expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+type LoginLinkStorer interface {
+	AddLoginLink(link LoginLink) error
+	// TakeLoginLink returns link with given hash and removes it, so it can be used once
+	TakeLoginLink(hash string) (*LoginLink, error)
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrClientNotFound     error = errors.New("OAuth client not found")
+	ErrCodeNotFound       error = errors.New("Authorization code not found")
+	ErrOAuthTokenNotFound error = errors.New("OAuth token not found")
+	// ErrOAuthTokenReused is returned for refresh token which was already exchanged
+	ErrOAuthTokenReused error = errors.New("Refresh token already used")
+)
+
+// Kinds of tokens issued to OAuth clients
+const (
+	OAuthAccessToken  = "access_token"
+	OAuthRefreshToken = "refresh_token"
+)
+
+// OAuthClient is an app allowed to log users in through the service.
+// Public clients (mobile and browser apps) can't keep a secret and have to
+// use PKCE instead
+type OAuthClient struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	SecretHash   string    `json:"-"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	Created      time.Time `json:"created"`
+}
+
+// AllowsRedirect checks redirect URI is exactly one of registered ones
+func (c OAuthClient) AllowsRedirect(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+// AllowsScopes checks client was registered with all of the scopes
+func (c OAuthClient) AllowsScopes(scopes []string) bool {
+	return containsAll(c.Scopes, scopes)
+}
+
+// AuthorizationCode is short lived, single use code given to client after
+// user approved it. Only hash of the code is stored
+type AuthorizationCode struct {
+	Hash                string
+	ClientID            string
+	Email               string
+	RedirectURI         string
+	Scopes              []string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce is sent back in ID token, for OpenID Connect clients
+	Nonce   string
+	Expires time.Time
+}
+
+// Expired checks if code is no longer valid at given time
+func (c AuthorizationCode) Expired(now time.Time) bool {
+	return !now.Before(c.Expires)
+}
+
+// OAuthToken is access or refresh token issued to a client. Tokens issued
+// from the same authorization share Family, so whole chain of rotated
+// refresh tokens can be revoked at once. Only hash of the token is stored
+type OAuthToken struct {
+	Hash     string
+	Kind     string
+	Family   string
+	ClientID string
+	Email    string
+	Scopes   []string
+	Created  time.Time
+	Expires  time.Time
+	Used     bool
+}
+
+// Expired checks if token is no longer valid at given time
+func (t OAuthToken) Expired(now time.Time) bool {
+	return !now.Before(t.Expires)
+}
+
+type OAuthStorer interface {
+	AddClient(client OAuthClient) error
+	GetClient(id string) (*OAuthClient, error)
+	ListClients() ([]OAuthClient, error)
+	// DeleteClient removes client with all tokens issued to it
+	DeleteClient(id string) error
+
+	AddAuthorizationCode(code AuthorizationCode) error
+	// TakeAuthorizationCode returns code with given hash and removes it, so it can be used once
+	TakeAuthorizationCode(hash string) (*AuthorizationCode, error)
+
+	AddOAuthToken(token OAuthToken) error
+	// GetOAuthToken returns not expired token with given hash
+	GetOAuthToken(hash string) (*OAuthToken, error)
+	// UseRefreshToken marks refresh token of the client as exchanged. Token
+	// used before is returned together with ErrOAuthTokenReused. Token of
+	// another client is not found, and is left untouched
+	UseRefreshToken(hash string, clientId string) (*OAuthToken, error)
+	// RevokeOAuthFamily removes all tokens issued from single authorization
+	RevokeOAuthFamily(family string) error
+
+	// Consent returns scopes user already approved for the client
+	Consent(email string, clientId string) ([]string, error)
+	GrantConsent(email string, clientId string, scopes []string) error
+}
+
+func containsAll(items []string, wanted []string) bool {
+	for _, item := range wanted {
+		if !contains(items, item) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidCredentials error = errors.New("Invalid email or password")
+	ErrAccountLocked      error = errors.New("Too many failed logins. Try again later")
+	ErrUserExists         error = errors.New("User already exists")
+	ErrNoPassword         error = errors.New("User has no password")
+)
+
+// Password lets user log in without auth provider. Hash is bcrypt hash of
+// the password. Failures counts failed logins since the last successful one
+type Password struct {
+	Hash        string    `json:"-" bson:"hash"`
+	Failures    int       `json:"failures" bson:"failures"`
+	LockedUntil time.Time `json:"locked_until" bson:"locked_until"`
+	Changed     time.Time `json:"changed" bson:"changed"`
+}
+
+// Locked checks if password can't be used at given time
+func (p Password) Locked(now time.Time) bool {
+	return now.Before(p.LockedUntil)
+}
+
+type PasswordStorer interface {
+	// AddPasswordUser creates user logging in with password. Returns
+	// ErrUserExists when user with the email exists already
+	AddPasswordUser(user *User, hash string) error
+	// GetPassword returns ErrNoPassword for users without password
+	GetPassword(email string) (*Password, error)
+	// SetPassword replaces password hash and clears failed logins
+	SetPassword(email string, hash string) error
+	// PasswordFailed counts failed login. Password is locked until given
+	// time once it fails maxFailures times in a row
+	PasswordFailed(email string, maxFailures int, lockUntil time.Time) error
+	// PasswordSucceeded clears failed logins
+	PasswordSucceeded(email string) error
+}
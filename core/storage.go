@@ -2,16 +2,83 @@ package core
 
 import (
 	"errors"
+	"time"
 )
 
-var ErrSessionNotFound error = errors.New("Session not found")
+var (
+	ErrSessionNotFound error = errors.New("Session not found")
+	ErrUserNotFound    error = errors.New("User not found")
+	ErrUserDisabled    error = errors.New("User disabled")
+)
+
+// Session is a single login of the user. ID is a secret known only to
+// the user, PublicID identifies the session when listing them. UserAgent,
+// IP and Provider describe the login, so users can tell their sessions apart
+type Session struct {
+	ID        string    `json:"-"`
+	PublicID  string    `json:"id"`
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"last_used"`
+	Expires   time.Time `json:"expires"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	// Current marks session the list was asked for with
+	Current bool `json:"current,omitempty"`
+	// TwoFactorPending marks session of user, who has to give two-factor
+	// authentication code before the session can be used
+	TwoFactorPending bool `json:"two_factor_pending,omitempty"`
+}
+
+// Expired checks if session is no longer valid at given time
+func (s Session) Expired(now time.Time) bool {
+	return !now.Before(s.Expires)
+}
 
 type UserSessioner interface {
 	GetUserBySession(sessionId string) (*User, error)
-	AddUserToSession(sessionId string, user *User) error
+	// AddUserToSession stores the session, creating user if needed, or
+	// refreshing name and avatar of existing one. Fills in ID of the user.
+	// Returns ErrUserDisabled for disabled users
+	AddUserToSession(session Session, user *User) error
+	// RenewSession marks session as used now and moves its expiry
+	RenewSession(sessionId string, expires time.Time) error
 	DeleteSession(sessionId string) error
+	// ListUserSessions returns active sessions of user logged in with the
+	// session, without their secret IDs
+	ListUserSessions(sessionId string) ([]Session, error)
+	// RevokeUserSession removes session of user logged in with the session
+	RevokeUserSession(sessionId string, publicId string) error
+	// RevokeOtherSessions removes all sessions of the user, but the given one
+	RevokeOtherSessions(sessionId string) error
+}
+
+// UserList is a page of users matching a query
+type UserList struct {
+	Users  []User `json:"users"`
+	Total  int    `json:"total"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
 }
 
 type Administrator interface {
+	// ListUsers returns users with email or name containing query, ordered by email
+	ListUsers(query string, offset int, limit int) (*UserList, error)
+	GetUser(email string) (*User, error)
 	MakeUserAnAdmin(email string) error
+	RevokeAdmin(email string) error
+	// DisableUser prevents user from logging in and removes all user's sessions
+	DisableUser(email string) error
+	// DeleteUser removes user together with all user's sessions
+	DeleteUser(email string) error
+	// ListSessions returns user's active sessions without their secret IDs
+	ListSessions(email string) ([]Session, error)
+	RevokeSession(email string, publicId string) error
+	RevokeSessions(email string) error
+	// PurgeExpiredSessions removes expired sessions of all users and returns how many were removed
+	PurgeExpiredSessions() (int, error)
+	GrantRole(email string, role string) error
+	RevokeRole(email string, role string) error
+	GrantPermission(email string, permission string) error
+	RevokePermission(email string, permission string) error
 }
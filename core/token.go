@@ -0,0 +1,56 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrTokenNotFound error = errors.New("Access token not found")
+)
+
+// ScopeAdmin lets access token act with owner's admin rights
+const ScopeAdmin = "admin"
+
+// AccessToken is personal access token, used by machine clients instead
+// of session cookie. Only hash of the secret is stored
+type AccessToken struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Scopes   []string  `json:"scopes"`
+	Hash     string    `json:"-"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Expired checks if token is no longer valid at given time
+func (t AccessToken) Expired(now time.Time) bool {
+	return !now.Before(t.Expires)
+}
+
+// Restrict returns user as seen through the token. Token keeps owner's
+// identity and roles, but only permissions listed in its scopes, and admin
+// rights only with ScopeAdmin
+func (t AccessToken) Restrict(user *User) *User {
+	restricted := *user
+	restricted.Admin = user.Admin && contains(t.Scopes, ScopeAdmin)
+	restricted.Permissions = []string{}
+	for _, permission := range user.Permissions {
+		if contains(t.Scopes, permission) {
+			restricted.Permissions = append(restricted.Permissions, permission)
+		}
+	}
+	return &restricted
+}
+
+type AccessTokenStorer interface {
+	// AddAccessToken stores token of existing user
+	AddAccessToken(email string, token AccessToken) error
+	// GetUserByAccessToken finds owner of valid token with given hash and
+	// marks token as used. Returns ErrTokenNotFound for unknown, expired
+	// tokens and those of disabled users
+	GetUserByAccessToken(hash string) (*User, *AccessToken, error)
+	ListAccessTokens(email string) ([]AccessToken, error)
+	RevokeAccessToken(email string, id string) error
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrTwoFactorNotEnabled error = errors.New("Two-factor authentication not enabled")
+	ErrTwoFactorEnabled    error = errors.New("Two-factor authentication already enabled")
+	ErrTwoFactorPending    error = errors.New("Two-factor authentication code required")
+	ErrTwoFactorRequired   error = errors.New("Two-factor authentication is mandatory")
+	ErrInvalidCode         error = errors.New("Invalid two-factor authentication code")
+)
+
+// TwoFactor holds TOTP secret of the user. It is enabled once user proves
+// authenticator app was set up, by giving valid code
+type TwoFactor struct {
+	Secret  string `json:"-" bson:"secret"`
+	Enabled bool   `json:"enabled" bson:"enabled"`
+	// LastStep is time step of the last code accepted, so codes can't be reused
+	LastStep int64 `json:"-" bson:"last_step"`
+	// RecoveryCodes are hashes of unused recovery codes
+	RecoveryCodes []string `json:"-" bson:"recovery_codes"`
+}
+
+// TwoFactorStorer keeps TOTP secrets of users, and marks sessions of users,
+// who logged in but didn't give the code yet. GetUserBySession returns
+// ErrTwoFactorPending for such sessions
+type TwoFactorStorer interface {
+	// GetTwoFactor returns ErrTwoFactorNotEnabled for users, who never
+	// started enrolling
+	GetTwoFactor(email string) (*TwoFactor, error)
+	// SetTwoFactor starts enrolling with new secret, replacing not yet
+	// enabled one. Returns ErrTwoFactorEnabled once enrolling is complete
+	SetTwoFactor(email string, secret string) error
+	// EnableTwoFactor completes enrolling, storing hashes of recovery codes
+	EnableTwoFactor(email string, recoveryCodes []string) error
+	DisableTwoFactor(email string) error
+	// UseTwoFactorStep accepts code of given time step. Returns
+	// ErrInvalidCode for steps not later than the last accepted one
+	UseTwoFactorStep(email string, step int64) error
+	// UseRecoveryCode removes recovery code with given hash. Returns
+	// ErrInvalidCode when there is no such code
+	UseRecoveryCode(email string, hash string) error
+	// GetPendingUser returns user of session waiting for the code
+	GetPendingUser(sessionId string) (*User, error)
+	// SetTwoFactorPending marks session as waiting for the code, or not,
+	// and moves its expiry
+	SetTwoFactorPending(sessionId string, pending bool, expires time.Time) error
+}
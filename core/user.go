@@ -8,9 +8,33 @@ var (
 	ErrUserNotLoggedIn = errors.New("User not logged in")
 )
 
+// User is identified by ID, which unlike email never changes
 type User struct {
-	Name   string `json:"name"`
-	Email  string `json:"email"`
-	Avatar string `json:"avatar"`
-	Admin  bool   `json:"admin,omitempty"`
+	ID          string   `json:"id" bson:"-"`
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	Avatar      string   `json:"avatar"`
+	Admin       bool     `json:"admin,omitempty"`
+	Disabled    bool     `json:"disabled,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// HasRole checks if user was granted the role
+func (u User) HasRole(role string) bool {
+	return contains(u.Roles, role)
+}
+
+// HasPermission checks if user was granted the permission, e.g. "billing:read"
+func (u User) HasPermission(permission string) bool {
+	return contains(u.Permissions, permission)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrCredentialNotFound error = errors.New("Passkey not found")
+	ErrCredentialExists   error = errors.New("Passkey already registered")
+	ErrSignCount          error = errors.New("Passkey signature counter went back, passkey may be cloned")
+	ErrChallengeNotFound  error = errors.New("WebAuthn challenge not found or expired")
+)
+
+// WebAuthnCredential is passkey or security key registered by user
+type WebAuthnCredential struct {
+	// ID is base64url encoded credential id
+	ID        string `json:"id" bson:"id"`
+	Name      string `json:"name" bson:"name"`
+	PublicKey []byte `json:"-" bson:"public_key"`
+	// SignCount is signature counter reported with the last use
+	SignCount int64     `json:"-" bson:"sign_count"`
+	Created   time.Time `json:"created" bson:"created"`
+	LastUsed  time.Time `json:"last_used,omitempty" bson:"last_used,omitempty"`
+}
+
+// WebAuthnChallenge is single use challenge of ceremony in progress, stored
+// by hash. Email is of the user ceremony is for, empty for passkey login
+type WebAuthnChallenge struct {
+	Hash     string
+	Email    string
+	Ceremony string
+	Expires  time.Time
+}
+
+// Expired checks if challenge is no longer valid at given time
+func (c WebAuthnChallenge) Expired(now time.Time) bool {
+	return !now.Before(c.Expires)
+}
+
+type WebAuthnStorer interface {
+	// AddCredential returns ErrCredentialExists when any user has
+	// credential with the same id
+	AddCredential(email string, credential WebAuthnCredential) error
+	ListCredentials(email string) ([]WebAuthnCredential, error)
+	// GetCredential finds credential by id, with email of its owner
+	GetCredential(id string) (string, *WebAuthnCredential, error)
+	// UseCredential records use of credential with signature counter
+	// reported. Returns ErrSignCount when counter did not grow, unless
+	// authenticator doesn't count signatures and it stays zero
+	UseCredential(id string, signCount int64, used time.Time) error
+	DeleteCredential(email string, id string) error
+
+	AddWebAuthnChallenge(challenge WebAuthnChallenge) error
+	// TakeWebAuthnChallenge returns challenge with given hash and removes
+	// it, so it can be used once
+	TakeWebAuthnChallenge(hash string) (*WebAuthnChallenge, error)
+}
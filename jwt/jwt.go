@@ -0,0 +1,223 @@
+// Package jwt implements the subset of JSON Web Token (RFC 7519) and
+// JSON Web Key (RFC 7517) specifications needed by the service
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	// SessionTokenUse is "token_use" claim of session tokens, so other tokens
+	// of the same issuer, like ID tokens, can't be used as session
+	SessionTokenUse = "session"
+)
+
+var (
+	ErrMalformedToken       = errors.New("Malformed token")
+	ErrUnsupportedAlgorithm = errors.New("Unsupported signing algorithm")
+	ErrInvalidSignature     = errors.New("Invalid token signature")
+	ErrKeyNotFound          = errors.New("Key used to sign the token not found")
+	ErrInvalidIssuer        = errors.New("Token issued by unexpected issuer")
+	ErrTokenExpired         = errors.New("Token expired")
+	ErrWrongTokenUse        = errors.New("Token issued for another use")
+)
+
+// Header is JOSE header of the token
+type Header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// Claims holds decoded payload of the token
+type Claims map[string]interface{}
+
+// NewClaims builds claims out of JSON representation of the object
+func NewClaims(obj interface{}) (Claims, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := Claims{}
+	err = json.Unmarshal(data, &claims)
+	return claims, err
+}
+
+// Decode fills the object with claims matching its JSON representation
+func (c Claims) Decode(obj interface{}) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, obj)
+}
+
+// Validate checks that token was issued by the issuer and is not expired
+func (c Claims) Validate(issuer string, now time.Time) error {
+	if strings.TrimRight(c.String("iss"), "/") != strings.TrimRight(issuer, "/") {
+		return ErrInvalidIssuer
+	}
+	if !now.Before(c.Time("exp")) {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// ValidateSession checks that token is valid session token of the issuer.
+// Tokens with audience are issued to someone else and are rejected too
+func (c Claims) ValidateSession(issuer string, now time.Time) error {
+	if c.String("token_use") != SessionTokenUse || c["aud"] != nil {
+		return ErrWrongTokenUse
+	}
+	return c.Validate(issuer, now)
+}
+
+// String returns value of string claim or empty string if not present
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Time returns value of NumericDate claim or zero time if not present
+func (c Claims) Time(name string) time.Time {
+	value, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(value), 0)
+}
+
+// Audience returns "aud" claim which may be a single string or list of them
+func (c Claims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		audience := make([]string, 0, len(aud))
+		for _, item := range aud {
+			if value, ok := item.(string); ok {
+				audience = append(audience, value)
+			}
+		}
+		return audience
+	}
+	return nil
+}
+
+// HasAudience checks if token was issued for given audience
+func (c Claims) HasAudience(audience string) bool {
+	for _, aud := range c.Audience() {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// Token is parsed, but not yet verified, JWT in compact serialization
+type Token struct {
+	Header    Header
+	Claims    Claims
+	signed    string
+	signature []byte
+}
+
+// Parse decodes token without verifying its signature
+func Parse(token string) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	t := &Token{
+		signed: parts[0] + "." + parts[1],
+	}
+
+	if err := decodeSegment(parts[0], &t.Header); err != nil {
+		return nil, err
+	}
+	if err := decodeSegment(parts[1], &t.Claims); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	t.signature = signature
+
+	return t, nil
+}
+
+// Verify checks signature of the token with given public key,
+// or shared secret ([]byte) for HS256
+func (t *Token) Verify(key crypto.PublicKey) error {
+	switch t.Header.Algorithm {
+	case AlgHS256:
+		secret, ok := key.([]byte)
+		if !ok || !hmac.Equal(hmacSHA256(secret, t.signed), t.signature) {
+			return ErrInvalidSignature
+		}
+	case AlgEdDSA:
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(edKey, []byte(t.signed), t.signature) {
+			return ErrInvalidSignature
+		}
+	case AlgRS256:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		digest := sha256.Sum256([]byte(t.signed))
+		if rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], t.signature) != nil {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+
+	return nil
+}
+
+// VerifyWithKeySet checks signature of the token with key from the set
+// matching key id from the token header
+func (t *Token) VerifyWithKeySet(keys KeySet) error {
+	jwk, ok := keys.Key(t.Header.KeyID)
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	key, err := jwk.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	return t.Verify(key)
+}
+
+func decodeSegment(segment string, obj interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformedToken
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(obj); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package jwt_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/jwt"
+)
+
+func signers(t *testing.T) []*jwt.Signer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSigner, err := jwt.NewSigner(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edSigner, err := jwt.NewSigner(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	return []*jwt.Signer{jwt.NewHMACSigner(secret), rsaSigner, edSigner}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, signer := range signers(t) {
+		value, err := signer.Sign(jwt.Claims{"sub": "bob", "aud": []string{"a", "b"}})
+		assert.NoError(t, err)
+
+		token, err := jwt.Parse(value)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, signer.Algorithm, token.Header.Algorithm)
+		assert.Equal(t, "bob", token.Claims.String("sub"))
+		assert.True(t, token.Claims.HasAudience("b"))
+		assert.NoError(t, signer.Verify(token))
+
+		if signer.Algorithm != jwt.AlgHS256 {
+			assert.NoError(t, token.VerifyWithKeySet(signer.KeySet()), signer.Algorithm)
+		}
+	}
+}
+
+func TestVerifyRejectsOtherKeys(t *testing.T) {
+	signersA, signersB := signers(t), signers(t)
+
+	for i, signer := range signersA {
+		value, _ := signer.Sign(jwt.Claims{"sub": "bob"})
+		token, _ := jwt.Parse(value)
+
+		assert.Equal(t, jwt.ErrInvalidSignature, signersB[i].Verify(token), signer.Algorithm)
+	}
+}
+
+func TestVerifyRejectsAlgorithmConfusion(t *testing.T) {
+	all := signers(t)
+	hmacSigner, rsaSigner := all[0], all[1]
+
+	// HS256 token signed with public key bytes must not pass RSA verification
+	value, _ := hmacSigner.Sign(jwt.Claims{"sub": "bob"})
+	token, _ := jwt.Parse(value)
+	assert.Error(t, token.VerifyWithKeySet(rsaSigner.KeySet()))
+	assert.Equal(t, jwt.ErrInvalidSignature, rsaSigner.Verify(token))
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, value := range []string{"", "a.b", "a.b.c", "e30.e30.!!!"} {
+		_, err := jwt.Parse(value)
+		assert.Equal(t, jwt.ErrMalformedToken, err, value)
+	}
+}
+
+func TestClaimsValidate(t *testing.T) {
+	now := time.Now()
+	claims := jwt.Claims{"iss": "http://auth.example.com/", "exp": float64(now.Add(time.Hour).Unix())}
+
+	assert.NoError(t, claims.Validate("http://auth.example.com", now))
+	assert.Equal(t, jwt.ErrInvalidIssuer, claims.Validate("http://other.example.com/", now))
+	assert.Equal(t, jwt.ErrTokenExpired, claims.Validate("http://auth.example.com/", now.Add(2*time.Hour)))
+}
+
+func TestClaimsValidateSession(t *testing.T) {
+	now := time.Now()
+	claims := jwt.Claims{"iss": "http://auth.example.com/", "exp": float64(now.Add(time.Hour).Unix()), "token_use": jwt.SessionTokenUse}
+	assert.NoError(t, claims.ValidateSession("http://auth.example.com/", now))
+	assert.Equal(t, jwt.ErrTokenExpired, claims.ValidateSession("http://auth.example.com/", now.Add(2*time.Hour)))
+
+	// ID tokens of the same issuer are not sessions
+	claims["aud"] = "partner-app"
+	assert.Equal(t, jwt.ErrWrongTokenUse, claims.ValidateSession("http://auth.example.com/", now))
+	delete(claims, "aud")
+	delete(claims, "token_use")
+	assert.Equal(t, jwt.ErrWrongTokenUse, claims.ValidateSession("http://auth.example.com/", now))
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	key, err := jwt.ParsePrivateKey(data)
+	assert.NoError(t, err)
+	assert.Equal(t, edKey.Public(), key.Public())
+
+	_, err = jwt.ParsePrivateKey([]byte("garbage"))
+	assert.Equal(t, jwt.ErrInvalidPEM, err)
+}
@@ -0,0 +1,154 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+)
+
+var ErrInvalidPEM = errors.New("Could not decode PEM encoded key")
+
+// Signer issues tokens signed with single key
+type Signer struct {
+	Algorithm string
+	KeyID     string
+	key       interface{}
+}
+
+// NewHMACSigner creates signer using HS256 with shared secret
+func NewHMACSigner(secret []byte) *Signer {
+	return &Signer{
+		Algorithm: AlgHS256,
+		key:       secret,
+	}
+}
+
+// NewSigner creates signer for RSA (RS256) or Ed25519 (EdDSA) private key.
+// Key id is set to JWK thumbprint of the public key
+func NewSigner(key crypto.Signer) (*Signer, error) {
+	s := &Signer{key: key}
+
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		s.Algorithm = AlgRS256
+	case ed25519.PrivateKey:
+		s.Algorithm = AlgEdDSA
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	jwk, err := NewJSONWebKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	s.KeyID = jwk.Thumbprint()
+
+	return s, nil
+}
+
+// ParsePrivateKey decodes PEM encoded PKCS#8 or PKCS#1 private key
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return signer, nil
+}
+
+// Sign serializes claims into signed token
+func (s *Signer) Sign(claims Claims) (string, error) {
+	header, err := json.Marshal(Header{
+		Algorithm: s.Algorithm,
+		KeyID:     s.KeyID,
+		Type:      "JWT",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch key := s.key.(type) {
+	case []byte:
+		signature = hmacSHA256(key, signed)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	default:
+		err = ErrUnsupportedKey
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks token signed by this signer
+func (s *Signer) Verify(token *Token) error {
+	if token.Header.Algorithm != s.Algorithm {
+		return ErrInvalidSignature
+	}
+
+	switch key := s.key.(type) {
+	case []byte:
+		return token.Verify(key)
+	case crypto.Signer:
+		return token.Verify(key.Public())
+	}
+	return ErrUnsupportedKey
+}
+
+// KeySet returns public keys to publish for verifying tokens.
+// Tokens signed with shared secret have none
+func (s *Signer) KeySet() KeySet {
+	keys := KeySet{Keys: []JSONWebKey{}}
+
+	if key, ok := s.key.(crypto.Signer); ok {
+		jwk, err := NewJSONWebKey(key.Public())
+		if err == nil {
+			jwk.KeyID = s.KeyID
+			jwk.Algorithm = s.Algorithm
+			jwk.Use = "sig"
+			keys.Keys = append(keys.Keys, jwk)
+		}
+	}
+
+	return keys
+}
+
+func hmacSHA256(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
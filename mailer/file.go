@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer saves messages as .eml files in a directory instead of
+// sending them. It is meant for development without SMTP server
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+func (f *FileMailer) Send(msg Message) error {
+	data, err := msg.bytes(f.from)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return ioutil.WriteFile(filepath.Join(f.dir, name), data, 0600)
+}
@@ -0,0 +1,135 @@
+package mailer_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/mailer"
+)
+
+var message = mailer.Message{
+	To:      "bob@example.com",
+	Subject: "Log in to Example",
+	Body:    "Follow the link to log in:\nhttp://localhost:1234/login/email/callback?token=abc",
+}
+
+func TestMemMailer(t *testing.T) {
+	m := mailer.NewMemMailer()
+	assert.Empty(t, m.Messages())
+
+	assert.NoError(t, m.Send(message))
+	assert.Equal(t, []mailer.Message{message}, m.Messages())
+}
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := mailer.NewFileMailer(dir, "Example <auth@example.com>")
+	assert.NoError(t, m.Send(message))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if !assert.Len(t, files, 1) {
+		return
+	}
+	file, _ := os.Open(files[0])
+	defer file.Close()
+
+	email, err := mail.ReadMessage(file)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Example <auth@example.com>", email.Header.Get("From"))
+		assert.Equal(t, "bob@example.com", email.Header.Get("To"))
+		assert.Equal(t, "Log in to Example", email.Header.Get("Subject"))
+		assert.Equal(t, "quoted-printable", email.Header.Get("Content-Transfer-Encoding"))
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	m := mailer.NewFileMailer(os.TempDir(), "auth@example.com")
+
+	injected := message
+	injected.To = "bob@example.com\r\nBcc: eve@example.com"
+	assert.Equal(t, mailer.ErrInvalidHeader, m.Send(injected))
+}
+
+// smtpServer accepts single message and returns its envelope and data
+func smtpServer(t *testing.T) (addr string, received chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received = make(chan []string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		text := textproto.NewConn(conn)
+		defer text.Close()
+
+		envelope := []string{}
+		text.PrintfLine("220 localhost ready")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); command {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 localhost")
+			case "MAIL", "RCPT":
+				envelope = append(envelope, line)
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 Go ahead")
+				data, _ := text.ReadDotBytes()
+				received <- append(envelope, string(data))
+				text.PrintfLine("250 OK")
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				return
+			default:
+				text.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := smtpServer(t)
+
+	m, err := mailer.NewSMTPMailer(addr, "", "", "Example <auth@example.com>")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, m.Send(message))
+
+	envelope := <-received
+	if assert.Len(t, envelope, 3) {
+		assert.Equal(t, "MAIL FROM:<auth@example.com>", strings.SplitN(envelope[0], " BODY", 2)[0])
+		assert.Equal(t, "RCPT TO:<bob@example.com>", envelope[1])
+		assert.Contains(t, envelope[2], "Subject: Log in to Example\n")
+		assert.Contains(t, envelope[2], "login/email/callback?token=3Dabc")
+	}
+}
+
+func TestSMTPMailerNeedsSender(t *testing.T) {
+	_, err := mailer.NewSMTPMailer("localhost:25", "", "", "not an address")
+	assert.Error(t, err)
+	_, err = mailer.NewSMTPMailer("localhost", "", "", "auth@example.com")
+	assert.Error(t, err)
+}
@@ -0,0 +1,31 @@
+package mailer
+
+import (
+	"sync"
+)
+
+// MemMailer keeps messages instead of sending them. It is meant for tests
+type MemMailer struct {
+	lock     sync.Mutex
+	messages []Message
+}
+
+func NewMemMailer() *MemMailer {
+	return &MemMailer{}
+}
+
+func (m *MemMailer) Send(msg Message) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns messages sent so far, oldest first
+func (m *MemMailer) Messages() []Message {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer sends messages through SMTP server. Password is sent only
+// over TLS, or to server on localhost
+type SMTPMailer struct {
+	addr   string
+	from   string
+	sender string
+	auth   smtp.Auth
+}
+
+// NewSMTPMailer sends messages through server at addr (host:port) from
+// sender, e.g. "Example <auth@example.com>". Empty user skips authentication
+func NewSMTPMailer(addr string, user string, password string, from string) (*SMTPMailer, error) {
+	sender, err := address(from)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mailer := &SMTPMailer{
+		addr:   addr,
+		from:   from,
+		sender: sender,
+	}
+	if user != "" {
+		mailer.auth = smtp.PlainAuth("", user, password, host)
+	}
+	return mailer, nil
+}
+
+func (s *SMTPMailer) Send(msg Message) error {
+	data, err := msg.bytes(s.from)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(s.addr, s.auth, s.sender, []string{msg.To}, data)
+}
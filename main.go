@@ -1,49 +1,90 @@
 package main
 
 import (
-	"flag"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 
 	"github.com/codegangsta/negroni"
 	"github.com/hashtock/service-tools/serialize"
 
 	"github.com/hashtock/auth/conf"
 	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/jwt"
+	"github.com/hashtock/auth/mailer"
 	"github.com/hashtock/auth/storage"
 	"github.com/hashtock/auth/webapp"
 )
 
-const (
-	CommandMakeAdmin = "make_admin"
-)
-
 func main() {
 	cfg := conf.GetConfig()
 
-	mongoStorage, err := storage.NewMongoStorage(cfg.DB, cfg.DBName)
+	appStorage, err := newStorage(cfg)
 	if err != nil {
 		log.Fatalln("Could not configure storage. ", err)
 	}
 
-	ok, err := AdminCommands(mongoStorage)
+	if handled, exitCode := AdminCommands(appStorage, os.Args[1:], os.Stdout, os.Stderr); handled {
+		os.Exit(exitCode)
+	}
+
+	tokenSigner, err := newTokenSigner(cfg)
+	if err != nil {
+		log.Fatalln("Could not configure session tokens. ", err)
+	}
+
+	idTokenSigner, err := newIDTokenSigner(cfg)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return
-	} else if ok {
-		return
+		log.Fatalln("Could not configure ID tokens. ", err)
+	}
+
+	appMailer, err := newMailer(cfg)
+	if err != nil {
+		log.Fatalln("Could not configure mailer. ", err)
 	}
 
 	handlerOptions := webapp.Options{
-		Serializer:         &serialize.WebAPISerializer{},
-		Storage:            mongoStorage,
-		AppAddress:         cfg.AppAddress,
-		GoogleClientID:     cfg.GoogleClientID,
-		GoogleClientSecret: cfg.GoogleClientSecret,
-		SessionSecret:      cfg.SessionSecret,
+		Serializer:    &serialize.WebAPISerializer{},
+		Storage:       appStorage,
+		Administrator: appStorage,
+		AccessTokens:  appStorage,
+		Identities:    appStorage,
+		Passwords:     appStorage,
+		LoginLinks:    appStorage,
+		Mailer:        appMailer,
+		TwoFactor:     appStorage,
+		WebAuthn:      appStorage,
+		WebAuthnRPID:  cfg.WebAuthnRPID,
+		OAuth:         appStorage,
+		AppAddress:    cfg.AppAddress,
+		Providers:     cfg.Providers,
+		SessionSecret: cfg.SessionSecret,
+
+		SessionTimeout:  cfg.SessionTimeout,
+		SlidingSessions: cfg.SessionSliding,
+		TokenSigner:     tokenSigner,
+		IDTokenSigner:   idTokenSigner,
+		RedirectOrigins: cfg.RedirectOrigins,
+		LogoutWithGet:   cfg.LogoutWithGet,
+		TrustProxy:      cfg.TrustProxy,
+
+		RequireAdminTwoFactor: cfg.AdminTwoFactor,
+		Admission: webapp.AdmissionPolicy{
+			AllowedDomains: cfg.AllowedDomains,
+			AllowedEmails:  cfg.AllowedEmails,
+			DeniedEmails:   cfg.DeniedEmails,
+			InviteOnly:     cfg.InviteOnly,
+		},
+		Cookie: webapp.CookieOptions{
+			Name:     cfg.SessionName,
+			Path:     cfg.CookiePath,
+			Domain:   cfg.CookieDomain,
+			Secure:   cfg.CookieSecure,
+			SameSite: cfg.CookieSameSite,
+		},
 	}
 	handler := webapp.Handlers(handlerOptions)
 
@@ -59,34 +100,84 @@ func main() {
 	}
 }
 
-func AdminCommands(adminStorage core.Administrator) (ok bool, err error) {
-	flag.Parse()
-	if flag.NArg() == 0 {
-		return false, nil
+type serviceStorage interface {
+	core.UserSessioner
+	core.Administrator
+	core.AccessTokenStorer
+	core.IdentityStorer
+	core.PasswordStorer
+	core.LoginLinkStorer
+	core.TwoFactorStorer
+	core.WebAuthnStorer
+	core.OAuthStorer
+}
+
+func newStorage(cfg *conf.Config) (serviceStorage, error) {
+	switch cfg.Storage {
+	case conf.StorageMongo:
+		mgoStorage, err := storage.NewMongoStorage(cfg.DB, cfg.DBName)
+		if err != nil {
+			return nil, err
+		}
+		mgoStorage.UseSessionHashKey([]byte(cfg.SessionHashKey))
+		return mgoStorage, nil
+	case conf.StorageMemory:
+		return storage.NewMemStorage(), nil
 	}
+	return nil, fmt.Errorf("Storage %#v not recognised", cfg.Storage)
+}
 
-	cmd := flag.Arg(0)
-	if cmd != CommandMakeAdmin {
-		fmt.Fprintf(os.Stderr, "Command %#v not recognised\n", cmd)
-		PrintUsage()
-	} else if cmd == CommandMakeAdmin {
-		if flag.NArg() != 2 {
-			fmt.Fprintf(os.Stderr, "Command %#v accept exactly 1 argument. %v given\n", cmd, flag.NArg()-1)
-			PrintUsage()
-		} else if err = adminStorage.MakeUserAnAdmin(flag.Arg(1)); err != nil {
-			return false, err
+func newTokenSigner(cfg *conf.Config) (*jwt.Signer, error) {
+	switch cfg.TokenAlgorithm {
+	case "":
+		return nil, nil
+	case jwt.AlgHS256:
+		if cfg.TokenKey == "" {
+			return nil, errors.New("Secret for HS256 tokens not provided")
+		}
+		return jwt.NewHMACSigner([]byte(cfg.TokenKey)), nil
+	case jwt.AlgRS256, jwt.AlgEdDSA:
+		signer, err := loadSigner(cfg.TokenKey)
+		if err != nil {
+			return nil, err
+		}
+		if signer.Algorithm != cfg.TokenAlgorithm {
+			return nil, fmt.Errorf("Key in %v can't be used with %v", cfg.TokenKey, cfg.TokenAlgorithm)
 		}
+		return signer, nil
 	}
+	return nil, fmt.Errorf("Token algorithm %#v not recognised", cfg.TokenAlgorithm)
+}
 
-	return true, nil
+// newMailer picks SMTP server, or directory when there is none. Without
+// either login links can't be sent
+func newMailer(cfg *conf.Config) (mailer.Mailer, error) {
+	switch {
+	case cfg.SMTPAddr != "":
+		return mailer.NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPUser, cfg.SMTPPassword, cfg.MailFrom)
+	case cfg.MailDir != "":
+		return mailer.NewFileMailer(cfg.MailDir, cfg.MailFrom), nil
+	}
+	return nil, nil
 }
 
-func PrintUsage() {
-	_, appName := filepath.Split(os.Args[0])
-	fmt.Fprintf(os.Stderr, "Usage of %s:\n\n", appName)
-	fmt.Fprintf(os.Stderr, "        %s [command [arguments]]\n\n", appName)
-	fmt.Fprintf(os.Stderr, "The commands are:\n\n")
-	fmt.Fprintf(os.Stderr, "    [no command]\trun web app\n")
-	fmt.Fprintf(os.Stderr, "    %s email\tmark user with given email as an admin\n", CommandMakeAdmin)
-	flag.PrintDefaults()
+// newIDTokenSigner loads separate key for ID tokens, when configured
+func newIDTokenSigner(cfg *conf.Config) (*jwt.Signer, error) {
+	if cfg.IDTokenKey == "" {
+		return nil, nil
+	}
+	return loadSigner(cfg.IDTokenKey)
+}
+
+// loadSigner creates signer for PEM encoded private key stored in the file
+func loadSigner(path string) (*jwt.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.NewSigner(key)
 }
@@ -0,0 +1,263 @@
+// Package oidc implements goth.Provider for any OpenID Connect compliant
+// issuer, e.g. Keycloak. Endpoints are found with OpenID Connect Discovery
+// and ID tokens are verified against issuer's published keys
+package oidc
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+
+	"github.com/hashtock/auth/jwt"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+)
+
+var (
+	ErrIssuerMismatch       = errors.New("Issuer in discovery document does not match configured one")
+	ErrMissingIDToken       = errors.New("Token response does not contain ID token")
+	ErrInvalidIssuer        = errors.New("ID token issued by unexpected issuer")
+	ErrInvalidAudience      = errors.New("ID token issued for different client")
+	ErrInvalidNonce         = errors.New("ID token nonce does not match")
+	ErrTokenExpired         = errors.New("ID token expired")
+	ErrSessionNotAuthorized = errors.New("Session not authorized yet")
+
+	// DefaultScopes are requested when none are configured
+	DefaultScopes = []string{"openid", "email", "profile"}
+
+	// ClockSkew is tolerated difference between clocks of issuer and service
+	ClockSkew = time.Minute
+
+	// KeyRefreshInterval is the least time between fetches of issuer keys,
+	// so tokens with made up key ids can't make the service flood issuer
+	KeyRefreshInterval = time.Minute
+)
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is the implementation of `goth.Provider` for OpenID Connect issuers
+type Provider struct {
+	ProviderName string
+	Issuer       string
+	ClientKey    string
+	Secret       string
+	CallbackURL  string
+	HTTPClient   *http.Client
+
+	config  *oauth2.Config
+	jwksURI string
+
+	keysLock  sync.Mutex
+	keys      jwt.KeySet
+	refreshed time.Time
+}
+
+// New creates provider with given name for the issuer. Issuer's discovery
+// document and keys are fetched straight away
+func New(name, issuer, clientKey, secret, callbackURL string, scopes ...string) (*Provider, error) {
+	p := &Provider{
+		ProviderName: name,
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientKey:    clientKey,
+		Secret:       secret,
+		CallbackURL:  callbackURL,
+		HTTPClient:   http.DefaultClient,
+	}
+
+	doc := discovery{}
+	if err := p.getJSON(p.Issuer+discoveryPath, &doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(doc.Issuer, "/") != p.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+
+	p.jwksURI = doc.JWKSURI
+	p.config = &oauth2.Config{
+		ClientID:     clientKey,
+		ClientSecret: secret,
+		RedirectURL:  callbackURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  doc.AuthorizationEndpoint,
+			TokenURL: doc.TokenEndpoint,
+		},
+		Scopes: scopes,
+	}
+
+	if err := p.refreshKeys(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Name is the name used to retrieve this provider later.
+func (p *Provider) Name() string {
+	return p.ProviderName
+}
+
+// Debug is a no-op for the oidc package.
+func (p *Provider) Debug(debug bool) {}
+
+// BeginAuth builds authentication end-point with fresh nonce
+func (p *Provider) BeginAuth(state string) (goth.Session, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := url.Parse(p.config.AuthCodeURL(state))
+	if err != nil {
+		return nil, err
+	}
+	query := authURL.Query()
+	query.Set("nonce", nonce)
+	authURL.RawQuery = query.Encode()
+
+	session := &Session{
+		AuthURL: authURL.String(),
+		Nonce:   nonce,
+	}
+	return session, nil
+}
+
+// FetchUser maps claims of verified ID token into goth.User
+func (p *Provider) FetchUser(session goth.Session) (goth.User, error) {
+	sess := session.(*Session)
+	if sess.claims == nil {
+		return goth.User{}, ErrSessionNotAuthorized
+	}
+
+	user := goth.User{
+		RawData:     sess.claims,
+		UserID:      sess.claims.String("sub"),
+		Email:       sess.claims.String("email"),
+		Name:        sess.claims.String("name"),
+		NickName:    sess.claims.String("preferred_username"),
+		AvatarURL:   sess.claims.String("picture"),
+		AccessToken: sess.AccessToken,
+	}
+	return user, nil
+}
+
+// UnmarshalSession will unmarshal a JSON string into a session.
+func (p *Provider) UnmarshalSession(data string) (goth.Session, error) {
+	sess := &Session{}
+	err := json.NewDecoder(strings.NewReader(data)).Decode(sess)
+	return sess, err
+}
+
+// VerifyIDToken checks signature and claims of ID token
+func (p *Provider) VerifyIDToken(idToken string, nonce string) (jwt.Claims, error) {
+	token, err := jwt.Parse(idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := p.keysFor(token.Header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := token.VerifyWithKeySet(keys); err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims
+	if strings.TrimRight(claims.String("iss"), "/") != p.Issuer {
+		return nil, ErrInvalidIssuer
+	}
+	if !claims.HasAudience(p.ClientKey) {
+		return nil, ErrInvalidAudience
+	}
+	if nonce == "" || claims.String("nonce") != nonce {
+		return nil, ErrInvalidNonce
+	}
+	if time.Now().After(claims.Time("exp").Add(ClockSkew)) {
+		return nil, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+// keysFor returns known keys, refreshing them once if key with
+// given id is not among them (e.g. issuer rotated keys), unless they
+// were refreshed within KeyRefreshInterval
+func (p *Provider) keysFor(keyID string) (jwt.KeySet, error) {
+	p.keysLock.Lock()
+	keys := p.keys
+	_, known := keys.Key(keyID)
+	refresh := !known && time.Since(p.refreshed) >= KeyRefreshInterval
+	if refresh {
+		// Failed fetch waits for the interval too
+		p.refreshed = time.Now()
+	}
+	p.keysLock.Unlock()
+
+	if !refresh {
+		return keys, nil
+	}
+
+	if err := p.refreshKeys(); err != nil {
+		return jwt.KeySet{}, err
+	}
+
+	p.keysLock.Lock()
+	defer p.keysLock.Unlock()
+	return p.keys, nil
+}
+
+func (p *Provider) refreshKeys() error {
+	keys := jwt.KeySet{}
+	if err := p.getJSON(p.jwksURI, &keys); err != nil {
+		return err
+	}
+
+	p.keysLock.Lock()
+	p.keys = keys
+	p.refreshed = time.Now()
+	p.keysLock.Unlock()
+	return nil
+}
+
+func (p *Provider) getJSON(location string, obj interface{}) error {
+	resp, err := p.HTTPClient.Get(location)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Problem with request to %v: %s", location, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(obj)
+}
+
+func newNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
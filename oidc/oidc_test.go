@@ -0,0 +1,269 @@
+package oidc_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/jwt"
+	"github.com/hashtock/auth/oidc"
+)
+
+const (
+	clientID = "client-id"
+	keyID    = "key-1"
+)
+
+//////////////////
+// Test issuer  //
+//////////////////
+
+type testIssuer struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+	// Claims to put into next issued ID token
+	Claims jwt.Claims
+	// KeyFetches counts requests for keys
+	KeyFetches int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &testIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, map[string]string{
+			"issuer":                 issuer.URL,
+			"authorization_endpoint": issuer.URL + "/auth",
+			"token_endpoint":         issuer.URL + "/token",
+			"jwks_uri":               issuer.URL + "/certs",
+		})
+	})
+	mux.HandleFunc("/certs", func(rw http.ResponseWriter, req *http.Request) {
+		issuer.KeyFetches++
+		writeJSON(rw, jwt.KeySet{Keys: []jwt.JSONWebKey{{
+			KeyType:   "RSA",
+			KeyID:     keyID,
+			Algorithm: jwt.AlgRS256,
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     issuer.sign(t, keyID, issuer.Claims),
+		})
+	})
+	issuer.Server = httptest.NewServer(mux)
+
+	return issuer
+}
+
+func (i *testIssuer) validClaims(nonce string) jwt.Claims {
+	return jwt.Claims{
+		"iss":     i.URL,
+		"sub":     "user-123",
+		"aud":     clientID,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+		"iat":     time.Now().Unix(),
+		"nonce":   nonce,
+		"email":   "bob@example.com",
+		"name":    "Bob",
+		"picture": "http://example.com/bob.png",
+	}
+}
+
+func (i *testIssuer) sign(t *testing.T, kid string, claims jwt.Claims) string {
+	header, _ := json.Marshal(jwt.Header{Algorithm: jwt.AlgRS256, KeyID: kid, Type: "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(rw http.ResponseWriter, obj interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(obj)
+}
+
+///////////
+// Tests //
+///////////
+
+func newProvider(t *testing.T, issuer *testIssuer) *oidc.Provider {
+	provider, err := oidc.New("keycloak", issuer.URL, clientID, "secret", "http://localhost:1234/login/keycloak/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func beginAuth(t *testing.T, provider *oidc.Provider) (*oidc.Session, string) {
+	session, err := provider.BeginAuth("state")
+	assert.NoError(t, err)
+
+	authURL, err := session.GetAuthURL()
+	assert.NoError(t, err)
+
+	location, _ := url.Parse(authURL)
+	nonce := location.Query().Get("nonce")
+	assert.NotEmpty(t, nonce)
+
+	return session.(*oidc.Session), nonce
+}
+
+func TestDiscovery(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	provider := newProvider(t, issuer)
+	session, _ := beginAuth(t, provider)
+
+	location, _ := url.Parse(session.AuthURL)
+	assert.Equal(t, issuer.URL+"/auth", location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal(t, "state", location.Query().Get("state"))
+	assert.Equal(t, clientID, location.Query().Get("client_id"))
+	assert.Equal(t, "openid email profile", location.Query().Get("scope"))
+	assert.Equal(t, "keycloak", provider.Name())
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	_, err := oidc.New("keycloak", issuer.URL+"/realms/other", clientID, "secret", "")
+	assert.Error(t, err)
+}
+
+func TestLoginFlow(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	provider := newProvider(t, issuer)
+	session, nonce := beginAuth(t, provider)
+	issuer.Claims = issuer.validClaims(nonce)
+
+	// Session survives round trip through the cookie
+	restored, err := provider.UnmarshalSession(session.Marshal())
+	assert.NoError(t, err)
+
+	_, err = restored.Authorize(provider, url.Values{"code": {"code"}})
+	assert.NoError(t, err)
+
+	user, err := provider.FetchUser(restored)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-123", user.UserID)
+	assert.Equal(t, "bob@example.com", user.Email)
+	assert.Equal(t, "Bob", user.Name)
+	assert.Equal(t, "http://example.com/bob.png", user.AvatarURL)
+	assert.Equal(t, "access-token", user.AccessToken)
+}
+
+func TestFetchUserBeforeAuthorize(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	provider := newProvider(t, issuer)
+	session, _ := beginAuth(t, provider)
+
+	_, err := provider.FetchUser(session)
+	assert.Equal(t, oidc.ErrSessionNotAuthorized, err)
+}
+
+func TestIDTokenValidation(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	provider := newProvider(t, issuer)
+
+	tests := []struct {
+		name   string
+		modify func(claims jwt.Claims)
+		err    error
+	}{
+		{"wrong issuer", func(c jwt.Claims) { c["iss"] = "http://evil.example.com" }, oidc.ErrInvalidIssuer},
+		{"wrong audience", func(c jwt.Claims) { c["aud"] = "other-client" }, oidc.ErrInvalidAudience},
+		{"audience list", func(c jwt.Claims) { c["aud"] = []string{"other-client", clientID} }, nil},
+		{"wrong nonce", func(c jwt.Claims) { c["nonce"] = "other-nonce" }, oidc.ErrInvalidNonce},
+		{"missing nonce", func(c jwt.Claims) { delete(c, "nonce") }, oidc.ErrInvalidNonce},
+		{"expired", func(c jwt.Claims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, oidc.ErrTokenExpired},
+		{"missing expiry", func(c jwt.Claims) { delete(c, "exp") }, oidc.ErrTokenExpired},
+	}
+
+	for _, test := range tests {
+		session, nonce := beginAuth(t, provider)
+		issuer.Claims = issuer.validClaims(nonce)
+		test.modify(issuer.Claims)
+
+		_, err := session.Authorize(provider, url.Values{"code": {"code"}})
+		assert.Equal(t, test.err, err, test.name)
+	}
+}
+
+func TestIDTokenBadSignature(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	provider := newProvider(t, issuer)
+	_, nonce := beginAuth(t, provider)
+
+	otherIssuer := newTestIssuer(t)
+	defer otherIssuer.Close()
+
+	forged := otherIssuer.sign(t, keyID, issuer.validClaims(nonce))
+	_, err := provider.VerifyIDToken(forged, nonce)
+	assert.Equal(t, jwt.ErrInvalidSignature, err)
+
+	unknownKey := issuer.sign(t, "key-2", issuer.validClaims(nonce))
+	_, err = provider.VerifyIDToken(unknownKey, nonce)
+	assert.Equal(t, jwt.ErrKeyNotFound, err)
+}
+
+func TestKeyRefreshLimited(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	provider := newProvider(t, issuer)
+	_, nonce := beginAuth(t, provider)
+	assert.Equal(t, 1, issuer.KeyFetches)
+
+	// Keys were just fetched, so unknown key ids don't fetch them again
+	for i := 0; i < 3; i++ {
+		unknownKey := issuer.sign(t, "key-2", issuer.validClaims(nonce))
+		_, err := provider.VerifyIDToken(unknownKey, nonce)
+		assert.Equal(t, jwt.ErrKeyNotFound, err)
+	}
+	assert.Equal(t, 1, issuer.KeyFetches)
+
+	defer func(interval time.Duration) { oidc.KeyRefreshInterval = interval }(oidc.KeyRefreshInterval)
+	oidc.KeyRefreshInterval = 0
+	unknownKey := issuer.sign(t, "key-2", issuer.validClaims(nonce))
+	provider.VerifyIDToken(unknownKey, nonce)
+	assert.Equal(t, 2, issuer.KeyFetches)
+}
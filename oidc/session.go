@@ -0,0 +1,63 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+
+	"github.com/hashtock/auth/jwt"
+)
+
+// Session stores data during the auth process with OpenID Connect issuer.
+type Session struct {
+	AuthURL     string
+	Nonce       string
+	AccessToken string
+	IDToken     string
+
+	claims jwt.Claims
+}
+
+// GetAuthURL will return the URL set by calling the `BeginAuth` function on the provider.
+func (s Session) GetAuthURL() (string, error) {
+	if s.AuthURL == "" {
+		return "", errors.New("an AuthURL has not be set")
+	}
+	return s.AuthURL, nil
+}
+
+// Authorize exchanges the code for tokens and verifies received ID token
+func (s *Session) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	p := provider.(*Provider)
+	token, err := p.config.Exchange(oauth2.NoContext, params.Get("code"))
+	if err != nil {
+		return "", err
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		return "", ErrMissingIDToken
+	}
+
+	claims, err := p.VerifyIDToken(idToken, s.Nonce)
+	if err != nil {
+		return "", err
+	}
+
+	s.AccessToken = token.AccessToken
+	s.IDToken = idToken
+	s.claims = claims
+	return token.AccessToken, nil
+}
+
+// Marshal the session into a string
+func (s Session) Marshal() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func (s Session) String() string {
+	return s.Marshal()
+}
@@ -0,0 +1,101 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+type adminStorage interface {
+	core.UserSessioner
+	core.Administrator
+}
+
+// Both storages have to behave the same way for admin API
+func checkAdministration(t *testing.T, s adminStorage) {
+	for _, email := range []string{"bob@example.com", "alice@example.com", "carol@example.com"} {
+		s.AddUserToSession(newSession(email+"-1"), &core.User{Name: email, Email: email})
+		s.AddUserToSession(newSession(email+"-2"), &core.User{Name: email, Email: email})
+	}
+
+	list, err := s.ListUsers("", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, list.Total)
+	if assert.Len(t, list.Users, 1) {
+		assert.Equal(t, "bob@example.com", list.Users[0].Email)
+	}
+
+	list, err = s.ListUsers("", -1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, list.Offset)
+	if assert.Len(t, list.Users, 1) {
+		assert.Equal(t, "alice@example.com", list.Users[0].Email)
+	}
+
+	list, err = s.ListUsers("CAROL", 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, list.Total)
+
+	user, err := s.GetUser("alice@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", user.Name)
+
+	_, err = s.GetUser("nobody@example.com")
+	assert.Equal(t, core.ErrUserNotFound, err)
+
+	assert.NoError(t, s.MakeUserAnAdmin("alice@example.com"))
+	assert.NoError(t, s.RevokeAdmin("alice@example.com"))
+	user, _ = s.GetUser("alice@example.com")
+	assert.False(t, user.Admin)
+
+	sessions, err := s.ListSessions("bob@example.com")
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 2) {
+		assert.NotEmpty(t, sessions[0].PublicID)
+		assert.Empty(t, sessions[0].ID)
+	}
+
+	assert.NoError(t, s.RevokeSession("bob@example.com", sessions[0].PublicID))
+	assert.Equal(t, core.ErrSessionNotFound, s.RevokeSession("bob@example.com", sessions[0].PublicID))
+	assert.Equal(t, core.ErrSessionNotFound, s.RevokeSession("alice@example.com", sessions[1].PublicID))
+
+	assert.NoError(t, s.DisableUser("bob@example.com"))
+	_, err = s.GetUserBySession("bob@example.com-2")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+	err = s.AddUserToSession(newSession("bob@example.com-3"), &core.User{Email: "bob@example.com"})
+	assert.Equal(t, core.ErrUserDisabled, err)
+
+	assert.Equal(t, core.ErrUserNotFound, s.DisableUser("nobody@example.com"))
+
+	s.AddUserToSession(newSessionWithTTL("carol@example.com-expired", -time.Minute), &core.User{Email: "carol@example.com"})
+	removed, err := s.PurgeExpiredSessions()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	_, err = s.GetUserBySession("carol@example.com-1")
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.DeleteUser("carol@example.com"))
+	_, err = s.GetUser("carol@example.com")
+	assert.Equal(t, core.ErrUserNotFound, err)
+	_, err = s.GetUserBySession("carol@example.com-1")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+	assert.Equal(t, core.ErrUserNotFound, s.DeleteUser("carol@example.com"))
+}
+
+func TestMemStorageAdministration(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkAdministration(t, memStorage)
+}
+
+func TestAdministration(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkAdministration(t, mgoStorage)
+}
@@ -0,0 +1,159 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+type identityStorage interface {
+	adminStorage
+	core.IdentityStorer
+}
+
+func checkIdentities(t *testing.T, s identityStorage) {
+	bob := &core.User{Name: "Bob", Email: "bob@example.com"}
+	google := core.Identity{Provider: "gplus", UserID: "g-1", Email: "bob@example.com", EmailVerified: true}
+
+	// First login creates user
+	user, err := s.AddIdentityToSession(newSession("session-1"), google, bob)
+	assert.NoError(t, err)
+	assert.Equal(t, "bob@example.com", user.Email)
+	user, err = s.GetUserBySession("session-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bob", user.Name)
+
+	// Identity is found even when provider reports another email now
+	google.Email = "bob@gmail.com"
+	user, err = s.AddIdentityToSession(newSession("session-2"), google, &core.User{Email: "bob@gmail.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "bob@example.com", user.Email)
+
+	// Unverified email of existing user is not trusted
+	github := core.Identity{Provider: "github", UserID: "1234", Email: "bob@example.com"}
+	_, err = s.AddIdentityToSession(newSession("session-3"), github, &core.User{Email: "bob@example.com"})
+	assert.Equal(t, core.ErrEmailNotVerified, err)
+	_, err = s.GetUserBySession("session-3")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	// Missing email can't create user
+	_, err = s.AddIdentityToSession(newSession("session-3"), core.Identity{Provider: "github", UserID: "5678"}, &core.User{})
+	assert.Equal(t, core.ErrEmailNotVerified, err)
+
+	// Verified one is merged
+	github.EmailVerified = true
+	user, err = s.AddIdentityToSession(newSession("session-3"), github, &core.User{Email: "bob@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bob", user.Name)
+
+	identities, err := s.ListIdentities("bob@example.com")
+	assert.NoError(t, err)
+	if assert.Len(t, identities, 2) {
+		assert.Equal(t, "gplus", identities[0].Provider)
+		assert.Equal(t, "g-1", identities[0].UserID)
+		assert.False(t, identities[0].Linked.IsZero())
+		assert.Equal(t, "github", identities[1].Provider)
+	}
+	_, err = s.ListIdentities("alice@example.com")
+	assert.Equal(t, core.ErrUserNotFound, err)
+
+	// Linking
+	alice := core.Identity{Provider: "gplus", UserID: "g-2", Email: "alice@example.com", EmailVerified: true}
+	s.AddIdentityToSession(newSession("session-4"), alice, &core.User{Email: "alice@example.com"})
+	assert.Equal(t, core.ErrIdentityLinked, s.LinkIdentity("alice@example.com", google))
+	assert.NoError(t, s.LinkIdentity("bob@example.com", google)) // Already linked
+	assert.NoError(t, s.LinkIdentity("alice@example.com", core.Identity{Provider: "facebook", UserID: "fb-2", Email: "ally@example.com"}))
+	assert.Equal(t, core.ErrUserNotFound, s.LinkIdentity("carol@example.com", core.Identity{Provider: "facebook", UserID: "fb-3"}))
+
+	user, err = s.AddIdentityToSession(newSession("session-5"), core.Identity{Provider: "facebook", UserID: "fb-2", Email: "ally@example.com"}, &core.User{})
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", user.Email)
+
+	// Unlinking
+	assert.Equal(t, core.ErrIdentityNotFound, s.UnlinkIdentity("alice@example.com", "github", "1234"))
+	assert.NoError(t, s.UnlinkIdentity("bob@example.com", "github", "1234"))
+	assert.Equal(t, core.ErrLastIdentity, s.UnlinkIdentity("bob@example.com", "gplus", "g-1"))
+	identities, _ = s.ListIdentities("bob@example.com")
+	assert.Len(t, identities, 1)
+
+	// Disabled users can't log in with linked identities
+	assert.NoError(t, s.DisableUser("bob@example.com"))
+	_, err = s.AddIdentityToSession(newSession("session-6"), google, bob)
+	assert.Equal(t, core.ErrUserDisabled, err)
+
+	// Deleted user's identities can create new user
+	assert.NoError(t, s.DeleteUser("alice@example.com"))
+	user, err = s.AddIdentityToSession(newSession("session-7"), core.Identity{Provider: "facebook", UserID: "fb-2", Email: "ally@example.com"}, &core.User{Email: "ally@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ally@example.com", user.Email)
+}
+
+func TestMemStorageIdentities(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkIdentities(t, memStorage)
+}
+
+func TestIdentities(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkIdentities(t, mgoStorage)
+}
+
+type takeoverStorage interface {
+	passwordStorage
+	core.AccessTokenStorer
+	core.OAuthStorer
+}
+
+// Verified email locks out whoever registered it with password before
+func checkAccountTakeover(t *testing.T, s takeoverStorage) {
+	squatter := &core.User{Email: "bob@example.com"}
+	assert.NoError(t, s.AddPasswordUser(squatter, "hash"))
+	assert.NoError(t, s.AddUserToSession(newSession("squatter-session"), squatter))
+	github := core.Identity{Provider: "github", UserID: "gh-1", Email: "bob@example.com"}
+	assert.NoError(t, s.LinkIdentity("bob@example.com", github))
+	assert.NoError(t, s.AddAccessToken("bob@example.com", newAccessToken("1", time.Hour)))
+	assert.NoError(t, s.AddOAuthToken(newOAuthToken("refresh-1", core.OAuthRefreshToken, "family-1", time.Hour)))
+
+	google := core.Identity{Provider: "gplus", UserID: "g-1", Email: "bob@example.com", EmailVerified: true}
+	_, err := s.AddIdentityToSession(newSession("session-1"), google, &core.User{Email: "bob@example.com"})
+	assert.NoError(t, err)
+	_, err = s.GetUserBySession("session-1")
+	assert.NoError(t, err)
+
+	_, err = s.GetUserBySession("squatter-session")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+	_, _, err = s.GetUserByAccessToken("hash-1")
+	assert.Equal(t, core.ErrTokenNotFound, err)
+	_, err = s.GetOAuthToken("refresh-1")
+	assert.Equal(t, core.ErrOAuthTokenNotFound, err)
+
+	identities, err := s.ListIdentities("bob@example.com")
+	assert.NoError(t, err)
+	if assert.Len(t, identities, 1) {
+		assert.Equal(t, "gplus", identities[0].Provider)
+	}
+	_, err = s.AddIdentityToSession(newSession("squatter-session-2"), github, &core.User{Email: "bob@example.com"})
+	assert.Equal(t, core.ErrEmailNotVerified, err)
+}
+
+func TestMemStorageAccountTakeover(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkAccountTakeover(t, memStorage)
+}
+
+func TestAccountTakeover(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkAccountTakeover(t, mgoStorage)
+}
@@ -0,0 +1,53 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+func checkLoginLinks(t *testing.T, s core.LoginLinkStorer) {
+	link := core.LoginLink{
+		Hash:    "hash-1",
+		Email:   "bob@example.com",
+		Target:  "/dashboard/",
+		Expires: time.Now().Add(time.Minute),
+	}
+	assert.NoError(t, s.AddLoginLink(link))
+
+	taken, err := s.TakeLoginLink("hash-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "bob@example.com", taken.Email)
+		assert.Equal(t, "/dashboard/", taken.Target)
+	}
+
+	// Link works once
+	_, err = s.TakeLoginLink("hash-1")
+	assert.Equal(t, core.ErrLoginLinkNotFound, err)
+	_, err = s.TakeLoginLink("hash-2")
+	assert.Equal(t, core.ErrLoginLinkNotFound, err)
+
+	link.Hash = "hash-3"
+	link.Expires = time.Now().Add(-time.Second)
+	assert.NoError(t, s.AddLoginLink(link))
+	_, err = s.TakeLoginLink("hash-3")
+	assert.Equal(t, core.ErrLoginLinkNotFound, err)
+}
+
+func TestMemStorageLoginLinks(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkLoginLinks(t, memStorage)
+}
+
+func TestLoginLinks(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkLoginLinks(t, mgoStorage)
+}
@@ -0,0 +1,494 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+var (
+	// SweepInterval is how often expired sessions are removed from MemStorage
+	SweepInterval = time.Minute
+)
+
+// MemStorage keeps users and sessions in memory. It is meant for development
+// and integration tests, as all the data is lost when service stops
+type MemStorage struct {
+	lock       sync.RWMutex
+	users      map[string]*core.User
+	sessions   map[string]memSession
+	tokens     map[string]memAccessToken
+	identities map[string]memIdentity
+	passwords  map[string]core.Password
+	loginLinks map[string]core.LoginLink
+	twoFactors map[string]core.TwoFactor
+	passkeys   map[string]memCredential
+	challenges map[string]core.WebAuthnChallenge
+	oauth      memOAuth
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+type memSession struct {
+	core.Session
+	email string
+}
+
+// NewMemStorage creates empty storage. Expired sessions are removed
+// in the background until Close is called
+func NewMemStorage() *MemStorage {
+	m := &MemStorage{
+		users:      make(map[string]*core.User),
+		sessions:   make(map[string]memSession),
+		tokens:     make(map[string]memAccessToken),
+		identities: make(map[string]memIdentity),
+		passwords:  make(map[string]core.Password),
+		loginLinks: make(map[string]core.LoginLink),
+		twoFactors: make(map[string]core.TwoFactor),
+		passkeys:   make(map[string]memCredential),
+		challenges: make(map[string]core.WebAuthnChallenge),
+		oauth:      newMemOAuth(),
+		stop:       make(chan struct{}),
+	}
+
+	go m.sweep(SweepInterval)
+
+	return m
+}
+
+// Close stops background removal of expired sessions
+func (m *MemStorage) Close() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+func (m *MemStorage) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.PurgeExpiredSessions()
+			m.purgeExpiredTokens()
+			m.purgeExpiredGrants()
+			m.purgeExpiredLoginLinks()
+			m.purgeExpiredChallenges()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// PurgeExpiredSessions removes all expired sessions and returns how many were removed
+func (m *MemStorage) PurgeExpiredSessions() (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for sessionId, session := range m.sessions {
+		if session.Expired(now) {
+			delete(m.sessions, sessionId)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (m *MemStorage) GetUserBySession(sessionId string) (*core.User, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	session, ok := m.sessions[sessionId]
+	if !ok || session.Expired(now) {
+		return nil, core.ErrSessionNotFound
+	}
+
+	user, ok := m.users[session.email]
+	if !ok || user.Disabled {
+		return nil, core.ErrSessionNotFound
+	} else if session.TwoFactorPending {
+		return nil, core.ErrTwoFactorPending
+	}
+
+	if now.Sub(session.LastUsed) >= SessionUseInterval {
+		session.LastUsed = now
+		m.sessions[sessionId] = session
+	}
+	return copyUser(user), nil
+}
+
+func (m *MemStorage) AddUserToSession(session core.Session, user *core.User) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stored, ok := m.users[user.Email]
+	if !ok {
+		// New user, need to create
+		stored = m.addUser(user)
+	} else if stored.Disabled {
+		return core.ErrUserDisabled
+	}
+	refreshProfile(stored, user)
+	user.ID = stored.ID
+
+	m.addSession(session, user.Email)
+	return nil
+}
+
+// addSession stores session of the user. Caller has to hold the lock
+func (m *MemStorage) addSession(session core.Session, email string) {
+	if session.PublicID == "" {
+		session.PublicID = newPublicId()
+	}
+
+	m.sessions[session.ID] = memSession{
+		Session: session,
+		email:   email,
+	}
+}
+
+func (m *MemStorage) RenewSession(sessionId string, expires time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	session, ok := m.sessions[sessionId]
+	if !ok || session.Expired(now) {
+		return core.ErrSessionNotFound
+	}
+
+	session.LastUsed = now
+	session.Expires = expires
+	m.sessions[sessionId] = session
+	return nil
+}
+
+func (m *MemStorage) DeleteSession(sessionId string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.sessions[sessionId]; !ok {
+		return core.ErrSessionNotFound
+	}
+
+	delete(m.sessions, sessionId)
+	return nil
+}
+
+func (m *MemStorage) ListUserSessions(sessionId string) ([]core.Session, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	email, err := m.sessionOwner(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	current := m.sessions[sessionId].PublicID
+	sessions := m.listSessions(email)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].PublicID == current
+	}
+	return sessions, nil
+}
+
+func (m *MemStorage) RevokeUserSession(sessionId string, publicId string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	email, err := m.sessionOwner(sessionId)
+	if err != nil {
+		return err
+	}
+
+	return m.revokeSession(email, publicId)
+}
+
+func (m *MemStorage) RevokeOtherSessions(sessionId string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	email, err := m.sessionOwner(sessionId)
+	if err != nil {
+		return err
+	}
+
+	for id, session := range m.sessions {
+		if session.email == email && id != sessionId {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// sessionOwner returns email of user logged in with active session, which
+// is not waiting for two-factor code. Caller has to hold the lock
+func (m *MemStorage) sessionOwner(sessionId string) (string, error) {
+	session, ok := m.sessions[sessionId]
+	if !ok || session.Expired(time.Now()) || session.TwoFactorPending {
+		return "", core.ErrSessionNotFound
+	}
+	return session.email, nil
+}
+
+func (m *MemStorage) ListUsers(query string, offset int, limit int) (*core.UserList, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if offset < 0 {
+		offset = 0
+	}
+	if limit < 0 {
+		limit = 0
+	}
+
+	query = strings.ToLower(query)
+	matching := []core.User{}
+	for _, user := range m.users {
+		if strings.Contains(strings.ToLower(user.Email), query) || strings.Contains(strings.ToLower(user.Name), query) {
+			matching = append(matching, *copyUser(user))
+		}
+	}
+	sort.Sort(byEmail(matching))
+
+	list := &core.UserList{
+		Users:  []core.User{},
+		Total:  len(matching),
+		Offset: offset,
+		Limit:  limit,
+	}
+	if offset < len(matching) {
+		end := offset + limit
+		if end > len(matching) {
+			end = len(matching)
+		}
+		list.Users = matching[offset:end]
+	}
+	return list, nil
+}
+
+func (m *MemStorage) GetUser(email string) (*core.User, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	user, ok := m.users[email]
+	if !ok {
+		return nil, core.ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (m *MemStorage) MakeUserAnAdmin(email string) error {
+	return m.updateUser(email, func(user *core.User) {
+		user.Admin = true
+	})
+}
+
+func (m *MemStorage) RevokeAdmin(email string) error {
+	return m.updateUser(email, func(user *core.User) {
+		user.Admin = false
+	})
+}
+
+func (m *MemStorage) DisableUser(email string) error {
+	err := m.updateUser(email, func(user *core.User) {
+		user.Disabled = true
+	})
+	if err != nil {
+		return err
+	}
+	return m.RevokeSessions(email)
+}
+
+func (m *MemStorage) DeleteUser(email string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return core.ErrUserNotFound
+	}
+
+	delete(m.users, email)
+	m.removeUserData(email)
+	return nil
+}
+
+// removeUserData removes everything user can log in or act with: sessions,
+// tokens, identities, password, second factors and OAuth grants. Caller has
+// to hold the lock
+func (m *MemStorage) removeUserData(email string) {
+	for sessionId, session := range m.sessions {
+		if session.email == email {
+			delete(m.sessions, sessionId)
+		}
+	}
+	for hash, token := range m.tokens {
+		if token.email == email {
+			delete(m.tokens, hash)
+		}
+	}
+	for id, identity := range m.identities {
+		if identity.email == email {
+			delete(m.identities, id)
+		}
+	}
+	delete(m.passwords, email)
+	delete(m.twoFactors, email)
+	m.deleteCredentials(email)
+	m.oauth.removeUserGrants(email)
+}
+
+func (m *MemStorage) ListSessions(email string) ([]core.Session, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if _, ok := m.users[email]; !ok {
+		return nil, core.ErrUserNotFound
+	}
+
+	return m.listSessions(email), nil
+}
+
+// listSessions returns active sessions of the user. Caller has to hold the lock
+func (m *MemStorage) listSessions(email string) []core.Session {
+	now := time.Now()
+	sessions := []core.Session{}
+	for _, session := range m.sessions {
+		if session.email == email && !session.Expired(now) {
+			listed := session.Session
+			listed.ID = ""
+			sessions = append(sessions, listed)
+		}
+	}
+	sort.Sort(byCreated(sessions))
+	return sessions
+}
+
+func (m *MemStorage) RevokeSession(email string, publicId string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return core.ErrUserNotFound
+	}
+
+	return m.revokeSession(email, publicId)
+}
+
+// revokeSession removes session of the user. Caller has to hold the lock
+func (m *MemStorage) revokeSession(email string, publicId string) error {
+	for sessionId, session := range m.sessions {
+		if session.email == email && session.PublicID == publicId {
+			delete(m.sessions, sessionId)
+			return nil
+		}
+	}
+	return core.ErrSessionNotFound
+}
+
+func (m *MemStorage) RevokeSessions(email string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return core.ErrUserNotFound
+	}
+
+	for sessionId, session := range m.sessions {
+		if session.email == email {
+			delete(m.sessions, sessionId)
+		}
+	}
+	return nil
+}
+
+func (m *MemStorage) GrantRole(email string, role string) error {
+	return m.updateUser(email, func(user *core.User) {
+		user.Roles = addToSet(user.Roles, role)
+	})
+}
+
+func (m *MemStorage) RevokeRole(email string, role string) error {
+	return m.updateUser(email, func(user *core.User) {
+		user.Roles = pull(user.Roles, role)
+	})
+}
+
+func (m *MemStorage) GrantPermission(email string, permission string) error {
+	return m.updateUser(email, func(user *core.User) {
+		user.Permissions = addToSet(user.Permissions, permission)
+	})
+}
+
+func (m *MemStorage) RevokePermission(email string, permission string) error {
+	return m.updateUser(email, func(user *core.User) {
+		user.Permissions = pull(user.Permissions, permission)
+	})
+}
+
+func (m *MemStorage) updateUser(email string, update func(user *core.User)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	user, ok := m.users[email]
+	if !ok {
+		return core.ErrUserNotFound
+	}
+
+	update(user)
+	return nil
+}
+
+// addUser stores copy of new user with generated ID. Caller has to hold the lock
+func (m *MemStorage) addUser(user *core.User) *core.User {
+	stored := copyUser(user)
+	stored.ID = newPublicId()
+	m.users[stored.Email] = stored
+	return stored
+}
+
+// copyUser returns deep copy of the user, so stored one can't be changed by callers
+func copyUser(user *core.User) *core.User {
+	userCopy := *user
+	userCopy.Roles = append([]string(nil), user.Roles...)
+	userCopy.Permissions = append([]string(nil), user.Permissions...)
+	return &userCopy
+}
+
+func addToSet(items []string, item string) []string {
+	for _, i := range items {
+		if i == item {
+			return items
+		}
+	}
+	return append(items, item)
+}
+
+func pull(items []string, item string) []string {
+	kept := []string{}
+	for _, i := range items {
+		if i != item {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+type byEmail []core.User
+
+func (u byEmail) Len() int           { return len(u) }
+func (u byEmail) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+func (u byEmail) Less(i, j int) bool { return u[i].Email < u[j].Email }
+
+type byCreated []core.Session
+
+func (s byCreated) Len() int           { return len(s) }
+func (s byCreated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCreated) Less(i, j int) bool { return s[i].Created.Before(s[j].Created) }
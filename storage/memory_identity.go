@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+type memIdentity struct {
+	core.Identity
+	email string
+}
+
+func (m *MemStorage) AddIdentityToSession(session core.Session, identity core.Identity, user *core.User) (*core.User, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	email := identity.Email
+	linked, isLinked := m.identities[identityId(identity.Provider, identity.UserID)]
+	_, exists := m.users[email]
+
+	switch {
+	case isLinked:
+		email = linked.email
+	case email == "" || exists && !identity.EmailVerified:
+		return nil, core.ErrEmailNotVerified
+	case !exists:
+		// New user, need to create
+		user = copyUser(user)
+		user.Email = email
+		m.addUser(user)
+		m.linkIdentity(email, identity)
+	default:
+		// Provider proved the email belongs to someone who may not be the one
+		// who registered it with password, so whoever that was is locked out:
+		// password, second factors, sessions, tokens and identities they set
+		// up can't be used any more
+		if _, ok := m.passwords[email]; ok {
+			m.removeUserData(email)
+		}
+		m.linkIdentity(email, identity)
+	}
+
+	stored, ok := m.users[email]
+	if !ok {
+		return nil, core.ErrUserNotFound
+	} else if stored.Disabled {
+		return nil, core.ErrUserDisabled
+	}
+	refreshProfile(stored, user)
+
+	m.addSession(session, email)
+	return copyUser(stored), nil
+}
+
+func (m *MemStorage) ListIdentities(email string) ([]core.Identity, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if _, ok := m.users[email]; !ok {
+		return nil, core.ErrUserNotFound
+	}
+
+	identities := []core.Identity{}
+	for _, identity := range m.identities {
+		if identity.email == email {
+			identities = append(identities, identity.Identity)
+		}
+	}
+	sort.Sort(byLinked(identities))
+	return identities, nil
+}
+
+func (m *MemStorage) LinkIdentity(email string, identity core.Identity) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return core.ErrUserNotFound
+	}
+
+	if linked, ok := m.identities[identityId(identity.Provider, identity.UserID)]; ok {
+		if linked.email != email {
+			return core.ErrIdentityLinked
+		}
+		return nil
+	}
+
+	m.linkIdentity(email, identity)
+	return nil
+}
+
+func (m *MemStorage) UnlinkIdentity(email string, provider string, userId string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return core.ErrUserNotFound
+	}
+
+	id := identityId(provider, userId)
+	if linked, ok := m.identities[id]; !ok || linked.email != email {
+		return core.ErrIdentityNotFound
+	}
+
+	count := 0
+	for _, identity := range m.identities {
+		if identity.email == email {
+			count++
+		}
+	}
+	if count == 1 {
+		return core.ErrLastIdentity
+	}
+
+	delete(m.identities, id)
+	return nil
+}
+
+// linkIdentity adds identity to the user. Caller has to hold the lock
+func (m *MemStorage) linkIdentity(email string, identity core.Identity) {
+	if identity.Linked.IsZero() {
+		identity.Linked = time.Now()
+	}
+
+	m.identities[identityId(identity.Provider, identity.UserID)] = memIdentity{
+		Identity: identity,
+		email:    email,
+	}
+}
+
+type byLinked []core.Identity
+
+func (i byLinked) Len() int      { return len(i) }
+func (i byLinked) Swap(a, b int) { i[a], i[b] = i[b], i[a] }
+func (i byLinked) Less(a, b int) bool {
+	if i[a].Linked.Equal(i[b].Linked) {
+		return i[a].Provider < i[b].Provider
+	}
+	return i[a].Linked.Before(i[b].Linked)
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+func (m *MemStorage) AddLoginLink(link core.LoginLink) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.loginLinks[link.Hash] = link
+	return nil
+}
+
+func (m *MemStorage) TakeLoginLink(hash string) (*core.LoginLink, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	link, ok := m.loginLinks[hash]
+	if !ok {
+		return nil, core.ErrLoginLinkNotFound
+	}
+
+	delete(m.loginLinks, hash)
+	if link.Expired(time.Now()) {
+		return nil, core.ErrLoginLinkNotFound
+	}
+	return &link, nil
+}
+
+func (m *MemStorage) purgeExpiredLoginLinks() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	for hash, link := range m.loginLinks {
+		if link.Expired(now) {
+			delete(m.loginLinks, hash)
+		}
+	}
+}
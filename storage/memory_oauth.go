@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+// memOAuth holds OAuth state of MemStorage, guarded by MemStorage's lock
+type memOAuth struct {
+	clients  map[string]core.OAuthClient
+	codes    map[string]core.AuthorizationCode
+	tokens   map[string]core.OAuthToken
+	consents map[consentKey][]string
+}
+
+type consentKey struct {
+	email    string
+	clientId string
+}
+
+func newMemOAuth() memOAuth {
+	return memOAuth{
+		clients:  make(map[string]core.OAuthClient),
+		codes:    make(map[string]core.AuthorizationCode),
+		tokens:   make(map[string]core.OAuthToken),
+		consents: make(map[consentKey][]string),
+	}
+}
+
+func (m *MemStorage) AddClient(client core.OAuthClient) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	client.RedirectURIs = append([]string(nil), client.RedirectURIs...)
+	client.Scopes = append([]string(nil), client.Scopes...)
+	m.oauth.clients[client.ID] = client
+	return nil
+}
+
+func (m *MemStorage) GetClient(id string) (*core.OAuthClient, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	client, ok := m.oauth.clients[id]
+	if !ok {
+		return nil, core.ErrClientNotFound
+	}
+	client.RedirectURIs = append([]string(nil), client.RedirectURIs...)
+	client.Scopes = append([]string(nil), client.Scopes...)
+	return &client, nil
+}
+
+func (m *MemStorage) ListClients() ([]core.OAuthClient, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	clients := []core.OAuthClient{}
+	for _, client := range m.oauth.clients {
+		client.SecretHash = ""
+		client.RedirectURIs = append([]string(nil), client.RedirectURIs...)
+		client.Scopes = append([]string(nil), client.Scopes...)
+		clients = append(clients, client)
+	}
+	sort.Sort(byClientName(clients))
+	return clients, nil
+}
+
+func (m *MemStorage) DeleteClient(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.oauth.clients[id]; !ok {
+		return core.ErrClientNotFound
+	}
+
+	delete(m.oauth.clients, id)
+	for hash, code := range m.oauth.codes {
+		if code.ClientID == id {
+			delete(m.oauth.codes, hash)
+		}
+	}
+	for hash, token := range m.oauth.tokens {
+		if token.ClientID == id {
+			delete(m.oauth.tokens, hash)
+		}
+	}
+	for key := range m.oauth.consents {
+		if key.clientId == id {
+			delete(m.oauth.consents, key)
+		}
+	}
+	return nil
+}
+
+func (m *MemStorage) AddAuthorizationCode(code core.AuthorizationCode) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	code.Scopes = append([]string(nil), code.Scopes...)
+	m.oauth.codes[code.Hash] = code
+	return nil
+}
+
+func (m *MemStorage) TakeAuthorizationCode(hash string) (*core.AuthorizationCode, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	code, ok := m.oauth.codes[hash]
+	if !ok {
+		return nil, core.ErrCodeNotFound
+	}
+
+	delete(m.oauth.codes, hash)
+	if code.Expired(time.Now()) {
+		return nil, core.ErrCodeNotFound
+	}
+	return &code, nil
+}
+
+func (m *MemStorage) AddOAuthToken(token core.OAuthToken) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	token.Scopes = append([]string(nil), token.Scopes...)
+	m.oauth.tokens[token.Hash] = token
+	return nil
+}
+
+func (m *MemStorage) GetOAuthToken(hash string) (*core.OAuthToken, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	token, ok := m.oauth.tokens[hash]
+	if !ok || token.Expired(time.Now()) {
+		return nil, core.ErrOAuthTokenNotFound
+	}
+	token.Scopes = append([]string(nil), token.Scopes...)
+	return &token, nil
+}
+
+func (m *MemStorage) UseRefreshToken(hash string, clientId string) (*core.OAuthToken, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	token, ok := m.oauth.tokens[hash]
+	if !ok || token.Kind != core.OAuthRefreshToken || token.ClientID != clientId || token.Expired(time.Now()) {
+		return nil, core.ErrOAuthTokenNotFound
+	}
+
+	used := token
+	used.Used = true
+	m.oauth.tokens[hash] = used
+
+	token.Scopes = append([]string(nil), token.Scopes...)
+	if token.Used {
+		return &token, core.ErrOAuthTokenReused
+	}
+	return &token, nil
+}
+
+func (m *MemStorage) RevokeOAuthFamily(family string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for hash, token := range m.oauth.tokens {
+		if token.Family == family {
+			delete(m.oauth.tokens, hash)
+		}
+	}
+	return nil
+}
+
+func (m *MemStorage) Consent(email string, clientId string) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return append([]string{}, m.oauth.consents[consentKey{email, clientId}]...), nil
+}
+
+func (m *MemStorage) GrantConsent(email string, clientId string, scopes []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := consentKey{email, clientId}
+	granted := m.oauth.consents[key]
+	for _, scope := range scopes {
+		granted = addToSet(granted, scope)
+	}
+	m.oauth.consents[key] = granted
+	return nil
+}
+
+func (m *MemStorage) purgeExpiredGrants() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	for hash, code := range m.oauth.codes {
+		if code.Expired(now) {
+			delete(m.oauth.codes, hash)
+		}
+	}
+	for hash, token := range m.oauth.tokens {
+		if token.Expired(now) {
+			delete(m.oauth.tokens, hash)
+		}
+	}
+}
+
+// removeUserGrants removes consents and tokens given by the user to clients.
+// Caller has to hold the lock
+func (o memOAuth) removeUserGrants(email string) {
+	for hash, code := range o.codes {
+		if code.Email == email {
+			delete(o.codes, hash)
+		}
+	}
+	for hash, token := range o.tokens {
+		if token.Email == email {
+			delete(o.tokens, hash)
+		}
+	}
+	for key := range o.consents {
+		if key.email == email {
+			delete(o.consents, key)
+		}
+	}
+}
+
+type byClientName []core.OAuthClient
+
+func (c byClientName) Len() int           { return len(c) }
+func (c byClientName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byClientName) Less(i, j int) bool { return c[i].Name < c[j].Name }
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+func (m *MemStorage) AddPasswordUser(user *core.User, hash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[user.Email]; ok {
+		return core.ErrUserExists
+	}
+
+	user.ID = m.addUser(user).ID
+	m.passwords[user.Email] = core.Password{Hash: hash, Changed: time.Now()}
+	return nil
+}
+
+func (m *MemStorage) GetPassword(email string) (*core.Password, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if _, ok := m.users[email]; !ok {
+		return nil, core.ErrUserNotFound
+	}
+
+	password, ok := m.passwords[email]
+	if !ok {
+		return nil, core.ErrNoPassword
+	}
+	return &password, nil
+}
+
+func (m *MemStorage) SetPassword(email string, hash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return core.ErrUserNotFound
+	}
+
+	m.passwords[email] = core.Password{Hash: hash, Changed: time.Now()}
+	return nil
+}
+
+func (m *MemStorage) PasswordFailed(email string, maxFailures int, lockUntil time.Time) error {
+	return m.updatePassword(email, func(password *core.Password) {
+		password.Failures++
+		if password.Failures >= maxFailures {
+			password.Failures = 0
+			password.LockedUntil = lockUntil
+		}
+	})
+}
+
+func (m *MemStorage) PasswordSucceeded(email string) error {
+	return m.updatePassword(email, func(password *core.Password) {
+		password.Failures = 0
+	})
+}
+
+func (m *MemStorage) updatePassword(email string, change func(password *core.Password)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	password, ok := m.passwords[email]
+	if !ok {
+		return core.ErrNoPassword
+	}
+
+	change(&password)
+	m.passwords[email] = password
+	return nil
+}
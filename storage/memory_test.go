@@ -0,0 +1,182 @@
+package storage_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+func TestMemStorageInstance(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	assert.Implements(t, (*core.UserSessioner)(nil), memStorage)
+	assert.Implements(t, (*core.Administrator)(nil), memStorage)
+}
+
+func TestMemStorageSessions(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	user := &core.User{
+		Name:  "Name",
+		Email: "bob@example.com",
+	}
+	assert.NoError(t, memStorage.AddUserToSession(newSession("session-1"), user))
+	assert.NoError(t, memStorage.AddUserToSession(newSession("session-2"), user))
+
+	fetchedUser1, err1 := memStorage.GetUserBySession("session-1")
+	fetchedUser2, err2 := memStorage.GetUserBySession("session-2")
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.EqualValues(t, user, fetchedUser1)
+	assert.EqualValues(t, user, fetchedUser2)
+
+	assert.NoError(t, memStorage.DeleteSession("session-1"))
+	_, err := memStorage.GetUserBySession("session-1")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	fetchedUser2, err2 = memStorage.GetUserBySession("session-2")
+	assert.NoError(t, err2)
+	assert.EqualValues(t, user, fetchedUser2)
+
+	assert.Equal(t, core.ErrSessionNotFound, memStorage.DeleteSession("session-1"))
+}
+
+func TestMemStorageReturnsCopies(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	user := &core.User{Email: "bob@example.com"}
+	memStorage.AddUserToSession(newSession("session-1"), user)
+	user.Admin = true
+
+	fetchedUser, _ := memStorage.GetUserBySession("session-1")
+	assert.False(t, fetchedUser.Admin)
+	fetchedUser.Admin = true
+
+	fetchedAgain, _ := memStorage.GetUserBySession("session-1")
+	assert.False(t, fetchedAgain.Admin)
+}
+
+func TestMemStorageMarkAsAdmin(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	user := &core.User{Email: "bob@example.com"}
+	memStorage.AddUserToSession(newSession("session-1"), user)
+	memStorage.MakeUserAnAdmin(user.Email)
+	memStorage.AddUserToSession(newSession("session-2"), user)
+
+	adminUser, err := memStorage.GetUserBySession("session-2")
+	assert.NoError(t, err)
+	assert.True(t, adminUser.Admin)
+
+	assert.Equal(t, core.ErrUserNotFound, memStorage.MakeUserAnAdmin("alice@example.com"))
+}
+
+func TestMemStorageSessionExpiry(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	memStorage.AddUserToSession(newSessionWithTTL("session-1", 10*time.Millisecond), &core.User{Email: "bob@example.com"})
+	_, err := memStorage.GetUserBySession("session-1")
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = memStorage.GetUserBySession("session-1")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	err = memStorage.RenewSession("session-1", time.Now().Add(time.Hour))
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	removed, err := memStorage.PurgeExpiredSessions()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+}
+
+func TestMemStorageRenewSession(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	memStorage.AddUserToSession(newSessionWithTTL("session-1", 10*time.Millisecond), &core.User{Email: "bob@example.com"})
+	assert.NoError(t, memStorage.RenewSession("session-1", time.Now().Add(time.Hour)))
+
+	time.Sleep(20 * time.Millisecond)
+	_, err := memStorage.GetUserBySession("session-1")
+	assert.NoError(t, err)
+
+	assert.Equal(t, core.ErrSessionNotFound, memStorage.RenewSession("session-2", time.Now().Add(time.Hour)))
+}
+
+func TestMemStorageSweeper(t *testing.T) {
+	defer func(interval time.Duration) { storage.SweepInterval = interval }(storage.SweepInterval)
+	storage.SweepInterval = 5 * time.Millisecond
+
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	memStorage.AddUserToSession(newSessionWithTTL("session-1", time.Millisecond), &core.User{Email: "bob@example.com"})
+	time.Sleep(50 * time.Millisecond)
+
+	// Sweeper got there first
+	removed, _ := memStorage.PurgeExpiredSessions()
+	assert.Equal(t, 0, removed)
+}
+
+func TestMemStorageConcurrentAccess(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			sessionId := fmt.Sprintf("session-%v", i)
+			user := &core.User{Email: fmt.Sprintf("user-%v@example.com", i%5)}
+
+			assert.NoError(t, memStorage.AddUserToSession(newSession(sessionId), user))
+			memStorage.MakeUserAnAdmin(user.Email)
+			fetched, err := memStorage.GetUserBySession(sessionId)
+			if assert.NoError(t, err) {
+				assert.Equal(t, user.Email, fetched.Email)
+			}
+			assert.NoError(t, memStorage.DeleteSession(sessionId))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestMemStorageRolesAndPermissions(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	user := &core.User{Email: "bob@example.com"}
+	memStorage.AddUserToSession(newSession("session-1"), user)
+
+	assert.NoError(t, memStorage.GrantRole(user.Email, "trader"))
+	assert.NoError(t, memStorage.GrantRole(user.Email, "trader"))
+	assert.NoError(t, memStorage.GrantRole(user.Email, "moderator"))
+	assert.NoError(t, memStorage.GrantPermission(user.Email, "billing:read"))
+
+	fetchedUser, _ := memStorage.GetUserBySession("session-1")
+	assert.Equal(t, []string{"trader", "moderator"}, fetchedUser.Roles)
+	assert.Equal(t, []string{"billing:read"}, fetchedUser.Permissions)
+
+	assert.NoError(t, memStorage.RevokeRole(user.Email, "trader"))
+	assert.NoError(t, memStorage.RevokePermission(user.Email, "billing:read"))
+
+	fetchedUser, _ = memStorage.GetUserBySession("session-1")
+	assert.Equal(t, []string{"moderator"}, fetchedUser.Roles)
+	assert.False(t, fetchedUser.HasPermission("billing:read"))
+
+	assert.Error(t, memStorage.GrantRole("alice@example.com", "trader"))
+}
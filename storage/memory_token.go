@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+type memAccessToken struct {
+	core.AccessToken
+	email string
+}
+
+func (m *MemStorage) AddAccessToken(email string, token core.AccessToken) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return core.ErrUserNotFound
+	}
+
+	token.Scopes = append([]string(nil), token.Scopes...)
+	m.tokens[token.Hash] = memAccessToken{
+		AccessToken: token,
+		email:       email,
+	}
+	return nil
+}
+
+func (m *MemStorage) GetUserByAccessToken(hash string) (*core.User, *core.AccessToken, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	token, ok := m.tokens[hash]
+	if !ok || token.Expired(now) {
+		return nil, nil, core.ErrTokenNotFound
+	}
+
+	user, ok := m.users[token.email]
+	if !ok || user.Disabled {
+		return nil, nil, core.ErrTokenNotFound
+	}
+
+	token.LastUsed = now
+	m.tokens[hash] = token
+
+	listed := token.listed()
+	return copyUser(user), &listed, nil
+}
+
+func (m *MemStorage) ListAccessTokens(email string) ([]core.AccessToken, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if _, ok := m.users[email]; !ok {
+		return nil, core.ErrUserNotFound
+	}
+
+	now := time.Now()
+	tokens := []core.AccessToken{}
+	for _, token := range m.tokens {
+		if token.email == email && !token.Expired(now) {
+			tokens = append(tokens, token.listed())
+		}
+	}
+	sort.Sort(byTokenCreated(tokens))
+	return tokens, nil
+}
+
+func (m *MemStorage) RevokeAccessToken(email string, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return core.ErrUserNotFound
+	}
+
+	for hash, token := range m.tokens {
+		if token.email == email && token.ID == id {
+			delete(m.tokens, hash)
+			return nil
+		}
+	}
+	return core.ErrTokenNotFound
+}
+
+func (m *MemStorage) purgeExpiredTokens() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	for hash, token := range m.tokens {
+		if token.Expired(now) {
+			delete(m.tokens, hash)
+		}
+	}
+}
+
+// listed returns copy of the token without hash of its secret
+func (t memAccessToken) listed() core.AccessToken {
+	token := t.AccessToken
+	token.Hash = ""
+	token.Scopes = append([]string(nil), token.Scopes...)
+	return token
+}
+
+type byTokenCreated []core.AccessToken
+
+func (t byTokenCreated) Len() int           { return len(t) }
+func (t byTokenCreated) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byTokenCreated) Less(i, j int) bool { return t[i].Created.Before(t[j].Created) }
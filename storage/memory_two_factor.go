@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+func (m *MemStorage) GetTwoFactor(email string) (*core.TwoFactor, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if _, ok := m.users[email]; !ok {
+		return nil, core.ErrUserNotFound
+	}
+
+	twoFactor, ok := m.twoFactors[email]
+	if !ok {
+		return nil, core.ErrTwoFactorNotEnabled
+	}
+	twoFactor.RecoveryCodes = append([]string(nil), twoFactor.RecoveryCodes...)
+	return &twoFactor, nil
+}
+
+func (m *MemStorage) SetTwoFactor(email string, secret string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return core.ErrUserNotFound
+	} else if m.twoFactors[email].Enabled {
+		return core.ErrTwoFactorEnabled
+	}
+
+	m.twoFactors[email] = core.TwoFactor{Secret: secret}
+	return nil
+}
+
+func (m *MemStorage) EnableTwoFactor(email string, recoveryCodes []string) error {
+	return m.updateTwoFactor(email, func(twoFactor *core.TwoFactor) error {
+		twoFactor.Enabled = true
+		twoFactor.RecoveryCodes = append([]string(nil), recoveryCodes...)
+		return nil
+	})
+}
+
+func (m *MemStorage) DisableTwoFactor(email string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.twoFactors[email]; !ok {
+		return core.ErrTwoFactorNotEnabled
+	}
+
+	delete(m.twoFactors, email)
+	return nil
+}
+
+func (m *MemStorage) UseTwoFactorStep(email string, step int64) error {
+	return m.updateTwoFactor(email, func(twoFactor *core.TwoFactor) error {
+		if step <= twoFactor.LastStep {
+			return core.ErrInvalidCode
+		}
+		twoFactor.LastStep = step
+		return nil
+	})
+}
+
+func (m *MemStorage) UseRecoveryCode(email string, hash string) error {
+	return m.updateTwoFactor(email, func(twoFactor *core.TwoFactor) error {
+		for i, code := range twoFactor.RecoveryCodes {
+			if code == hash {
+				twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes[:i:i], twoFactor.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+		return core.ErrInvalidCode
+	})
+}
+
+func (m *MemStorage) updateTwoFactor(email string, update func(twoFactor *core.TwoFactor) error) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	twoFactor, ok := m.twoFactors[email]
+	if !ok {
+		return core.ErrTwoFactorNotEnabled
+	}
+
+	if err := update(&twoFactor); err != nil {
+		return err
+	}
+	m.twoFactors[email] = twoFactor
+	return nil
+}
+
+func (m *MemStorage) GetPendingUser(sessionId string) (*core.User, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	session, ok := m.sessions[sessionId]
+	if !ok || session.Expired(time.Now()) || !session.TwoFactorPending {
+		return nil, core.ErrSessionNotFound
+	}
+
+	user, ok := m.users[session.email]
+	if !ok || user.Disabled {
+		return nil, core.ErrSessionNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (m *MemStorage) SetTwoFactorPending(sessionId string, pending bool, expires time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	session, ok := m.sessions[sessionId]
+	if !ok || session.Expired(time.Now()) {
+		return core.ErrSessionNotFound
+	}
+
+	session.TwoFactorPending = pending
+	session.Expires = expires
+	m.sessions[sessionId] = session
+	return nil
+}
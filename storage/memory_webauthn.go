@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+type memCredential struct {
+	core.WebAuthnCredential
+	email string
+}
+
+func (m *MemStorage) AddCredential(email string, credential core.WebAuthnCredential) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return core.ErrUserNotFound
+	} else if _, ok := m.passkeys[credential.ID]; ok {
+		return core.ErrCredentialExists
+	}
+
+	m.passkeys[credential.ID] = memCredential{credential, email}
+	return nil
+}
+
+func (m *MemStorage) ListCredentials(email string) ([]core.WebAuthnCredential, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if _, ok := m.users[email]; !ok {
+		return nil, core.ErrUserNotFound
+	}
+
+	credentials := []core.WebAuthnCredential{}
+	for _, credential := range m.passkeys {
+		if credential.email == email {
+			credentials = append(credentials, credential.WebAuthnCredential)
+		}
+	}
+	sort.Sort(credentialsByCreated(credentials))
+	return credentials, nil
+}
+
+func (m *MemStorage) GetCredential(id string) (string, *core.WebAuthnCredential, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	credential, ok := m.passkeys[id]
+	if !ok {
+		return "", nil, core.ErrCredentialNotFound
+	}
+	return credential.email, &credential.WebAuthnCredential, nil
+}
+
+func (m *MemStorage) UseCredential(id string, signCount int64, used time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	credential, ok := m.passkeys[id]
+	if !ok {
+		return core.ErrCredentialNotFound
+	} else if !signCountGrew(credential.SignCount, signCount) {
+		return core.ErrSignCount
+	}
+
+	credential.SignCount = signCount
+	credential.LastUsed = used
+	m.passkeys[id] = credential
+	return nil
+}
+
+// signCountGrew checks counter reported by authenticator. Ones which don't
+// count signatures always report zero
+func signCountGrew(stored int64, reported int64) bool {
+	return reported > stored || reported == 0 && stored == 0
+}
+
+func (m *MemStorage) DeleteCredential(email string, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if credential, ok := m.passkeys[id]; !ok || credential.email != email {
+		return core.ErrCredentialNotFound
+	}
+
+	delete(m.passkeys, id)
+	return nil
+}
+
+// deleteCredentials removes all credentials of the user. Caller has to hold the lock
+func (m *MemStorage) deleteCredentials(email string) {
+	for id, credential := range m.passkeys {
+		if credential.email == email {
+			delete(m.passkeys, id)
+		}
+	}
+}
+
+func (m *MemStorage) AddWebAuthnChallenge(challenge core.WebAuthnChallenge) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.challenges[challenge.Hash] = challenge
+	return nil
+}
+
+func (m *MemStorage) TakeWebAuthnChallenge(hash string) (*core.WebAuthnChallenge, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	challenge, ok := m.challenges[hash]
+	if !ok {
+		return nil, core.ErrChallengeNotFound
+	}
+
+	delete(m.challenges, hash)
+	if challenge.Expired(time.Now()) {
+		return nil, core.ErrChallengeNotFound
+	}
+	return &challenge, nil
+}
+
+func (m *MemStorage) purgeExpiredChallenges() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	for hash, challenge := range m.challenges {
+		if challenge.Expired(now) {
+			delete(m.challenges, hash)
+		}
+	}
+}
+
+type credentialsByCreated []core.WebAuthnCredential
+
+func (c credentialsByCreated) Len() int           { return len(c) }
+func (c credentialsByCreated) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c credentialsByCreated) Less(i, j int) bool { return c[i].Created.Before(c[j].Created) }
@@ -2,7 +2,7 @@ package storage
 
 import (
 	"errors"
-	"fmt"
+	"regexp"
 	"time"
 
 	"gopkg.in/mgo.v2"
@@ -12,7 +12,10 @@ import (
 )
 
 const (
-	userColection = "user"
+	userColection     = "user"
+	sessionColection  = "session"
+	tokenColection    = "access_token"
+	identityColection = "identity"
 )
 
 var (
@@ -20,17 +23,49 @@ var (
 	ErrDBNameMissing = errors.New("Name of database for Mongodb not provided")
 
 	DialTimout = 5 * time.Second
+
+	// LegacySessionTimeout is given to sessions kept in session array of
+	// user document by versions before session collection. They had no expiry
+	LegacySessionTimeout = 7 * 24 * time.Hour
 )
 
 type MgoStorage struct {
 	session *mgo.Session
 	db      string
 	dbName  string
+	// sessionKey keys hashes of session ids, see UseSessionHashKey
+	sessionKey []byte
 }
 
 type mongoUser struct {
+	Id        bson.ObjectId `bson:"_id,omitempty"`
 	core.User `bson:",inline"`
-	Session   []string `bson:"session"`
+	// Password is set only for users registered with local provider
+	Password  *core.Password  `bson:"password,omitempty"`
+	TwoFactor *core.TwoFactor `bson:"two_factor,omitempty"`
+	// Passkeys are WebAuthn credentials registered by user
+	Passkeys []core.WebAuthnCredential `bson:"webauthn_credentials,omitempty"`
+}
+
+// user returns stored user with its ID
+func (u *mongoUser) user() *core.User {
+	u.User.ID = u.Id.Hex()
+	return &u.User
+}
+
+type mongoSession struct {
+	Id        string        `bson:"_id"`
+	PublicId  string        `bson:"public_id"`
+	User      bson.ObjectId `bson:"user"`
+	Created   time.Time     `bson:"created"`
+	LastUsed  time.Time     `bson:"last_used"`
+	Expires   time.Time     `bson:"expires"`
+	UserAgent string        `bson:"user_agent,omitempty"`
+	IP        string        `bson:"ip,omitempty"`
+	Provider  string        `bson:"provider,omitempty"`
+	// Hashed is false for sessions stored with raw id, before RehashSessions
+	Hashed           bool `bson:"hashed"`
+	TwoFactorPending bool `bson:"two_factor_pending,omitempty"`
 }
 
 func NewMongoStorage(dbUrl string, dbName string) (*MgoStorage, error) {
@@ -51,65 +86,214 @@ func NewMongoStorage(dbUrl string, dbName string) (*MgoStorage, error) {
 		session: msession,
 	}
 
+	if err := mgostorage.ensureIndexes(); err != nil {
+		msession.Close()
+		return nil, err
+	}
+
 	return mgostorage, nil
 }
 
+func (m *MgoStorage) ensureIndexes() error {
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
+
+	// Let Mongo purge sessions once they expire
+	expiryIndex := mgo.Index{
+		Key:         []string{"expires"},
+		ExpireAfter: time.Second,
+	}
+	if err := col.EnsureIndex(expiryIndex); err != nil {
+		return err
+	}
+
+	if err := col.EnsureIndexKey("user"); err != nil {
+		return err
+	}
+
+	// Legacy sessions are looked up, until they are all migrated
+	legacyIndex := mgo.Index{Key: []string{"session"}, Sparse: true}
+	if err := col.Database.C(userColection).EnsureIndex(legacyIndex); err != nil {
+		return err
+	}
+
+	if err := m.ensureTokenIndexes(); err != nil {
+		return err
+	}
+
+	if err := m.ensureOAuthIndexes(); err != nil {
+		return err
+	}
+
+	if err := col.Database.C(loginLinkColection).EnsureIndex(expiryIndex); err != nil {
+		return err
+	}
+
+	if err := m.ensureWebAuthnIndexes(col.Database, expiryIndex); err != nil {
+		return err
+	}
+
+	return col.Database.C(identityColection).EnsureIndexKey("user")
+}
+
 func (m *MgoStorage) userColection() *mgo.Collection {
 	session := m.session.Copy()
 	return session.DB(m.dbName).C(userColection)
 }
 
+func (m *MgoStorage) sessionColection() *mgo.Collection {
+	session := m.session.Copy()
+	return session.DB(m.dbName).C(sessionColection)
+}
+
 func (m *MgoStorage) GetUserBySession(sessionId string) (*core.User, error) {
+	sessionCol := m.sessionColection()
+	defer sessionCol.Database.Session.Close()
+
+	mSession := mongoSession{}
+	err := sessionCol.FindId(m.sessionHash(sessionId)).One(&mSession)
+	if err == mgo.ErrNotFound {
+		return m.legacySession(sessionId)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Expired sessions are purged by Mongo only periodically
+	now := time.Now()
+	if mSession.session().Expired(now) {
+		return nil, core.ErrSessionNotFound
+	}
+
+	mUser := mongoUser{}
+	err = sessionCol.Database.C(userColection).FindId(mSession.User).One(&mUser)
+	if err == mgo.ErrNotFound || mUser.Disabled {
+		return nil, core.ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	} else if mSession.TwoFactorPending {
+		return nil, core.ErrTwoFactorPending
+	}
+
+	if now.Sub(mSession.LastUsed) >= SessionUseInterval {
+		// Failing to record usage should not fail authentication
+		sessionCol.UpdateId(mSession.Id, bson.M{"$set": bson.M{"last_used": now}})
+	}
+	return mUser.user(), nil
+}
+
+// legacySession finds user by session id kept in session array of user
+// document, as versions before session collection did, and moves the
+// session to the collection, so users stay logged in across upgrade
+func (m *MgoStorage) legacySession(sessionId string) (*core.User, error) {
 	col := m.userColection()
 	defer col.Database.Session.Close()
 
-	selector := bson.M{"session": sessionId}
 	mUser := mongoUser{}
+	err := col.Find(bson.M{"session": sessionId}).One(&mUser)
+	if err == mgo.ErrNotFound || err == nil && mUser.Disabled {
+		return nil, core.ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
 
-	err := col.Find(selector).One(&mUser)
-	if err == mgo.ErrNotFound {
-		err = core.ErrSessionNotFound
+	now := time.Now()
+	session := core.Session{
+		ID:       sessionId,
+		Created:  now,
+		LastUsed: now,
+		Expires:  now.Add(LegacySessionTimeout),
 	}
-	return &mUser.User, err
+	if err := m.addSession(session, mUser.Id); err != nil {
+		return nil, err
+	}
+	if err := col.UpdateId(mUser.Id, bson.M{"$pull": bson.M{"session": sessionId}}); err != nil {
+		return nil, err
+	}
+	return mUser.user(), nil
 }
 
-func (m *MgoStorage) AddUserToSession(sessionId string, user *core.User) (err error) {
+func (m *MgoStorage) AddUserToSession(session core.Session, user *core.User) (err error) {
 	col := m.userColection()
 	defer col.Database.Session.Close()
 
 	selector := bson.M{"email": user.Email}
 
-	cnt, err := col.Find(selector).Count()
-	if err != nil {
-		return err
-	} else if cnt == 0 {
+	mUser := mongoUser{}
+	err = col.Find(selector).One(&mUser)
+	if err == mgo.ErrNotFound {
 		// New user, need to create
-		mUser := mongoUser{}
+		mUser.Id = bson.NewObjectId()
 		mUser.User = *user
-		mUser.Session = []string{sessionId}
 		err = col.Insert(&mUser)
-	} else {
-		// User in place, try to add sessionId
-		change := bson.M{
-			"$addToSet": bson.M{
-				"session": sessionId,
-			},
-		}
+	} else if err == nil && !mUser.Disabled {
+		err = updateProfile(col, &mUser, user)
+	}
+	if err != nil {
+		return err
+	} else if mUser.Disabled {
+		return core.ErrUserDisabled
+	}
+
+	user.ID = mUser.Id.Hex()
+	return m.addSession(session, mUser.Id)
+}
 
-		err = col.Update(selector, change)
+// updateProfile stores name and avatar reported by provider, if they changed
+func updateProfile(col *mgo.Collection, mUser *mongoUser, user *core.User) error {
+	name, avatar := mUser.Name, mUser.Avatar
+	refreshProfile(&mUser.User, user)
+	if mUser.Name == name && mUser.Avatar == avatar {
+		return nil
 	}
-	return err
+
+	change := bson.M{
+		"$set": bson.M{
+			"name":   mUser.Name,
+			"avatar": mUser.Avatar,
+		},
+	}
+	return col.UpdateId(mUser.Id, change)
 }
 
-func (m *MgoStorage) DeleteSession(sessionId string) error {
-	col := m.userColection()
+func (m *MgoStorage) addSession(session core.Session, userId bson.ObjectId) error {
+	col := m.sessionColection()
 	defer col.Database.Session.Close()
 
-	selector := bson.M{"session": sessionId}
+	if session.PublicID == "" {
+		session.PublicID = newPublicId()
+	}
+
+	mSession := mongoSession{
+		Id:        m.sessionHash(session.ID),
+		PublicId:  session.PublicID,
+		User:      userId,
+		Created:   session.Created,
+		LastUsed:  session.LastUsed,
+		Expires:   session.Expires,
+		UserAgent: session.UserAgent,
+		IP:        session.IP,
+		Provider:  session.Provider,
+		Hashed:    true,
+
+		TwoFactorPending: session.TwoFactorPending,
+	}
+	_, err := col.UpsertId(mSession.Id, &mSession)
+	return err
+}
+
+func (m *MgoStorage) RenewSession(sessionId string, expires time.Time) error {
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
 
+	now := time.Now()
+	selector := bson.M{
+		"_id":     m.sessionHash(sessionId),
+		"expires": bson.M{"$gt": now},
+	}
 	change := bson.M{
-		"$pull": bson.M{
-			"session": sessionId,
+		"$set": bson.M{
+			"last_used": now,
+			"expires":   expires,
 		},
 	}
 
@@ -120,25 +304,305 @@ func (m *MgoStorage) DeleteSession(sessionId string) error {
 	return err
 }
 
-func (m *MgoStorage) MakeUserAnAdmin(email string) error {
+func (m *MgoStorage) DeleteSession(sessionId string) error {
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
+
+	err := col.RemoveId(m.sessionHash(sessionId))
+	if err == mgo.ErrNotFound {
+		// Session which was never used since upgrade is still in user document
+		selector := bson.M{"session": sessionId}
+		err = col.Database.C(userColection).Update(selector, bson.M{"$pull": selector})
+	}
+	if err == mgo.ErrNotFound {
+		err = core.ErrSessionNotFound
+	}
+	return err
+}
+
+func (m *MgoStorage) ListUsers(query string, offset int, limit int) (*core.UserList, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	if limit < 0 {
+		limit = 0
+	}
+
 	col := m.userColection()
 	defer col.Database.Session.Close()
 
-	selector := bson.M{"email": email}
+	selector := bson.M{}
+	if query != "" {
+		pattern := bson.RegEx{Pattern: regexp.QuoteMeta(query), Options: "i"}
+		selector["$or"] = []bson.M{
+			{"email": pattern},
+			{"name": pattern},
+		}
+	}
 
-	cnt, err := col.Find(selector).Count()
+	total, err := col.Find(selector).Count()
 	if err != nil {
-		return err
-	} else if cnt == 0 {
-		return fmt.Errorf("User with email %#v not found", email)
+		return nil, err
+	}
+
+	mUsers := []mongoUser{}
+	err = col.Find(selector).Sort("email").Skip(offset).Limit(limit).All(&mUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &core.UserList{
+		Users:  make([]core.User, 0, len(mUsers)),
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+	}
+	for _, mUser := range mUsers {
+		list.Users = append(list.Users, *mUser.user())
+	}
+	return list, nil
+}
+
+func (m *MgoStorage) GetUser(email string) (*core.User, error) {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return nil, err
+	}
+	return mUser.user(), nil
+}
+
+func (m *MgoStorage) getUser(email string) (*mongoUser, error) {
+	col := m.userColection()
+	defer col.Database.Session.Close()
+
+	mUser := &mongoUser{}
+	err := col.Find(bson.M{"email": email}).One(mUser)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrUserNotFound
 	}
+	return mUser, err
+}
 
+func (m *MgoStorage) MakeUserAnAdmin(email string) error {
 	change := bson.M{
 		"$set": bson.M{
 			"admin": true,
 		},
 	}
-	err = col.Update(selector, change)
+	return m.updateUser(email, change)
+}
+
+func (m *MgoStorage) RevokeAdmin(email string) error {
+	return m.updateUser(email, bson.M{"$set": bson.M{"admin": false}})
+}
+
+func (m *MgoStorage) DisableUser(email string) error {
+	if err := m.updateUser(email, bson.M{"$set": bson.M{"disabled": true}}); err != nil {
+		return err
+	}
+	return m.RevokeSessions(email)
+}
+
+func (m *MgoStorage) DeleteUser(email string) error {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return err
+	}
+
+	if err := m.removeUserData(mUser.Id, email); err != nil {
+		return err
+	}
+
+	userCol := m.userColection()
+	defer userCol.Database.Session.Close()
+
+	err = userCol.RemoveId(mUser.Id)
+	if err == mgo.ErrNotFound {
+		err = core.ErrUserNotFound
+	}
+	return err
+}
+
+// removeUserData removes sessions, tokens, identities and OAuth grants of
+// the user, kept outside of user document
+func (m *MgoStorage) removeUserData(userId bson.ObjectId, email string) error {
+	sessionCol := m.sessionColection()
+	defer sessionCol.Database.Session.Close()
+
+	for _, name := range []string{sessionColection, tokenColection, identityColection} {
+		if _, err := sessionCol.Database.C(name).RemoveAll(bson.M{"user": userId}); err != nil {
+			return err
+		}
+	}
+	return m.removeUserGrants(email)
+}
+
+func (m *MgoStorage) ListSessions(email string) ([]core.Session, error) {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.listSessions(mUser.Id)
+}
+
+func (m *MgoStorage) listSessions(userId bson.ObjectId) ([]core.Session, error) {
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
+
+	selector := bson.M{
+		"user":    userId,
+		"expires": bson.M{"$gt": time.Now()},
+	}
+
+	mSessions := []mongoSession{}
+	if err := col.Find(selector).Sort("created").All(&mSessions); err != nil {
+		return nil, err
+	}
+
+	sessions := make([]core.Session, 0, len(mSessions))
+	for _, mSession := range mSessions {
+		session := mSession.session()
+		session.ID = ""
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
 
+func (m *MgoStorage) RevokeSession(email string, publicId string) error {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return err
+	}
+
+	return m.revokeSession(mUser.Id, publicId)
+}
+
+func (m *MgoStorage) revokeSession(userId bson.ObjectId, publicId string) error {
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
+
+	err := col.Remove(bson.M{"user": userId, "public_id": publicId})
+	if err == mgo.ErrNotFound {
+		err = core.ErrSessionNotFound
+	}
 	return err
 }
+
+func (m *MgoStorage) RevokeSessions(email string) error {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return err
+	}
+
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
+
+	_, err = col.RemoveAll(bson.M{"user": mUser.Id})
+	return err
+}
+
+func (m *MgoStorage) ListUserSessions(sessionId string) ([]core.Session, error) {
+	mSession, err := m.activeSession(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := m.listSessions(mSession.User)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].PublicID == mSession.PublicId
+	}
+	return sessions, err
+}
+
+func (m *MgoStorage) RevokeUserSession(sessionId string, publicId string) error {
+	mSession, err := m.activeSession(sessionId)
+	if err != nil {
+		return err
+	}
+
+	return m.revokeSession(mSession.User, publicId)
+}
+
+func (m *MgoStorage) RevokeOtherSessions(sessionId string) error {
+	mSession, err := m.activeSession(sessionId)
+	if err != nil {
+		return err
+	}
+
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
+
+	_, err = col.RemoveAll(bson.M{"user": mSession.User, "_id": bson.M{"$ne": mSession.Id}})
+	return err
+}
+
+// activeSession finds session which has not expired yet, and is not waiting
+// for two-factor code
+func (m *MgoStorage) activeSession(sessionId string) (*mongoSession, error) {
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
+
+	mSession := &mongoSession{}
+	err := col.FindId(m.sessionHash(sessionId)).One(mSession)
+	if err == mgo.ErrNotFound || err == nil && (mSession.session().Expired(time.Now()) || mSession.TwoFactorPending) {
+		return nil, core.ErrSessionNotFound
+	}
+	return mSession, err
+}
+
+// PurgeExpiredSessions removes expired sessions without waiting for TTL monitor
+func (m *MgoStorage) PurgeExpiredSessions() (int, error) {
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
+
+	info, err := col.RemoveAll(bson.M{"expires": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
+}
+
+func (m *MgoStorage) GrantRole(email string, role string) error {
+	return m.updateUser(email, bson.M{"$addToSet": bson.M{"roles": role}})
+}
+
+func (m *MgoStorage) RevokeRole(email string, role string) error {
+	return m.updateUser(email, bson.M{"$pull": bson.M{"roles": role}})
+}
+
+func (m *MgoStorage) GrantPermission(email string, permission string) error {
+	return m.updateUser(email, bson.M{"$addToSet": bson.M{"permissions": permission}})
+}
+
+func (m *MgoStorage) RevokePermission(email string, permission string) error {
+	return m.updateUser(email, bson.M{"$pull": bson.M{"permissions": permission}})
+}
+
+func (m *MgoStorage) updateUser(email string, change bson.M) error {
+	col := m.userColection()
+	defer col.Database.Session.Close()
+
+	selector := bson.M{"email": email}
+
+	err := col.Update(selector, change)
+	if err == mgo.ErrNotFound {
+		err = core.ErrUserNotFound
+	}
+	return err
+}
+
+func (s mongoSession) session() core.Session {
+	return core.Session{
+		ID:        s.Id,
+		PublicID:  s.PublicId,
+		Created:   s.Created,
+		LastUsed:  s.LastUsed,
+		Expires:   s.Expires,
+		UserAgent: s.UserAgent,
+		IP:        s.IP,
+		Provider:  s.Provider,
+
+		TwoFactorPending: s.TwoFactorPending,
+	}
+}
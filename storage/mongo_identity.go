@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/hashtock/auth/core"
+)
+
+type mongoIdentity struct {
+	Id            string        `bson:"_id"`
+	User          bson.ObjectId `bson:"user"`
+	Provider      string        `bson:"provider"`
+	UserId        string        `bson:"user_id"`
+	Email         string        `bson:"email"`
+	EmailVerified bool          `bson:"email_verified"`
+	Linked        time.Time     `bson:"linked"`
+}
+
+// identityId makes identity unique per provider
+func identityId(provider string, userId string) string {
+	return provider + "|" + userId
+}
+
+func (m *MgoStorage) identityColection() *mgo.Collection {
+	session := m.session.Copy()
+	return session.DB(m.dbName).C(identityColection)
+}
+
+func (m *MgoStorage) AddIdentityToSession(session core.Session, identity core.Identity, user *core.User) (*core.User, error) {
+	col := m.identityColection()
+	defer col.Database.Session.Close()
+
+	mUser := mongoUser{}
+	mIdentity := mongoIdentity{}
+	err := col.FindId(identityId(identity.Provider, identity.UserID)).One(&mIdentity)
+	if err == nil {
+		err = col.Database.C(userColection).FindId(mIdentity.User).One(&mUser)
+	} else if err == mgo.ErrNotFound {
+		err = m.userForIdentity(col.Database, identity, user, &mUser)
+	}
+	if err == nil && !mUser.Disabled {
+		err = updateProfile(col.Database.C(userColection), &mUser, user)
+	}
+	if err != nil {
+		return nil, err
+	} else if mUser.Disabled {
+		return nil, core.ErrUserDisabled
+	}
+
+	if err := m.addSession(session, mUser.Id); err != nil {
+		return nil, err
+	}
+	return mUser.user(), nil
+}
+
+// userForIdentity finds user with verified email of unknown identity or
+// creates new one, and links identity to the user
+func (m *MgoStorage) userForIdentity(db *mgo.Database, identity core.Identity, user *core.User, mUser *mongoUser) error {
+	if identity.Email == "" {
+		return core.ErrEmailNotVerified
+	}
+
+	err := db.C(userColection).Find(bson.M{"email": identity.Email}).One(mUser)
+	if err == nil && !identity.EmailVerified {
+		return core.ErrEmailNotVerified
+	} else if err == nil && mUser.Password != nil {
+		// Provider proved the email belongs to someone who may not be the one
+		// who registered it with password, so whoever that was is locked out:
+		// password, second factors, sessions, tokens and identities they set
+		// up can't be used any more
+		unset := bson.M{"password": "", "two_factor": "", "webauthn_credentials": "", "session": ""}
+		err = db.C(userColection).UpdateId(mUser.Id, bson.M{"$unset": unset})
+		if err == nil {
+			err = m.removeUserData(mUser.Id, mUser.Email)
+		}
+	} else if err == mgo.ErrNotFound {
+		// New user, need to create
+		mUser.Id = bson.NewObjectId()
+		mUser.User = *user
+		mUser.Email = identity.Email
+		err = db.C(userColection).Insert(mUser)
+	}
+	if err != nil {
+		return err
+	}
+
+	return db.C(identityColection).Insert(newMongoIdentity(mUser.Id, identity))
+}
+
+func (m *MgoStorage) ListIdentities(email string) ([]core.Identity, error) {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return nil, err
+	}
+
+	col := m.identityColection()
+	defer col.Database.Session.Close()
+
+	mIdentities := []mongoIdentity{}
+	if err := col.Find(bson.M{"user": mUser.Id}).Sort("linked", "provider").All(&mIdentities); err != nil {
+		return nil, err
+	}
+
+	identities := make([]core.Identity, 0, len(mIdentities))
+	for _, mIdentity := range mIdentities {
+		identities = append(identities, mIdentity.identity())
+	}
+	return identities, nil
+}
+
+func (m *MgoStorage) LinkIdentity(email string, identity core.Identity) error {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return err
+	}
+
+	col := m.identityColection()
+	defer col.Database.Session.Close()
+
+	mIdentity := mongoIdentity{}
+	err = col.FindId(identityId(identity.Provider, identity.UserID)).One(&mIdentity)
+	if err == nil && mIdentity.User != mUser.Id {
+		return core.ErrIdentityLinked
+	} else if err == nil {
+		return nil
+	} else if err != mgo.ErrNotFound {
+		return err
+	}
+
+	err = col.Insert(newMongoIdentity(mUser.Id, identity))
+	if mgo.IsDup(err) {
+		// Linked by someone else in the meantime
+		return core.ErrIdentityLinked
+	}
+	return err
+}
+
+func (m *MgoStorage) UnlinkIdentity(email string, provider string, userId string) error {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return err
+	}
+
+	col := m.identityColection()
+	defer col.Database.Session.Close()
+
+	id := identityId(provider, userId)
+	count, err := col.Find(bson.M{"_id": id, "user": mUser.Id}).Count()
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return core.ErrIdentityNotFound
+	}
+
+	count, err = col.Find(bson.M{"user": mUser.Id}).Count()
+	if err != nil {
+		return err
+	} else if count == 1 {
+		return core.ErrLastIdentity
+	}
+
+	err = col.Remove(bson.M{"_id": id, "user": mUser.Id})
+	if err == mgo.ErrNotFound {
+		err = core.ErrIdentityNotFound
+	}
+	return err
+}
+
+func newMongoIdentity(userId bson.ObjectId, identity core.Identity) *mongoIdentity {
+	if identity.Linked.IsZero() {
+		identity.Linked = time.Now()
+	}
+
+	return &mongoIdentity{
+		Id:            identityId(identity.Provider, identity.UserID),
+		User:          userId,
+		Provider:      identity.Provider,
+		UserId:        identity.UserID,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Linked:        identity.Linked,
+	}
+}
+
+func (i mongoIdentity) identity() core.Identity {
+	return core.Identity{
+		Provider:      i.Provider,
+		UserID:        i.UserId,
+		Email:         i.Email,
+		EmailVerified: i.EmailVerified,
+		Linked:        i.Linked,
+	}
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+
+	"github.com/hashtock/auth/core"
+)
+
+const loginLinkColection = "login_link"
+
+type mongoLoginLink struct {
+	Hash    string    `bson:"_id"`
+	Email   string    `bson:"email"`
+	Target  string    `bson:"target,omitempty"`
+	Expires time.Time `bson:"expires"`
+}
+
+func (m *MgoStorage) loginLinkColection() *mgo.Collection {
+	session := m.session.Copy()
+	return session.DB(m.dbName).C(loginLinkColection)
+}
+
+func (m *MgoStorage) AddLoginLink(link core.LoginLink) error {
+	col := m.loginLinkColection()
+	defer col.Database.Session.Close()
+
+	mLink := mongoLoginLink{
+		Hash:    link.Hash,
+		Email:   link.Email,
+		Target:  link.Target,
+		Expires: link.Expires,
+	}
+	return col.Insert(&mLink)
+}
+
+func (m *MgoStorage) TakeLoginLink(hash string) (*core.LoginLink, error) {
+	col := m.loginLinkColection()
+	defer col.Database.Session.Close()
+
+	mLink := mongoLoginLink{}
+	_, err := col.FindId(hash).Apply(mgo.Change{Remove: true}, &mLink)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrLoginLinkNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	link := core.LoginLink{
+		Hash:    mLink.Hash,
+		Email:   mLink.Email,
+		Target:  mLink.Target,
+		Expires: mLink.Expires,
+	}
+	if link.Expired(time.Now()) {
+		return nil, core.ErrLoginLinkNotFound
+	}
+	return &link, nil
+}
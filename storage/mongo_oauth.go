@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/hashtock/auth/core"
+)
+
+const (
+	clientColection  = "oauth_client"
+	codeColection    = "oauth_code"
+	grantColection   = "oauth_token"
+	consentColection = "oauth_consent"
+)
+
+type mongoClient struct {
+	Id           string    `bson:"_id"`
+	Name         string    `bson:"name"`
+	SecretHash   string    `bson:"secret_hash"`
+	RedirectURIs []string  `bson:"redirect_uris"`
+	Scopes       []string  `bson:"scopes"`
+	Public       bool      `bson:"public"`
+	Created      time.Time `bson:"created"`
+}
+
+type mongoCode struct {
+	Hash                string    `bson:"_id"`
+	ClientId            string    `bson:"client_id"`
+	Email               string    `bson:"email"`
+	RedirectURI         string    `bson:"redirect_uri"`
+	Scopes              []string  `bson:"scopes"`
+	CodeChallenge       string    `bson:"code_challenge"`
+	CodeChallengeMethod string    `bson:"code_challenge_method"`
+	Nonce               string    `bson:"nonce"`
+	Expires             time.Time `bson:"expires"`
+}
+
+type mongoOAuthToken struct {
+	Hash     string    `bson:"_id"`
+	Kind     string    `bson:"kind"`
+	Family   string    `bson:"family"`
+	ClientId string    `bson:"client_id"`
+	Email    string    `bson:"email"`
+	Scopes   []string  `bson:"scopes"`
+	Created  time.Time `bson:"created"`
+	Expires  time.Time `bson:"expires"`
+	Used     bool      `bson:"used"`
+}
+
+type mongoConsent struct {
+	Email    string   `bson:"email"`
+	ClientId string   `bson:"client_id"`
+	Scopes   []string `bson:"scopes"`
+}
+
+func (m *MgoStorage) oauthColection(name string) *mgo.Collection {
+	session := m.session.Copy()
+	return session.DB(m.dbName).C(name)
+}
+
+func (m *MgoStorage) ensureOAuthIndexes() error {
+	col := m.oauthColection(grantColection)
+	defer col.Database.Session.Close()
+
+	expiryIndex := mgo.Index{
+		Key:         []string{"expires"},
+		ExpireAfter: time.Second,
+	}
+	for _, name := range []string{codeColection, grantColection} {
+		if err := col.Database.C(name).EnsureIndex(expiryIndex); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range []string{"family", "client_id", "email"} {
+		if err := col.EnsureIndexKey(key); err != nil {
+			return err
+		}
+	}
+
+	consentIndex := mgo.Index{
+		Key:    []string{"email", "client_id"},
+		Unique: true,
+	}
+	return col.Database.C(consentColection).EnsureIndex(consentIndex)
+}
+
+func (m *MgoStorage) AddClient(client core.OAuthClient) error {
+	col := m.oauthColection(clientColection)
+	defer col.Database.Session.Close()
+
+	mClient := mongoClient{
+		Id:           client.ID,
+		Name:         client.Name,
+		SecretHash:   client.SecretHash,
+		RedirectURIs: client.RedirectURIs,
+		Scopes:       client.Scopes,
+		Public:       client.Public,
+		Created:      client.Created,
+	}
+	return col.Insert(&mClient)
+}
+
+func (m *MgoStorage) GetClient(id string) (*core.OAuthClient, error) {
+	col := m.oauthColection(clientColection)
+	defer col.Database.Session.Close()
+
+	mClient := mongoClient{}
+	err := col.FindId(id).One(&mClient)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrClientNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	client := mClient.client()
+	return &client, nil
+}
+
+func (m *MgoStorage) ListClients() ([]core.OAuthClient, error) {
+	col := m.oauthColection(clientColection)
+	defer col.Database.Session.Close()
+
+	mClients := []mongoClient{}
+	if err := col.Find(nil).Sort("name").All(&mClients); err != nil {
+		return nil, err
+	}
+
+	clients := make([]core.OAuthClient, 0, len(mClients))
+	for _, mClient := range mClients {
+		client := mClient.client()
+		client.SecretHash = ""
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+func (m *MgoStorage) DeleteClient(id string) error {
+	col := m.oauthColection(clientColection)
+	defer col.Database.Session.Close()
+
+	err := col.RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return core.ErrClientNotFound
+	} else if err != nil {
+		return err
+	}
+
+	for _, name := range []string{codeColection, grantColection, consentColection} {
+		if _, err := col.Database.C(name).RemoveAll(bson.M{"client_id": id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MgoStorage) AddAuthorizationCode(code core.AuthorizationCode) error {
+	col := m.oauthColection(codeColection)
+	defer col.Database.Session.Close()
+
+	mCode := mongoCode{
+		Hash:                code.Hash,
+		ClientId:            code.ClientID,
+		Email:               code.Email,
+		RedirectURI:         code.RedirectURI,
+		Scopes:              code.Scopes,
+		CodeChallenge:       code.CodeChallenge,
+		CodeChallengeMethod: code.CodeChallengeMethod,
+		Nonce:               code.Nonce,
+		Expires:             code.Expires,
+	}
+	return col.Insert(&mCode)
+}
+
+func (m *MgoStorage) TakeAuthorizationCode(hash string) (*core.AuthorizationCode, error) {
+	col := m.oauthColection(codeColection)
+	defer col.Database.Session.Close()
+
+	mCode := mongoCode{}
+	_, err := col.FindId(hash).Apply(mgo.Change{Remove: true}, &mCode)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrCodeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	code := core.AuthorizationCode{
+		Hash:                mCode.Hash,
+		ClientID:            mCode.ClientId,
+		Email:               mCode.Email,
+		RedirectURI:         mCode.RedirectURI,
+		Scopes:              mCode.Scopes,
+		CodeChallenge:       mCode.CodeChallenge,
+		CodeChallengeMethod: mCode.CodeChallengeMethod,
+		Nonce:               mCode.Nonce,
+		Expires:             mCode.Expires,
+	}
+	if code.Expired(time.Now()) {
+		return nil, core.ErrCodeNotFound
+	}
+	return &code, nil
+}
+
+func (m *MgoStorage) AddOAuthToken(token core.OAuthToken) error {
+	col := m.oauthColection(grantColection)
+	defer col.Database.Session.Close()
+
+	mToken := mongoOAuthToken{
+		Hash:     token.Hash,
+		Kind:     token.Kind,
+		Family:   token.Family,
+		ClientId: token.ClientID,
+		Email:    token.Email,
+		Scopes:   token.Scopes,
+		Created:  token.Created,
+		Expires:  token.Expires,
+		Used:     token.Used,
+	}
+	return col.Insert(&mToken)
+}
+
+func (m *MgoStorage) GetOAuthToken(hash string) (*core.OAuthToken, error) {
+	col := m.oauthColection(grantColection)
+	defer col.Database.Session.Close()
+
+	mToken := mongoOAuthToken{}
+	err := col.FindId(hash).One(&mToken)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrOAuthTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	token := mToken.token()
+	if token.Expired(time.Now()) {
+		return nil, core.ErrOAuthTokenNotFound
+	}
+	return &token, nil
+}
+
+func (m *MgoStorage) UseRefreshToken(hash string, clientId string) (*core.OAuthToken, error) {
+	col := m.oauthColection(grantColection)
+	defer col.Database.Session.Close()
+
+	// Previous state is returned, so only one of concurrent exchanges sees unused token
+	mToken := mongoOAuthToken{}
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"used": true}}}
+	_, err := col.Find(bson.M{"_id": hash, "kind": core.OAuthRefreshToken, "client_id": clientId}).Apply(change, &mToken)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrOAuthTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	token := mToken.token()
+	if token.Expired(time.Now()) {
+		return nil, core.ErrOAuthTokenNotFound
+	} else if token.Used {
+		return &token, core.ErrOAuthTokenReused
+	}
+	return &token, nil
+}
+
+func (m *MgoStorage) RevokeOAuthFamily(family string) error {
+	col := m.oauthColection(grantColection)
+	defer col.Database.Session.Close()
+
+	_, err := col.RemoveAll(bson.M{"family": family})
+	return err
+}
+
+func (m *MgoStorage) Consent(email string, clientId string) ([]string, error) {
+	col := m.oauthColection(consentColection)
+	defer col.Database.Session.Close()
+
+	mConsent := mongoConsent{}
+	err := col.Find(bson.M{"email": email, "client_id": clientId}).One(&mConsent)
+	if err == mgo.ErrNotFound {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return mConsent.Scopes, nil
+}
+
+func (m *MgoStorage) GrantConsent(email string, clientId string, scopes []string) error {
+	col := m.oauthColection(consentColection)
+	defer col.Database.Session.Close()
+
+	selector := bson.M{"email": email, "client_id": clientId}
+	change := bson.M{"$addToSet": bson.M{"scopes": bson.M{"$each": scopes}}}
+	_, err := col.Upsert(selector, change)
+	return err
+}
+
+// removeUserGrants removes consents and tokens given by the user to clients
+func (m *MgoStorage) removeUserGrants(email string) error {
+	col := m.oauthColection(grantColection)
+	defer col.Database.Session.Close()
+
+	for _, name := range []string{codeColection, grantColection, consentColection} {
+		if _, err := col.Database.C(name).RemoveAll(bson.M{"email": email}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c mongoClient) client() core.OAuthClient {
+	return core.OAuthClient{
+		ID:           c.Id,
+		Name:         c.Name,
+		SecretHash:   c.SecretHash,
+		RedirectURIs: c.RedirectURIs,
+		Scopes:       c.Scopes,
+		Public:       c.Public,
+		Created:      c.Created,
+	}
+}
+
+func (t mongoOAuthToken) token() core.OAuthToken {
+	return core.OAuthToken{
+		Hash:     t.Hash,
+		Kind:     t.Kind,
+		Family:   t.Family,
+		ClientID: t.ClientId,
+		Email:    t.Email,
+		Scopes:   t.Scopes,
+		Created:  t.Created,
+		Expires:  t.Expires,
+		Used:     t.Used,
+	}
+}
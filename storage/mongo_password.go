@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/hashtock/auth/core"
+)
+
+func (m *MgoStorage) AddPasswordUser(user *core.User, hash string) error {
+	col := m.userColection()
+	defer col.Database.Session.Close()
+
+	count, err := col.Find(bson.M{"email": user.Email}).Count()
+	if err != nil {
+		return err
+	} else if count > 0 {
+		return core.ErrUserExists
+	}
+
+	mUser := mongoUser{
+		Id:       bson.NewObjectId(),
+		User:     *user,
+		Password: &core.Password{Hash: hash, Changed: time.Now()},
+	}
+	if err := col.Insert(&mUser); mgo.IsDup(err) {
+		return core.ErrUserExists
+	} else if err != nil {
+		return err
+	}
+
+	user.ID = mUser.Id.Hex()
+	return nil
+}
+
+func (m *MgoStorage) GetPassword(email string) (*core.Password, error) {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return nil, err
+	} else if mUser.Password == nil {
+		return nil, core.ErrNoPassword
+	}
+	return mUser.Password, nil
+}
+
+func (m *MgoStorage) SetPassword(email string, hash string) error {
+	password := core.Password{Hash: hash, Changed: time.Now()}
+	return m.updateUser(email, bson.M{"$set": bson.M{"password": password}})
+}
+
+func (m *MgoStorage) PasswordFailed(email string, maxFailures int, lockUntil time.Time) error {
+	col := m.userColection()
+	defer col.Database.Session.Close()
+
+	selector := bson.M{"email": email, "password": bson.M{"$exists": true}}
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"password.failures": 1}},
+		ReturnNew: true,
+	}
+
+	mUser := mongoUser{}
+	_, err := col.Find(selector).Apply(change, &mUser)
+	if err == mgo.ErrNotFound {
+		return core.ErrNoPassword
+	} else if err != nil || mUser.Password.Failures < maxFailures {
+		return err
+	}
+
+	lock := bson.M{
+		"$set": bson.M{
+			"password.failures":     0,
+			"password.locked_until": lockUntil,
+		},
+	}
+	return col.UpdateId(mUser.Id, lock)
+}
+
+func (m *MgoStorage) PasswordSucceeded(email string) error {
+	col := m.userColection()
+	defer col.Database.Session.Close()
+
+	selector := bson.M{"email": email, "password": bson.M{"$exists": true}}
+	err := col.Update(selector, bson.M{"$set": bson.M{"password.failures": 0}})
+	if err == mgo.ErrNotFound {
+		err = core.ErrNoPassword
+	}
+	return err
+}
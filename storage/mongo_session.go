@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/hashtock/auth/core"
+)
+
+// UseSessionHashKey sets secret session ids are hashed with before they are
+// stored, so ids read from database or its backup can't be used to log in.
+// Changing the key ends all sessions
+func (m *MgoStorage) UseSessionHashKey(key []byte) {
+	m.sessionKey = key
+}
+
+// sessionHash is stored instead of session id
+func (m *MgoStorage) sessionHash(sessionId string) string {
+	mac := hmac.New(sha256.New, m.sessionKey)
+	mac.Write([]byte(sessionId))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RehashSessions replaces raw ids of sessions, stored before ids were
+// hashed, with their hashes. Sessions still kept in session array of user
+// document are moved to session collection too. Returns number of sessions
+// migrated
+func (m *MgoStorage) RehashSessions() (int, error) {
+	migrated, err := m.migrateLegacySessions()
+	if err != nil {
+		return migrated, err
+	}
+
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
+
+	mSession := mongoSession{}
+	iter := col.Find(bson.M{"hashed": bson.M{"$ne": true}}).Iter()
+	for iter.Next(&mSession) {
+		rawId := mSession.Id
+		mSession.Id = m.sessionHash(rawId)
+		mSession.Hashed = true
+
+		// Hashed one goes first, so session isn't lost when migration stops half way
+		if _, err := col.UpsertId(mSession.Id, &mSession); err != nil {
+			iter.Close()
+			return migrated, err
+		}
+		if err := col.RemoveId(rawId); err != nil {
+			iter.Close()
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, iter.Close()
+}
+
+// migrateLegacySessions moves session ids from session array of user
+// documents, where versions before session collection kept them, to
+// session collection as hashed sessions
+func (m *MgoStorage) migrateLegacySessions() (int, error) {
+	col := m.userColection()
+	defer col.Database.Session.Close()
+	sessionCol := col.Database.C(sessionColection)
+
+	migrated := 0
+	legacyUser := struct {
+		Id       bson.ObjectId `bson:"_id"`
+		Sessions []string      `bson:"session"`
+	}{}
+	iter := col.Find(bson.M{"session": bson.M{"$exists": true}}).Iter()
+	for iter.Next(&legacyUser) {
+		now := time.Now()
+		for _, sessionId := range legacyUser.Sessions {
+			// Session may have been migrated already when it was used
+			count, err := sessionCol.FindId(m.sessionHash(sessionId)).Count()
+			if err != nil {
+				iter.Close()
+				return migrated, err
+			}
+			if count > 0 {
+				continue
+			}
+
+			session := core.Session{
+				ID:       sessionId,
+				Created:  now,
+				LastUsed: now,
+				Expires:  now.Add(LegacySessionTimeout),
+			}
+			if err := m.addSession(session, legacyUser.Id); err != nil {
+				iter.Close()
+				return migrated, err
+			}
+			migrated++
+		}
+
+		err := col.UpdateId(legacyUser.Id, bson.M{"$unset": bson.M{"session": ""}})
+		if err != nil && err != mgo.ErrNotFound {
+			iter.Close()
+			return migrated, err
+		}
+		legacyUser.Sessions = nil
+	}
+	return migrated, iter.Close()
+}
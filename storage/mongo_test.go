@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 
 	"github.com/hashtock/auth/core"
 	"github.com/hashtock/auth/storage"
@@ -31,6 +32,20 @@ func newStorage(t *testing.T) (*storage.MgoStorage, string) {
 	return mgoStorage, DBName
 }
 
+func newSession(sessionId string) core.Session {
+	return newSessionWithTTL(sessionId, time.Hour)
+}
+
+func newSessionWithTTL(sessionId string, ttl time.Duration) core.Session {
+	now := time.Now()
+	return core.Session{
+		ID:       sessionId,
+		Created:  now,
+		LastUsed: now,
+		Expires:  now.Add(ttl),
+	}
+}
+
 func destroyStorage(t *testing.T, dbName string) {
 	msession, err := mgo.DialWithTimeout(dbUrl, 1*time.Second)
 	assert.NoError(t, err)
@@ -81,7 +96,7 @@ func TestSingleSession(t *testing.T) {
 		Name:  "Name",
 		Email: "bob@example.com",
 	}
-	mgoStorage.AddUserToSession("session-1", user)
+	mgoStorage.AddUserToSession(newSession("session-1"), user)
 	fetchedUser, err := mgoStorage.GetUserBySession("session-1")
 	assert.NoError(t, err)
 	assert.EqualValues(t, user, fetchedUser)
@@ -95,8 +110,8 @@ func TestMultipleSessions(t *testing.T) {
 		Name:  "Name",
 		Email: "bob@example.com",
 	}
-	mgoStorage.AddUserToSession("session-1", user)
-	mgoStorage.AddUserToSession("session-2", user)
+	mgoStorage.AddUserToSession(newSession("session-1"), user)
+	mgoStorage.AddUserToSession(newSession("session-2"), user)
 	fetchedUser1, err1 := mgoStorage.GetUserBySession("session-1")
 	fetchedUser2, err2 := mgoStorage.GetUserBySession("session-2")
 	assert.NoError(t, err1)
@@ -113,7 +128,7 @@ func TestSingleSessionDeleting(t *testing.T) {
 		Name:  "Name",
 		Email: "bob@example.com",
 	}
-	mgoStorage.AddUserToSession("session-1", user)
+	mgoStorage.AddUserToSession(newSession("session-1"), user)
 	fetchedUser1, err1 := mgoStorage.GetUserBySession("session-1")
 	assert.NoError(t, err1)
 	assert.EqualValues(t, user, fetchedUser1)
@@ -133,8 +148,8 @@ func TestMultipleSessionDeletingOne(t *testing.T) {
 		Name:  "Name",
 		Email: "bob@example.com",
 	}
-	mgoStorage.AddUserToSession("session-1", user)
-	mgoStorage.AddUserToSession("session-2", user)
+	mgoStorage.AddUserToSession(newSession("session-1"), user)
+	mgoStorage.AddUserToSession(newSession("session-2"), user)
 	fetchedUser1, err1 := mgoStorage.GetUserBySession("session-1")
 	assert.NoError(t, err1)
 	assert.EqualValues(t, user, fetchedUser1)
@@ -157,9 +172,9 @@ func TestAddingMultipleSessionsDoesNotCreateMultipleUsers(t *testing.T) {
 		Admin: false,
 	}
 
-	mgoStorage.AddUserToSession("session-1", user)
+	mgoStorage.AddUserToSession(newSession("session-1"), user)
 	mgoStorage.MakeUserAnAdmin(user.Email)
-	mgoStorage.AddUserToSession("session-2", user)
+	mgoStorage.AddUserToSession(newSession("session-2"), user)
 	fetchedUser1, err1 := mgoStorage.GetUserBySession("session-1")
 	fetchedUser2, err2 := mgoStorage.GetUserBySession("session-2")
 	assert.NoError(t, err1)
@@ -179,9 +194,181 @@ func TestMarkAsAdmin(t *testing.T) {
 		Email: "bob@example.com",
 		Admin: false,
 	}
-	mgoStorage.AddUserToSession("session-1", user)
+	mgoStorage.AddUserToSession(newSession("session-1"), user)
 	mgoStorage.MakeUserAnAdmin(user.Email)
 	adminUser, err := mgoStorage.GetUserBySession("session-1")
 	assert.NoError(t, err)
 	assert.True(t, adminUser.Admin)
 }
+
+func TestExpiredSession(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	user := &core.User{
+		Name:  "Name",
+		Email: "bob@example.com",
+	}
+	mgoStorage.AddUserToSession(newSessionWithTTL("session-1", -time.Minute), user)
+
+	_, err := mgoStorage.GetUserBySession("session-1")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	err = mgoStorage.RenewSession("session-1", time.Now().Add(time.Hour))
+	assert.Equal(t, core.ErrSessionNotFound, err)
+}
+
+func TestRenewSession(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	user := &core.User{
+		Name:  "Name",
+		Email: "bob@example.com",
+	}
+	mgoStorage.AddUserToSession(newSessionWithTTL("session-1", time.Second), user)
+
+	err := mgoStorage.RenewSession("session-1", time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	time.Sleep(time.Second)
+	fetchedUser, err := mgoStorage.GetUserBySession("session-1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, user, fetchedUser)
+
+	err = mgoStorage.RenewSession("session-2", time.Now().Add(time.Hour))
+	assert.Equal(t, core.ErrSessionNotFound, err)
+}
+
+func TestRolesAndPermissions(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	user := &core.User{
+		Name:  "Name",
+		Email: "bob@example.com",
+	}
+	mgoStorage.AddUserToSession(newSession("session-1"), user)
+
+	assert.NoError(t, mgoStorage.GrantRole(user.Email, "trader"))
+	assert.NoError(t, mgoStorage.GrantRole(user.Email, "trader"))
+	assert.NoError(t, mgoStorage.GrantRole(user.Email, "moderator"))
+	assert.NoError(t, mgoStorage.GrantPermission(user.Email, "billing:read"))
+
+	fetchedUser, err := mgoStorage.GetUserBySession("session-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"trader", "moderator"}, fetchedUser.Roles)
+	assert.Equal(t, []string{"billing:read"}, fetchedUser.Permissions)
+
+	assert.NoError(t, mgoStorage.RevokeRole(user.Email, "trader"))
+	assert.NoError(t, mgoStorage.RevokePermission(user.Email, "billing:read"))
+
+	fetchedUser, err = mgoStorage.GetUserBySession("session-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"moderator"}, fetchedUser.Roles)
+	assert.False(t, fetchedUser.HasPermission("billing:read"))
+
+	assert.Error(t, mgoStorage.GrantRole("alice@example.com", "trader"))
+}
+
+func TestSessionIdsHashedAtRest(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+	mgoStorage.UseSessionHashKey([]byte("secret"))
+
+	user := &core.User{Email: "bob@example.com"}
+	assert.NoError(t, mgoStorage.AddUserToSession(newSession("session-1"), user))
+
+	msession, _ := mgo.DialWithTimeout(dbUrl, 1*time.Second)
+	defer msession.Close()
+	sessions := msession.DB(dbName).C("session")
+
+	count, _ := sessions.FindId("session-1").Count()
+	assert.Equal(t, 0, count)
+	count, _ = sessions.Find(nil).Count()
+	assert.Equal(t, 1, count)
+
+	// Sessions stored before hashing keep working once rehashed
+	sessions.Insert(bson.M{
+		"_id":     "old-session",
+		"user":    bson.ObjectIdHex(user.ID),
+		"expires": time.Now().Add(time.Hour),
+	})
+
+	migrated, err := mgoStorage.RehashSessions()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	count, _ = sessions.FindId("old-session").Count()
+	assert.Equal(t, 0, count)
+
+	fetched, err := mgoStorage.GetUserBySession("old-session")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, fetched.ID)
+
+	migrated, _ = mgoStorage.RehashSessions()
+	assert.Equal(t, 0, migrated)
+}
+
+func TestLegacySessions(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	// Versions before session collection kept ids in user document
+	msession, _ := mgo.DialWithTimeout(dbUrl, 1*time.Second)
+	defer msession.Close()
+	users := msession.DB(dbName).C("user")
+	users.Insert(bson.M{"email": "bob@example.com", "session": []string{"legacy-1", "legacy-2"}})
+
+	user, err := mgoStorage.GetUserBySession("legacy-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "bob@example.com", user.Email)
+	}
+
+	// Session is moved to session collection
+	count, _ := users.Find(bson.M{"session": "legacy-1"}).Count()
+	assert.Equal(t, 0, count)
+	sessions, err := mgoStorage.ListSessions("bob@example.com")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	_, err = mgoStorage.GetUserBySession("legacy-1")
+	assert.NoError(t, err)
+
+	assert.NoError(t, mgoStorage.DeleteSession("legacy-2"))
+	_, err = mgoStorage.GetUserBySession("legacy-2")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+	assert.Equal(t, core.ErrSessionNotFound, mgoStorage.DeleteSession("legacy-2"))
+}
+
+func TestRehashLegacySessions(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+	mgoStorage.UseSessionHashKey([]byte("secret"))
+
+	msession, _ := mgo.DialWithTimeout(dbUrl, 1*time.Second)
+	defer msession.Close()
+	users := msession.DB(dbName).C("user")
+	users.Insert(bson.M{"email": "bob@example.com", "session": []string{"legacy-1", "legacy-2"}})
+
+	migrated, err := mgoStorage.RehashSessions()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+
+	// Array is gone and sessions are hashed rows
+	count, _ := users.Find(bson.M{"session": bson.M{"$exists": true}}).Count()
+	assert.Equal(t, 0, count)
+	sessions := msession.DB(dbName).C("session")
+	count, _ = sessions.FindId("legacy-1").Count()
+	assert.Equal(t, 0, count)
+	count, _ = sessions.Find(bson.M{"hashed": true}).Count()
+	assert.Equal(t, 2, count)
+
+	for _, sessionId := range []string{"legacy-1", "legacy-2"} {
+		user, err := mgoStorage.GetUserBySession(sessionId)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "bob@example.com", user.Email)
+		}
+	}
+
+	migrated, _ = mgoStorage.RehashSessions()
+	assert.Equal(t, 0, migrated)
+}
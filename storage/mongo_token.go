@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/hashtock/auth/core"
+)
+
+type mongoAccessToken struct {
+	Id       string        `bson:"_id"`
+	Hash     string        `bson:"hash"`
+	User     bson.ObjectId `bson:"user"`
+	Name     string        `bson:"name"`
+	Scopes   []string      `bson:"scopes"`
+	Created  time.Time     `bson:"created"`
+	LastUsed time.Time     `bson:"last_used"`
+	Expires  time.Time     `bson:"expires"`
+}
+
+func (m *MgoStorage) tokenColection() *mgo.Collection {
+	session := m.session.Copy()
+	return session.DB(m.dbName).C(tokenColection)
+}
+
+func (m *MgoStorage) ensureTokenIndexes() error {
+	col := m.tokenColection()
+	defer col.Database.Session.Close()
+
+	expiryIndex := mgo.Index{
+		Key:         []string{"expires"},
+		ExpireAfter: time.Second,
+	}
+	if err := col.EnsureIndex(expiryIndex); err != nil {
+		return err
+	}
+
+	hashIndex := mgo.Index{
+		Key:    []string{"hash"},
+		Unique: true,
+	}
+	if err := col.EnsureIndex(hashIndex); err != nil {
+		return err
+	}
+
+	return col.EnsureIndexKey("user")
+}
+
+func (m *MgoStorage) AddAccessToken(email string, token core.AccessToken) error {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return err
+	}
+
+	col := m.tokenColection()
+	defer col.Database.Session.Close()
+
+	mToken := mongoAccessToken{
+		Id:       token.ID,
+		Hash:     token.Hash,
+		User:     mUser.Id,
+		Name:     token.Name,
+		Scopes:   token.Scopes,
+		Created:  token.Created,
+		LastUsed: token.LastUsed,
+		Expires:  token.Expires,
+	}
+	return col.Insert(&mToken)
+}
+
+func (m *MgoStorage) GetUserByAccessToken(hash string) (*core.User, *core.AccessToken, error) {
+	col := m.tokenColection()
+	defer col.Database.Session.Close()
+
+	now := time.Now()
+	selector := bson.M{
+		"hash":    hash,
+		"expires": bson.M{"$gt": now},
+	}
+
+	mToken := mongoAccessToken{}
+	err := col.Find(selector).One(&mToken)
+	if err == mgo.ErrNotFound {
+		return nil, nil, core.ErrTokenNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	mUser := mongoUser{}
+	err = col.Database.C(userColection).FindId(mToken.User).One(&mUser)
+	if err == mgo.ErrNotFound || mUser.Disabled {
+		return nil, nil, core.ErrTokenNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	// Failing to record usage should not fail authentication
+	col.UpdateId(mToken.Id, bson.M{"$set": bson.M{"last_used": now}})
+	mToken.LastUsed = now
+
+	token := mToken.token()
+	return mUser.user(), &token, nil
+}
+
+func (m *MgoStorage) ListAccessTokens(email string) ([]core.AccessToken, error) {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return nil, err
+	}
+
+	col := m.tokenColection()
+	defer col.Database.Session.Close()
+
+	selector := bson.M{
+		"user":    mUser.Id,
+		"expires": bson.M{"$gt": time.Now()},
+	}
+
+	mTokens := []mongoAccessToken{}
+	if err := col.Find(selector).Sort("created").All(&mTokens); err != nil {
+		return nil, err
+	}
+
+	tokens := make([]core.AccessToken, 0, len(mTokens))
+	for _, mToken := range mTokens {
+		tokens = append(tokens, mToken.token())
+	}
+	return tokens, nil
+}
+
+func (m *MgoStorage) RevokeAccessToken(email string, id string) error {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return err
+	}
+
+	col := m.tokenColection()
+	defer col.Database.Session.Close()
+
+	err = col.Remove(bson.M{"_id": id, "user": mUser.Id})
+	if err == mgo.ErrNotFound {
+		err = core.ErrTokenNotFound
+	}
+	return err
+}
+
+// token converts stored token, leaving hash of its secret behind
+func (t mongoAccessToken) token() core.AccessToken {
+	return core.AccessToken{
+		ID:       t.Id,
+		Name:     t.Name,
+		Scopes:   t.Scopes,
+		Created:  t.Created,
+		LastUsed: t.LastUsed,
+		Expires:  t.Expires,
+	}
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/hashtock/auth/core"
+)
+
+func (m *MgoStorage) GetTwoFactor(email string) (*core.TwoFactor, error) {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return nil, err
+	} else if mUser.TwoFactor == nil {
+		return nil, core.ErrTwoFactorNotEnabled
+	}
+	return mUser.TwoFactor, nil
+}
+
+func (m *MgoStorage) SetTwoFactor(email string, secret string) error {
+	if _, err := m.getUser(email); err != nil {
+		return err
+	}
+
+	selector := bson.M{
+		"email":              email,
+		"two_factor.enabled": bson.M{"$ne": true},
+	}
+	change := bson.M{"$set": bson.M{"two_factor": core.TwoFactor{Secret: secret}}}
+	return m.updateTwoFactor(selector, change, core.ErrTwoFactorEnabled)
+}
+
+func (m *MgoStorage) EnableTwoFactor(email string, recoveryCodes []string) error {
+	selector := bson.M{
+		"email":      email,
+		"two_factor": bson.M{"$exists": true},
+	}
+	change := bson.M{
+		"$set": bson.M{
+			"two_factor.enabled":        true,
+			"two_factor.recovery_codes": recoveryCodes,
+		},
+	}
+	return m.updateTwoFactor(selector, change, core.ErrTwoFactorNotEnabled)
+}
+
+func (m *MgoStorage) DisableTwoFactor(email string) error {
+	selector := bson.M{
+		"email":      email,
+		"two_factor": bson.M{"$exists": true},
+	}
+	change := bson.M{"$unset": bson.M{"two_factor": ""}}
+	return m.updateTwoFactor(selector, change, core.ErrTwoFactorNotEnabled)
+}
+
+func (m *MgoStorage) UseTwoFactorStep(email string, step int64) error {
+	selector := bson.M{
+		"email":                email,
+		"two_factor.last_step": bson.M{"$lt": step},
+	}
+	change := bson.M{"$set": bson.M{"two_factor.last_step": step}}
+	return m.updateTwoFactor(selector, change, core.ErrInvalidCode)
+}
+
+func (m *MgoStorage) UseRecoveryCode(email string, hash string) error {
+	selector := bson.M{
+		"email":                     email,
+		"two_factor.recovery_codes": hash,
+	}
+	change := bson.M{"$pull": bson.M{"two_factor.recovery_codes": hash}}
+	return m.updateTwoFactor(selector, change, core.ErrInvalidCode)
+}
+
+// updateTwoFactor changes user matching selector, which is meant to check
+// the change is allowed. Returns notFound when it is not
+func (m *MgoStorage) updateTwoFactor(selector bson.M, change bson.M, notFound error) error {
+	col := m.userColection()
+	defer col.Database.Session.Close()
+
+	err := col.Update(selector, change)
+	if err == mgo.ErrNotFound {
+		err = notFound
+	}
+	return err
+}
+
+func (m *MgoStorage) GetPendingUser(sessionId string) (*core.User, error) {
+	sessionCol := m.sessionColection()
+	defer sessionCol.Database.Session.Close()
+
+	selector := bson.M{
+		"_id":                m.sessionHash(sessionId),
+		"two_factor_pending": true,
+		"expires":            bson.M{"$gt": time.Now()},
+	}
+
+	mSession := mongoSession{}
+	err := sessionCol.Find(selector).One(&mSession)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	mUser := mongoUser{}
+	err = sessionCol.Database.C(userColection).FindId(mSession.User).One(&mUser)
+	if err == mgo.ErrNotFound || mUser.Disabled {
+		return nil, core.ErrSessionNotFound
+	}
+	return mUser.user(), err
+}
+
+func (m *MgoStorage) SetTwoFactorPending(sessionId string, pending bool, expires time.Time) error {
+	col := m.sessionColection()
+	defer col.Database.Session.Close()
+
+	selector := bson.M{
+		"_id":     m.sessionHash(sessionId),
+		"expires": bson.M{"$gt": time.Now()},
+	}
+	change := bson.M{
+		"$set": bson.M{
+			"two_factor_pending": pending,
+			"expires":            expires,
+		},
+	}
+
+	err := col.Update(selector, change)
+	if err == mgo.ErrNotFound {
+		err = core.ErrSessionNotFound
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/hashtock/auth/core"
+)
+
+const challengeColection = "webauthn_challenge"
+
+type mongoChallenge struct {
+	Hash     string    `bson:"_id"`
+	Email    string    `bson:"email,omitempty"`
+	Ceremony string    `bson:"ceremony"`
+	Expires  time.Time `bson:"expires"`
+}
+
+func (m *MgoStorage) challengeColection() *mgo.Collection {
+	session := m.session.Copy()
+	return session.DB(m.dbName).C(challengeColection)
+}
+
+func (m *MgoStorage) ensureWebAuthnIndexes(db *mgo.Database, expiryIndex mgo.Index) error {
+	// Credential can't be registered by two users
+	credentialIndex := mgo.Index{
+		Key:    []string{"webauthn_credentials.id"},
+		Unique: true,
+		Sparse: true,
+	}
+	if err := db.C(userColection).EnsureIndex(credentialIndex); err != nil {
+		return err
+	}
+
+	return db.C(challengeColection).EnsureIndex(expiryIndex)
+}
+
+func (m *MgoStorage) AddCredential(email string, credential core.WebAuthnCredential) error {
+	if _, err := m.getUser(email); err != nil {
+		return err
+	}
+
+	col := m.userColection()
+	defer col.Database.Session.Close()
+
+	selector := bson.M{
+		"email":                   email,
+		"webauthn_credentials.id": bson.M{"$ne": credential.ID},
+	}
+	err := col.Update(selector, bson.M{"$push": bson.M{"webauthn_credentials": credential}})
+	if err == mgo.ErrNotFound || mgo.IsDup(err) {
+		return core.ErrCredentialExists
+	}
+	return err
+}
+
+func (m *MgoStorage) ListCredentials(email string) ([]core.WebAuthnCredential, error) {
+	mUser, err := m.getUser(email)
+	if err != nil {
+		return nil, err
+	}
+
+	if mUser.Passkeys == nil {
+		return []core.WebAuthnCredential{}, nil
+	}
+	return mUser.Passkeys, nil
+}
+
+func (m *MgoStorage) GetCredential(id string) (string, *core.WebAuthnCredential, error) {
+	col := m.userColection()
+	defer col.Database.Session.Close()
+
+	mUser := mongoUser{}
+	err := col.Find(bson.M{"webauthn_credentials.id": id}).One(&mUser)
+	if err == mgo.ErrNotFound {
+		return "", nil, core.ErrCredentialNotFound
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	for _, credential := range mUser.Passkeys {
+		if credential.ID == id {
+			return mUser.Email, &credential, nil
+		}
+	}
+	return "", nil, core.ErrCredentialNotFound
+}
+
+func (m *MgoStorage) UseCredential(id string, signCount int64, used time.Time) error {
+	col := m.userColection()
+	defer col.Database.Session.Close()
+
+	// Authenticators which don't count signatures always report zero
+	countMatch := interface{}(bson.M{"$lt": signCount})
+	if signCount == 0 {
+		countMatch = 0
+	}
+	selector := bson.M{
+		"webauthn_credentials": bson.M{
+			"$elemMatch": bson.M{"id": id, "sign_count": countMatch},
+		},
+	}
+	change := bson.M{
+		"$set": bson.M{
+			"webauthn_credentials.$.sign_count": signCount,
+			"webauthn_credentials.$.last_used":  used,
+		},
+	}
+
+	err := col.Update(selector, change)
+	if err != mgo.ErrNotFound {
+		return err
+	}
+
+	count, err := col.Find(bson.M{"webauthn_credentials.id": id}).Count()
+	if err != nil {
+		return err
+	} else if count > 0 {
+		return core.ErrSignCount
+	}
+	return core.ErrCredentialNotFound
+}
+
+func (m *MgoStorage) DeleteCredential(email string, id string) error {
+	col := m.userColection()
+	defer col.Database.Session.Close()
+
+	selector := bson.M{
+		"email":                   email,
+		"webauthn_credentials.id": id,
+	}
+	err := col.Update(selector, bson.M{"$pull": bson.M{"webauthn_credentials": bson.M{"id": id}}})
+	if err == mgo.ErrNotFound {
+		return core.ErrCredentialNotFound
+	}
+	return err
+}
+
+func (m *MgoStorage) AddWebAuthnChallenge(challenge core.WebAuthnChallenge) error {
+	col := m.challengeColection()
+	defer col.Database.Session.Close()
+
+	mChallenge := mongoChallenge{
+		Hash:     challenge.Hash,
+		Email:    challenge.Email,
+		Ceremony: challenge.Ceremony,
+		Expires:  challenge.Expires,
+	}
+	return col.Insert(&mChallenge)
+}
+
+func (m *MgoStorage) TakeWebAuthnChallenge(hash string) (*core.WebAuthnChallenge, error) {
+	col := m.challengeColection()
+	defer col.Database.Session.Close()
+
+	mChallenge := mongoChallenge{}
+	_, err := col.FindId(hash).Apply(mgo.Change{Remove: true}, &mChallenge)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrChallengeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	challenge := core.WebAuthnChallenge{
+		Hash:     mChallenge.Hash,
+		Email:    mChallenge.Email,
+		Ceremony: mChallenge.Ceremony,
+		Expires:  mChallenge.Expires,
+	}
+	if challenge.Expired(time.Now()) {
+		return nil, core.ErrChallengeNotFound
+	}
+	return &challenge, nil
+}
@@ -0,0 +1,151 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+func newOAuthToken(hash string, kind string, family string, ttl time.Duration) core.OAuthToken {
+	now := time.Now()
+	return core.OAuthToken{
+		Hash:     hash,
+		Kind:     kind,
+		Family:   family,
+		ClientID: "client-1",
+		Email:    "bob@example.com",
+		Scopes:   []string{"billing:read"},
+		Created:  now,
+		Expires:  now.Add(ttl),
+	}
+}
+
+type oauthStorage interface {
+	adminStorage
+	core.OAuthStorer
+}
+
+func checkOAuth(t *testing.T, s oauthStorage) {
+	s.AddUserToSession(newSession("session-1"), &core.User{Email: "bob@example.com"})
+
+	// Clients
+	assert.NoError(t, s.AddClient(core.OAuthClient{
+		ID:           "client-1",
+		Name:         "Reports",
+		SecretHash:   "secret-hash",
+		RedirectURIs: []string{"https://reports.example.com/cb"},
+		Scopes:       []string{"billing:read"},
+	}))
+	assert.NoError(t, s.AddClient(core.OAuthClient{ID: "client-2", Name: "Mobile", Public: true}))
+
+	client, err := s.GetClient("client-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-hash", client.SecretHash)
+	assert.Equal(t, []string{"https://reports.example.com/cb"}, client.RedirectURIs)
+	_, err = s.GetClient("client-3")
+	assert.Equal(t, core.ErrClientNotFound, err)
+
+	clients, err := s.ListClients()
+	assert.NoError(t, err)
+	if assert.Len(t, clients, 2) {
+		assert.Equal(t, "Mobile", clients[0].Name)
+		assert.True(t, clients[0].Public)
+		assert.Empty(t, clients[1].SecretHash)
+	}
+
+	// Codes can be taken once
+	assert.NoError(t, s.AddAuthorizationCode(core.AuthorizationCode{
+		Hash:          "code-1",
+		ClientID:      "client-1",
+		Email:         "bob@example.com",
+		Scopes:        []string{"billing:read"},
+		CodeChallenge: "challenge",
+		Nonce:         "nonce",
+		Expires:       time.Now().Add(time.Minute),
+	}))
+	assert.NoError(t, s.AddAuthorizationCode(core.AuthorizationCode{Hash: "code-2", Expires: time.Now().Add(-time.Minute)}))
+
+	code, err := s.TakeAuthorizationCode("code-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob@example.com", code.Email)
+	assert.Equal(t, "challenge", code.CodeChallenge)
+	assert.Equal(t, "nonce", code.Nonce)
+	_, err = s.TakeAuthorizationCode("code-1")
+	assert.Equal(t, core.ErrCodeNotFound, err)
+	_, err = s.TakeAuthorizationCode("code-2")
+	assert.Equal(t, core.ErrCodeNotFound, err) // Expired
+
+	// Tokens
+	assert.NoError(t, s.AddOAuthToken(newOAuthToken("access-1", core.OAuthAccessToken, "family-1", time.Hour)))
+	assert.NoError(t, s.AddOAuthToken(newOAuthToken("refresh-1", core.OAuthRefreshToken, "family-1", time.Hour)))
+	assert.NoError(t, s.AddOAuthToken(newOAuthToken("refresh-2", core.OAuthRefreshToken, "family-2", time.Hour)))
+	assert.NoError(t, s.AddOAuthToken(newOAuthToken("expired", core.OAuthAccessToken, "family-2", -time.Hour)))
+
+	token, err := s.GetOAuthToken("access-1")
+	assert.NoError(t, err)
+	assert.Equal(t, core.OAuthAccessToken, token.Kind)
+	assert.Equal(t, []string{"billing:read"}, token.Scopes)
+	_, err = s.GetOAuthToken("expired")
+	assert.Equal(t, core.ErrOAuthTokenNotFound, err)
+
+	_, err = s.UseRefreshToken("access-1", "client-1")
+	assert.Equal(t, core.ErrOAuthTokenNotFound, err) // Not a refresh token
+	_, err = s.UseRefreshToken("refresh-1", "client-2")
+	assert.Equal(t, core.ErrOAuthTokenNotFound, err) // Issued to another client
+	token, err = s.UseRefreshToken("refresh-1", "client-1")
+	assert.NoError(t, err)
+	assert.False(t, token.Used)
+	token, err = s.UseRefreshToken("refresh-1", "client-1")
+	assert.Equal(t, core.ErrOAuthTokenReused, err)
+	assert.Equal(t, "family-1", token.Family)
+
+	assert.NoError(t, s.RevokeOAuthFamily("family-1"))
+	_, err = s.GetOAuthToken("access-1")
+	assert.Equal(t, core.ErrOAuthTokenNotFound, err)
+	_, err = s.GetOAuthToken("refresh-2")
+	assert.NoError(t, err)
+
+	// Consent
+	granted, err := s.Consent("bob@example.com", "client-1")
+	assert.NoError(t, err)
+	assert.Len(t, granted, 0)
+	assert.NoError(t, s.GrantConsent("bob@example.com", "client-1", []string{"billing:read"}))
+	assert.NoError(t, s.GrantConsent("bob@example.com", "client-1", []string{"billing:read", "billing:write"}))
+	granted, _ = s.Consent("bob@example.com", "client-1")
+	assert.Equal(t, []string{"billing:read", "billing:write"}, granted)
+
+	// Deleting client removes what was given to it
+	assert.NoError(t, s.DeleteClient("client-1"))
+	assert.Equal(t, core.ErrClientNotFound, s.DeleteClient("client-1"))
+	_, err = s.GetOAuthToken("refresh-2")
+	assert.Equal(t, core.ErrOAuthTokenNotFound, err)
+	granted, _ = s.Consent("bob@example.com", "client-1")
+	assert.Len(t, granted, 0)
+
+	// So does deleting user
+	assert.NoError(t, s.GrantConsent("bob@example.com", "client-2", []string{"profile"}))
+	assert.NoError(t, s.AddOAuthToken(newOAuthToken("access-2", core.OAuthAccessToken, "family-3", time.Hour)))
+	assert.NoError(t, s.DeleteUser("bob@example.com"))
+	_, err = s.GetOAuthToken("access-2")
+	assert.Equal(t, core.ErrOAuthTokenNotFound, err)
+	granted, _ = s.Consent("bob@example.com", "client-2")
+	assert.Len(t, granted, 0)
+}
+
+func TestMemStorageOAuth(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkOAuth(t, memStorage)
+}
+
+func TestOAuth(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkOAuth(t, mgoStorage)
+}
@@ -0,0 +1,87 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+type passwordStorage interface {
+	adminStorage
+	core.IdentityStorer
+	core.PasswordStorer
+}
+
+func checkPasswords(t *testing.T, s passwordStorage) {
+	bob := &core.User{Name: "Bob", Email: "bob@example.com"}
+	assert.NoError(t, s.AddPasswordUser(bob, "hash-1"))
+	assert.NotEmpty(t, bob.ID)
+	assert.Equal(t, core.ErrUserExists, s.AddPasswordUser(&core.User{Email: "bob@example.com"}, "hash-2"))
+
+	password, err := s.GetPassword("bob@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "hash-1", password.Hash)
+	assert.False(t, password.Changed.IsZero())
+	_, err = s.GetPassword("alice@example.com")
+	assert.Equal(t, core.ErrUserNotFound, err)
+
+	// Password is locked after too many failures in a row
+	lockUntil := time.Now().Add(time.Hour)
+	assert.NoError(t, s.PasswordFailed("bob@example.com", 3, lockUntil))
+	assert.NoError(t, s.PasswordFailed("bob@example.com", 3, lockUntil))
+	assert.NoError(t, s.PasswordSucceeded("bob@example.com"))
+	assert.NoError(t, s.PasswordFailed("bob@example.com", 3, lockUntil))
+	assert.NoError(t, s.PasswordFailed("bob@example.com", 3, lockUntil))
+	password, _ = s.GetPassword("bob@example.com")
+	assert.Equal(t, 2, password.Failures)
+	assert.False(t, password.Locked(time.Now()))
+
+	assert.NoError(t, s.PasswordFailed("bob@example.com", 3, lockUntil))
+	password, _ = s.GetPassword("bob@example.com")
+	assert.Equal(t, 0, password.Failures)
+	assert.True(t, password.Locked(time.Now()))
+
+	// New password unlocks it
+	assert.NoError(t, s.SetPassword("bob@example.com", "hash-3"))
+	password, _ = s.GetPassword("bob@example.com")
+	assert.Equal(t, "hash-3", password.Hash)
+	assert.False(t, password.Locked(time.Now()))
+	assert.Equal(t, core.ErrUserNotFound, s.SetPassword("alice@example.com", "hash"))
+
+	// Users logging in with providers have no password
+	s.AddUserToSession(newSession("session-1"), &core.User{Email: "alice@example.com"})
+	_, err = s.GetPassword("alice@example.com")
+	assert.Equal(t, core.ErrNoPassword, err)
+	assert.Equal(t, core.ErrNoPassword, s.PasswordFailed("alice@example.com", 3, lockUntil))
+	assert.Equal(t, core.ErrNoPassword, s.PasswordSucceeded("alice@example.com"))
+
+	// Verified email takes account over from whoever registered it
+	google := core.Identity{Provider: "gplus", UserID: "g-1", Email: "bob@example.com", EmailVerified: true}
+	_, err = s.AddIdentityToSession(newSession("session-2"), google, bob)
+	assert.NoError(t, err)
+	_, err = s.GetPassword("bob@example.com")
+	assert.Equal(t, core.ErrNoPassword, err)
+
+	// Password is deleted with user
+	assert.NoError(t, s.AddPasswordUser(&core.User{Email: "carol@example.com"}, "hash"))
+	assert.NoError(t, s.DeleteUser("carol@example.com"))
+	assert.NoError(t, s.AddPasswordUser(&core.User{Email: "carol@example.com"}, "hash"))
+}
+
+func TestMemStoragePasswords(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkPasswords(t, memStorage)
+}
+
+func TestPasswords(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkPasswords(t, mgoStorage)
+}
@@ -0,0 +1,108 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+// Users manage their own sessions, knowing only the one they use
+func checkUserSessions(t *testing.T, s core.UserSessioner) {
+	bob := &core.User{Email: "bob@example.com"}
+	laptop := newSession("laptop")
+	laptop.Created = laptop.Created.Add(-time.Minute) // Listed first
+	laptop.UserAgent = "Firefox"
+	laptop.IP = "192.0.2.1"
+	laptop.Provider = "github"
+	s.AddUserToSession(laptop, bob)
+	s.AddUserToSession(newSession("phone"), bob)
+	s.AddUserToSession(newSession("tablet"), bob)
+	s.AddUserToSession(newSession("alice"), &core.User{Email: "alice@example.com"})
+
+	sessions, err := s.ListUserSessions("laptop")
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 3) {
+		assert.Equal(t, "Firefox", sessions[0].UserAgent)
+		assert.Equal(t, "192.0.2.1", sessions[0].IP)
+		assert.Equal(t, "github", sessions[0].Provider)
+		assert.True(t, sessions[0].Current)
+		assert.Empty(t, sessions[0].ID)
+		assert.False(t, sessions[1].Current)
+	}
+	_, err = s.ListUserSessions("unknown")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	// Only own sessions can be revoked
+	alice, _ := s.ListUserSessions("alice")
+	assert.Equal(t, core.ErrSessionNotFound, s.RevokeUserSession("laptop", alice[0].PublicID))
+	assert.NoError(t, s.RevokeUserSession("laptop", sessions[1].PublicID))
+	_, err = s.GetUserBySession("phone")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	assert.NoError(t, s.RevokeOtherSessions("laptop"))
+	_, err = s.GetUserBySession("tablet")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+	_, err = s.GetUserBySession("laptop")
+	assert.NoError(t, err)
+	_, err = s.GetUserBySession("alice")
+	assert.NoError(t, err)
+
+	assert.Equal(t, core.ErrSessionNotFound, s.RevokeOtherSessions("tablet"))
+}
+
+// Every use of session is recorded, at most once per SessionUseInterval
+func checkSessionLastUsed(t *testing.T, s core.UserSessioner) {
+	bob := &core.User{Email: "bob@example.com"}
+	idle := newSession("idle")
+	idle.Created = idle.Created.Add(-time.Minute) // Listed first
+	idle.LastUsed = idle.LastUsed.Add(-time.Hour)
+	s.AddUserToSession(idle, bob)
+	busy := newSession("busy")
+	busy.LastUsed = busy.LastUsed.Add(-time.Second)
+	s.AddUserToSession(busy, bob)
+
+	before := time.Now()
+	_, err := s.GetUserBySession("idle")
+	assert.NoError(t, err)
+	_, err = s.GetUserBySession("busy")
+	assert.NoError(t, err)
+
+	sessions, err := s.ListUserSessions("idle")
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 2) {
+		assert.False(t, sessions[0].LastUsed.Before(before.Add(-time.Millisecond)))
+		assert.True(t, sessions[1].LastUsed.Before(before))
+	}
+}
+
+func TestMemStorageSessionLastUsed(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkSessionLastUsed(t, memStorage)
+}
+
+func TestSessionLastUsed(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkSessionLastUsed(t, mgoStorage)
+}
+
+func TestMemStorageUserSessions(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkUserSessions(t, memStorage)
+}
+
+func TestUserSessions(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkUserSessions(t, mgoStorage)
+}
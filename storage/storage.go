@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+// SessionUseInterval is how often use of session is recorded in its last
+// used time, so busy sessions don't turn every lookup into a write
+var SessionUseInterval = time.Minute
+
+// newPublicId generates identifier for users and sessions which have none
+func newPublicId() string {
+	id := make([]byte, 12)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// refreshProfile updates stored user with name and avatar reported by provider
+func refreshProfile(stored *core.User, user *core.User) {
+	if user.Name != "" {
+		stored.Name = user.Name
+	}
+	if user.Avatar != "" {
+		stored.Avatar = user.Avatar
+	}
+}
@@ -0,0 +1,79 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+func newAccessToken(id string, ttl time.Duration) core.AccessToken {
+	now := time.Now()
+	return core.AccessToken{
+		ID:      id,
+		Name:    "token " + id,
+		Scopes:  []string{"billing:read"},
+		Hash:    "hash-" + id,
+		Created: now,
+		Expires: now.Add(ttl),
+	}
+}
+
+type tokenStorage interface {
+	adminStorage
+	core.AccessTokenStorer
+}
+
+func checkAccessTokens(t *testing.T, s tokenStorage) {
+	s.AddUserToSession(newSession("session-1"), &core.User{Email: "bob@example.com"})
+
+	assert.NoError(t, s.AddAccessToken("bob@example.com", newAccessToken("1", time.Hour)))
+	assert.NoError(t, s.AddAccessToken("bob@example.com", newAccessToken("2", -time.Hour)))
+	assert.Equal(t, core.ErrUserNotFound, s.AddAccessToken("alice@example.com", newAccessToken("3", time.Hour)))
+
+	user, token, err := s.GetUserByAccessToken("hash-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob@example.com", user.Email)
+	assert.Equal(t, "1", token.ID)
+	assert.Empty(t, token.Hash)
+	assert.False(t, token.LastUsed.IsZero())
+
+	_, _, err = s.GetUserByAccessToken("hash-2")
+	assert.Equal(t, core.ErrTokenNotFound, err) // Expired
+	_, _, err = s.GetUserByAccessToken("1")
+	assert.Equal(t, core.ErrTokenNotFound, err) // Only hash is accepted
+
+	listed, err := s.ListAccessTokens("bob@example.com")
+	assert.NoError(t, err)
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, "token 1", listed[0].Name)
+		assert.Equal(t, []string{"billing:read"}, listed[0].Scopes)
+		assert.Empty(t, listed[0].Hash)
+	}
+
+	assert.NoError(t, s.DisableUser("bob@example.com"))
+	_, _, err = s.GetUserByAccessToken("hash-1")
+	assert.Equal(t, core.ErrTokenNotFound, err)
+
+	assert.Equal(t, core.ErrTokenNotFound, s.RevokeAccessToken("bob@example.com", "3"))
+	assert.NoError(t, s.RevokeAccessToken("bob@example.com", "1"))
+	listed, _ = s.ListAccessTokens("bob@example.com")
+	assert.Len(t, listed, 0)
+}
+
+func TestMemStorageAccessTokens(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkAccessTokens(t, memStorage)
+}
+
+func TestAccessTokens(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkAccessTokens(t, mgoStorage)
+}
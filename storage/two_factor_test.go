@@ -0,0 +1,141 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+type twoFactorStorage interface {
+	adminStorage
+	core.TwoFactorStorer
+}
+
+func checkTwoFactor(t *testing.T, s twoFactorStorage) {
+	s.AddUserToSession(newSession("session-1"), &core.User{Email: "bob@example.com"})
+
+	_, err := s.GetTwoFactor("bob@example.com")
+	assert.Equal(t, core.ErrTwoFactorNotEnabled, err)
+	_, err = s.GetTwoFactor("alice@example.com")
+	assert.Equal(t, core.ErrUserNotFound, err)
+	assert.Equal(t, core.ErrUserNotFound, s.SetTwoFactor("alice@example.com", "secret"))
+
+	// Secret can be replaced until enrolling is complete
+	assert.NoError(t, s.SetTwoFactor("bob@example.com", "secret-1"))
+	assert.NoError(t, s.SetTwoFactor("bob@example.com", "secret-2"))
+	twoFactor, err := s.GetTwoFactor("bob@example.com")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "secret-2", twoFactor.Secret)
+		assert.False(t, twoFactor.Enabled)
+	}
+
+	assert.NoError(t, s.EnableTwoFactor("bob@example.com", []string{"hash-1", "hash-2"}))
+	assert.Equal(t, core.ErrTwoFactorEnabled, s.SetTwoFactor("bob@example.com", "secret-3"))
+	twoFactor, _ = s.GetTwoFactor("bob@example.com")
+	assert.True(t, twoFactor.Enabled)
+	assert.Equal(t, "secret-2", twoFactor.Secret)
+	assert.Equal(t, []string{"hash-1", "hash-2"}, twoFactor.RecoveryCodes)
+
+	// Codes can't be reused
+	assert.NoError(t, s.UseTwoFactorStep("bob@example.com", 100))
+	assert.Equal(t, core.ErrInvalidCode, s.UseTwoFactorStep("bob@example.com", 100))
+	assert.Equal(t, core.ErrInvalidCode, s.UseTwoFactorStep("bob@example.com", 99))
+	assert.NoError(t, s.UseTwoFactorStep("bob@example.com", 101))
+
+	assert.NoError(t, s.UseRecoveryCode("bob@example.com", "hash-1"))
+	assert.Equal(t, core.ErrInvalidCode, s.UseRecoveryCode("bob@example.com", "hash-1"))
+	assert.Equal(t, core.ErrInvalidCode, s.UseRecoveryCode("bob@example.com", "hash-3"))
+	twoFactor, _ = s.GetTwoFactor("bob@example.com")
+	assert.Equal(t, []string{"hash-2"}, twoFactor.RecoveryCodes)
+
+	assert.NoError(t, s.DisableTwoFactor("bob@example.com"))
+	assert.Equal(t, core.ErrTwoFactorNotEnabled, s.DisableTwoFactor("bob@example.com"))
+	_, err = s.GetTwoFactor("bob@example.com")
+	assert.Equal(t, core.ErrTwoFactorNotEnabled, err)
+
+	// Two-factor settings are deleted with user
+	assert.NoError(t, s.SetTwoFactor("bob@example.com", "secret"))
+	assert.NoError(t, s.DeleteUser("bob@example.com"))
+	s.AddUserToSession(newSession("session-2"), &core.User{Email: "bob@example.com"})
+	_, err = s.GetTwoFactor("bob@example.com")
+	assert.Equal(t, core.ErrTwoFactorNotEnabled, err)
+}
+
+func checkTwoFactorPending(t *testing.T, s twoFactorStorage) {
+	s.AddUserToSession(newSession("session-1"), &core.User{Email: "bob@example.com"})
+	s.AddUserToSession(newSession("session-2"), &core.User{Email: "bob@example.com"})
+
+	_, err := s.GetPendingUser("session-1")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	expires := time.Now().Add(time.Minute)
+	assert.NoError(t, s.SetTwoFactorPending("session-1", true, expires))
+	assert.Equal(t, core.ErrSessionNotFound, s.SetTwoFactorPending("session-3", true, expires))
+
+	// Pending session can't be used for anything else
+	_, err = s.GetUserBySession("session-1")
+	assert.Equal(t, core.ErrTwoFactorPending, err)
+	_, err = s.ListUserSessions("session-1")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+	assert.Equal(t, core.ErrSessionNotFound, s.RevokeOtherSessions("session-1"))
+
+	user, err := s.GetPendingUser("session-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "bob@example.com", user.Email)
+	}
+	_, err = s.GetPendingUser("session-2")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	sessions, _ := s.ListUserSessions("session-2")
+	if assert.Len(t, sessions, 2) {
+		for _, session := range sessions {
+			assert.Equal(t, !session.Current, session.TwoFactorPending)
+		}
+	}
+
+	assert.NoError(t, s.SetTwoFactorPending("session-1", false, expires.Add(time.Hour)))
+	user, err = s.GetUserBySession("session-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "bob@example.com", user.Email)
+	}
+	_, err = s.GetPendingUser("session-1")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	// Pending session of disabled user is gone
+	assert.NoError(t, s.SetTwoFactorPending("session-2", true, expires))
+	s.DisableUser("bob@example.com")
+	_, err = s.GetPendingUser("session-2")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+}
+
+func TestMemStorageTwoFactor(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkTwoFactor(t, memStorage)
+}
+
+func TestTwoFactor(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkTwoFactor(t, mgoStorage)
+}
+
+func TestMemStorageTwoFactorPending(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkTwoFactorPending(t, memStorage)
+}
+
+func TestTwoFactorPending(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkTwoFactorPending(t, mgoStorage)
+}
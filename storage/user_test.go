@@ -0,0 +1,60 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+// User keeps its ID, while profile follows the provider
+func checkUserProfile(t *testing.T, s identityStorage) {
+	user := &core.User{Name: "Bob", Email: "bob@example.com", Avatar: "http://example.com/bob.jpg"}
+	assert.NoError(t, s.AddUserToSession(newSession("session-1"), user))
+	assert.NotEmpty(t, user.ID)
+	id := user.ID
+
+	renamed := &core.User{Name: "Robert", Email: "bob@example.com"}
+	assert.NoError(t, s.AddUserToSession(newSession("session-2"), renamed))
+	assert.Equal(t, id, renamed.ID)
+
+	fetched, err := s.GetUserBySession("session-1")
+	assert.NoError(t, err)
+	assert.Equal(t, id, fetched.ID)
+	assert.Equal(t, "Robert", fetched.Name)
+	assert.Equal(t, "http://example.com/bob.jpg", fetched.Avatar) // Not reported, so kept
+
+	fetched, _ = s.GetUser("bob@example.com")
+	assert.Equal(t, id, fetched.ID)
+	list, _ := s.ListUsers("", 0, 10)
+	if assert.Len(t, list.Users, 1) {
+		assert.Equal(t, id, list.Users[0].ID)
+	}
+
+	// Login with linked identity refreshes profile too
+	identity := core.Identity{Provider: "gplus", UserID: "g-1", Email: "bob@example.com", EmailVerified: true}
+	fetched, err = s.AddIdentityToSession(newSession("session-3"), identity, &core.User{Name: "Bobby", Email: "bob@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, id, fetched.ID)
+	assert.Equal(t, "Bobby", fetched.Name)
+
+	alice := &core.User{Name: "Alice", Email: "alice@example.com"}
+	s.AddUserToSession(newSession("session-4"), alice)
+	assert.NotEqual(t, id, alice.ID)
+}
+
+func TestMemStorageUserProfile(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkUserProfile(t, memStorage)
+}
+
+func TestUserProfile(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkUserProfile(t, mgoStorage)
+}
@@ -0,0 +1,181 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+)
+
+type webAuthnStorage interface {
+	passwordStorage
+	core.TwoFactorStorer
+	core.WebAuthnStorer
+}
+
+func newCredential(id string, created time.Time) core.WebAuthnCredential {
+	return core.WebAuthnCredential{
+		ID:        id,
+		Name:      "Key " + id,
+		PublicKey: []byte("key-" + id),
+		SignCount: 5,
+		Created:   created,
+	}
+}
+
+func checkWebAuthnCredentials(t *testing.T, s webAuthnStorage) {
+	s.AddUserToSession(newSession("session-1"), &core.User{Email: "bob@example.com"})
+	s.AddUserToSession(newSession("session-2"), &core.User{Email: "alice@example.com"})
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	assert.NoError(t, s.AddCredential("bob@example.com", newCredential("cred-2", now)))
+	assert.NoError(t, s.AddCredential("bob@example.com", newCredential("cred-1", now.Add(-time.Hour))))
+	assert.Equal(t, core.ErrUserNotFound, s.AddCredential("carol@example.com", newCredential("cred-3", now)))
+
+	// Credential belongs to one user
+	assert.Equal(t, core.ErrCredentialExists, s.AddCredential("bob@example.com", newCredential("cred-1", now)))
+	assert.Equal(t, core.ErrCredentialExists, s.AddCredential("alice@example.com", newCredential("cred-1", now)))
+
+	credentials, err := s.ListCredentials("bob@example.com")
+	assert.NoError(t, err)
+	if assert.Len(t, credentials, 2) {
+		assert.Equal(t, "cred-1", credentials[0].ID)
+		assert.Equal(t, []byte("key-cred-1"), credentials[0].PublicKey)
+		assert.Equal(t, "Key cred-2", credentials[1].Name)
+	}
+	credentials, err = s.ListCredentials("alice@example.com")
+	assert.NoError(t, err)
+	assert.Empty(t, credentials)
+	_, err = s.ListCredentials("carol@example.com")
+	assert.Equal(t, core.ErrUserNotFound, err)
+
+	email, credential, err := s.GetCredential("cred-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob@example.com", email)
+	assert.Equal(t, int64(5), credential.SignCount)
+	_, _, err = s.GetCredential("cred-3")
+	assert.Equal(t, core.ErrCredentialNotFound, err)
+
+	// Signature counter has to grow
+	assert.NoError(t, s.UseCredential("cred-2", 6, now))
+	assert.Equal(t, core.ErrSignCount, s.UseCredential("cred-2", 6, now))
+	assert.Equal(t, core.ErrSignCount, s.UseCredential("cred-2", 0, now))
+	assert.Equal(t, core.ErrCredentialNotFound, s.UseCredential("cred-3", 1, now))
+	_, credential, _ = s.GetCredential("cred-2")
+	assert.Equal(t, int64(6), credential.SignCount)
+	assert.True(t, now.Equal(credential.LastUsed))
+
+	// Unless authenticator doesn't count them
+	counterless := newCredential("cred-4", now)
+	counterless.SignCount = 0
+	assert.NoError(t, s.AddCredential("alice@example.com", counterless))
+	assert.NoError(t, s.UseCredential("cred-4", 0, now))
+	assert.NoError(t, s.UseCredential("cred-4", 0, now))
+
+	assert.Equal(t, core.ErrCredentialNotFound, s.DeleteCredential("alice@example.com", "cred-1"))
+	assert.NoError(t, s.DeleteCredential("bob@example.com", "cred-1"))
+	assert.Equal(t, core.ErrCredentialNotFound, s.DeleteCredential("bob@example.com", "cred-1"))
+	credentials, _ = s.ListCredentials("bob@example.com")
+	assert.Len(t, credentials, 1)
+
+	// Credentials are deleted with user
+	assert.NoError(t, s.DeleteUser("alice@example.com"))
+	_, _, err = s.GetCredential("cred-4")
+	assert.Equal(t, core.ErrCredentialNotFound, err)
+}
+
+func checkWebAuthnTakeover(t *testing.T, s webAuthnStorage) {
+	// Whoever registered email with password set up second factors
+	squatter := &core.User{Email: "bob@example.com"}
+	assert.NoError(t, s.AddPasswordUser(squatter, "hash"))
+	assert.NoError(t, s.AddCredential("bob@example.com", newCredential("cred-1", time.Now())))
+	assert.NoError(t, s.SetTwoFactor("bob@example.com", "secret"))
+
+	google := core.Identity{Provider: "gplus", UserID: "g-1", Email: "bob@example.com", EmailVerified: true}
+	_, err := s.AddIdentityToSession(newSession("session-1"), google, &core.User{Email: "bob@example.com"})
+	assert.NoError(t, err)
+
+	credentials, _ := s.ListCredentials("bob@example.com")
+	assert.Empty(t, credentials)
+	_, err = s.GetTwoFactor("bob@example.com")
+	assert.Equal(t, core.ErrTwoFactorNotEnabled, err)
+
+	// Second factors of user who logs in with another provider stay
+	assert.NoError(t, s.AddCredential("bob@example.com", newCredential("cred-2", time.Now())))
+	github := core.Identity{Provider: "github", UserID: "gh-1", Email: "bob@example.com", EmailVerified: true}
+	_, err = s.AddIdentityToSession(newSession("session-2"), github, &core.User{Email: "bob@example.com"})
+	assert.NoError(t, err)
+	credentials, _ = s.ListCredentials("bob@example.com")
+	assert.Len(t, credentials, 1)
+}
+
+func checkWebAuthnChallenges(t *testing.T, s core.WebAuthnStorer) {
+	challenge := core.WebAuthnChallenge{
+		Hash:     "hash-1",
+		Email:    "bob@example.com",
+		Ceremony: "register",
+		Expires:  time.Now().Add(time.Minute),
+	}
+	assert.NoError(t, s.AddWebAuthnChallenge(challenge))
+
+	taken, err := s.TakeWebAuthnChallenge("hash-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "bob@example.com", taken.Email)
+		assert.Equal(t, "register", taken.Ceremony)
+	}
+
+	// Challenge works once
+	_, err = s.TakeWebAuthnChallenge("hash-1")
+	assert.Equal(t, core.ErrChallengeNotFound, err)
+
+	challenge.Hash = "hash-2"
+	challenge.Expires = time.Now().Add(-time.Second)
+	assert.NoError(t, s.AddWebAuthnChallenge(challenge))
+	_, err = s.TakeWebAuthnChallenge("hash-2")
+	assert.Equal(t, core.ErrChallengeNotFound, err)
+}
+
+func TestMemStorageWebAuthnCredentials(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkWebAuthnCredentials(t, memStorage)
+}
+
+func TestWebAuthnCredentials(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkWebAuthnCredentials(t, mgoStorage)
+}
+
+func TestMemStorageWebAuthnTakeover(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkWebAuthnTakeover(t, memStorage)
+}
+
+func TestWebAuthnTakeover(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkWebAuthnTakeover(t, mgoStorage)
+}
+
+func TestMemStorageWebAuthnChallenges(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	defer memStorage.Close()
+
+	checkWebAuthnChallenges(t, memStorage)
+}
+
+func TestWebAuthnChallenges(t *testing.T) {
+	mgoStorage, dbName := newStorage(t)
+	defer destroyStorage(t, dbName)
+
+	checkWebAuthnChallenges(t, mgoStorage)
+}
@@ -0,0 +1,79 @@
+package totp_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/totp"
+)
+
+// rfcSecret is "12345678901234567890", secret of RFC 6238 test vectors
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// Last 6 digits of SHA1 test vectors from RFC 6238
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := totp.Code(rfcSecret, totp.Step(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code, unix)
+	}
+
+	_, err := totp.Code("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totp.Step(now)
+
+	for _, offset := range []int64{-1, 0, 1} {
+		code, _ := totp.Code(rfcSecret, step+offset)
+		verified, ok := totp.Verify(rfcSecret, code, now)
+		assert.True(t, ok, offset)
+		assert.Equal(t, step+offset, verified)
+	}
+
+	for _, offset := range []int64{-2, 2} {
+		code, _ := totp.Code(rfcSecret, step+offset)
+		_, ok := totp.Verify(rfcSecret, code, now)
+		assert.False(t, ok, offset)
+	}
+
+	for _, code := range []string{"", "05047", "0504711", "abcdef"} {
+		_, ok := totp.Verify(rfcSecret, code, now)
+		assert.False(t, ok, code)
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := totp.NewSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	other, _ := totp.NewSecret()
+	assert.NotEqual(t, secret, other)
+
+	_, err = totp.Code(secret, 1)
+	assert.NoError(t, err)
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(totp.URI("auth.example.com", "bob@example.com", rfcSecret))
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/auth.example.com:bob@example.com", uri.Path)
+	assert.Equal(t, rfcSecret, uri.Query().Get("secret"))
+	assert.Equal(t, "auth.example.com", uri.Query().Get("issuer"))
+}
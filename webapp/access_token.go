@@ -0,0 +1,210 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashtock/service-tools/serialize"
+
+	"github.com/hashtock/auth/core"
+)
+
+const (
+	DefaultAccessTokenTTL = 90 * 24 * time.Hour
+	MaxAccessTokenTTL     = 365 * 24 * time.Hour
+
+	// accessTokenPrefix makes tokens easy to spot, e.g. by secret scanners
+	accessTokenPrefix = "pat_"
+)
+
+var (
+	ErrAccessTokenNotAllowed = errors.New("Access tokens can't be managed with access token")
+	ErrAccessTokenName       = errors.New("Access token needs a name")
+	ErrAccessTokenExpiry     = errors.New("Access token expiry has to be positive duration, up to a year")
+	ErrAccessTokenScope      = errors.New("Access token can't have scopes its owner doesn't have")
+)
+
+// accessTokenRequest describes token to create, e.g.
+// {"name": "backup job", "scopes": ["billing:read"], "expires_in": "720h"}
+type accessTokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in"`
+}
+
+// createdAccessToken is the only time token's secret is shown
+type createdAccessToken struct {
+	core.AccessToken
+	Token string `json:"token"`
+}
+
+type accessTokenController struct {
+	Serializer serialize.Serializer
+	Storage    core.AccessTokenStorer
+	Users      core.Administrator
+	Auth       *authController
+}
+
+// bearerToken returns token from "Authorization: Bearer <token>" header
+func bearerToken(req *http.Request) string {
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// hashSecret is used for random, high entropy secrets, which need no salt nor slow hashing
+func hashSecret(secret string) string {
+	digest := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(digest[:])
+}
+
+// userFromAccessToken finds token owner, restricted to token's scopes
+func (a *authController) userFromAccessToken(secret string) (*core.User, error) {
+	if a.oauth != nil && strings.HasPrefix(secret, oauthAccessTokenPrefix) {
+		return a.oauth.userFromToken(secret)
+	} else if a.AccessTokens == nil {
+		return nil, core.ErrUserNotLoggedIn
+	}
+
+	user, token, err := a.AccessTokens.GetUserByAccessToken(hashSecret(secret))
+	if err == core.ErrTokenNotFound {
+		return nil, core.ErrUserNotLoggedIn
+	} else if err != nil {
+		return nil, err
+	}
+
+	return token.Restrict(user), nil
+}
+
+func (c *accessTokenController) list(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	c.listFor(rw, user.Email)
+}
+
+func (c *accessTokenController) create(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	c.createFor(rw, req, user)
+}
+
+func (c *accessTokenController) revoke(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	c.revokeFor(rw, user.Email, req.URL.Query().Get(":token"))
+}
+
+func (c *accessTokenController) adminList(rw http.ResponseWriter, req *http.Request) {
+	c.listFor(rw, req.URL.Query().Get(":email"))
+}
+
+func (c *accessTokenController) adminCreate(rw http.ResponseWriter, req *http.Request) {
+	if bearerToken(req) != "" {
+		c.Serializer.JSON(rw, http.StatusForbidden, ErrAccessTokenNotAllowed)
+		return
+	}
+
+	user, err := c.Users.GetUser(req.URL.Query().Get(":email"))
+	if err != nil {
+		c.writeResult(rw, nil, err)
+		return
+	}
+
+	c.createFor(rw, req, user)
+}
+
+func (c *accessTokenController) adminRevoke(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	c.revokeFor(rw, query.Get(":email"), query.Get(":token"))
+}
+
+func (c *accessTokenController) listFor(rw http.ResponseWriter, email string) {
+	tokens, err := c.Storage.ListAccessTokens(email)
+	c.writeResult(rw, tokens, err)
+}
+
+func (c *accessTokenController) createFor(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	request := accessTokenRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		c.Serializer.JSON(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	token, err := newAccessToken(request, user)
+	if err != nil {
+		c.Serializer.JSON(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	secret := accessTokenPrefix + randomString(32)
+	token.Hash = hashSecret(secret)
+	if err := c.Storage.AddAccessToken(user.Email, token); err != nil {
+		c.writeResult(rw, nil, err)
+		return
+	}
+
+	token.Hash = ""
+	c.Serializer.JSON(rw, http.StatusCreated, createdAccessToken{AccessToken: token, Token: secret})
+}
+
+func (c *accessTokenController) revokeFor(rw http.ResponseWriter, email string, id string) {
+	if err := c.Storage.RevokeAccessToken(email, id); err != nil {
+		c.writeResult(rw, nil, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (c *accessTokenController) writeResult(rw http.ResponseWriter, obj interface{}, err error) {
+	switch err {
+	case nil:
+		c.Serializer.JSON(rw, http.StatusOK, obj)
+	case core.ErrUserNotFound, core.ErrTokenNotFound:
+		c.Serializer.JSON(rw, http.StatusNotFound, err)
+	default:
+		c.Serializer.JSON(rw, http.StatusInternalServerError, err)
+	}
+}
+
+// newAccessToken validates request against token's owner
+func newAccessToken(request accessTokenRequest, owner *core.User) (core.AccessToken, error) {
+	if strings.TrimSpace(request.Name) == "" {
+		return core.AccessToken{}, ErrAccessTokenName
+	}
+
+	ttl := DefaultAccessTokenTTL
+	if request.ExpiresIn != "" {
+		var err error
+		ttl, err = time.ParseDuration(request.ExpiresIn)
+		if err != nil || ttl <= 0 || ttl > MaxAccessTokenTTL {
+			return core.AccessToken{}, ErrAccessTokenExpiry
+		}
+	}
+
+	scopes := []string{}
+	for _, scope := range request.Scopes {
+		if scope == core.ScopeAdmin && !owner.Admin || scope != core.ScopeAdmin && !owner.HasPermission(scope) {
+			return core.AccessToken{}, ErrAccessTokenScope
+		}
+		scopes = append(scopes, scope)
+	}
+
+	now := time.Now()
+	return core.AccessToken{
+		ID:      randomString(12),
+		Name:    strings.TrimSpace(request.Name),
+		Scopes:  scopes,
+		Created: now,
+		Expires: now.Add(ttl),
+	}, nil
+}
+
+func randomString(size int) string {
+	data := make([]byte, size)
+	rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
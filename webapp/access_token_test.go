@@ -0,0 +1,155 @@
+package webapp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/webapp"
+)
+
+type createdToken struct {
+	core.AccessToken
+	Token string `json:"token"`
+}
+
+func tokenRequest(handler http.Handler, method string, path string, sessionId string, bearer string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	if sessionId != "" {
+		req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func createToken(t *testing.T, handler http.Handler, path string, sessionId string, body string) createdToken {
+	token := createdToken{}
+	w := tokenRequest(handler, "POST", path, sessionId, "", body)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &token)
+	return token
+}
+
+func TestAccessTokenAuthenticatesWho(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+	memStorage.GrantPermission("bob@example.com", "billing:read")
+	memStorage.GrantPermission("bob@example.com", "billing:write")
+
+	token := createToken(t, handler, "/tokens/", "bob@example.com", `{"name": "backup", "scopes": ["billing:read"], "expires_in": "24h"}`)
+	assert.True(t, strings.HasPrefix(token.Token, "pat_"))
+	assert.Equal(t, "backup", token.Name)
+
+	user := core.User{}
+	w := tokenRequest(handler, "GET", "/who/", "", token.Token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "bob@example.com", user.Email)
+	assert.Equal(t, []string{"billing:read"}, user.Permissions) // Only permissions in token scopes
+
+	w = tokenRequest(handler, "GET", "/who/", "", "pat_wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Token takes precedence over cookie
+	w = tokenRequest(handler, "GET", "/who/", "alice@example.com", token.Token, "")
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "bob@example.com", user.Email)
+}
+
+func TestAccessTokenValidation(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	bodies := []string{
+		`{"scopes": []}`,
+		`{"name": "backup", "scopes": ["billing:read"]}`,
+		`{"name": "backup", "scopes": ["admin"]}`,
+		`{"name": "backup", "expires_in": "-1h"}`,
+		`{"name": "backup", "expires_in": "10000h"}`,
+		`not json`,
+	}
+	for _, body := range bodies {
+		w := tokenRequest(handler, "POST", "/tokens/", "bob@example.com", "", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	w := tokenRequest(handler, "POST", "/tokens/", "", "", `{"name": "backup"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAccessTokenCantManageTokens(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+	token := createToken(t, handler, "/tokens/", "admin@example.com", `{"name": "ci", "scopes": ["admin"]}`)
+
+	w := tokenRequest(handler, "POST", "/tokens/", "", token.Token, `{"name": "more"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = tokenRequest(handler, "GET", "/tokens/", "", token.Token, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = tokenRequest(handler, "POST", "/admin/users/bob@example.com/tokens/", "", token.Token, `{"name": "more"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestListAndRevokeAccessTokens(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+	token := createToken(t, handler, "/tokens/", "bob@example.com", `{"name": "backup"}`)
+
+	tokens := []core.AccessToken{}
+	w := tokenRequest(handler, "GET", "/tokens/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), token.Token)
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	if assert.Len(t, tokens, 1) {
+		assert.Equal(t, token.ID, tokens[0].ID)
+	}
+
+	// Other users can't revoke it
+	w = tokenRequest(handler, "DELETE", "/tokens/"+token.ID+"/", "alice@example.com", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = tokenRequest(handler, "DELETE", "/tokens/"+token.ID+"/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = tokenRequest(handler, "GET", "/who/", "", token.Token, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAdminAccessTokens(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	token := createToken(t, handler, "/admin/users/bob@example.com/tokens/", "admin@example.com", `{"name": "for bob"}`)
+
+	user := core.User{}
+	w := tokenRequest(handler, "GET", "/who/", "", token.Token, "")
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "bob@example.com", user.Email)
+
+	tokens := []core.AccessToken{}
+	w = tokenRequest(handler, "GET", "/admin/users/bob@example.com/tokens/", "admin@example.com", "", "")
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	assert.Len(t, tokens, 1)
+
+	w = tokenRequest(handler, "DELETE", "/admin/users/bob@example.com/tokens/"+token.ID+"/", "admin@example.com", "", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestAccessTokenAdminScope(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	withAdmin := createToken(t, handler, "/tokens/", "admin@example.com", `{"name": "ci", "scopes": ["admin"]}`)
+	withoutAdmin := createToken(t, handler, "/tokens/", "admin@example.com", `{"name": "ci"}`)
+
+	w := tokenRequest(handler, "GET", "/admin/users/", "", withAdmin.Token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = tokenRequest(handler, "GET", "/admin/users/", "", withoutAdmin.Token, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
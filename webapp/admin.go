@@ -0,0 +1,135 @@
+package webapp
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/hashtock/service-tools/serialize"
+
+	"github.com/hashtock/auth/core"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+type adminController struct {
+	Serializer serialize.Serializer
+	Storage    core.Administrator
+	Auth       *authController
+}
+
+// admin wraps handler so it is called only for logged in admins, who set up
+// second factor when it is required, and, for changes, only from trusted origins
+func (a *adminController) admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && !a.Auth.sameOrigin(req) {
+			a.Serializer.JSON(rw, http.StatusForbidden, ErrCrossOriginRequest)
+			return
+		}
+
+		user, err := a.Auth.currentUser(req)
+		if err != nil {
+			a.Auth.writeError(rw, err)
+			return
+		} else if !user.Admin {
+			a.Serializer.JSON(rw, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		if lacks, err := a.Auth.lacksSecondFactor(user); err != nil {
+			a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+			return
+		} else if lacks {
+			a.Serializer.JSON(rw, http.StatusForbidden, core.ErrTwoFactorRequired)
+			return
+		}
+
+		handler(rw, req)
+	}
+}
+
+func (a *adminController) writeResult(rw http.ResponseWriter, obj interface{}, err error) {
+	switch err {
+	case nil:
+		a.Serializer.JSON(rw, http.StatusOK, obj)
+	case core.ErrUserNotFound, core.ErrSessionNotFound, core.ErrTokenNotFound:
+		a.Serializer.JSON(rw, http.StatusNotFound, err)
+	default:
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+	}
+}
+
+func (a *adminController) listUsers(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	offset := intParam(query.Get("offset"), 0, 0, -1)
+	limit := intParam(query.Get("limit"), defaultPageSize, 1, maxPageSize)
+
+	users, err := a.Storage.ListUsers(query.Get("q"), offset, limit)
+	a.writeResult(rw, users, err)
+}
+
+func (a *adminController) getUser(rw http.ResponseWriter, req *http.Request) {
+	user, err := a.Storage.GetUser(req.URL.Query().Get(":email"))
+	a.writeResult(rw, user, err)
+}
+
+func (a *adminController) grantAdmin(rw http.ResponseWriter, req *http.Request) {
+	a.updateUser(rw, req, a.Storage.MakeUserAnAdmin)
+}
+
+func (a *adminController) revokeAdmin(rw http.ResponseWriter, req *http.Request) {
+	a.updateUser(rw, req, a.Storage.RevokeAdmin)
+}
+
+func (a *adminController) disableUser(rw http.ResponseWriter, req *http.Request) {
+	a.updateUser(rw, req, a.Storage.DisableUser)
+}
+
+func (a *adminController) revokeSessions(rw http.ResponseWriter, req *http.Request) {
+	a.updateUser(rw, req, a.Storage.RevokeSessions)
+}
+
+// updateUser applies the change and responds with updated user
+func (a *adminController) updateUser(rw http.ResponseWriter, req *http.Request, change func(email string) error) {
+	email := req.URL.Query().Get(":email")
+	if err := change(email); err != nil {
+		a.writeResult(rw, nil, err)
+		return
+	}
+
+	user, err := a.Storage.GetUser(email)
+	a.writeResult(rw, user, err)
+}
+
+func (a *adminController) listSessions(rw http.ResponseWriter, req *http.Request) {
+	sessions, err := a.Storage.ListSessions(req.URL.Query().Get(":email"))
+	a.writeResult(rw, sessions, err)
+}
+
+func (a *adminController) revokeSession(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	err := a.Storage.RevokeSession(query.Get(":email"), query.Get(":session"))
+	if err != nil {
+		a.writeResult(rw, nil, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// intParam parses value falling back to def, clamped to [min, max]. Negative max means no upper bound
+func intParam(value string, def int, min int, max int) int {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return def
+	}
+	if number < min {
+		return min
+	}
+	if max >= 0 && number > max {
+		return max
+	}
+	return number
+}
@@ -0,0 +1,187 @@
+package webapp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+	"github.com/hashtock/auth/webapp"
+)
+
+func makeAdminHandler(t *testing.T) (http.Handler, *storage.MemStorage) {
+	return makeAdminHandlerWithOptions(t, webapp.Options{})
+}
+
+func makeAdminHandlerWithOptions(t *testing.T, options webapp.Options) (http.Handler, *storage.MemStorage) {
+	memStorage := storage.NewMemStorage()
+	url, _ := url.Parse("http://localhost:1234/")
+
+	options.AppAddress = url
+	options.Storage = memStorage
+	options.Administrator = memStorage
+	options.AccessTokens = memStorage
+	options.Identities = memStorage
+	options.Passwords = memStorage
+	options.LoginLinks = memStorage
+	options.TwoFactor = memStorage
+	options.WebAuthn = memStorage
+	options.OAuth = memStorage
+	options.Serializer = new(serializerLog)
+	handler := webapp.Handlers(options)
+
+	users := []*core.User{
+		{Name: "Admin", Email: "admin@example.com"},
+		{Name: "Bob", Email: "bob@example.com"},
+		{Name: "Alice", Email: "alice@example.com"},
+	}
+	for i, user := range users {
+		memStorage.AddUserToSession(newSession(user.Email), user)
+		memStorage.AddUserToSession(newSession(user.Email+"-other"), user)
+		if i == 0 {
+			memStorage.MakeUserAnAdmin(user.Email)
+		}
+	}
+
+	return handler, memStorage
+}
+
+func adminRequest(handler http.Handler, method string, path string, sessionId string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	if sessionId != "" {
+		req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
+	}
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRequiresAdmin(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	w := adminRequest(handler, "GET", "/admin/users/", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = adminRequest(handler, "GET", "/admin/users/", "bob@example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = adminRequest(handler, "GET", "/admin/users/", "admin@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdminDisabledWithoutAdministrator(t *testing.T) {
+	handler, _, storage := makeHandler()
+	storage.AddUserToSession(newSession("session"), &core.User{Email: "admin@example.com", Admin: true})
+
+	w := adminRequest(handler, "GET", "/admin/users/", "session")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminListUsers(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	list := core.UserList{}
+	w := adminRequest(handler, "GET", "/admin/users/?limit=2", "admin@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, 3, list.Total)
+	assert.Equal(t, 2, list.Limit)
+	if assert.Len(t, list.Users, 2) {
+		assert.Equal(t, "admin@example.com", list.Users[0].Email)
+		assert.Equal(t, "alice@example.com", list.Users[1].Email)
+	}
+
+	w = adminRequest(handler, "GET", "/admin/users/?limit=2&offset=2", "admin@example.com")
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if assert.Len(t, list.Users, 1) {
+		assert.Equal(t, "bob@example.com", list.Users[0].Email)
+	}
+
+	w = adminRequest(handler, "GET", "/admin/users/?q=ALI", "admin@example.com")
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, 1, list.Total)
+	assert.Equal(t, 50, list.Limit)
+}
+
+func TestAdminGetUser(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	user := core.User{}
+	w := adminRequest(handler, "GET", "/admin/users/bob@example.com/", "admin@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "Bob", user.Name)
+	assert.NotEmpty(t, user.ID)
+
+	who := core.User{}
+	w = adminRequest(handler, "GET", "/who/", "bob@example.com")
+	json.Unmarshal(w.Body.Bytes(), &who)
+	assert.Equal(t, user.ID, who.ID)
+
+	w = adminRequest(handler, "GET", "/admin/users/nobody@example.com/", "admin@example.com")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminGrantAndRevokeAdmin(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+
+	w := adminRequest(handler, "PUT", "/admin/users/bob@example.com/admin/", "admin@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	user, _ := memStorage.GetUser("bob@example.com")
+	assert.True(t, user.Admin)
+
+	w = adminRequest(handler, "DELETE", "/admin/users/bob@example.com/admin/", "admin@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	user, _ = memStorage.GetUser("bob@example.com")
+	assert.False(t, user.Admin)
+
+	w = adminRequest(handler, "PUT", "/admin/users/nobody@example.com/admin/", "admin@example.com")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminDisableUser(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+
+	w := adminRequest(handler, "POST", "/admin/users/bob@example.com/disable/", "admin@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	user, _ := memStorage.GetUser("bob@example.com")
+	assert.True(t, user.Disabled)
+
+	_, err := memStorage.GetUserBySession("bob@example.com")
+	assert.Equal(t, core.ErrSessionNotFound, err)
+
+	err = memStorage.AddUserToSession(newSession("new-session"), &core.User{Email: "bob@example.com"})
+	assert.Equal(t, core.ErrUserDisabled, err)
+}
+
+func TestAdminSessions(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+
+	sessions := []core.Session{}
+	w := adminRequest(handler, "GET", "/admin/users/bob@example.com/sessions/", "admin@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "bob@example.com-other") // Secret session ids are not exposed
+	json.Unmarshal(w.Body.Bytes(), &sessions)
+	if !assert.Len(t, sessions, 2) {
+		return
+	}
+
+	w = adminRequest(handler, "DELETE", "/admin/users/bob@example.com/sessions/"+sessions[0].PublicID+"/", "admin@example.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	remaining, _ := memStorage.ListSessions("bob@example.com")
+	assert.Len(t, remaining, 1)
+
+	w = adminRequest(handler, "DELETE", "/admin/users/bob@example.com/sessions/"+sessions[0].PublicID+"/", "admin@example.com")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = adminRequest(handler, "DELETE", "/admin/users/bob@example.com/sessions/", "admin@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	remaining, _ = memStorage.ListSessions("bob@example.com")
+	assert.Len(t, remaining, 0)
+}
@@ -0,0 +1,139 @@
+package webapp
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/core"
+)
+
+// ErrNotAdmitted doesn't tell which rule rejected the user, that is logged only
+var ErrNotAdmitted = errors.New("User is not allowed to log in")
+
+// AdmissionPolicy decides who can log in. Empty policy admits everyone.
+// Emails and domains are compared case insensitively, and domains have to
+// match exactly, so subdomains have to be listed on their own
+type AdmissionPolicy struct {
+	// AllowedDomains, when set, admit only emails in them. Like invite-only
+	// mode and per provider domains, they admit only verified emails, so
+	// local accounts can't be used with them
+	AllowedDomains []string
+	// AllowedEmails are admitted regardless of AllowedDomains and InviteOnly
+	AllowedEmails []string
+	// DeniedEmails are never admitted. Entries starting with @ deny the
+	// whole domain
+	DeniedEmails []string
+	// InviteOnly admits only users who exist already, and AllowedEmails,
+	// so no new users are created
+	InviteOnly bool
+}
+
+// admissionPolicy is AdmissionPolicy with per provider allowed domains
+// from conf.Provider, ready to check emails against
+type admissionPolicy struct {
+	domains         map[string]bool
+	allowed         map[string]bool
+	denied          map[string]bool
+	inviteOnly      bool
+	providerDomains map[string]map[string]bool
+	// users tells who exists already, for invite-only mode
+	users core.Administrator
+}
+
+func newAdmissionPolicy(policy AdmissionPolicy, providers []conf.Provider, users core.Administrator) admissionPolicy {
+	admission := admissionPolicy{
+		domains:         lowerSet(policy.AllowedDomains),
+		allowed:         lowerSet(policy.AllowedEmails),
+		denied:          lowerSet(policy.DeniedEmails),
+		inviteOnly:      policy.InviteOnly,
+		providerDomains: make(map[string]map[string]bool),
+		users:           users,
+	}
+	for _, provider := range providers {
+		if len(provider.AllowedDomains) > 0 {
+			admission.providerDomains[provider.Name] = lowerSet(provider.AllowedDomains)
+		}
+	}
+
+	if admission.inviteOnly && users == nil {
+		log.Printf("Invite-only mode needs storage to find existing users. Only allowed emails are admitted.")
+	}
+	return admission
+}
+
+func lowerSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[strings.ToLower(strings.TrimSpace(item))] = true
+	}
+	return set
+}
+
+func (p admissionPolicy) empty() bool {
+	return len(p.domains) == 0 && len(p.allowed) == 0 && len(p.denied) == 0 && !p.inviteOnly && len(p.providerDomains) == 0
+}
+
+// rejection returns why user with the email can't log in with provider,
+// or empty string when the user is admitted. Anyone can claim email which
+// is not verified, so only rules denying emails apply to it
+func (p admissionPolicy) rejection(provider string, email string, verified bool) (string, error) {
+	if p.empty() {
+		return "", nil
+	}
+
+	address := strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(address, "@")
+	if at < 1 {
+		// Nothing to check rules against
+		return "no email", nil
+	}
+	domain := address[at+1:]
+
+	if p.denied[address] || p.denied["@"+domain] {
+		return "email denied", nil
+	}
+	domains, restricted := p.providerDomains[provider]
+	if !verified && (restricted || len(p.domains) > 0 || p.inviteOnly) {
+		return "email not verified", nil
+	}
+	if restricted && !domains[domain] {
+		return "domain not allowed for provider", nil
+	}
+	if p.allowed[address] {
+		return "", nil
+	}
+	if len(p.domains) > 0 && !p.domains[domain] {
+		return "domain not allowed", nil
+	}
+
+	if p.inviteOnly {
+		if p.users == nil {
+			return "not invited", nil
+		}
+		_, err := p.users.GetUser(email)
+		if err == core.ErrUserNotFound {
+			return "not invited", nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// admitted checks user with the email may log in with provider. Otherwise
+// responds with 403 and logs why, so admins can see who was turned away
+func (a *authController) admitted(rw http.ResponseWriter, req *http.Request, provider string, email string, verified bool) bool {
+	reason, err := a.Admission.rejection(provider, email, verified)
+	if err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return false
+	} else if reason != "" {
+		log.Printf("Audit: login of %#v with %v not admitted: %v. IP: %v", email, provider, reason, a.clientIP(req))
+		a.Serializer.JSON(rw, http.StatusForbidden, ErrNotAdmitted)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package webapp_test
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/mailer"
+	"github.com/hashtock/auth/storage"
+	"github.com/hashtock/auth/webapp"
+)
+
+// captureLog returns what was logged while f ran
+func captureLog(f func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	f()
+	return buf.String()
+}
+
+func register(handler http.Handler, email string) int {
+	w := passwordRequest(handler, "/login/local/register", "", url.Values{"email": {email}, "password": {"correct horse"}})
+	return w.Code
+}
+
+// emailLogin logs in with link sent to the email. Returns status of request
+// for the link, or of following the link when it was sent
+func emailLogin(t *testing.T, handler http.Handler, mail *mailer.MemMailer, email string) int {
+	w := passwordRequest(handler, "/login/email/", "", url.Values{"email": {email}})
+	if w.Code != http.StatusAccepted {
+		return w.Code
+	}
+
+	messages := mail.Messages()
+	match := loginLinkPattern.FindStringSubmatch(messages[len(messages)-1].Body)
+	if !assert.Len(t, match, 2) {
+		return 0
+	}
+	return tokenRequest(handler, "GET", match[1], "", "", "").Code
+}
+
+// makeAdmissionHandler serves local and email providers with the policy
+func makeAdmissionHandler(t *testing.T, policy webapp.AdmissionPolicy) (http.Handler, *storage.MemStorage, *mailer.MemMailer) {
+	mail := mailer.NewMemMailer()
+	handler, memStorage := makeAdminHandlerWithOptions(t, webapp.Options{
+		Providers: []conf.Provider{{Name: conf.LocalProvider}, {Name: conf.EmailProvider}},
+		Mailer:    mail,
+		Admission: policy,
+	})
+	return handler, memStorage, mail
+}
+
+func TestAdmissionAllowedDomains(t *testing.T) {
+	handler, memStorage, mail := makeAdmissionHandler(t, webapp.AdmissionPolicy{AllowedDomains: []string{"Example.com"}})
+
+	assert.Equal(t, http.StatusOK, emailLogin(t, handler, mail, "carol@example.com"))
+	assert.Equal(t, http.StatusOK, emailLogin(t, handler, mail, "dave@EXAMPLE.com"))
+
+	logged := captureLog(func() {
+		w := passwordRequest(handler, "/login/email/", "", url.Values{"email": {"eve@sub.example.com"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), webapp.ErrNotAdmitted.Error())
+	})
+	assert.Contains(t, logged, `"eve@sub.example.com" with email not admitted: domain not allowed`)
+
+	// User is not stored
+	_, err := memStorage.GetUser("eve@sub.example.com")
+	assert.Equal(t, core.ErrUserNotFound, err)
+}
+
+func TestAdmissionUnverifiedEmails(t *testing.T) {
+	handler, memStorage, _ := makeAdmissionHandler(t, webapp.AdmissionPolicy{AllowedDomains: []string{"allowed.com"}})
+
+	// Anyone could register with email in allowed domain
+	logged := captureLog(func() {
+		w := passwordRequest(handler, "/login/local/register", "", url.Values{"email": {"x@allowed.com"}, "password": {"correct horse"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, sessionIdFrom(w))
+	})
+	assert.Contains(t, logged, `"x@allowed.com" with local not admitted: email not verified`)
+	_, err := memStorage.GetUser("x@allowed.com")
+	assert.Equal(t, core.ErrUserNotFound, err)
+
+	// Nor can local accounts made before log in
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	memStorage.AddPasswordUser(&core.User{Email: "y@allowed.com"}, string(hash))
+	assert.Equal(t, http.StatusForbidden, passwordLogin(handler, "y@allowed.com", "correct horse").Code)
+
+	// Denying emails only is fine for them
+	handler, _, _ = makeAdmissionHandler(t, webapp.AdmissionPolicy{DeniedEmails: []string{"@denied.com"}})
+	assert.Equal(t, http.StatusCreated, register(handler, "x@allowed.com"))
+	assert.Equal(t, http.StatusForbidden, register(handler, "x@denied.com"))
+}
+
+func TestAdmissionAllowAndDenyLists(t *testing.T) {
+	handler, memStorage, mail := makeAdmissionHandler(t, webapp.AdmissionPolicy{
+		AllowedDomains: []string{"example.com"},
+		AllowedEmails:  []string{"guest@partner.com"},
+		DeniedEmails:   []string{"Mallory@example.com", "@partner.com"},
+	})
+
+	// Denied emails win over allowed ones
+	assert.Equal(t, http.StatusForbidden, emailLogin(t, handler, mail, "guest@partner.com"))
+	assert.Equal(t, http.StatusForbidden, emailLogin(t, handler, mail, "mallory@example.com"))
+	assert.Equal(t, http.StatusOK, emailLogin(t, handler, mail, "carol@example.com"))
+
+	// Existing users are checked on every login, after their password
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	memStorage.AddPasswordUser(&core.User{Email: "mallory@example.com"}, string(hash))
+	w := passwordLogin(handler, "mallory@example.com", "wrong horse")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	logged := captureLog(func() {
+		w = passwordLogin(handler, "mallory@example.com", "correct horse")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+	assert.Contains(t, logged, "email denied")
+}
+
+func TestAdmissionAllowedEmailOutsideDomains(t *testing.T) {
+	handler, _, mail := makeAdmissionHandler(t, webapp.AdmissionPolicy{
+		AllowedDomains: []string{"example.com"},
+		AllowedEmails:  []string{"Guest@partner.com"},
+	})
+
+	assert.Equal(t, http.StatusOK, emailLogin(t, handler, mail, "guest@partner.com"))
+	assert.Equal(t, http.StatusForbidden, emailLogin(t, handler, mail, "host@partner.com"))
+	assert.Equal(t, http.StatusForbidden, register(handler, "guest@partner.com"))
+}
+
+func TestAdmissionInviteOnly(t *testing.T) {
+	mail := mailer.NewMemMailer()
+	handler, _ := makeAdminHandlerWithOptions(t, webapp.Options{
+		Providers: []conf.Provider{{Name: conf.EmailProvider}},
+		Mailer:    mail,
+		Admission: webapp.AdmissionPolicy{
+			AllowedEmails: []string{"carol@example.com"},
+			InviteOnly:    true,
+		},
+	})
+
+	// Existing users and allowed emails can log in
+	path := requestLoginLink(t, handler, mail, "/login/email/", "bob@example.com")
+	w := tokenRequest(handler, "GET", path, "", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	path = requestLoginLink(t, handler, mail, "/login/email/", "carol@example.com")
+	w = tokenRequest(handler, "GET", path, "", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Others get no link
+	sent := len(mail.Messages())
+	logged := captureLog(func() {
+		w = passwordRequest(handler, "/login/email/", "", url.Values{"email": {"eve@example.com"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+	assert.Len(t, mail.Messages(), sent)
+	assert.Contains(t, logged, `"eve@example.com" with email not admitted: not invited`)
+}
+
+func TestAdmissionPerProvider(t *testing.T) {
+	handler, memStorage := makeAdminHandlerWithOptions(t, webapp.Options{
+		Providers: []conf.Provider{
+			{Name: conf.LocalProvider},
+			{Name: "faux", AllowedDomains: []string{"example.com"}},
+		},
+	})
+
+	// Test provider reports "email", which is in no domain
+	logged := captureLog(func() {
+		w := loginFlow(t, handler, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, sessionIdFrom(w))
+	})
+	assert.Contains(t, logged, "with faux not admitted")
+	_, err := memStorage.GetUser("email")
+	assert.Equal(t, core.ErrUserNotFound, err)
+
+	// Restriction is only for the provider
+	assert.Equal(t, http.StatusCreated, register(handler, "carol@partner.com"))
+}
+
+func TestAdmissionEmptyPolicy(t *testing.T) {
+	handler, _ := makeAdminHandlerWithOptions(t, webapp.Options{
+		Providers: []conf.Provider{{Name: "faux"}},
+	})
+
+	// Providers which report no valid email are fine without policy
+	w := loginFlow(t, handler, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
@@ -1,30 +1,79 @@
 package webapp
 
 import (
+	"crypto/cipher"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
-	"code.google.com/p/go-uuid/uuid"
 	"github.com/hashtock/service-tools/serialize"
 	"github.com/markbates/goth/gothic"
 
+	"github.com/hashtock/auth/conf"
 	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/jwt"
+	"github.com/hashtock/auth/mailer"
+	"github.com/hashtock/auth/webauthn"
 )
 
 const (
-	SessionName   = "auth_session_id"
+	SessionName   = core.SessionCookieName
 	SessionTimout = 7 * 24 * time.Hour
 )
 
 type authController struct {
-	Serializer serialize.Serializer
-	Storage    core.UserSessioner
-	Providers  map[string]string
+	Serializer      serialize.Serializer
+	Storage         core.UserSessioner
+	Providers       map[string]string
+	SessionTimeout  time.Duration
+	SlidingSessions bool
+	Redirects       redirectPolicy
+	Cookie          CookieOptions
+	// AccessTokens, when set, lets clients authenticate with
+	// "Authorization: Bearer <token>" header instead of cookie
+	AccessTokens core.AccessTokenStorer
+	// Identities, when set, finds users by their account at provider
+	// instead of email, and lets them link more providers
+	Identities core.IdentityStorer
+	// TrustedEmails are providers which emails are treated as verified
+	TrustedEmails map[string]bool
+	// Admission decides who can log in, with any provider
+	Admission admissionPolicy
+	// Passwords, when set, lets users of local provider log in with password
+	Passwords core.PasswordStorer
+	// dummyHash is checked for unknown users, see verifyPassword
+	dummyHash []byte
+	// LoginLinks and Mailer, when set, let users log in with emailed links
+	LoginLinks   core.LoginLinkStorer
+	Mailer       mailer.Mailer
+	loginLinkURL string
+	// TwoFactor, when set, asks users who enabled it for TOTP code after
+	// login. RequireAdminTwoFactor makes admins enable it
+	TwoFactor             core.TwoFactorStorer
+	RequireAdminTwoFactor bool
+	twoFactorURL          string
+	// WebAuthn, when set, lets users register passkeys, and log in with
+	// them or use them as second factor
+	WebAuthn     core.WebAuthnStorer
+	relyingParty *webauthn.RelyingParty
+	// appName is shown to users in emails and authenticator apps
+	appName string
+	// oauth, when set, lets OAuth clients use access tokens issued to them
+	oauth *oauthController
+
+	// TrustProxy takes client IP from X-Forwarded-For header
+	TrustProxy bool
+
+	// Session cookie holds signed token instead of session id, when set.
+	// sessionIdCipher encrypts id of the session in the token
+	TokenSigner     *jwt.Signer
+	Issuer          string
+	sessionIdCipher cipher.AEAD
 }
 
-func getSessionId(req *http.Request) string {
-	cookie, err := req.Cookie(SessionName)
+func (a *authController) getSessionId(req *http.Request) string {
+	cookie, err := req.Cookie(a.Cookie.Name)
 	if err != nil {
 		return ""
 	}
@@ -33,24 +82,128 @@ func getSessionId(req *http.Request) string {
 }
 
 func (a *authController) who(rw http.ResponseWriter, req *http.Request) {
-	sessionId := getSessionId(req)
+	if secret := a.getAccessToken(req); secret != "" {
+		user, err := a.userFromAccessToken(secret)
+		if err != nil {
+			a.writeError(rw, err)
+			return
+		}
+
+		a.Serializer.JSON(rw, http.StatusOK, user)
+		return
+	}
+
+	sessionId := a.getSessionId(req)
 	if sessionId == "" {
 		a.Serializer.JSON(rw, http.StatusUnauthorized, core.ErrUserNotLoggedIn)
 		return
 	}
 
+	if a.TokenSigner != nil {
+		a.tokenWho(rw, sessionId)
+		return
+	}
+
 	user, err := a.Storage.GetUserBySession(sessionId)
 	if err != nil {
 		errCode := http.StatusInternalServerError
 		if err == core.ErrSessionNotFound {
 			err = core.ErrUserNotLoggedIn
 			errCode = http.StatusUnauthorized
+		} else if err == core.ErrTwoFactorPending {
+			errCode = http.StatusUnauthorized
 		}
 
 		a.Serializer.JSON(rw, errCode, err)
 		return
 	}
 
+	if a.SlidingSessions {
+		expires := time.Now().Add(a.SessionTimeout)
+		if err := a.Storage.RenewSession(sessionId, expires); err != nil {
+			log.Printf("Could not renew session. Err: %v", err)
+		} else {
+			a.setSessionCookie(rw, sessionId, expires)
+		}
+	}
+
+	a.Serializer.JSON(rw, http.StatusOK, user)
+}
+
+// getAccessToken returns bearer token, when access tokens are enabled
+func (a *authController) getAccessToken(req *http.Request) string {
+	if a.AccessTokens == nil && a.oauth == nil {
+		return ""
+	}
+	return bearerToken(req)
+}
+
+// currentUser finds logged in user in storage, even with token sessions.
+// Bearer access token takes precedence over session cookie
+func (a *authController) currentUser(req *http.Request) (*core.User, error) {
+	if secret := a.getAccessToken(req); secret != "" {
+		return a.userFromAccessToken(secret)
+	}
+
+	sessionId := a.currentSessionId(req)
+	if sessionId == "" {
+		return nil, core.ErrUserNotLoggedIn
+	}
+
+	user, err := a.Storage.GetUserBySession(sessionId)
+	if err == core.ErrSessionNotFound || err == core.ErrTwoFactorPending {
+		err = core.ErrUserNotLoggedIn
+	}
+	return user, err
+}
+
+// currentSessionId returns id of session from cookie, also with token sessions
+func (a *authController) currentSessionId(req *http.Request) string {
+	sessionId := a.getSessionId(req)
+	if a.TokenSigner != nil && sessionId != "" {
+		// Token can't be revoked, but session it was issued for can be
+		claims, _ := a.verifyToken(sessionId)
+		sessionId = a.openSessionId(claims.String("sid"))
+	}
+	return sessionId
+}
+
+// sessionUser wraps handler so it is called only for users logged in with
+// session cookie, so leaked access token can't be used to manage the account
+func (a *authController) sessionUser(handler func(rw http.ResponseWriter, req *http.Request, user *core.User)) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if bearerToken(req) != "" {
+			a.Serializer.JSON(rw, http.StatusForbidden, ErrAccessTokenNotAllowed)
+			return
+		}
+
+		user, err := a.currentUser(req)
+		if err != nil {
+			a.writeError(rw, err)
+			return
+		}
+
+		handler(rw, req, user)
+	}
+}
+
+// writeError responds with 401 for users not logged in, 500 otherwise
+func (a *authController) writeError(rw http.ResponseWriter, err error) {
+	errCode := http.StatusInternalServerError
+	if err == core.ErrUserNotLoggedIn {
+		errCode = http.StatusUnauthorized
+	}
+
+	a.Serializer.JSON(rw, errCode, err)
+}
+
+func (a *authController) tokenWho(rw http.ResponseWriter, token string) {
+	user, err := a.userFromToken(token)
+	if err != nil {
+		a.writeError(rw, err)
+		return
+	}
+
 	a.Serializer.JSON(rw, http.StatusOK, user)
 }
 
@@ -58,6 +211,16 @@ func (a *authController) providers(rw http.ResponseWriter, req *http.Request) {
 	a.Serializer.JSON(rw, http.StatusOK, a.Providers)
 }
 
+// beginAuth starts login with provider, once post-login target is known to be safe
+func (a *authController) beginAuth(rw http.ResponseWriter, req *http.Request) {
+	if target := redirectTarget(req); target != "" && !a.Redirects.Allowed(target) {
+		a.Serializer.JSON(rw, http.StatusBadRequest, ErrRedirectNotAllowed)
+		return
+	}
+
+	gothic.BeginAuthHandler(rw, req)
+}
+
 func (a *authController) authCallback(rw http.ResponseWriter, req *http.Request) {
 	authUser, err := gothic.CompleteUserAuth(rw, req)
 	if err != nil {
@@ -65,55 +228,121 @@ func (a *authController) authCallback(rw http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	state := req.URL.Query().Get("state")
+	if a.Identities != nil && strings.HasPrefix(state, linkStatePrefix) {
+		a.completeLink(rw, req, authUser)
+		return
+	}
+
+	provider := req.URL.Query().Get(":provider")
+	verified := a.identityFromUser(provider, authUser).EmailVerified
+	if !a.admitted(rw, req, provider, authUser.Email, verified) {
+		return
+	}
+
 	user := &core.User{
 		Name:   authUser.Name,
 		Email:  authUser.Email,
 		Avatar: authUser.AvatarURL,
 	}
 
-	sessionId := getSessionId(req)
-	if sessionId == "" {
-		sessionId = uuid.New()
+	a.startSession(rw, req, provider, stateTarget(state), http.StatusOK, func(session core.Session) (*core.User, error) {
+		return a.addToSession(session, provider, authUser, user)
+	})
+}
+
+// startSession logs user in with fresh session, which addUser stores for the
+// user. Responds with the user, or redirects browsers to post-login target
+func (a *authController) startSession(rw http.ResponseWriter, req *http.Request, provider string, target string, status int, addUser func(session core.Session) (*core.User, error)) {
+	// Fresh id on every login, so id planted by someone else is never logged in
+	sessionId, err := newSessionId()
+	if err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now()
+	session := core.Session{
+		ID:        sessionId,
+		Created:   now,
+		LastUsed:  now,
+		Expires:   now.Add(a.SessionTimeout),
+		UserAgent: req.UserAgent(),
+		IP:        a.clientIP(req),
+		Provider:  provider,
 	}
 
-	if err := a.Storage.AddUserToSession(sessionId, user); err != nil {
+	user, err := addUser(session)
+	if err == core.ErrUserDisabled || err == core.ErrEmailNotVerified {
+		a.Serializer.JSON(rw, http.StatusForbidden, err)
+		return
+	} else if err != nil {
 		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
 		return
 	}
 
-	cookie := http.Cookie{
-		Name:     SessionName,
-		Value:    sessionId,
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   int(SessionTimout.Seconds()),
-		Expires:  time.Now().Add(SessionTimout),
+	if previousId := a.currentSessionId(req); previousId != "" {
+		if err := a.Storage.DeleteSession(previousId); err != nil && err != core.ErrSessionNotFound {
+			log.Printf("Could not remove previous session from storage. Err: %v", err)
+		}
 	}
-	http.SetCookie(rw, &cookie)
 
-	a.Serializer.JSON(rw, http.StatusOK, user)
+	// Passkey which verified the user is second factor itself
+	if provider != conf.WebAuthnProvider {
+		challenge, err := a.loginChallenge(session.ID)
+		if err != nil {
+			a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+			return
+		} else if challenge != nil {
+			a.requireTwoFactor(rw, session.ID, target, challenge)
+			return
+		}
+	}
+
+	cookieValue := session.ID
+	if a.TokenSigner != nil {
+		token, err := a.issueToken(session)
+		if err != nil {
+			a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+			return
+		}
+		cookieValue = token
+	}
+
+	a.setSessionCookie(rw, cookieValue, session.Expires)
+
+	if target != "" && !wantsJSON(req) {
+		if a.Redirects.Allowed(target) {
+			http.Redirect(rw, req, target, http.StatusSeeOther)
+			return
+		}
+		log.Printf("Post-login redirect to %#v not allowed.", target)
+	}
+
+	a.Serializer.JSON(rw, status, user)
+}
+
+func (a *authController) setSessionCookie(rw http.ResponseWriter, sessionId string, expires time.Time) {
+	maxAge := int(expires.Sub(time.Now()).Seconds() + 0.5)
+	http.SetCookie(rw, a.Cookie.cookie(sessionId, maxAge, expires))
 }
 
 func (a *authController) logout(rw http.ResponseWriter, req *http.Request) {
-	sessionId := getSessionId(req)
-	if sessionId == "" {
+	if a.getSessionId(req) == "" {
 		// No session - nothing to do
 		rw.WriteHeader(http.StatusOK)
 		return
 	}
 
-	cookie := http.Cookie{
-		Name:    SessionName,
-		Value:   "",
-		Path:    "/",
-		MaxAge:  -1,
-		Expires: time.Now().Add(-time.Hour),
-	}
-	http.SetCookie(rw, &cookie)
+	sessionId := a.currentSessionId(req)
+	http.SetCookie(rw, a.Cookie.cookie("", -1, time.Now().Add(-time.Hour)))
 
-	if err := a.Storage.DeleteSession(sessionId); err != nil {
-		// While cleanup operation failed, user session is gone now, so continue
-		log.Printf("Could not remove session %v from storage.", err)
+	// Invalid token leaves nothing to remove
+	if sessionId != "" {
+		if err := a.Storage.DeleteSession(sessionId); err != nil {
+			// While cleanup operation failed, user session is gone now, so continue
+			log.Printf("Could not remove session %v from storage.", err)
+		}
 	}
 
 	rw.WriteHeader(http.StatusOK)
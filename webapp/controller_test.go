@@ -5,11 +5,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/github"
 	"github.com/markbates/goth/providers/gplus"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/hashtock/auth/conf"
 	"github.com/hashtock/auth/core"
 	"github.com/hashtock/auth/webapp"
 )
@@ -32,7 +35,7 @@ func TestWhoLoggedIn(t *testing.T) {
 
 	sessionId := "session"
 	user := &core.User{Name: "user", Email: "email", Avatar: "avatar"}
-	storage.AddUserToSession(sessionId, user)
+	storage.AddUserToSession(newSession(sessionId), user)
 
 	req, _ := http.NewRequest("GET", "/who/", nil)
 	req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
@@ -42,6 +45,49 @@ func TestWhoLoggedIn(t *testing.T) {
 	assert.EqualValues(t, user, serializer.obj)
 }
 
+func TestWhoSlidingSession(t *testing.T) {
+	handler, serializer, storage := makeHandlerWithOptions("", webapp.Options{
+		SessionTimeout:  time.Hour,
+		SlidingSessions: true,
+	})
+	w := httptest.NewRecorder()
+
+	sessionId := "session"
+	user := &core.User{Name: "user", Email: "email", Avatar: "avatar"}
+	session := newSession(sessionId)
+	session.Expires = time.Now().Add(time.Minute)
+	storage.AddUserToSession(session, user)
+
+	req, _ := http.NewRequest("GET", "/who/", nil)
+	req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, user, serializer.obj)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), storage.Sessions[sessionId].Expires, time.Second)
+
+	cookie := w.HeaderMap["Set-Cookie"][0]
+	assert.Contains(t, cookie, webapp.SessionName+"="+sessionId)
+	assert.Contains(t, cookie, "Max-Age=3600")
+}
+
+func TestWhoWithoutSlidingSession(t *testing.T) {
+	handler, _, storage := makeHandler()
+	w := httptest.NewRecorder()
+
+	sessionId := "session"
+	session := newSession(sessionId)
+	storage.AddUserToSession(session, &core.User{Name: "user", Email: "email"})
+
+	req, _ := http.NewRequest("GET", "/who/", nil)
+	req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, session.Expires, storage.Sessions[sessionId].Expires)
+	assert.Len(t, w.HeaderMap["Set-Cookie"], 0)
+}
+
 func TestWhoWrongSession(t *testing.T) {
 	handler, serializer, _ := makeHandler()
 	w := httptest.NewRecorder()
@@ -101,6 +147,35 @@ func TestProvidersAsRelativeToPath(t *testing.T) {
 	assert.EqualValues(t, expectedProviders, serializer.obj)
 }
 
+func TestProvidersFromConfig(t *testing.T) {
+	handler, serializer, _ := makeHandlerWithProviders("",
+		conf.Provider{Name: "gplus"},
+		conf.Provider{Name: "github", Scopes: []string{"user:email"}},
+		conf.Provider{Name: "lastfm"},
+		conf.Provider{Name: "twitter"},
+		conf.Provider{Name: "unknown"},
+		conf.Provider{Name: "keycloak", Issuer: "http://127.0.0.1:1/unreachable"},
+	)
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/providers/", nil)
+	handler.ServeHTTP(w, req)
+
+	expectedProviders := map[string]string{
+		"gplus":   "/login/gplus/",
+		"github":  "/login/github/",
+		"lastfm":  "/login/lastfm/",
+		"twitter": "/login/twitter/",
+	}
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, expectedProviders, serializer.obj)
+
+	provider, err := goth.GetProvider("github")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:1234/login/github/callback", provider.(*github.Provider).CallbackURL)
+}
+
 func TestLoginGoogle(t *testing.T) {
 	handler, _, _ := makeHandler()
 	provider, _ := goth.GetProvider("gplus")
@@ -130,42 +205,19 @@ func TestLoginGoogleCallbackURL(t *testing.T) {
 func TestLoginFakeProvider(t *testing.T) {
 	handler, serializer, storage := makeHandler()
 
-	flow := func(handler http.Handler, nextError error) *httptest.ResponseRecorder {
-		// Login
-		wLogin := httptest.NewRecorder()
-
-		provider := &testProvider{NextError: nextError}
-		goth.UseProviders(provider)
-
-		req, _ := http.NewRequest("GET", "/login/faux/", nil)
-		handler.ServeHTTP(wLogin, req)
-
-		assert.Equal(t, http.StatusTemporaryRedirect, wLogin.Code)
-		assert.Equal(t, wLogin.HeaderMap["Location"][0], "http://example.com/auth/")
-
-		// Callback
-		wCallback := httptest.NewRecorder()
-
-		reqCallback, _ := http.NewRequest("GET", "/login/faux/callback", nil)
-		reqCallback.Header["Cookie"] = wLogin.HeaderMap["Set-Cookie"]
-		handler.ServeHTTP(wCallback, reqCallback)
-
-		return wCallback
-	}
-
 	// Error in auth
-	wAuthErr := flow(handler, errors.New("Auth error"))
+	wAuthErr := loginFlow(t, handler, errors.New("Auth error"))
 	assert.EqualValues(t, errors.New("Auth error"), serializer.obj)
 	assert.Equal(t, http.StatusInternalServerError, wAuthErr.Code)
 
 	// Error in auth
 	storage.NextError = errors.New("Storage error")
-	wStorErr := flow(handler, nil)
+	wStorErr := loginFlow(t, handler, nil)
 	assert.EqualValues(t, errors.New("Storage error"), serializer.obj)
 	assert.Equal(t, http.StatusInternalServerError, wStorErr.Code)
 
 	// All ok
-	wOk := flow(handler, nil)
+	wOk := loginFlow(t, handler, nil)
 	user := &core.User{Name: "name", Email: "email", Avatar: ""}
 	assert.EqualValues(t, user, serializer.obj)
 	assert.Equal(t, http.StatusOK, wOk.Code)
@@ -174,6 +226,31 @@ func TestLoginFakeProvider(t *testing.T) {
 	assert.Contains(t, cookie, "Path=/;")
 	assert.Contains(t, cookie, "HttpOnly")
 	assert.Contains(t, cookie, "Max-Age=604800;")
+
+	for _, session := range storage.Sessions {
+		assert.WithinDuration(t, time.Now().Add(webapp.SessionTimout), session.Expires, time.Second)
+		assert.WithinDuration(t, time.Now(), session.Created, time.Second)
+	}
+}
+
+func TestLoginRotatesSessionId(t *testing.T) {
+	handler, _, storage := makeHandler()
+	storage.AddUserToSession(newSession("planted-session"), &core.User{Email: "email"})
+
+	header := http.Header{}
+	header.Add("Cookie", webapp.SessionName+"=planted-session")
+	w := loginFlowFrom(t, handler, "/login/faux/", nil, header)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Previous session is gone, new one has random id
+	_, planted := storage.Sessions["planted-session"]
+	assert.False(t, planted)
+	if assert.Len(t, storage.Sessions, 1) {
+		for sessionId := range storage.Sessions {
+			assert.Len(t, sessionId, 43)
+			assert.Contains(t, w.HeaderMap["Set-Cookie"][0], webapp.SessionName+"="+sessionId+";")
+		}
+	}
 }
 
 func TestLogout(t *testing.T) {
@@ -182,12 +259,12 @@ func TestLogout(t *testing.T) {
 
 	sessionId := "session"
 	user := &core.User{Name: "user", Email: "email", Avatar: "avatar"}
-	storage.AddUserToSession(sessionId, user)
+	storage.AddUserToSession(newSession(sessionId), user)
 
 	// Sanity check
 	assert.Len(t, storage.Data, 1)
 
-	req, _ := http.NewRequest("GET", "/logout/", nil)
+	req, _ := http.NewRequest("POST", "/logout/", nil)
 	req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
 	handler.ServeHTTP(w, req)
 
@@ -200,7 +277,7 @@ func TestLogoutUserWithoutSession(t *testing.T) {
 	handler, _, _ := makeHandler()
 	w := httptest.NewRecorder()
 
-	req, _ := http.NewRequest("GET", "/logout/", nil)
+	req, _ := http.NewRequest("POST", "/logout/", nil)
 	handler.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -213,13 +290,13 @@ func TestLogoutErrorInStorage(t *testing.T) {
 
 	sessionId := "session"
 	user := &core.User{Name: "user", Email: "email", Avatar: "avatar"}
-	storage.AddUserToSession(sessionId, user)
+	storage.AddUserToSession(newSession(sessionId), user)
 
 	// Sanity check
 	assert.Len(t, storage.Data, 1)
 	storage.NextError = errors.New("Some other error")
 
-	req, _ := http.NewRequest("GET", "/logout/", nil)
+	req, _ := http.NewRequest("POST", "/logout/", nil)
 	req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
 	handler.ServeHTTP(w, req)
 
@@ -0,0 +1,83 @@
+package webapp
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var ErrCrossOriginRequest = errors.New("Cross origin request not allowed")
+
+// CookieOptions describe session cookie issued by the service
+type CookieOptions struct {
+	// Name defaults to SessionName
+	Name string
+	// Path defaults to "/"
+	Path   string
+	Domain string
+	Secure bool
+	// SameSite defaults to http.SameSiteLaxMode
+	SameSite http.SameSite
+}
+
+func (c CookieOptions) withDefaults() CookieOptions {
+	if c.Name == "" {
+		c.Name = SessionName
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.SameSite == 0 {
+		c.SameSite = http.SameSiteLaxMode
+	}
+	return c
+}
+
+func (c CookieOptions) cookie(value string, maxAge int, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     c.Name,
+		Value:    value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+		MaxAge:   maxAge,
+		Expires:  expires,
+	}
+}
+
+// sameOrigin tells whether request comes from one of trusted origins.
+// Browsers send Origin, or at least Referer, with cross-site POST requests,
+// so requests without them come from non-browser clients which are not
+// subject to CSRF
+func (a *authController) sameOrigin(req *http.Request) bool {
+	if origin := req.Header.Get("Origin"); origin != "" {
+		return a.trustedOrigin(origin)
+	}
+	if referer := req.Header.Get("Referer"); referer != "" {
+		return a.trustedOrigin(referer)
+	}
+	return req.Header.Get("Sec-Fetch-Site") != "cross-site"
+}
+
+func (a *authController) trustedOrigin(address string) bool {
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return a.Redirects.origins[originOf(u)]
+}
+
+// csrfProtected rejects state changing requests made by other sites
+func (a *authController) csrfProtected(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if !a.sameOrigin(req) {
+			a.Serializer.JSON(rw, http.StatusForbidden, ErrCrossOriginRequest)
+			return
+		}
+
+		handler(rw, req)
+	}
+}
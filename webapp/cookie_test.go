@@ -0,0 +1,152 @@
+package webapp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/webapp"
+)
+
+func logoutRequest(handler http.Handler, method string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, "/logout/", nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: "session"})
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestLogoutWithGetDisabledByDefault(t *testing.T) {
+	handler, _, storage := makeHandler()
+	storage.AddUserToSession(newSession("session"), &core.User{Email: "email"})
+
+	w := logoutRequest(handler, "GET", nil)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Len(t, storage.Data, 1)
+}
+
+func TestLogoutWithGetWhenEnabled(t *testing.T) {
+	handler, _, storage := makeHandlerWithOptions("", webapp.Options{LogoutWithGet: true})
+	storage.AddUserToSession(newSession("session"), &core.User{Email: "email"})
+
+	w := logoutRequest(handler, "GET", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, storage.Data, 0)
+}
+
+func TestLogoutFromTrustedOrigin(t *testing.T) {
+	headers := []http.Header{
+		{"Origin": {"http://localhost:1234"}},
+		{"Referer": {"http://localhost:1234/profile/"}},
+		{"Origin": {"https://app.example.com"}},
+		{"Sec-Fetch-Site": {"same-origin"}},
+	}
+
+	for _, header := range headers {
+		handler, _, storage := makeHandlerWithOptions("", webapp.Options{RedirectOrigins: []string{"https://app.example.com"}})
+		storage.AddUserToSession(newSession("session"), &core.User{Email: "email"})
+
+		w := logoutRequest(handler, "POST", header)
+
+		assert.Equal(t, http.StatusOK, w.Code, "%v", header)
+		assert.Len(t, storage.Data, 0)
+	}
+}
+
+func TestLogoutFromOtherSiteRejected(t *testing.T) {
+	headers := []http.Header{
+		{"Origin": {"https://evil.example.com"}},
+		{"Origin": {"null"}},
+		{"Referer": {"https://evil.example.com/page"}},
+		{"Sec-Fetch-Site": {"cross-site"}},
+	}
+
+	for _, header := range headers {
+		handler, serializer, storage := makeHandler()
+		storage.AddUserToSession(newSession("session"), &core.User{Email: "email"})
+
+		w := logoutRequest(handler, "POST", header)
+
+		assert.Equal(t, http.StatusForbidden, w.Code, "%v", header)
+		assert.Equal(t, webapp.ErrCrossOriginRequest, serializer.obj)
+		assert.Len(t, storage.Data, 1)
+		assert.Len(t, w.HeaderMap["Set-Cookie"], 0)
+	}
+}
+
+func TestDefaultCookieAttributes(t *testing.T) {
+	handler, _, _ := makeHandler()
+
+	w := loginFlow(t, handler, nil)
+
+	cookie := w.Header().Get("Set-Cookie")
+	assert.Contains(t, cookie, "auth_session_id=")
+	assert.Contains(t, cookie, "Path=/;")
+	assert.Contains(t, cookie, "HttpOnly")
+	assert.Contains(t, cookie, "SameSite=Lax")
+	assert.NotContains(t, cookie, "Secure")
+	assert.NotContains(t, cookie, "Domain")
+}
+
+func TestConfiguredCookie(t *testing.T) {
+	handler, _, storage := makeHandlerWithOptions("", webapp.Options{
+		Providers: []conf.Provider{{Name: "gplus"}},
+		Cookie: webapp.CookieOptions{
+			Name:     "sid",
+			Path:     "/app/",
+			Domain:   "example.com",
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		},
+	})
+
+	w := loginFlow(t, handler, nil)
+
+	cookie := w.Header().Get("Set-Cookie")
+	assert.Contains(t, cookie, "sid=")
+	assert.Contains(t, cookie, "Path=/app/")
+	assert.Contains(t, cookie, "Domain=example.com")
+	assert.Contains(t, cookie, "Secure")
+	assert.Contains(t, cookie, "SameSite=Strict")
+
+	// Session is recognised by configured cookie name only
+	var sessionId string
+	for id := range storage.Data {
+		sessionId = id
+	}
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/who/", nil)
+	req.AddCookie(&http.Cookie{Name: "sid", Value: sessionId})
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/who/", nil)
+	req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAdminChangesFromOtherSiteRejected(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/admin/users/bob@example.com/admin/", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: "admin@example.com"})
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	user, _ := memStorage.GetUser("bob@example.com")
+	assert.False(t, user.Admin)
+}
@@ -2,12 +2,17 @@ package webapp_test
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"testing"
+	"time"
 
 	"github.com/hashtock/service-tools/serialize"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/providers/faux"
+	"github.com/stretchr/testify/assert"
 
+	"github.com/hashtock/auth/conf"
 	"github.com/hashtock/auth/core"
 	"github.com/hashtock/auth/webapp"
 )
@@ -18,6 +23,7 @@ import (
 
 type mapStorage struct {
 	Data      map[string]*core.User
+	Sessions  map[string]core.Session
 	NextError error
 }
 
@@ -38,12 +44,27 @@ func (m *mapStorage) GetUserBySession(sessionId string) (*core.User, error) {
 	}
 	return value, nil
 }
-func (m *mapStorage) AddUserToSession(sessionId string, user *core.User) error {
+func (m *mapStorage) AddUserToSession(session core.Session, user *core.User) error {
 	if m.NextError != nil {
 		return m.nextError()
 	}
 
-	m.Data[sessionId] = user
+	m.Data[session.ID] = user
+	m.Sessions[session.ID] = session
+	return nil
+}
+func (m *mapStorage) RenewSession(sessionId string, expires time.Time) error {
+	if m.NextError != nil {
+		return m.nextError()
+	}
+
+	session, ok := m.Sessions[sessionId]
+	if !ok {
+		return core.ErrSessionNotFound
+	}
+	session.LastUsed = time.Now()
+	session.Expires = expires
+	m.Sessions[sessionId] = session
 	return nil
 }
 func (m *mapStorage) DeleteSession(sessionId string) error {
@@ -52,9 +73,74 @@ func (m *mapStorage) DeleteSession(sessionId string) error {
 	}
 
 	delete(m.Data, sessionId)
+	delete(m.Sessions, sessionId)
+	return nil
+}
+func (m *mapStorage) ListUserSessions(sessionId string) ([]core.Session, error) {
+	if m.NextError != nil {
+		return nil, m.nextError()
+	}
+
+	user, ok := m.Data[sessionId]
+	if !ok {
+		return nil, core.ErrSessionNotFound
+	}
+
+	sessions := []core.Session{}
+	for id, session := range m.Sessions {
+		if m.Data[id].Email == user.Email {
+			session.Current = id == sessionId
+			session.ID = ""
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+func (m *mapStorage) RevokeUserSession(sessionId string, publicId string) error {
+	if m.NextError != nil {
+		return m.nextError()
+	}
+
+	user, ok := m.Data[sessionId]
+	if !ok {
+		return core.ErrSessionNotFound
+	}
+
+	for id, session := range m.Sessions {
+		if m.Data[id].Email == user.Email && session.PublicID == publicId {
+			return m.DeleteSession(id)
+		}
+	}
+	return core.ErrSessionNotFound
+}
+func (m *mapStorage) RevokeOtherSessions(sessionId string) error {
+	if m.NextError != nil {
+		return m.nextError()
+	}
+
+	user, ok := m.Data[sessionId]
+	if !ok {
+		return core.ErrSessionNotFound
+	}
+
+	for id := range m.Sessions {
+		if m.Data[id].Email == user.Email && id != sessionId {
+			m.DeleteSession(id)
+		}
+	}
 	return nil
 }
 
+func newSession(sessionId string) core.Session {
+	now := time.Now()
+	return core.Session{
+		ID:       sessionId,
+		Created:  now,
+		LastUsed: now,
+		Expires:  now.Add(time.Hour),
+	}
+}
+
 ////////////////
 // Serializer //
 ////////////////
@@ -77,9 +163,11 @@ type testProvider struct {
 	faux.Provider
 
 	NextError error
+	State     string
 }
 
 func (t *testProvider) BeginAuth(state string) (goth.Session, error) {
+	t.State = state
 	return &faux.Session{
 		Name:  "name",
 		Email: "email",
@@ -94,6 +182,39 @@ func (t *testProvider) UnmarshalSession(data string) (goth.Session, error) {
 	return t.Provider.UnmarshalSession(data)
 }
 
+// loginFlow goes through login with test provider and returns callback response
+func loginFlow(t *testing.T, handler http.Handler, nextError error) *httptest.ResponseRecorder {
+	return loginFlowFrom(t, handler, "/login/faux/", nextError, nil)
+}
+
+// loginFlowFrom starts login at loginPath and sends callbackHeader with callback.
+// Like real providers, test provider passes state back to callback
+func loginFlowFrom(t *testing.T, handler http.Handler, loginPath string, nextError error, callbackHeader http.Header) *httptest.ResponseRecorder {
+	// Login
+	wLogin := httptest.NewRecorder()
+
+	provider := &testProvider{NextError: nextError}
+	goth.UseProviders(provider)
+
+	req, _ := http.NewRequest("GET", loginPath, nil)
+	handler.ServeHTTP(wLogin, req)
+
+	assert.Equal(t, http.StatusTemporaryRedirect, wLogin.Code)
+	assert.Equal(t, wLogin.HeaderMap["Location"][0], "http://example.com/auth/")
+
+	// Callback
+	wCallback := httptest.NewRecorder()
+
+	reqCallback, _ := http.NewRequest("GET", "/login/faux/callback?state="+url.QueryEscape(provider.State), nil)
+	for key, values := range callbackHeader {
+		reqCallback.Header[key] = values
+	}
+	reqCallback.Header["Cookie"] = append(reqCallback.Header["Cookie"], wLogin.HeaderMap["Set-Cookie"]...)
+	handler.ServeHTTP(wCallback, reqCallback)
+
+	return wCallback
+}
+
 //////////////////
 // Test handler //
 //////////////////
@@ -102,19 +223,27 @@ func makeHandler() (http.Handler, *serializerLog, *mapStorage) {
 }
 
 func makeHandlerSubPath(path string) (http.Handler, *serializerLog, *mapStorage) {
+	return makeHandlerWithProviders(path, conf.Provider{Name: "gplus"})
+}
+
+func makeHandlerWithProviders(path string, providers ...conf.Provider) (http.Handler, *serializerLog, *mapStorage) {
+	return makeHandlerWithOptions(path, webapp.Options{Providers: providers})
+}
+
+// makeHandlerWithOptions fills in app address, storage and serializer
+func makeHandlerWithOptions(path string, options webapp.Options) (http.Handler, *serializerLog, *mapStorage) {
 	url, _ := url.Parse("http://localhost:1234/")
 	url.Path = path
 
 	serializer := new(serializerLog)
 	storage := &mapStorage{
-		Data: make(map[string]*core.User),
+		Data:     make(map[string]*core.User),
+		Sessions: make(map[string]core.Session),
 	}
 
-	options := webapp.Options{
-		AppAddress: url,
-		Storage:    storage,
-		Serializer: serializer,
-	}
+	options.AppAddress = url
+	options.Storage = storage
+	options.Serializer = serializer
 
 	handler := webapp.Handlers(options)
 	return handler, serializer, storage
@@ -0,0 +1,155 @@
+package webapp
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+
+	"github.com/hashtock/auth/core"
+)
+
+const (
+	// linkStatePrefix marks state of provider login started to link identity
+	linkStatePrefix = "link."
+	// linkSessionKey holds state and email of user linking identity, in
+	// signed gothic session, so callback can't be replayed by other users
+	linkSessionKey = "link"
+)
+
+var ErrLinkNotStarted = errors.New("Identity link was not started by this user")
+
+// linkURL is where user has to be sent to link identity
+type linkURL struct {
+	URL string `json:"url"`
+}
+
+// identityFromUser describes account of user at provider. Email is verified
+// when provider is trusted or says so with OpenID Connect claim
+func (a *authController) identityFromUser(provider string, authUser goth.User) core.Identity {
+	verified, _ := authUser.RawData["email_verified"].(bool)
+	if !verified {
+		// Google's userinfo names it differently
+		verified, _ = authUser.RawData["verified_email"].(bool)
+	}
+
+	return core.Identity{
+		Provider:      provider,
+		UserID:        authUser.UserID,
+		Email:         authUser.Email,
+		EmailVerified: authUser.Email != "" && (verified || a.TrustedEmails[provider]),
+	}
+}
+
+// addToSession stores session for the user, by identity when provider
+// reported one. Returns stored user
+func (a *authController) addToSession(session core.Session, provider string, authUser goth.User, user *core.User) (*core.User, error) {
+	if a.Identities == nil || authUser.UserID == "" {
+		return user, a.Storage.AddUserToSession(session, user)
+	}
+
+	return a.Identities.AddIdentityToSession(session, a.identityFromUser(provider, authUser), user)
+}
+
+func (a *authController) listIdentities(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	identities, err := a.Identities.ListIdentities(user.Email)
+	a.writeIdentityResult(rw, http.StatusOK, identities, err)
+}
+
+// beginLink starts login with provider, which identity will be linked to
+// the user. Browsers are redirected, API clients get URL to follow
+func (a *authController) beginLink(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	provider, err := goth.GetProvider(req.URL.Query().Get(":provider"))
+	if err != nil {
+		a.Serializer.JSON(rw, http.StatusNotFound, err)
+		return
+	}
+
+	state := linkStatePrefix + randomString(16)
+	sess, err := provider.BeginAuth(state)
+	if err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+	authURL, err := sess.GetAuthURL()
+	if err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	session, _ := gothic.Store.Get(req, gothic.SessionName)
+	session.Values[gothic.SessionName] = sess.Marshal()
+	session.Values[linkSessionKey] = state + " " + user.Email
+	if err := session.Save(req, rw); err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	if wantsJSON(req) {
+		a.Serializer.JSON(rw, http.StatusOK, linkURL{URL: authURL})
+		return
+	}
+	http.Redirect(rw, req, authURL, http.StatusSeeOther)
+}
+
+// linkingEmail returns email of user who started linking with given state.
+// Pending link is forgotten, so it can be completed only once
+func (a *authController) linkingEmail(rw http.ResponseWriter, req *http.Request, state string) string {
+	session, _ := gothic.Store.Get(req, gothic.SessionName)
+	pending, _ := session.Values[linkSessionKey].(string)
+	delete(session.Values, linkSessionKey)
+	session.Save(req, rw)
+
+	parts := strings.SplitN(pending, " ", 2)
+	if len(parts) != 2 || parts[0] != state {
+		return ""
+	}
+	return parts[1]
+}
+
+// completeLink links identity to user, who has to be still logged in
+func (a *authController) completeLink(rw http.ResponseWriter, req *http.Request, authUser goth.User) {
+	email := a.linkingEmail(rw, req, req.URL.Query().Get("state"))
+	user, err := a.currentUser(req)
+	if email == "" || err == nil && user.Email != email {
+		a.Serializer.JSON(rw, http.StatusForbidden, ErrLinkNotStarted)
+		return
+	} else if err != nil {
+		a.writeError(rw, err)
+		return
+	}
+
+	identity := a.identityFromUser(req.URL.Query().Get(":provider"), authUser)
+	if identity.UserID == "" {
+		a.Serializer.JSON(rw, http.StatusBadRequest, core.ErrIdentityNotFound)
+		return
+	}
+
+	err = a.Identities.LinkIdentity(email, identity)
+	a.writeIdentityResult(rw, http.StatusCreated, identity, err)
+}
+
+func (a *authController) unlinkIdentity(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	query := req.URL.Query()
+	if err := a.Identities.UnlinkIdentity(user.Email, query.Get(":provider"), query.Get(":id")); err != nil {
+		a.writeIdentityResult(rw, 0, nil, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (a *authController) writeIdentityResult(rw http.ResponseWriter, status int, obj interface{}, err error) {
+	switch err {
+	case nil:
+		a.Serializer.JSON(rw, status, obj)
+	case core.ErrUserNotFound, core.ErrIdentityNotFound:
+		a.Serializer.JSON(rw, http.StatusNotFound, err)
+	case core.ErrIdentityLinked, core.ErrLastIdentity:
+		a.Serializer.JSON(rw, http.StatusConflict, err)
+	default:
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,149 @@
+package webapp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/webapp"
+)
+
+// identityProvider reports account id and whether email is verified
+type identityProvider struct {
+	testProvider
+
+	UserID   string
+	Email    string
+	Verified bool
+}
+
+func (p *identityProvider) FetchUser(session goth.Session) (goth.User, error) {
+	return goth.User{
+		Name:    "name",
+		Email:   p.Email,
+		UserID:  p.UserID,
+		RawData: map[string]interface{}{"email_verified": p.Verified},
+	}, nil
+}
+
+// identityCallback completes login with provider, which was started with
+// loginCookies set, as user logged in with sessionId
+func identityCallback(handler http.Handler, provider *identityProvider, loginCookies []string, sessionId string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login/faux/callback?state="+url.QueryEscape(provider.State), nil)
+	req.Header["Cookie"] = loginCookies
+	if sessionId != "" {
+		req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
+	}
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func identityLogin(handler http.Handler, provider *identityProvider) *httptest.ResponseRecorder {
+	goth.UseProviders(provider)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login/faux/", nil)
+	handler.ServeHTTP(w, req)
+
+	return identityCallback(handler, provider, w.HeaderMap["Set-Cookie"], "")
+}
+
+func identityLink(handler http.Handler, provider *identityProvider, sessionId string) *httptest.ResponseRecorder {
+	goth.UseProviders(provider)
+
+	w := tokenRequest(handler, "POST", "/identities/faux/", sessionId, "", "")
+	if w.Code != http.StatusSeeOther {
+		return w
+	}
+	return identityCallback(handler, provider, w.HeaderMap["Set-Cookie"], sessionId)
+}
+
+func TestLoginWithIdentity(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+
+	// Verified email is merged with existing user
+	w := identityLogin(handler, &identityProvider{UserID: "1", Email: "bob@example.com", Verified: true})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user := core.User{}
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "bob@example.com", user.Email)
+	assert.NotEmpty(t, user.ID)
+
+	// Linked identity is found by id, not email
+	w = identityLogin(handler, &identityProvider{UserID: "1", Email: "bob@gmail.com"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "bob@example.com", user.Email)
+
+	// Unverified email can't take over account
+	w = identityLogin(handler, &identityProvider{UserID: "2", Email: "alice@example.com"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	identities, _ := memStorage.ListIdentities("alice@example.com")
+	assert.Empty(t, identities)
+}
+
+func TestLinkIdentity(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+
+	w := identityLink(handler, &identityProvider{UserID: "1", Email: "bob@example.com"}, "bob@example.com")
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = identityLink(handler, &identityProvider{UserID: "2", Email: "robert@example.com"}, "bob@example.com")
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	identities := []core.Identity{}
+	w = tokenRequest(handler, "GET", "/identities/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &identities)
+	assert.Len(t, identities, 2)
+
+	// Now user can log in with linked identity, even with unverified email
+	w = identityLogin(handler, &identityProvider{UserID: "2", Email: "robert@example.com"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Identity can't be linked to two users
+	w = identityLink(handler, &identityProvider{UserID: "2"}, "alice@example.com")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Unlinking
+	w = tokenRequest(handler, "DELETE", "/identities/faux/2/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = tokenRequest(handler, "DELETE", "/identities/faux/2/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = tokenRequest(handler, "DELETE", "/identities/faux/1/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	identities, _ = memStorage.ListIdentities("bob@example.com")
+	assert.Len(t, identities, 1)
+}
+
+func TestLinkIdentityNeedsSession(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	w := identityLink(handler, &identityProvider{UserID: "1"}, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = tokenRequest(handler, "GET", "/identities/", "", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLinkIdentityRejectsForeignCallback(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+
+	// Attacker starts linking and sends callback to Bob, who started login
+	provider := &identityProvider{UserID: "evil", Email: "eve@example.com"}
+	goth.UseProviders(provider)
+	wBob := tokenRequest(handler, "GET", "/login/faux/", "", "", "")
+	tokenRequest(handler, "POST", "/identities/faux/", "alice@example.com", "", "")
+
+	w := identityCallback(handler, provider, wBob.HeaderMap["Set-Cookie"], "bob@example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	identities, _ := memStorage.ListIdentities("bob@example.com")
+	assert.Empty(t, identities)
+}
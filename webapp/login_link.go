@@ -0,0 +1,98 @@
+package webapp
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/markbates/goth"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/mailer"
+)
+
+// LoginLinkTimeout is how long emailed login link works
+const LoginLinkTimeout = 15 * time.Minute
+
+const loginLinkText = `Follow the link to log in to %s:
+
+%s
+
+It works once, within %v. If you did not ask for it, ignore this email.
+`
+
+// sendLoginLink emails link which logs the user in, creating new user when
+// needed, as following the link proves the email belongs to the user
+func (a *authController) sendLoginLink(rw http.ResponseWriter, req *http.Request) {
+	target := redirectTarget(req)
+	if target != "" && !a.Redirects.Allowed(target) {
+		a.Serializer.JSON(rw, http.StatusBadRequest, ErrRedirectNotAllowed)
+		return
+	}
+
+	email := formEmail(req)
+	if email == "" {
+		a.Serializer.JSON(rw, http.StatusBadRequest, ErrInvalidEmail)
+		return
+	}
+	// Following the link proves the email is user's
+	if !a.admitted(rw, req, conf.EmailProvider, email, true) {
+		return
+	}
+
+	token := randomString(32)
+	link := core.LoginLink{
+		Hash:    hashSecret(token),
+		Email:   email,
+		Target:  target,
+		Expires: time.Now().Add(LoginLinkTimeout),
+	}
+	if err := a.LoginLinks.AddLoginLink(link); err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg := mailer.Message{
+		To:      email,
+		Subject: "Log in to " + a.appName,
+		Body:    fmt.Sprintf(loginLinkText, a.appName, a.loginLinkURL+"?token="+token, LoginLinkTimeout),
+	}
+	if err := a.Mailer.Send(msg); err != nil {
+		log.Printf("Could not send login link. Err: %v", err)
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.Serializer.JSON(rw, http.StatusAccepted, nil)
+}
+
+// loginWithLink logs in user the link was sent to
+func (a *authController) loginWithLink(rw http.ResponseWriter, req *http.Request) {
+	link, err := a.LoginLinks.TakeLoginLink(hashSecret(req.URL.Query().Get("token")))
+	if err == core.ErrLoginLinkNotFound {
+		a.Serializer.JSON(rw, http.StatusUnauthorized, err)
+		return
+	} else if err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Policy may have changed since the link was sent
+	if !a.admitted(rw, req, conf.EmailProvider, link.Email, true) {
+		return
+	}
+
+	// Email is verified, so it can be linked like account at provider
+	authUser := goth.User{
+		UserID:  link.Email,
+		Email:   link.Email,
+		RawData: map[string]interface{}{"email_verified": true},
+	}
+	user := &core.User{Email: link.Email}
+
+	a.startSession(rw, req, conf.EmailProvider, link.Target, http.StatusOK, func(session core.Session) (*core.User, error) {
+		return a.addToSession(session, conf.EmailProvider, authUser, user)
+	})
+}
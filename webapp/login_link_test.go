@@ -0,0 +1,146 @@
+package webapp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/mailer"
+	"github.com/hashtock/auth/storage"
+	"github.com/hashtock/auth/webapp"
+)
+
+var loginLinkPattern = regexp.MustCompile(`http://localhost:1234(/login/email/callback\?token=[\w-]+)`)
+
+func makeLoginLinkHandler(t *testing.T, providers ...conf.Provider) (http.Handler, *storage.MemStorage, *mailer.MemMailer) {
+	mail := mailer.NewMemMailer()
+	handler, memStorage := makeAdminHandlerWithOptions(t, webapp.Options{
+		Providers: append(providers, conf.Provider{Name: conf.EmailProvider}),
+		Mailer:    mail,
+	})
+	return handler, memStorage, mail
+}
+
+// requestLoginLink asks for link and returns path of the link sent
+func requestLoginLink(t *testing.T, handler http.Handler, mail *mailer.MemMailer, path string, email string) string {
+	w := passwordRequest(handler, path, "", url.Values{"email": {email}})
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	messages := mail.Messages()
+	if !assert.NotEmpty(t, messages) {
+		return ""
+	}
+	msg := messages[len(messages)-1]
+	assert.Equal(t, email, msg.To)
+
+	match := loginLinkPattern.FindStringSubmatch(msg.Body)
+	if !assert.Len(t, match, 2, msg.Body) {
+		return ""
+	}
+	return match[1]
+}
+
+func TestEmailProviderListed(t *testing.T) {
+	handler, _, _ := makeLoginLinkHandler(t)
+
+	providers := map[string]string{}
+	w := tokenRequest(handler, "GET", "/providers/", "", "", "")
+	json.Unmarshal(w.Body.Bytes(), &providers)
+	assert.Equal(t, map[string]string{"email": "/login/email/"}, providers)
+}
+
+func TestEmailProviderNeedsMailer(t *testing.T) {
+	handler, _ := makeAdminHandlerWithOptions(t, webapp.Options{
+		Providers: []conf.Provider{{Name: conf.EmailProvider}},
+	})
+
+	providers := map[string]string{}
+	w := tokenRequest(handler, "GET", "/providers/", "", "", "")
+	json.Unmarshal(w.Body.Bytes(), &providers)
+	assert.Empty(t, providers)
+}
+
+func TestLoginWithLink(t *testing.T) {
+	handler, _, mail := makeLoginLinkHandler(t)
+
+	link := requestLoginLink(t, handler, mail, "/login/email/", "carol@example.com")
+	w := tokenRequest(handler, "GET", link, "", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user := core.User{}
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "carol@example.com", user.Email)
+	assert.NotEmpty(t, user.ID)
+
+	w = tokenRequest(handler, "GET", "/who/", sessionIdFrom(w), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Link works once
+	w = tokenRequest(handler, "GET", link, "", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, sessionIdFrom(w))
+	w = tokenRequest(handler, "GET", "/login/email/callback?token=made-up", "", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLoginWithLinkExistingUser(t *testing.T) {
+	handler, memStorage, mail := makeLoginLinkHandler(t)
+
+	link := requestLoginLink(t, handler, mail, "/login/email/", "bob@example.com")
+	w := tokenRequest(handler, "GET", link, "", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user := core.User{}
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "Bob", user.Name)
+
+	identities, _ := memStorage.ListIdentities("bob@example.com")
+	if assert.Len(t, identities, 1) {
+		assert.Equal(t, conf.EmailProvider, identities[0].Provider)
+	}
+}
+
+func TestLoginWithLinkRemovesUnverifiedPassword(t *testing.T) {
+	handler, memStorage, mail := makeLoginLinkHandler(t, conf.Provider{Name: conf.LocalProvider})
+	passwordRequest(handler, "/login/local/register", "", url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}})
+
+	link := requestLoginLink(t, handler, mail, "/login/email/", "carol@example.com")
+	w := tokenRequest(handler, "GET", link, "", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	_, err := memStorage.GetPassword("carol@example.com")
+	assert.Equal(t, core.ErrNoPassword, err)
+}
+
+func TestLoginWithLinkRedirect(t *testing.T) {
+	handler, _, mail := makeLoginLinkHandler(t)
+
+	w := passwordRequest(handler, "/login/email/?next=https://evil.example.com/", "", url.Values{"email": {"carol@example.com"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	link := requestLoginLink(t, handler, mail, "/login/email/?next=/welcome", "carol@example.com")
+	w = tokenRequest(handler, "GET", link, "", "", "")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/welcome", w.HeaderMap.Get("Location"))
+}
+
+func TestLoginLinkValidation(t *testing.T) {
+	handler, _, mail := makeLoginLinkHandler(t)
+
+	for _, email := range []string{"", "carol", "carol@example.com\r\nBcc: eve@example.com"} {
+		w := passwordRequest(handler, "/login/email/", "", url.Values{"email": {email}})
+		assert.Equal(t, http.StatusBadRequest, w.Code, email)
+	}
+	assert.Empty(t, mail.Messages())
+
+	w := passwordRequest(handler, "/login/email/", "", url.Values{"email": {"carol@example.com"}})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	if assert.Len(t, mail.Messages(), 1) {
+		assert.True(t, strings.HasPrefix(mail.Messages()[0].Subject, "Log in to localhost:1234"))
+	}
+}
@@ -0,0 +1,277 @@
+package webapp
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashtock/service-tools/serialize"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/jwt"
+)
+
+const (
+	OAuthCodeTTL         = 5 * time.Minute
+	OAuthAccessTokenTTL  = time.Hour
+	OAuthRefreshTokenTTL = 30 * 24 * time.Hour
+
+	// Prefixes tell tokens issued to OAuth clients from personal access tokens
+	oauthAccessTokenPrefix  = "oat_"
+	oauthRefreshTokenPrefix = "ort_"
+
+	pkceMethodS256 = "S256"
+)
+
+// oauthError is error response defined by RFC 6749
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// authorizeRequest is validated request for authorization code
+type authorizeRequest struct {
+	Client              *core.OAuthClient
+	RedirectURI         string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// oauthController makes the service an OAuth2 authorization server for
+// registered clients, with users logged in through upstream providers
+type oauthController struct {
+	Serializer serialize.Serializer
+	Storage    core.OAuthStorer
+	Users      core.Administrator
+	Auth       *authController
+	// IDTokens signs OpenID Connect ID tokens, when set
+	IDTokens *jwt.Signer
+	// Endpoints holds absolute URLs of endpoints, for discovery document
+	Endpoints map[string]string
+}
+
+// authorize shows consent page, or redirects back to client with code
+// right away when user already approved requested scopes
+func (o *oauthController) authorize(rw http.ResponseWriter, req *http.Request) {
+	request, ok := o.authorizeRequest(rw, req)
+	if !ok {
+		return
+	}
+
+	user, ok := o.sessionUser(rw, req)
+	if !ok {
+		return
+	}
+
+	granted, err := o.Storage.Consent(user.Email, request.Client.ID)
+	if err != nil {
+		o.redirectError(rw, req, request, "server_error", "")
+		return
+	}
+
+	if containsAll(granted, request.Scopes) {
+		o.redirectWithCode(rw, req, request, user)
+		return
+	}
+
+	renderPage(rw, http.StatusOK, consentPage, map[string]interface{}{
+		"Client": request.Client,
+		"User":   user,
+		"Scopes": request.Scopes,
+		"Action": req.URL.RequestURI(),
+	})
+}
+
+// decide handles consent form, which posts back to authorize URL
+func (o *oauthController) decide(rw http.ResponseWriter, req *http.Request) {
+	request, ok := o.authorizeRequest(rw, req)
+	if !ok {
+		return
+	}
+
+	user, ok := o.sessionUser(rw, req)
+	if !ok {
+		return
+	}
+
+	if req.PostFormValue("decision") != "allow" {
+		o.redirectError(rw, req, request, "access_denied", "User denied access")
+		return
+	}
+
+	if err := o.Storage.GrantConsent(user.Email, request.Client.ID, request.Scopes); err != nil {
+		o.redirectError(rw, req, request, "server_error", "")
+		return
+	}
+
+	o.redirectWithCode(rw, req, request, user)
+}
+
+// authorizeRequest validates query of authorize request. Until client and
+// redirect URI are known to be right, errors are shown to the user instead
+// of sending them to redirect URI
+func (o *oauthController) authorizeRequest(rw http.ResponseWriter, req *http.Request) (*authorizeRequest, bool) {
+	query := req.URL.Query()
+
+	client, err := o.Storage.GetClient(query.Get("client_id"))
+	if err == core.ErrClientNotFound {
+		o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"invalid_client", "Unknown client"})
+		return nil, false
+	} else if err != nil {
+		o.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	request := &authorizeRequest{
+		Client:              client,
+		RedirectURI:         query.Get("redirect_uri"),
+		Scopes:              splitScopes(query.Get("scope")),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+		Nonce:               query.Get("nonce"),
+	}
+
+	if request.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		request.RedirectURI = client.RedirectURIs[0]
+	}
+	if !client.AllowsRedirect(request.RedirectURI) {
+		o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"invalid_request", "Redirect URI not registered for the client"})
+		return nil, false
+	}
+
+	if len(request.Scopes) == 0 {
+		request.Scopes = client.Scopes
+	}
+
+	switch {
+	case query.Get("response_type") != "code":
+		o.redirectError(rw, req, request, "unsupported_response_type", "Only code response type is supported")
+	case !client.AllowsScopes(request.Scopes):
+		o.redirectError(rw, req, request, "invalid_scope", "Client is not allowed to request the scopes")
+	case client.Public && request.CodeChallenge == "":
+		o.redirectError(rw, req, request, "invalid_request", "Public clients have to use PKCE")
+	case request.CodeChallenge != "" && request.CodeChallengeMethod != pkceMethodS256:
+		o.redirectError(rw, req, request, "invalid_request", "Only S256 code challenge method is supported")
+	default:
+		return request, true
+	}
+	return nil, false
+}
+
+// sessionUser finds user logged in with session cookie, showing login page
+// otherwise. Access tokens are rejected, so token with narrow scopes can't
+// grant consent to any client for any scope
+func (o *oauthController) sessionUser(rw http.ResponseWriter, req *http.Request) (*core.User, bool) {
+	if bearerToken(req) != "" {
+		o.Serializer.JSON(rw, http.StatusForbidden, ErrAccessTokenNotAllowed)
+		return nil, false
+	}
+
+	user, err := o.Auth.currentUser(req)
+	if err == nil {
+		return user, true
+	} else if err != core.ErrUserNotLoggedIn {
+		o.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	// Come back here once logged in
+	next := url.QueryEscape(req.URL.RequestURI())
+	names := make([]string, 0, len(o.Auth.Providers))
+	for name := range o.Auth.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	links := make([]map[string]string, 0, len(names))
+	for _, name := range names {
+		links = append(links, map[string]string{
+			"Name": name,
+			"URL":  o.Auth.Providers[name] + "?next=" + next,
+		})
+	}
+
+	renderPage(rw, http.StatusUnauthorized, loginPage, map[string]interface{}{
+		"Providers": links,
+	})
+	return nil, false
+}
+
+func (o *oauthController) redirectWithCode(rw http.ResponseWriter, req *http.Request, request *authorizeRequest, user *core.User) {
+	code := randomString(32)
+	err := o.Storage.AddAuthorizationCode(core.AuthorizationCode{
+		Hash:                hashSecret(code),
+		ClientID:            request.Client.ID,
+		Email:               user.Email,
+		RedirectURI:         request.RedirectURI,
+		Scopes:              request.Scopes,
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		Nonce:               request.Nonce,
+		Expires:             time.Now().Add(OAuthCodeTTL),
+	})
+	if err != nil {
+		o.redirectError(rw, req, request, "server_error", "")
+		return
+	}
+
+	o.redirectBack(rw, req, request, url.Values{"code": {code}})
+}
+
+func (o *oauthController) redirectError(rw http.ResponseWriter, req *http.Request, request *authorizeRequest, code string, description string) {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	o.redirectBack(rw, req, request, params)
+}
+
+// redirectBack sends user to client's redirect URI, keeping its own query
+func (o *oauthController) redirectBack(rw http.ResponseWriter, req *http.Request, request *authorizeRequest, params url.Values) {
+	target, _ := url.Parse(request.RedirectURI)
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	target.RawQuery = query.Encode()
+
+	http.Redirect(rw, req, target.String(), http.StatusFound)
+}
+
+// splitScopes splits space separated scopes, as sent by OAuth clients
+func splitScopes(value string) []string {
+	scopes := []string{}
+	for _, scope := range strings.Fields(value) {
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func containsString(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(items []string, wanted []string) bool {
+	for _, item := range wanted {
+		if !containsString(items, item) {
+			return false
+		}
+	}
+	return true
+}
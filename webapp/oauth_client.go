@@ -0,0 +1,118 @@
+package webapp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+var (
+	ErrClientName        = errors.New("OAuth client needs a name")
+	ErrClientRedirectURI = errors.New("OAuth client needs absolute redirect URIs without fragment")
+)
+
+// clientRequest describes client to register, e.g.
+// {"name": "Reports", "redirect_uris": ["https://reports.example.com/cb"], "scopes": ["billing:read"]}
+type clientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"`
+}
+
+// registeredClient is the only time client's secret is shown
+type registeredClient struct {
+	core.OAuthClient
+	Secret string `json:"client_secret,omitempty"`
+}
+
+func (o *oauthController) listClients(rw http.ResponseWriter, req *http.Request) {
+	clients, err := o.Storage.ListClients()
+	o.writeResult(rw, clients, err)
+}
+
+func (o *oauthController) createClient(rw http.ResponseWriter, req *http.Request) {
+	request := clientRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		o.Serializer.JSON(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	client, err := newClient(request)
+	if err != nil {
+		o.Serializer.JSON(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	secret := ""
+	if !client.Public {
+		secret = randomString(32)
+		client.SecretHash = hashSecret(secret)
+	}
+
+	if err := o.Storage.AddClient(client); err != nil {
+		o.writeResult(rw, nil, err)
+		return
+	}
+
+	client.SecretHash = ""
+	o.Serializer.JSON(rw, http.StatusCreated, registeredClient{OAuthClient: client, Secret: secret})
+}
+
+func (o *oauthController) deleteClient(rw http.ResponseWriter, req *http.Request) {
+	if err := o.Storage.DeleteClient(req.URL.Query().Get(":client")); err != nil {
+		o.writeResult(rw, nil, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (o *oauthController) writeResult(rw http.ResponseWriter, obj interface{}, err error) {
+	switch err {
+	case nil:
+		o.Serializer.JSON(rw, http.StatusOK, obj)
+	case core.ErrClientNotFound:
+		o.Serializer.JSON(rw, http.StatusNotFound, err)
+	default:
+		o.Serializer.JSON(rw, http.StatusInternalServerError, err)
+	}
+}
+
+// newClient validates registration request
+func newClient(request clientRequest) (core.OAuthClient, error) {
+	if strings.TrimSpace(request.Name) == "" {
+		return core.OAuthClient{}, ErrClientName
+	}
+
+	if len(request.RedirectURIs) == 0 {
+		return core.OAuthClient{}, ErrClientRedirectURI
+	}
+	for _, uri := range request.RedirectURIs {
+		parsed, err := url.Parse(uri)
+		// Native apps may use private schemes, e.g. com.example.app:/callback
+		web := parsed != nil && (parsed.Scheme == "http" || parsed.Scheme == "https")
+		if err != nil || !parsed.IsAbs() || web && parsed.Host == "" || parsed.Fragment != "" {
+			return core.OAuthClient{}, ErrClientRedirectURI
+		}
+	}
+
+	scopes := request.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return core.OAuthClient{
+		ID:           randomString(12),
+		Name:         strings.TrimSpace(request.Name),
+		RedirectURIs: request.RedirectURIs,
+		Scopes:       scopes,
+		Public:       request.Public,
+		Created:      time.Now(),
+	}, nil
+}
@@ -0,0 +1,360 @@
+package webapp_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/webapp"
+)
+
+const (
+	testRedirectURI = "https://reports.example.com/cb"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk-verifier"
+)
+
+type registeredClient struct {
+	core.OAuthClient
+	Secret string `json:"client_secret"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	IDToken      string `json:"id_token"`
+	Error        string `json:"error"`
+}
+
+func registerClient(t *testing.T, handler http.Handler, body string) registeredClient {
+	client := registeredClient{}
+	w := tokenRequest(handler, "POST", "/admin/oauth/clients/", "admin@example.com", "", body)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &client)
+	return client
+}
+
+func codeChallenge(verifier string) string {
+	digest := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func authorizePath(client registeredClient, scope string) string {
+	return "/oauth/authorize?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {scope},
+		"state":                 {"xyz"},
+		"code_challenge":        {codeChallenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+}
+
+func formRequest(handler http.Handler, path string, sessionId string, form url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if sessionId != "" {
+		req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
+	}
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// authorize goes through consent and returns code from redirect
+func authorize(t *testing.T, handler http.Handler, client registeredClient, scope string) string {
+	path := authorizePath(client, scope)
+	w := formRequest(handler, path, "bob@example.com", url.Values{"decision": {"allow"}})
+	assert.Equal(t, http.StatusFound, w.Code, w.Body.String())
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+	return location.Query().Get("code")
+}
+
+func exchange(handler http.Handler, client registeredClient, form url.Values) (*httptest.ResponseRecorder, tokenResponse) {
+	form.Set("client_id", client.ID)
+	if client.Secret != "" {
+		form.Set("client_secret", client.Secret)
+	}
+
+	response := tokenResponse{}
+	w := formRequest(handler, "/oauth/token", "", form)
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w, response
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+	memStorage.GrantPermission("bob@example.com", "billing:read")
+	memStorage.GrantPermission("bob@example.com", "billing:write")
+	client := registerClient(t, handler, `{"name": "Reports", "redirect_uris": ["`+testRedirectURI+`"], "scopes": ["billing:read"]}`)
+	assert.NotEmpty(t, client.Secret)
+
+	// Not logged in users are asked to log in first
+	w := tokenRequest(handler, "GET", authorizePath(client, "billing:read"), "", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Consent page shows client and scopes
+	w = tokenRequest(handler, "GET", authorizePath(client, "billing:read"), "bob@example.com", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Reports")
+	assert.Contains(t, w.Body.String(), "billing:read")
+
+	code := authorize(t, handler, client, "billing:read")
+	assert.NotEmpty(t, code)
+
+	w, tokens := exchange(handler, client, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.True(t, strings.HasPrefix(tokens.AccessToken, "oat_"))
+	assert.True(t, strings.HasPrefix(tokens.RefreshToken, "ort_"))
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	assert.Equal(t, "billing:read", tokens.Scope)
+
+	// Access token authenticates with its scopes only
+	user := core.User{}
+	w = tokenRequest(handler, "GET", "/who/", "", tokens.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "bob@example.com", user.Email)
+	assert.Equal(t, []string{"billing:read"}, user.Permissions)
+
+	// Refresh token is no access token
+	w = tokenRequest(handler, "GET", "/who/", "", tokens.RefreshToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Code can be used once
+	w, tokens = exchange(handler, client, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"code_verifier": {testVerifier},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_grant", tokens.Error)
+}
+
+func TestOAuthRemembersConsent(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+	client := registerClient(t, handler, `{"name": "Reports", "redirect_uris": ["`+testRedirectURI+`"], "scopes": ["billing:read", "billing:write"]}`)
+	authorize(t, handler, client, "billing:read")
+
+	w := tokenRequest(handler, "GET", authorizePath(client, "billing:read"), "bob@example.com", "", "")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Contains(t, w.Header().Get("Location"), "code=")
+
+	// Asking for more needs another consent
+	w = tokenRequest(handler, "GET", authorizePath(client, "billing:read billing:write"), "bob@example.com", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	granted, _ := memStorage.Consent("bob@example.com", client.ID)
+	assert.Equal(t, []string{"billing:read"}, granted)
+}
+
+func TestOAuthAuthorizeNeedsSession(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+	memStorage.GrantPermission("bob@example.com", "billing:read")
+	memStorage.GrantPermission("bob@example.com", "billing:write")
+	client := registerClient(t, handler, `{"name": "Reports", "redirect_uris": ["`+testRedirectURI+`"], "scopes": ["billing:read", "billing:write"]}`)
+	authorize(t, handler, client, "billing:read")
+	token := createToken(t, handler, "/tokens/", "bob@example.com", `{"name": "backup", "scopes": ["billing:read"]}`)
+
+	// Neither consent given before, nor new one, is used with access token
+	w := tokenRequest(handler, "GET", authorizePath(client, "billing:read"), "", token.Token, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = tokenRequest(handler, "POST", authorizePath(client, "billing:write"), "", token.Token, "decision=allow")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+
+	granted, _ := memStorage.Consent("bob@example.com", client.ID)
+	assert.Equal(t, []string{"billing:read"}, granted)
+}
+
+func TestOAuthAuthorizeErrors(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+	client := registerClient(t, handler, `{"name": "Mobile", "redirect_uris": ["`+testRedirectURI+`"], "scopes": ["billing:read"], "public": true}`)
+	assert.Empty(t, client.Secret)
+
+	// Errors are not sent to unknown redirect URIs
+	w := tokenRequest(handler, "GET", "/oauth/authorize?response_type=code&client_id=unknown", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = tokenRequest(handler, "GET", "/oauth/authorize?response_type=code&client_id="+client.ID+"&redirect_uri=https://evil.example.com/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	redirectError := func(query string) string {
+		w := tokenRequest(handler, "GET", "/oauth/authorize?client_id="+client.ID+"&"+query, "bob@example.com", "", "")
+		assert.Equal(t, http.StatusFound, w.Code)
+		location, _ := url.Parse(w.Header().Get("Location"))
+		return location.Query().Get("error")
+	}
+	challenge := "&code_challenge=" + codeChallenge(testVerifier) + "&code_challenge_method=S256"
+
+	assert.Equal(t, "unsupported_response_type", redirectError("response_type=token"+challenge))
+	assert.Equal(t, "invalid_scope", redirectError("response_type=code&scope=admin"+challenge))
+	assert.Equal(t, "invalid_request", redirectError("response_type=code")) // Public clients need PKCE
+	assert.Equal(t, "invalid_request", redirectError("response_type=code&code_challenge=abc&code_challenge_method=plain"))
+
+	// Denying consent
+	w = formRequest(handler, authorizePath(client, ""), "bob@example.com", url.Values{"decision": {"deny"}})
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Contains(t, w.Header().Get("Location"), "error=access_denied")
+
+	// Consent can't be given from other sites
+	req, _ := http.NewRequest("POST", authorizePath(client, ""), strings.NewReader("decision=allow"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: "bob@example.com"})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestOAuthTokenChecksClientAndVerifier(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+	client := registerClient(t, handler, `{"name": "Reports", "redirect_uris": ["`+testRedirectURI+`"]}`)
+	other := registerClient(t, handler, `{"name": "Other", "redirect_uris": ["`+testRedirectURI+`"]}`)
+
+	code := authorize(t, handler, client, "")
+	wrongSecret := client
+	wrongSecret.Secret = "wrong"
+	w, response := exchange(handler, wrongSecret, url.Values{"grant_type": {"authorization_code"}, "code": {code}})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "invalid_client", response.Error)
+
+	w, response = exchange(handler, other, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {testVerifier}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_grant", response.Error)
+
+	code = authorize(t, handler, client, "")
+	w, response = exchange(handler, client, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {testVerifier + "x"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_grant", response.Error)
+
+	// HTTP Basic authentication
+	code = authorize(t, handler, client, "")
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {testVerifier}}
+	req, _ := http.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(client.ID, client.Secret)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w, response = exchange(handler, client, url.Values{"grant_type": {"password"}})
+	assert.Equal(t, "unsupported_grant_type", response.Error)
+}
+
+func TestOAuthRefreshRotation(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+	client := registerClient(t, handler, `{"name": "Reports", "redirect_uris": ["`+testRedirectURI+`"], "scopes": ["billing:read", "billing:write"]}`)
+	code := authorize(t, handler, client, "billing:read billing:write")
+	_, first := exchange(handler, client, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {testVerifier}})
+
+	w, second := exchange(handler, client, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {first.RefreshToken}, "scope": {"billing:read"}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	assert.Equal(t, "billing:read", second.Scope)
+
+	// Other client can't use the token up
+	other := registerClient(t, handler, `{"name": "Other", "redirect_uris": ["`+testRedirectURI+`"]}`)
+	w, response := exchange(handler, other, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {second.RefreshToken}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_grant", response.Error)
+
+	w, response = exchange(handler, client, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {second.RefreshToken}, "scope": {"admin"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_scope", response.Error)
+
+	// Reusing rotated token revokes all tokens of the authorization
+	w, response = exchange(handler, client, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {first.RefreshToken}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_grant", response.Error)
+
+	w = tokenRequest(handler, "GET", "/who/", "", second.AccessToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = exchange(handler, client, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {second.RefreshToken}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestOAuthIntrospectAndRevoke(t *testing.T) {
+	handler, memStorage := makeAdminHandler(t)
+	client := registerClient(t, handler, `{"name": "Reports", "redirect_uris": ["`+testRedirectURI+`"], "scopes": ["billing:read"]}`)
+	code := authorize(t, handler, client, "billing:read")
+	_, tokens := exchange(handler, client, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {testVerifier}})
+
+	introspect := func(token string) map[string]interface{} {
+		result := map[string]interface{}{}
+		w := formRequest(handler, "/oauth/introspect", "", url.Values{"client_id": {client.ID}, "client_secret": {client.Secret}, "token": {token}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return result
+	}
+
+	result := introspect(tokens.AccessToken)
+	assert.Equal(t, true, result["active"])
+	assert.Equal(t, "bob@example.com", result["username"])
+	bob, _ := memStorage.GetUser("bob@example.com")
+	assert.Equal(t, bob.ID, result["sub"])
+	assert.Equal(t, "billing:read", result["scope"])
+	assert.Equal(t, client.ID, result["client_id"])
+	assert.Equal(t, false, introspect("oat_unknown")["active"])
+
+	// Unknown tokens are revoked just fine
+	w := formRequest(handler, "/oauth/revoke", "", url.Values{"client_id": {client.ID}, "client_secret": {client.Secret}, "token": {"ort_unknown"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = formRequest(handler, "/oauth/revoke", "", url.Values{"client_id": {client.ID}, "client_secret": {client.Secret}, "token": {tokens.RefreshToken}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, false, introspect(tokens.AccessToken)["active"])
+
+	// Tokens of disabled users are not active
+	code = authorize(t, handler, client, "billing:read")
+	_, tokens = exchange(handler, client, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {testVerifier}})
+	memStorage.DisableUser("bob@example.com")
+	assert.Equal(t, false, introspect(tokens.AccessToken)["active"])
+	w = tokenRequest(handler, "GET", "/who/", "", tokens.AccessToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestOAuthClientAdministration(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	w := tokenRequest(handler, "POST", "/admin/oauth/clients/", "bob@example.com", "", `{"name": "Reports", "redirect_uris": ["`+testRedirectURI+`"]}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = tokenRequest(handler, "POST", "/admin/oauth/clients/", "admin@example.com", "", `{"name": "Reports", "redirect_uris": ["/relative"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = tokenRequest(handler, "POST", "/admin/oauth/clients/", "admin@example.com", "", `{"redirect_uris": ["`+testRedirectURI+`"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	client := registerClient(t, handler, `{"name": "Mobile", "redirect_uris": ["com.example.app:/cb"], "public": true}`)
+
+	clients := []registeredClient{}
+	w = tokenRequest(handler, "GET", "/admin/oauth/clients/", "admin@example.com", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &clients)
+	if assert.Len(t, clients, 1) {
+		assert.Equal(t, client.ID, clients[0].ID)
+		assert.Empty(t, clients[0].Secret)
+	}
+
+	w = tokenRequest(handler, "DELETE", "/admin/oauth/clients/"+client.ID+"/", "admin@example.com", "", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = tokenRequest(handler, "DELETE", "/admin/oauth/clients/"+client.ID+"/", "admin@example.com", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,295 @@
+package webapp
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashtock/auth/core"
+)
+
+// tokenResponse is successful token response defined by RFC 6749
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// introspection is token introspection response defined by RFC 7662
+type introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Expires   int64  `json:"exp,omitempty"`
+}
+
+func (o *oauthController) token(rw http.ResponseWriter, req *http.Request) {
+	// Tokens must not be cached anywhere on the way
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Pragma", "no-cache")
+
+	client, ok := o.authenticateClient(rw, req)
+	if !ok {
+		return
+	}
+
+	switch req.PostFormValue("grant_type") {
+	case "authorization_code":
+		o.exchangeCode(rw, req, client)
+	case "refresh_token":
+		o.refresh(rw, req, client)
+	default:
+		o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"unsupported_grant_type", ""})
+	}
+}
+
+func (o *oauthController) exchangeCode(rw http.ResponseWriter, req *http.Request, client *core.OAuthClient) {
+	code, err := o.Storage.TakeAuthorizationCode(hashSecret(req.PostFormValue("code")))
+	if err == core.ErrCodeNotFound {
+		o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"invalid_grant", "Unknown or expired code"})
+		return
+	} else if err != nil {
+		o.Serializer.JSON(rw, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return
+	}
+
+	redirectURI := req.PostFormValue("redirect_uri")
+	switch {
+	case code.ClientID != client.ID:
+		o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"invalid_grant", "Code was issued to another client"})
+	case redirectURI != "" && redirectURI != code.RedirectURI:
+		o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"invalid_grant", "Redirect URI does not match"})
+	case !verifyCodeChallenge(code, req.PostFormValue("code_verifier")):
+		o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"invalid_grant", "Code verifier does not match"})
+	default:
+		o.issueTokens(rw, client, code.Email, code.Scopes, randomString(12), code.Nonce)
+	}
+}
+
+func (o *oauthController) refresh(rw http.ResponseWriter, req *http.Request, client *core.OAuthClient) {
+	// Token of another client is not found, so that client can't use it up
+	token, err := o.Storage.UseRefreshToken(hashSecret(req.PostFormValue("refresh_token")), client.ID)
+	if err == core.ErrOAuthTokenReused {
+		// Either client or attacker holds stolen token, so neither can continue
+		o.Storage.RevokeOAuthFamily(token.Family)
+		o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"invalid_grant", "Refresh token already used"})
+		return
+	} else if err == core.ErrOAuthTokenNotFound {
+		o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"invalid_grant", "Unknown or expired refresh token"})
+		return
+	} else if err != nil {
+		o.Serializer.JSON(rw, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return
+	}
+
+	// Client may ask for fewer scopes than originally granted
+	scopes := token.Scopes
+	if requested := splitScopes(req.PostFormValue("scope")); len(requested) > 0 {
+		if !containsAll(token.Scopes, requested) {
+			o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"invalid_scope", ""})
+			return
+		}
+		scopes = requested
+	}
+
+	o.issueTokens(rw, client, token.Email, scopes, token.Family, "")
+}
+
+// issueTokens responds with new access and refresh token pair for active
+// user, together with ID token for OpenID Connect clients
+func (o *oauthController) issueTokens(rw http.ResponseWriter, client *core.OAuthClient, email string, scopes []string, family string, nonce string) {
+	user, err := o.activeUser(email)
+	if err != nil {
+		o.Serializer.JSON(rw, http.StatusBadRequest, oauthError{"invalid_grant", "User not active"})
+		return
+	}
+
+	idToken, err := o.issueIDToken(client, user, scopes, nonce)
+	if err != nil {
+		o.Serializer.JSON(rw, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return
+	}
+
+	now := time.Now()
+	accessToken := oauthAccessTokenPrefix + randomString(32)
+	refreshToken := oauthRefreshTokenPrefix + randomString(32)
+
+	tokens := []core.OAuthToken{
+		{
+			Hash:    hashSecret(accessToken),
+			Kind:    core.OAuthAccessToken,
+			Expires: now.Add(OAuthAccessTokenTTL),
+		},
+		{
+			Hash:    hashSecret(refreshToken),
+			Kind:    core.OAuthRefreshToken,
+			Expires: now.Add(OAuthRefreshTokenTTL),
+		},
+	}
+	for _, token := range tokens {
+		token.Family = family
+		token.ClientID = client.ID
+		token.Email = email
+		token.Scopes = scopes
+		token.Created = now
+
+		if err := o.Storage.AddOAuthToken(token); err != nil {
+			o.Serializer.JSON(rw, http.StatusInternalServerError, oauthError{"server_error", ""})
+			return
+		}
+	}
+
+	o.Serializer.JSON(rw, http.StatusOK, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(OAuthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+		IDToken:      idToken,
+	})
+}
+
+// introspect tells resource servers whether token is active and whose it is
+func (o *oauthController) introspect(rw http.ResponseWriter, req *http.Request) {
+	client, ok := o.authenticateClient(rw, req)
+	if !ok {
+		return
+	} else if client.Public {
+		o.Serializer.JSON(rw, http.StatusUnauthorized, oauthError{"invalid_client", "Public clients can't introspect tokens"})
+		return
+	}
+
+	token, err := o.Storage.GetOAuthToken(hashSecret(req.PostFormValue("token")))
+	if err != nil || token.Used {
+		o.Serializer.JSON(rw, http.StatusOK, introspection{Active: false})
+		return
+	}
+
+	user, err := o.activeUser(token.Email)
+	if err != nil {
+		o.Serializer.JSON(rw, http.StatusOK, introspection{Active: false})
+		return
+	}
+
+	tokenType := "Bearer"
+	if token.Kind == core.OAuthRefreshToken {
+		tokenType = core.OAuthRefreshToken
+	}
+
+	o.Serializer.JSON(rw, http.StatusOK, introspection{
+		Active:    true,
+		Scope:     strings.Join(token.Scopes, " "),
+		ClientID:  token.ClientID,
+		Username:  token.Email,
+		Subject:   user.ID,
+		TokenType: tokenType,
+		Issuer:    o.Auth.Issuer,
+		IssuedAt:  token.Created.Unix(),
+		Expires:   token.Expires.Unix(),
+	})
+}
+
+// revoke revokes token together with all tokens issued from the same
+// authorization. Unknown tokens are not an error, as per RFC 7009
+func (o *oauthController) revoke(rw http.ResponseWriter, req *http.Request) {
+	client, ok := o.authenticateClient(rw, req)
+	if !ok {
+		return
+	}
+
+	token, err := o.Storage.GetOAuthToken(hashSecret(req.PostFormValue("token")))
+	if err == nil && token.ClientID == client.ID {
+		if err := o.Storage.RevokeOAuthFamily(token.Family); err != nil {
+			o.Serializer.JSON(rw, http.StatusServiceUnavailable, oauthError{"server_error", ""})
+			return
+		}
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// authenticateClient checks client credentials sent with HTTP Basic auth or
+// in the form. Public clients identify themselves with client_id only
+func (o *oauthController) authenticateClient(rw http.ResponseWriter, req *http.Request) (*core.OAuthClient, bool) {
+	clientId, secret, basic := req.BasicAuth()
+	if basic {
+		// Credentials are form encoded before being put in the header
+		clientId, _ = url.QueryUnescape(clientId)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientId = req.PostFormValue("client_id")
+		secret = req.PostFormValue("client_secret")
+	}
+
+	client, err := o.Storage.GetClient(clientId)
+	if err != nil && err != core.ErrClientNotFound {
+		o.Serializer.JSON(rw, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return nil, false
+	}
+
+	if err == nil && (client.Public && secret == "" || !client.Public && secretMatches(client.SecretHash, secret)) {
+		return client, true
+	}
+
+	if basic {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	o.Serializer.JSON(rw, http.StatusUnauthorized, oauthError{"invalid_client", "Client authentication failed"})
+	return nil, false
+}
+
+// userFromToken finds user OAuth access token was issued for, restricted to token's scopes
+func (o *oauthController) userFromToken(secret string) (*core.User, error) {
+	token, err := o.Storage.GetOAuthToken(hashSecret(secret))
+	if err == core.ErrOAuthTokenNotFound || err == nil && token.Kind != core.OAuthAccessToken {
+		return nil, core.ErrUserNotLoggedIn
+	} else if err != nil {
+		return nil, err
+	}
+
+	user, err := o.activeUser(token.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return core.AccessToken{Scopes: token.Scopes}.Restrict(user), nil
+}
+
+// activeUser returns user unless user was removed or disabled
+func (o *oauthController) activeUser(email string) (*core.User, error) {
+	user, err := o.Users.GetUser(email)
+	if err == core.ErrUserNotFound || err == nil && user.Disabled {
+		return nil, core.ErrUserNotLoggedIn
+	}
+	return user, err
+}
+
+func secretMatches(hash string, secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(hashSecret(secret))) == 1
+}
+
+// verifyCodeChallenge checks PKCE verifier (RFC 7636) for codes issued with challenge
+func verifyCodeChallenge(code *core.AuthorizationCode, verifier string) bool {
+	if code.CodeChallenge == "" {
+		return true
+	}
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	digest := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(digest[:])
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) == 1
+}
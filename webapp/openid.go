@@ -0,0 +1,137 @@
+package webapp
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/jwt"
+)
+
+const (
+	IDTokenTTL = time.Hour
+
+	// Scopes defined by OpenID Connect. Clients have to be registered with them
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+)
+
+// discovery is OpenID Provider metadata, as defined by OpenID Connect Discovery
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	IntrospectionEndpoint string   `json:"introspection_endpoint"`
+	RevocationEndpoint    string   `json:"revocation_endpoint"`
+	ScopesSupported       []string `json:"scopes_supported"`
+	ResponseTypes         []string `json:"response_types_supported"`
+	GrantTypes            []string `json:"grant_types_supported"`
+	SubjectTypes          []string `json:"subject_types_supported"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+	AuthMethods           []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported       []string `json:"claims_supported"`
+}
+
+// openIDConfiguration serves discovery document telling OIDC clients where to find endpoints
+func (o *oauthController) openIDConfiguration(rw http.ResponseWriter, req *http.Request) {
+	o.Serializer.JSON(rw, http.StatusOK, discovery{
+		Issuer:                o.Auth.Issuer,
+		AuthorizationEndpoint: o.Endpoints["authorize"],
+		TokenEndpoint:         o.Endpoints["token"],
+		UserinfoEndpoint:      o.Endpoints["userinfo"],
+		JWKSURI:               o.Endpoints["jwks"],
+		IntrospectionEndpoint: o.Endpoints["introspect"],
+		RevocationEndpoint:    o.Endpoints["revoke"],
+		ScopesSupported:       []string{scopeOpenID, scopeProfile, scopeEmail, core.ScopeAdmin},
+		ResponseTypes:         []string{"code"},
+		GrantTypes:            []string{"authorization_code", "refresh_token"},
+		SubjectTypes:          []string{"public"},
+		SigningAlgorithms:     []string{o.IDTokens.Algorithm},
+		AuthMethods:           []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethods:  []string{pkceMethodS256},
+		ClaimsSupported:       []string{"iss", "sub", "aud", "exp", "iat", "nonce", "email", "name", "picture", "admin", "roles", "permissions"},
+	})
+}
+
+// userinfo returns claims about user the access token was issued for,
+// the OpenID Connect counterpart of /who/
+func (o *oauthController) userinfo(rw http.ResponseWriter, req *http.Request) {
+	token, err := o.Storage.GetOAuthToken(hashSecret(bearerToken(req)))
+	if err == nil && token.Kind != core.OAuthAccessToken {
+		err = core.ErrOAuthTokenNotFound
+	}
+	if err != nil && err != core.ErrOAuthTokenNotFound {
+		o.Serializer.JSON(rw, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return
+	}
+
+	var user *core.User
+	if err == nil {
+		user, err = o.activeUser(token.Email)
+	}
+	if err != nil {
+		rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		o.Serializer.JSON(rw, http.StatusUnauthorized, oauthError{"invalid_token", ""})
+		return
+	}
+
+	if !containsString(token.Scopes, scopeOpenID) {
+		rw.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		o.Serializer.JSON(rw, http.StatusForbidden, oauthError{"insufficient_scope", ""})
+		return
+	}
+
+	o.Serializer.JSON(rw, http.StatusOK, userClaims(user, token.Scopes))
+}
+
+// issueIDToken signs ID token for the client, when openid scope was granted
+func (o *oauthController) issueIDToken(client *core.OAuthClient, user *core.User, scopes []string, nonce string) (string, error) {
+	if o.IDTokens == nil || !containsString(scopes, scopeOpenID) {
+		return "", nil
+	}
+
+	now := time.Now()
+	claims := userClaims(user, scopes)
+	claims["iss"] = o.Auth.Issuer
+	claims["aud"] = client.ID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(IDTokenTTL).Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return o.IDTokens.Sign(claims)
+}
+
+// userClaims describes user with standard claims of granted scopes. Admin
+// flag and roles describe the user, while permissions are limited to scopes
+func userClaims(user *core.User, scopes []string) jwt.Claims {
+	claims := jwt.Claims{"sub": user.ID}
+
+	if containsString(scopes, scopeEmail) {
+		claims["email"] = user.Email
+	}
+
+	if containsString(scopes, scopeProfile) {
+		claims["name"] = user.Name
+		if user.Avatar != "" {
+			claims["picture"] = user.Avatar
+		}
+		claims["admin"] = user.Admin
+		roles := user.Roles
+		if roles == nil {
+			roles = []string{}
+		}
+		claims["roles"] = roles
+	}
+
+	if permissions := (core.AccessToken{Scopes: scopes}).Restrict(user).Permissions; len(permissions) > 0 {
+		claims["permissions"] = permissions
+	}
+
+	return claims
+}
@@ -0,0 +1,163 @@
+package webapp_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/jwt"
+	"github.com/hashtock/auth/webapp"
+)
+
+func newEd25519Signer(t *testing.T) *jwt.Signer {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := jwt.NewSigner(key)
+	assert.NoError(t, err)
+	return signer
+}
+
+func publishedKeys(handler http.Handler) jwt.KeySet {
+	keys := jwt.KeySet{}
+	w := tokenRequest(handler, "GET", "/.well-known/jwks.json", "", "", "")
+	json.Unmarshal(w.Body.Bytes(), &keys)
+	return keys
+}
+
+func TestOpenIDDiscovery(t *testing.T) {
+	signer := newEd25519Signer(t)
+	handler, _ := makeAdminHandlerWithOptions(t, webapp.Options{IDTokenSigner: signer})
+
+	config := map[string]interface{}{}
+	w := tokenRequest(handler, "GET", "/.well-known/openid-configuration", "", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &config)
+
+	assert.Equal(t, "http://localhost:1234/", config["issuer"])
+	assert.Equal(t, "http://localhost:1234/oauth/authorize", config["authorization_endpoint"])
+	assert.Equal(t, "http://localhost:1234/oauth/token", config["token_endpoint"])
+	assert.Equal(t, "http://localhost:1234/oauth/userinfo", config["userinfo_endpoint"])
+	assert.Equal(t, "http://localhost:1234/.well-known/jwks.json", config["jwks_uri"])
+	assert.Equal(t, []interface{}{"EdDSA"}, config["id_token_signing_alg_values_supported"])
+
+	keys := publishedKeys(handler)
+	if assert.Len(t, keys.Keys, 1) {
+		assert.Equal(t, signer.KeyID, keys.Keys[0].KeyID)
+	}
+
+	// Keys of both session and ID tokens are published
+	handler, _ = makeAdminHandlerWithOptions(t, webapp.Options{TokenSigner: signer, IDTokenSigner: newEd25519Signer(t)})
+	assert.Len(t, publishedKeys(handler).Keys, 2)
+}
+
+func TestOpenIDDisabledWithoutSigner(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+	w := tokenRequest(handler, "GET", "/.well-known/openid-configuration", "", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Clients can't verify tokens signed with shared secret
+	handler, _ = makeAdminHandlerWithOptions(t, webapp.Options{IDTokenSigner: jwt.NewHMACSigner([]byte("secret"))})
+	w = tokenRequest(handler, "GET", "/.well-known/openid-configuration", "", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// ID tokens need their own key, not one of session tokens
+	signer := newEd25519Signer(t)
+	for _, options := range []webapp.Options{{TokenSigner: signer}, {TokenSigner: signer, IDTokenSigner: signer}} {
+		handler, _ = makeAdminHandlerWithOptions(t, options)
+		w = tokenRequest(handler, "GET", "/.well-known/openid-configuration", "", "", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+}
+
+func TestIDTokenIsNotSession(t *testing.T) {
+	idSigner := newEd25519Signer(t)
+	handler, _ := makeAdminHandlerWithOptions(t, webapp.Options{TokenSigner: newEd25519Signer(t), IDTokenSigner: idSigner})
+
+	idToken, _ := idSigner.Sign(jwt.Claims{
+		"iss":   "http://localhost:1234/",
+		"aud":   "partner-app",
+		"sub":   "admin",
+		"email": "admin@example.com",
+		"admin": true,
+		"exp":   4102444800,
+	})
+	w := tokenRequest(handler, "GET", "/who/", idToken, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestOpenIDCodeFlow(t *testing.T) {
+	handler, memStorage := makeAdminHandlerWithOptions(t, webapp.Options{IDTokenSigner: newEd25519Signer(t)})
+	memStorage.GrantRole("bob@example.com", "trader")
+	memStorage.GrantPermission("bob@example.com", "billing:read")
+	memStorage.GrantPermission("bob@example.com", "billing:write")
+	bob, _ := memStorage.GetUser("bob@example.com")
+	client := registerClient(t, handler, `{"name": "Grafana", "redirect_uris": ["`+testRedirectURI+`"], "scopes": ["openid", "profile", "email", "billing:read"]}`)
+
+	keys := publishedKeys(handler)
+
+	path := authorizePath(client, "openid profile email billing:read") + "&nonce=n-0S6_WzA2Mj"
+	w := formRequest(handler, path, "bob@example.com", url.Values{"decision": {"allow"}})
+	location, _ := url.Parse(w.Header().Get("Location"))
+	code := location.Query().Get("code")
+
+	w, tokens := exchange(handler, client, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {testVerifier}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	idToken, err := jwt.Parse(tokens.IDToken)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, idToken.VerifyWithKeySet(keys))
+	assert.NoError(t, idToken.Claims.Validate("http://localhost:1234/", idToken.Claims.Time("iat")))
+	assert.True(t, idToken.Claims.HasAudience(client.ID))
+	assert.Equal(t, "n-0S6_WzA2Mj", idToken.Claims.String("nonce"))
+	assert.NotEmpty(t, bob.ID)
+	assert.Equal(t, bob.ID, idToken.Claims.String("sub"))
+	assert.Equal(t, "bob@example.com", idToken.Claims.String("email"))
+	assert.Equal(t, "Bob", idToken.Claims.String("name"))
+	assert.Equal(t, false, idToken.Claims["admin"])
+	assert.Equal(t, []interface{}{"trader"}, idToken.Claims["roles"])
+	assert.Equal(t, []interface{}{"billing:read"}, idToken.Claims["permissions"])
+
+	// Userinfo holds the same claims
+	claims := map[string]interface{}{}
+	w = tokenRequest(handler, "GET", "/oauth/userinfo", "", tokens.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &claims)
+	assert.Equal(t, bob.ID, claims["sub"])
+	assert.Equal(t, "Bob", claims["name"])
+	assert.Equal(t, []interface{}{"trader"}, claims["roles"])
+	assert.Nil(t, claims["nonce"])
+
+	w = tokenRequest(handler, "GET", "/oauth/userinfo", "", "oat_unknown", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "invalid_token")
+	w = tokenRequest(handler, "GET", "/oauth/userinfo", "", tokens.RefreshToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Refreshed ID token has no nonce
+	_, refreshed := exchange(handler, client, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}, "scope": {"openid"}})
+	idToken, err = jwt.Parse(refreshed.IDToken)
+	if assert.NoError(t, err) {
+		assert.Equal(t, bob.ID, idToken.Claims.String("sub"))
+		assert.Empty(t, idToken.Claims.String("nonce"))
+		assert.Empty(t, idToken.Claims.String("email")) // Not in narrowed scopes
+	}
+}
+
+func TestOpenIDRequiresOpenIDScope(t *testing.T) {
+	handler, _ := makeAdminHandlerWithOptions(t, webapp.Options{IDTokenSigner: newEd25519Signer(t)})
+	client := registerClient(t, handler, `{"name": "Reports", "redirect_uris": ["`+testRedirectURI+`"], "scopes": ["openid", "billing:read"]}`)
+
+	code := authorize(t, handler, client, "billing:read")
+	_, tokens := exchange(handler, client, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {testVerifier}})
+	assert.Empty(t, tokens.IDToken)
+
+	w := tokenRequest(handler, "GET", "/oauth/userinfo", "", tokens.AccessToken, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "insufficient_scope")
+}
@@ -0,0 +1,54 @@
+package webapp
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// Pages are kept minimal, they are shown only during OAuth authorization
+var (
+	loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<h1>Sign in</h1>
+<ul>
+{{range .Providers}}<li><a href="{{.URL}}">Sign in with {{.Name}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+	consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize {{.Client.Name}}</title></head>
+<body>
+<h1>{{.Client.Name}} wants to access your account</h1>
+<p>Signed in as {{.User.Email}}</p>
+{{if .Scopes}}<p>It asks for:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>{{end}}
+<form method="POST" action="{{.Action}}">
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+)
+
+func renderPage(rw http.ResponseWriter, status int, page *template.Template, data interface{}) {
+	header := rw.Header()
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("Cache-Control", "no-store")
+	// Consent must not be clickjacked from other sites
+	header.Set("X-Frame-Options", "DENY")
+	header.Set("Content-Security-Policy", "frame-ancestors 'none'")
+	rw.WriteHeader(status)
+
+	if err := page.Execute(rw, data); err != nil {
+		log.Printf("Could not render %v page. Err: %v", page.Name(), err)
+	}
+}
@@ -0,0 +1,207 @@
+package webapp
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/core"
+)
+
+const (
+	MinPasswordLength = 8
+	// MaxPasswordLength is where bcrypt stops reading password
+	MaxPasswordLength = 72
+
+	// MaxPasswordFailures in a row lock password for PasswordLockout
+	MaxPasswordFailures = 5
+	PasswordLockout     = 15 * time.Minute
+)
+
+// PasswordCost is bcrypt cost new passwords are hashed with
+var PasswordCost = bcrypt.DefaultCost
+
+var (
+	ErrInvalidEmail   = errors.New("Valid email is required")
+	ErrPasswordLength = errors.New("Password has to be 8 to 72 characters long")
+)
+
+// newPasswordHash validates and hashes password chosen by user
+func newPasswordHash(password string) (string, error) {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return "", ErrPasswordLength
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
+	return string(hash), err
+}
+
+// formEmail returns email posted by user, if it is valid
+func formEmail(req *http.Request) string {
+	email := strings.TrimSpace(req.PostFormValue("email"))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return ""
+	}
+	return email
+}
+
+// register creates user with password and logs the user in
+func (a *authController) register(rw http.ResponseWriter, req *http.Request) {
+	target := redirectTarget(req)
+	if target != "" && !a.Redirects.Allowed(target) {
+		a.Serializer.JSON(rw, http.StatusBadRequest, ErrRedirectNotAllowed)
+		return
+	}
+
+	email := formEmail(req)
+	if email == "" {
+		a.Serializer.JSON(rw, http.StatusBadRequest, ErrInvalidEmail)
+		return
+	}
+	// Nobody checked the email belongs to the user
+	if !a.admitted(rw, req, conf.LocalProvider, email, false) {
+		return
+	}
+
+	hash, err := newPasswordHash(req.PostFormValue("password"))
+	if err == ErrPasswordLength {
+		a.Serializer.JSON(rw, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	user := &core.User{
+		Name:  strings.TrimSpace(req.PostFormValue("name")),
+		Email: email,
+	}
+	if err := a.Passwords.AddPasswordUser(user, hash); err != nil {
+		a.writePasswordResult(rw, err)
+		return
+	}
+
+	a.startSession(rw, req, conf.LocalProvider, target, http.StatusCreated, a.userSession(email))
+}
+
+// passwordLogin logs in user with email and password
+func (a *authController) passwordLogin(rw http.ResponseWriter, req *http.Request) {
+	target := redirectTarget(req)
+	if target != "" && !a.Redirects.Allowed(target) {
+		a.Serializer.JSON(rw, http.StatusBadRequest, ErrRedirectNotAllowed)
+		return
+	}
+
+	email := strings.TrimSpace(req.PostFormValue("email"))
+	err := a.verifyPassword(email, req.PostFormValue("password"))
+	if err == core.ErrUserNotFound || err == core.ErrNoPassword {
+		// Same response as for wrong password, so emails can't be probed
+		err = core.ErrInvalidCredentials
+	}
+	if err != nil {
+		a.writePasswordResult(rw, err)
+		return
+	}
+	if !a.admitted(rw, req, conf.LocalProvider, email, false) {
+		return
+	}
+
+	a.startSession(rw, req, conf.LocalProvider, target, http.StatusOK, a.userSession(email))
+}
+
+// userSession stores session for user with given email, who proved who they are
+func (a *authController) userSession(email string) func(session core.Session) (*core.User, error) {
+	return func(session core.Session) (*core.User, error) {
+		if err := a.Storage.AddUserToSession(session, &core.User{Email: email}); err != nil {
+			return nil, err
+		}
+		return a.Storage.GetUserBySession(session.ID)
+	}
+}
+
+// changePassword replaces password of logged in user, who has to know the
+// old one, and logs out all other sessions
+func (a *authController) changePassword(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	err := a.verifyPassword(user.Email, req.PostFormValue("old_password"))
+	if err == core.ErrInvalidCredentials {
+		// User is logged in, just can't do that
+		a.Serializer.JSON(rw, http.StatusForbidden, err)
+		return
+	} else if err != nil {
+		a.writePasswordResult(rw, err)
+		return
+	}
+
+	hash, err := newPasswordHash(req.PostFormValue("new_password"))
+	if err == ErrPasswordLength {
+		a.Serializer.JSON(rw, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := a.Passwords.SetPassword(user.Email, hash); err != nil {
+		a.writePasswordResult(rw, err)
+		return
+	}
+
+	// Whoever knew the old password is logged out
+	if err := a.Storage.RevokeOtherSessions(a.currentSessionId(req)); err != nil {
+		log.Printf("Could not revoke sessions after password change. Err: %v", err)
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// verifyPassword checks password of the user and counts failures, so
+// password gets locked after MaxPasswordFailures guesses
+func (a *authController) verifyPassword(email string, password string) error {
+	stored, err := a.Passwords.GetPassword(email)
+	if err == core.ErrUserNotFound || err == core.ErrNoPassword {
+		// Takes as long as checking real password, so emails can't be probed by timing
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if stored.Locked(now) {
+		return core.ErrAccountLocked
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(stored.Hash), []byte(password)) != nil {
+		if err := a.Passwords.PasswordFailed(email, MaxPasswordFailures, now.Add(PasswordLockout)); err != nil {
+			log.Printf("Could not count failed login. Err: %v", err)
+		}
+		return core.ErrInvalidCredentials
+	}
+
+	if stored.Failures > 0 {
+		return a.Passwords.PasswordSucceeded(email)
+	}
+	return nil
+}
+
+func (a *authController) writePasswordResult(rw http.ResponseWriter, err error) {
+	switch err {
+	case core.ErrInvalidCredentials:
+		a.Serializer.JSON(rw, http.StatusUnauthorized, err)
+	case core.ErrAccountLocked:
+		a.Serializer.JSON(rw, http.StatusTooManyRequests, err)
+	case core.ErrUserExists, core.ErrNoPassword:
+		a.Serializer.JSON(rw, http.StatusConflict, err)
+	case core.ErrUserNotFound:
+		a.Serializer.JSON(rw, http.StatusNotFound, err)
+	default:
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+	}
+}
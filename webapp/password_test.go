@@ -0,0 +1,219 @@
+package webapp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+	"github.com/hashtock/auth/webapp"
+)
+
+func init() {
+	webapp.PasswordCost = bcrypt.MinCost
+}
+
+func makePasswordHandler(t *testing.T) (http.Handler, *storage.MemStorage) {
+	return makeAdminHandlerWithOptions(t, webapp.Options{
+		Providers: []conf.Provider{{Name: conf.LocalProvider}},
+	})
+}
+
+// passwordRequest posts form as user logged in with sessionId
+func passwordRequest(handler http.Handler, path string, sessionId string, form url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if sessionId != "" {
+		req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: sessionId})
+	}
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func passwordLogin(handler http.Handler, email string, password string) *httptest.ResponseRecorder {
+	return passwordRequest(handler, "/login/local/", "", url.Values{"email": {email}, "password": {password}})
+}
+
+// sessionIdFrom returns session id set by response
+func sessionIdFrom(w *httptest.ResponseRecorder) string {
+	if cookie := sessionCookie(w); cookie != nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func TestLocalProviderListed(t *testing.T) {
+	handler, _ := makePasswordHandler(t)
+
+	providers := map[string]string{}
+	w := tokenRequest(handler, "GET", "/providers/", "", "", "")
+	json.Unmarshal(w.Body.Bytes(), &providers)
+	assert.Equal(t, map[string]string{"local": "/login/local/"}, providers)
+}
+
+func TestLocalProviderNeedsPasswordStorage(t *testing.T) {
+	handler, serializer, _ := makeHandlerWithProviders("", conf.Provider{Name: conf.LocalProvider})
+
+	tokenRequest(handler, "GET", "/providers/", "", "", "")
+	assert.Empty(t, serializer.obj)
+
+	w := passwordLogin(handler, "bob@example.com", "password")
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	handler, _ := makePasswordHandler(t)
+
+	form := url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}, "name": {"Carol"}}
+	w := passwordRequest(handler, "/login/local/register", "", form)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	user := core.User{}
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "carol@example.com", user.Email)
+	assert.Equal(t, "Carol", user.Name)
+	assert.NotEmpty(t, user.ID)
+
+	w = tokenRequest(handler, "GET", "/who/", sessionIdFrom(w), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Email can be registered once
+	w = passwordRequest(handler, "/login/local/register", "", form)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	form.Set("email", "bob@example.com")
+	w = passwordRequest(handler, "/login/local/register", "", form)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = tokenRequest(handler, "GET", "/who/", sessionIdFrom(w), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRegisterValidation(t *testing.T) {
+	handler, _ := makePasswordHandler(t)
+
+	invalid := []url.Values{
+		{"email": {"carol"}, "password": {"correct horse"}},
+		{"email": {"Carol <carol@example.com>"}, "password": {"correct horse"}},
+		{"email": {"carol@example.com"}, "password": {"short"}},
+		{"email": {"carol@example.com"}, "password": {strings.Repeat("x", 73)}},
+	}
+	for _, form := range invalid {
+		w := passwordRequest(handler, "/login/local/register", "", form)
+		assert.Equal(t, http.StatusBadRequest, w.Code, form.Encode())
+	}
+}
+
+func TestPasswordLoginFailures(t *testing.T) {
+	handler, memStorage := makePasswordHandler(t)
+	passwordRequest(handler, "/login/local/register", "", url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}})
+
+	// Unknown users and users of other providers look like wrong password
+	for _, email := range []string{"carol@example.com", "dave@example.com", "bob@example.com"} {
+		w := passwordLogin(handler, email, "wrong password")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, email)
+		assert.Empty(t, sessionIdFrom(w))
+	}
+
+	for i := 1; i < webapp.MaxPasswordFailures; i++ {
+		passwordLogin(handler, "carol@example.com", "wrong password")
+	}
+
+	// Even correct password doesn't work until lockout ends
+	w := passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	password, _ := memStorage.GetPassword("carol@example.com")
+	assert.WithinDuration(t, time.Now().Add(webapp.PasswordLockout), password.LockedUntil, time.Minute)
+}
+
+func TestPasswordLoginClearsFailures(t *testing.T) {
+	handler, memStorage := makePasswordHandler(t)
+	passwordRequest(handler, "/login/local/register", "", url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}})
+
+	passwordLogin(handler, "carol@example.com", "wrong password")
+	w := passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	password, _ := memStorage.GetPassword("carol@example.com")
+	assert.Equal(t, 0, password.Failures)
+}
+
+func TestPasswordLoginDisabledUser(t *testing.T) {
+	handler, memStorage := makePasswordHandler(t)
+	passwordRequest(handler, "/login/local/register", "", url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}})
+	memStorage.DisableUser("carol@example.com")
+
+	w := passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, sessionIdFrom(w))
+}
+
+func TestPasswordLoginRedirect(t *testing.T) {
+	handler, _ := makePasswordHandler(t)
+	form := url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}}
+
+	w := passwordRequest(handler, "/login/local/register?next=https://evil.example.com/", "", form)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = passwordRequest(handler, "/login/local/register?next=/welcome", "", form)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/welcome", w.HeaderMap.Get("Location"))
+	assert.NotEmpty(t, sessionIdFrom(w))
+}
+
+func TestPasswordLoginRejectsCrossOrigin(t *testing.T) {
+	handler, _ := makePasswordHandler(t)
+	passwordRequest(handler, "/login/local/register", "", url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login/local/", strings.NewReader("email=carol%40example.com&password=correct+horse"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example.com")
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestChangePassword(t *testing.T) {
+	handler, _ := makePasswordHandler(t)
+	w := passwordRequest(handler, "/login/local/register", "", url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}})
+	sessionId := sessionIdFrom(w)
+	otherSessionId := sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse"))
+
+	w = passwordRequest(handler, "/password/", sessionId, url.Values{"old_password": {"wrong password"}, "new_password": {"battery staple"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = passwordRequest(handler, "/password/", sessionId, url.Values{"old_password": {"correct horse"}, "new_password": {"short"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = passwordRequest(handler, "/password/", sessionId, url.Values{"old_password": {"correct horse"}, "new_password": {"battery staple"}})
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	// Other sessions are logged out
+	w = tokenRequest(handler, "GET", "/who/", sessionId, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = tokenRequest(handler, "GET", "/who/", otherSessionId, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = passwordLogin(handler, "carol@example.com", "battery staple")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestChangePasswordWithoutPassword(t *testing.T) {
+	handler, _ := makePasswordHandler(t)
+
+	w := passwordRequest(handler, "/password/", "bob@example.com", url.Values{"old_password": {"anything"}, "new_password": {"battery staple"}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = passwordRequest(handler, "/password/", "", url.Values{"old_password": {"anything"}, "new_password": {"battery staple"}})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
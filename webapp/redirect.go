@@ -0,0 +1,107 @@
+package webapp
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrRedirectNotAllowed = errors.New("Redirect target not allowed")
+
+const (
+	// defaultState is used when there is nothing to carry through provider
+	defaultState = "state"
+	// redirectStatePrefix marks state holding post-login redirect target
+	redirectStatePrefix = "next."
+)
+
+// redirectParams are query parameters of /login/{provider}/ holding
+// where to send user after login, in order of preference
+var redirectParams = []string{"next", "return_to"}
+
+// redirectPolicy decides where users can be sent after login, so login
+// can't be used as an open redirect
+type redirectPolicy struct {
+	origins map[string]bool
+}
+
+func newRedirectPolicy(appAddress *url.URL, origins []string) redirectPolicy {
+	policy := redirectPolicy{origins: make(map[string]bool, len(origins)+1)}
+
+	if appAddress != nil && appAddress.Host != "" {
+		policy.origins[originOf(appAddress)] = true
+	}
+	for _, origin := range origins {
+		if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			policy.origins[originOf(u)] = true
+		}
+	}
+	return policy
+}
+
+func originOf(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// Allowed accepts paths on this host and absolute URLs on allowed origins
+func (p redirectPolicy) Allowed(target string) bool {
+	// Browsers treat backslash as slash, "/\evil.com" would leave the host
+	if target == "" || strings.Contains(target, "\\") {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.User != nil || u.Opaque != "" {
+		return false
+	}
+
+	if !u.IsAbs() {
+		return u.Host == "" && strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	return p.origins[originOf(u)]
+}
+
+// redirectTarget returns post-login target requested when starting login
+func redirectTarget(req *http.Request) string {
+	query := req.URL.Query()
+	for _, param := range redirectParams {
+		if target := query.Get(param); target != "" {
+			return target
+		}
+	}
+	return ""
+}
+
+// loginState carries post-login target through provider as OAuth state
+func loginState(req *http.Request) string {
+	target := redirectTarget(req)
+	if target == "" {
+		return defaultState
+	}
+	return redirectStatePrefix + base64.RawURLEncoding.EncodeToString([]byte(target))
+}
+
+// stateTarget recovers post-login target from state returned by provider.
+// State comes back through the browser, so target has to be checked again
+func stateTarget(state string) string {
+	if !strings.HasPrefix(state, redirectStatePrefix) {
+		return ""
+	}
+
+	target, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(state, redirectStatePrefix))
+	if err != nil {
+		return ""
+	}
+	return string(target)
+}
+
+// wantsJSON tells API clients, which expect user object after login, from browsers
+func wantsJSON(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "application/json")
+}
@@ -0,0 +1,98 @@
+package webapp_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/webapp"
+)
+
+func makeRedirectHandler() (http.Handler, *serializerLog, *mapStorage) {
+	return makeHandlerWithOptions("", webapp.Options{
+		Providers:       []conf.Provider{{Name: "gplus"}},
+		RedirectOrigins: []string{"https://app.example.com"},
+	})
+}
+
+func TestLoginRedirectsToNext(t *testing.T) {
+	handler, _, storage := makeRedirectHandler()
+
+	w := loginFlowFrom(t, handler, "/login/faux/?next="+url.QueryEscape("/dashboard/?tab=1"), nil, nil)
+
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/dashboard/?tab=1", w.Header().Get("Location"))
+	assert.Contains(t, w.Header().Get("Set-Cookie"), webapp.SessionName)
+	assert.Len(t, storage.Data, 1)
+}
+
+func TestLoginRedirectsToAllowedOrigin(t *testing.T) {
+	handler, _, _ := makeRedirectHandler()
+
+	for _, target := range []string{"https://app.example.com/welcome", "http://localhost:1234/who/"} {
+		w := loginFlowFrom(t, handler, "/login/faux/?return_to="+url.QueryEscape(target), nil, nil)
+
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, target, w.Header().Get("Location"))
+	}
+}
+
+func TestLoginRejectsRedirectOutsideAllowList(t *testing.T) {
+	handler, serializer, _ := makeRedirectHandler()
+	goth.UseProviders(&testProvider{})
+
+	targets := []string{
+		"https://evil.example.com/",
+		"http://app.example.com/",
+		"//evil.example.com/",
+		"/\\evil.example.com/",
+		"https://app.example.com@evil.example.com/",
+		"javascript:alert(1)",
+		"dashboard/",
+	}
+	for _, target := range targets {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/login/faux/?next="+url.QueryEscape(target), nil)
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, target)
+		assert.Equal(t, webapp.ErrRedirectNotAllowed, serializer.obj)
+	}
+}
+
+func TestLoginReturnsJSONWhenAsked(t *testing.T) {
+	handler, serializer, _ := makeRedirectHandler()
+
+	header := http.Header{"Accept": {"application/json"}}
+	w := loginFlowFrom(t, handler, "/login/faux/?next=/dashboard/", nil, header)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+	assert.NotNil(t, serializer.obj)
+}
+
+func TestLoginIgnoresForgedState(t *testing.T) {
+	handler, serializer, _ := makeRedirectHandler()
+	goth.UseProviders(&testProvider{})
+
+	wLogin := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login/faux/", nil)
+	handler.ServeHTTP(wLogin, req)
+
+	// State comes back through browser, so it can't be trusted
+	state := "next." + base64.RawURLEncoding.EncodeToString([]byte("https://evil.example.com/"))
+	w := httptest.NewRecorder()
+	reqCallback, _ := http.NewRequest("GET", "/login/faux/callback?state="+state, nil)
+	reqCallback.Header["Cookie"] = wLogin.HeaderMap["Set-Cookie"]
+	handler.ServeHTTP(w, reqCallback)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+	assert.NotNil(t, serializer.obj)
+}
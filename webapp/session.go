@@ -0,0 +1,67 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/hashtock/auth/core"
+)
+
+// newSessionId generates unguessable session id
+func newSessionId() (string, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// clientIP returns address of the user. Behind trusted proxy it is the
+// last address proxy added to X-Forwarded-For, as earlier ones can be forged
+func (a *authController) clientIP(req *http.Request) string {
+	if a.TrustProxy {
+		forwarded := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (a *authController) listSessions(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	sessions, err := a.Storage.ListUserSessions(a.currentSessionId(req))
+	a.writeSessionResult(rw, sessions, err)
+}
+
+func (a *authController) revokeSession(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	err := a.Storage.RevokeUserSession(a.currentSessionId(req), req.URL.Query().Get(":session"))
+	a.writeSessionResult(rw, nil, err)
+}
+
+// revokeOtherSessions logs user out everywhere, but in this session
+func (a *authController) revokeOtherSessions(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	err := a.Storage.RevokeOtherSessions(a.currentSessionId(req))
+	a.writeSessionResult(rw, nil, err)
+}
+
+// writeSessionResult responds with 204 when there is nothing to return
+func (a *authController) writeSessionResult(rw http.ResponseWriter, obj interface{}, err error) {
+	switch {
+	case err == core.ErrSessionNotFound:
+		a.Serializer.JSON(rw, http.StatusNotFound, err)
+	case err != nil:
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+	case obj == nil:
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		a.Serializer.JSON(rw, http.StatusOK, obj)
+	}
+}
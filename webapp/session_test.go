@@ -0,0 +1,78 @@
+package webapp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/webapp"
+)
+
+func TestLoginRecordsSessionDetails(t *testing.T) {
+	handler, _, storage := makeHandlerWithOptions("", webapp.Options{TrustProxy: true})
+
+	header := http.Header{}
+	header.Set("User-Agent", "Firefox")
+	header.Set("X-Forwarded-For", "203.0.113.7, 192.0.2.1")
+	loginFlowFrom(t, handler, "/login/faux/", nil, header)
+
+	if assert.Len(t, storage.Sessions, 1) {
+		for _, session := range storage.Sessions {
+			assert.Equal(t, "Firefox", session.UserAgent)
+			assert.Equal(t, "192.0.2.1", session.IP)
+			assert.Equal(t, "faux", session.Provider)
+		}
+	}
+}
+
+func TestListAndRevokeOwnSessions(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	sessions := []core.Session{}
+	w := tokenRequest(handler, "GET", "/sessions/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &sessions)
+	if assert.Len(t, sessions, 2) {
+		assert.True(t, sessions[0].Current)
+		assert.False(t, sessions[1].Current)
+	}
+
+	w = tokenRequest(handler, "DELETE", "/sessions/"+sessions[1].PublicID+"/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = tokenRequest(handler, "GET", "/who/", "bob@example.com-other", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Sessions of other users can't be revoked
+	w = tokenRequest(handler, "GET", "/sessions/", "alice@example.com", "", "")
+	json.Unmarshal(w.Body.Bytes(), &sessions)
+	w = tokenRequest(handler, "DELETE", "/sessions/"+sessions[0].PublicID+"/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	w := tokenRequest(handler, "DELETE", "/sessions/", "bob@example.com-other", "", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = tokenRequest(handler, "GET", "/who/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = tokenRequest(handler, "GET", "/who/", "bob@example.com-other", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = tokenRequest(handler, "GET", "/who/", "alice@example.com", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestOwnSessionsNeedSession(t *testing.T) {
+	handler, _ := makeAdminHandler(t)
+
+	w := tokenRequest(handler, "GET", "/sessions/", "", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	token := createToken(t, handler, "/tokens/", "bob@example.com", `{"name": "ci"}`)
+	w = tokenRequest(handler, "DELETE", "/sessions/", "", token.Token, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
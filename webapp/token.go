@@ -0,0 +1,126 @@
+package webapp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/jwt"
+)
+
+// issueToken creates signed session token holding stored user
+func (a *authController) issueToken(session core.Session) (string, error) {
+	user, err := a.Storage.GetUserBySession(session.ID)
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := jwt.NewClaims(user)
+	if err != nil {
+		return "", err
+	}
+
+	claims["iss"] = a.Issuer
+	claims["token_use"] = jwt.SessionTokenUse
+	claims["sub"] = user.Email
+	claims["sid"] = a.sealSessionId(session.ID)
+	claims["iat"] = session.Created.Unix()
+	claims["exp"] = session.Expires.Unix()
+
+	return a.TokenSigner.Sign(claims)
+}
+
+// newSessionIdCipher makes cipher hiding session id in tokens, so services
+// reading tokens can't take over the session. Key is derived from secret,
+// random one (not surviving restarts) is used without it
+func newSessionIdCipher(secret string) cipher.AEAD {
+	var key []byte
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("session token sid"))
+		key = mac.Sum(nil)
+	} else {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+func (a *authController) sealSessionId(sessionId string) string {
+	nonce := make([]byte, a.sessionIdCipher.NonceSize())
+	rand.Read(nonce)
+	sealed := a.sessionIdCipher.Seal(nonce, nonce, []byte(sessionId), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// openSessionId returns session id hidden in token, or empty string when
+// it can't be decrypted
+func (a *authController) openSessionId(value string) string {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	size := a.sessionIdCipher.NonceSize()
+	if err != nil || len(sealed) < size {
+		return ""
+	}
+
+	sessionId, err := a.sessionIdCipher.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return ""
+	}
+	return string(sessionId)
+}
+
+// verifyToken checks session token and returns its claims
+func (a *authController) verifyToken(value string) (jwt.Claims, error) {
+	token, err := jwt.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.TokenSigner.Verify(token); err != nil {
+		return nil, err
+	}
+
+	if err := token.Claims.ValidateSession(a.Issuer, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return token.Claims, nil
+}
+
+func (a *authController) userFromToken(value string) (*core.User, error) {
+	claims, err := a.verifyToken(value)
+	if err != nil {
+		return nil, core.ErrUserNotLoggedIn
+	}
+
+	user := new(core.User)
+	err = claims.Decode(user)
+	return user, err
+}
+
+// jwks publishes keys of session and ID tokens
+func (a *authController) jwks(rw http.ResponseWriter, req *http.Request) {
+	keys := jwt.KeySet{Keys: []jwt.JSONWebKey{}}
+	if a.TokenSigner != nil {
+		keys = a.TokenSigner.KeySet()
+	}
+
+	if a.oauth != nil && a.oauth.IDTokens != nil {
+		for _, key := range a.oauth.IDTokens.KeySet().Keys {
+			if _, found := keys.Key(key.KeyID); !found {
+				keys.Keys = append(keys.Keys, key)
+			}
+		}
+	}
+
+	a.Serializer.JSON(rw, http.StatusOK, keys)
+}
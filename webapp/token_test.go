@@ -0,0 +1,140 @@
+package webapp_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/jwt"
+	"github.com/hashtock/auth/webapp"
+)
+
+func sessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	resp := http.Response{Header: w.HeaderMap}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == webapp.SessionName {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestTokenSessionLogin(t *testing.T) {
+	signer := jwt.NewHMACSigner([]byte("secret"))
+	handler, serializer, storage := makeHandlerWithOptions("", webapp.Options{TokenSigner: signer})
+
+	wOk := loginFlow(t, handler, nil)
+	assert.Equal(t, http.StatusOK, wOk.Code)
+
+	cookie := sessionCookie(wOk)
+	if !assert.NotNil(t, cookie) {
+		return
+	}
+
+	token, err := jwt.Parse(cookie.Value)
+	assert.NoError(t, err)
+	assert.NoError(t, signer.Verify(token))
+	assert.Equal(t, "http://localhost:1234", token.Claims.String("iss"))
+	assert.Equal(t, "email", token.Claims.String("email"))
+	// Session id is encrypted, services reading the token can't use it
+	assert.NotEmpty(t, token.Claims.String("sid"))
+	_, ok := storage.Sessions[token.Claims.String("sid")]
+	assert.False(t, ok)
+
+	// Who does not need storage with tokens
+	storage.NextError = errors.New("Storage should not be used")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/who/", nil)
+	req.AddCookie(cookie)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, &core.User{Name: "name", Email: "email"}, serializer.obj)
+}
+
+func TestTokenSessionInvalidToken(t *testing.T) {
+	signer := jwt.NewHMACSigner([]byte("secret"))
+	handler, serializer, _ := makeHandlerWithOptions("", webapp.Options{TokenSigner: signer})
+
+	forged, _ := jwt.NewHMACSigner([]byte("other secret")).Sign(jwt.Claims{
+		"iss":       "http://localhost:1234",
+		"email":     "email",
+		"exp":       4102444800,
+		"token_use": jwt.SessionTokenUse,
+	})
+	expired, _ := signer.Sign(jwt.Claims{
+		"iss":       "http://localhost:1234",
+		"email":     "email",
+		"exp":       1,
+		"token_use": jwt.SessionTokenUse,
+	})
+	// Tokens for other uses, like ID tokens, are signed by the same issuer
+	idToken, _ := signer.Sign(jwt.Claims{
+		"iss":   "http://localhost:1234",
+		"aud":   "partner-app",
+		"email": "email",
+		"admin": true,
+		"exp":   4102444800,
+	})
+
+	for _, token := range []string{"fake-session", forged, expired, idToken} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/who/", nil)
+		req.AddCookie(&http.Cookie{Name: webapp.SessionName, Value: token})
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.EqualValues(t, core.ErrUserNotLoggedIn, serializer.obj)
+	}
+}
+
+func TestTokenSessionLogout(t *testing.T) {
+	signer := jwt.NewHMACSigner([]byte("secret"))
+	handler, _, storage := makeHandlerWithOptions("", webapp.Options{TokenSigner: signer})
+
+	cookie := sessionCookie(loginFlow(t, handler, nil))
+	assert.Len(t, storage.Sessions, 1)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/logout/", nil)
+	req.AddCookie(cookie)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.HeaderMap["Set-Cookie"][0], "auth_session_id=;")
+	assert.Len(t, storage.Sessions, 0)
+}
+
+func TestJWKS(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := jwt.NewSigner(key)
+	assert.NoError(t, err)
+
+	handler, _, _ := makeHandlerWithOptions("", webapp.Options{TokenSigner: signer})
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `"kid":"`+signer.KeyID+`"`))
+	assert.True(t, strings.Contains(w.Body.String(), `"crv":"Ed25519"`))
+}
+
+func TestJWKSWithoutTokens(t *testing.T) {
+	handler, _, _ := makeHandler()
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"keys":[]}`, w.Body.String())
+}
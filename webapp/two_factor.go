@@ -0,0 +1,425 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"rsc.io/qr"
+
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/totp"
+)
+
+const (
+	// TwoFactorTimeout is how long user has to give the code after login
+	TwoFactorTimeout = 5 * time.Minute
+	// RecoveryCodes is how many recovery codes user gets with enabling
+	RecoveryCodes = 10
+)
+
+var ErrTwoFactorNotPending = errors.New("Session is not waiting for two-factor authentication code")
+
+// twoFactorChallenge tells client the user logged in, but has to give the
+// code at Verify, use passkey starting at WebAuthn, or set up second factor
+// first, starting at Enroll
+type twoFactorChallenge struct {
+	Verify   string `json:"verify,omitempty"`
+	WebAuthn string `json:"webauthn,omitempty"`
+	Enroll   string `json:"enroll,omitempty"`
+	Next     string `json:"next,omitempty"`
+}
+
+type twoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QR     string `json:"qr"`
+}
+
+type twoFactorStatus struct {
+	Enabled       bool `json:"enabled"`
+	Required      bool `json:"required"`
+	RecoveryCodes int  `json:"recovery_codes"`
+}
+
+// loginChallenge returns challenge, when user of fresh session has to give
+// second factor before the session can be used
+func (a *authController) loginChallenge(sessionId string) (*twoFactorChallenge, error) {
+	if a.TwoFactor == nil {
+		return nil, nil
+	}
+
+	user, err := a.Storage.GetUserBySession(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	return a.twoFactorChallenge(user)
+}
+
+// twoFactorChallenge lists second factors user can give. Passkeys are used
+// as second factor only when it is enabled or required
+func (a *authController) twoFactorChallenge(user *core.User) (*twoFactorChallenge, error) {
+	twoFactor, err := a.TwoFactor.GetTwoFactor(user.Email)
+	if err != nil && err != core.ErrTwoFactorNotEnabled {
+		return nil, err
+	}
+	enabled := err == nil && twoFactor.Enabled
+
+	passkeys, err := a.hasPasskeys(user)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := twoFactorChallenge{}
+	switch {
+	case enabled && passkeys:
+		challenge.Verify = a.twoFactorURL + "verify"
+		challenge.WebAuthn = a.twoFactorURL + "webauthn/begin"
+	case enabled:
+		challenge.Verify = a.twoFactorURL + "verify"
+	case a.twoFactorRequired(user) && passkeys:
+		challenge.WebAuthn = a.twoFactorURL + "webauthn/begin"
+	case a.twoFactorRequired(user):
+		challenge.Enroll = a.twoFactorURL + "enroll"
+	default:
+		return nil, nil
+	}
+	return &challenge, nil
+}
+
+func (a *authController) twoFactorRequired(user *core.User) bool {
+	return user.Admin && a.RequireAdminTwoFactor
+}
+
+// lacksSecondFactor tells user required to use two-factor authentication has
+// neither code nor passkey set up. Sessions started before it was required,
+// users made admins since, and tokens never met login challenge
+func (a *authController) lacksSecondFactor(user *core.User) (bool, error) {
+	if a.TwoFactor == nil || !a.twoFactorRequired(user) {
+		return false, nil
+	}
+
+	twoFactor, err := a.TwoFactor.GetTwoFactor(user.Email)
+	if err == nil && twoFactor.Enabled {
+		return false, nil
+	} else if err != nil && err != core.ErrTwoFactorNotEnabled {
+		return false, err
+	}
+
+	passkeys, err := a.hasPasskeys(user)
+	return !passkeys, err
+}
+
+// requireTwoFactor makes fresh session wait for the code
+func (a *authController) requireTwoFactor(rw http.ResponseWriter, sessionId string, target string, challenge *twoFactorChallenge) {
+	expires := time.Now().Add(TwoFactorTimeout)
+	if err := a.TwoFactor.SetTwoFactorPending(sessionId, true, expires); err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Raw id even with token sessions, as token would be trusted without the code
+	a.setSessionCookie(rw, sessionId, expires)
+
+	challenge.Next = target
+	a.Serializer.JSON(rw, http.StatusAccepted, challenge)
+}
+
+// twoFactorSessionId returns id of session from cookie. Cookie of session
+// waiting for the code holds raw id also with token sessions
+func (a *authController) twoFactorSessionId(req *http.Request) string {
+	if sessionId := a.currentSessionId(req); sessionId != "" {
+		return sessionId
+	}
+	if a.TokenSigner != nil {
+		return a.getSessionId(req)
+	}
+	return ""
+}
+
+// twoFactorUser wraps handler like sessionUser, but lets in also users who
+// still have to give the code, telling handler session is pending
+func (a *authController) twoFactorUser(handler func(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool)) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if bearerToken(req) != "" {
+			a.Serializer.JSON(rw, http.StatusForbidden, ErrAccessTokenNotAllowed)
+			return
+		}
+
+		sessionId := a.twoFactorSessionId(req)
+		if sessionId == "" {
+			a.writeError(rw, core.ErrUserNotLoggedIn)
+			return
+		}
+
+		user, err := a.Storage.GetUserBySession(sessionId)
+		pending := err == core.ErrTwoFactorPending
+		if pending {
+			user, err = a.TwoFactor.GetPendingUser(sessionId)
+		}
+		if err == core.ErrSessionNotFound {
+			err = core.ErrUserNotLoggedIn
+		}
+		if err != nil {
+			a.writeError(rw, err)
+			return
+		}
+
+		handler(rw, req, user, pending)
+	}
+}
+
+// enrollingUser wraps handler like twoFactorUser, but lets in only those
+// pending sessions, which wait for user to set up the first second factor
+func (a *authController) enrollingUser(handler func(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool)) http.HandlerFunc {
+	return a.twoFactorUser(func(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool) {
+		if pending {
+			challenge, err := a.twoFactorChallenge(user)
+			if err != nil {
+				a.writeTwoFactorResult(rw, err)
+				return
+			} else if challenge == nil || challenge.Enroll == "" {
+				a.Serializer.JSON(rw, http.StatusConflict, core.ErrTwoFactorEnabled)
+				return
+			}
+		}
+
+		handler(rw, req, user, pending)
+	})
+}
+
+func (a *authController) twoFactorStatus(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	status := twoFactorStatus{Required: a.twoFactorRequired(user)}
+
+	twoFactor, err := a.TwoFactor.GetTwoFactor(user.Email)
+	if err != nil && err != core.ErrTwoFactorNotEnabled {
+		a.writeTwoFactorResult(rw, err)
+		return
+	} else if err == nil && twoFactor.Enabled {
+		status.Enabled = true
+		status.RecoveryCodes = len(twoFactor.RecoveryCodes)
+	}
+
+	a.Serializer.JSON(rw, http.StatusOK, status)
+}
+
+// enrollTwoFactor generates new secret for authenticator app. It is not used
+// until user gives the first code to enableTwoFactor
+func (a *authController) enrollTwoFactor(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := a.TwoFactor.SetTwoFactor(user.Email, secret); err != nil {
+		a.writeTwoFactorResult(rw, err)
+		return
+	}
+
+	enrollment := twoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(a.appName, user.Email, secret),
+		QR:     a.twoFactorURL + "qr.png",
+	}
+	a.Serializer.JSON(rw, http.StatusOK, enrollment)
+}
+
+// twoFactorQR shows secret being enrolled as QR code to scan with the app
+func (a *authController) twoFactorQR(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool) {
+	twoFactor, err := a.TwoFactor.GetTwoFactor(user.Email)
+	if err == nil && twoFactor.Enabled {
+		// Secret is never shown again
+		err = core.ErrTwoFactorEnabled
+	}
+	if err != nil {
+		a.writeTwoFactorResult(rw, err)
+		return
+	}
+
+	code, err := qr.Encode(totp.URI(a.appName, user.Email, twoFactor.Secret), qr.M)
+	if err != nil {
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "image/png")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Write(code.PNG())
+}
+
+// enableTwoFactor completes enrolling, once user proves the app generates
+// codes. Responds with recovery codes, which are not shown again
+func (a *authController) enableTwoFactor(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool) {
+	twoFactor, err := a.TwoFactor.GetTwoFactor(user.Email)
+	if err == nil && twoFactor.Enabled {
+		err = core.ErrTwoFactorEnabled
+	}
+	if err != nil {
+		a.writeTwoFactorResult(rw, err)
+		return
+	}
+
+	if err := a.checkCode(user.Email, twoFactor, req); err != nil {
+		a.writeTwoFactorResult(rw, err)
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	if err := a.TwoFactor.EnableTwoFactor(user.Email, hashes); err != nil {
+		a.writeTwoFactorResult(rw, err)
+		return
+	}
+
+	if pending && !a.completeTwoFactor(rw, req) {
+		return
+	}
+
+	a.Serializer.JSON(rw, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// verifyTwoFactor completes login with the code or recovery code. Wrong code
+// ends the session, so each guess takes new login
+func (a *authController) verifyTwoFactor(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool) {
+	if !pending {
+		a.Serializer.JSON(rw, http.StatusConflict, ErrTwoFactorNotPending)
+		return
+	}
+
+	target := redirectTarget(req)
+	if target != "" && !a.Redirects.Allowed(target) {
+		a.Serializer.JSON(rw, http.StatusBadRequest, ErrRedirectNotAllowed)
+		return
+	}
+
+	twoFactor, err := a.TwoFactor.GetTwoFactor(user.Email)
+	if err == nil && !twoFactor.Enabled {
+		// Admin enrolling completes login with enableTwoFactor
+		err = core.ErrTwoFactorNotEnabled
+	} else if err == nil {
+		err = a.checkCode(user.Email, twoFactor, req)
+	}
+	if err == core.ErrInvalidCode {
+		if err := a.Storage.DeleteSession(a.twoFactorSessionId(req)); err != nil {
+			log.Printf("Could not remove session after wrong code. Err: %v", err)
+		}
+		http.SetCookie(rw, a.Cookie.cookie("", -1, time.Now().Add(-time.Hour)))
+	}
+	if err != nil {
+		a.writeTwoFactorResult(rw, err)
+		return
+	}
+
+	a.finishTwoFactor(rw, req, user, target)
+}
+
+// finishTwoFactor completes login once second factor is verified. Responds
+// with the user, or redirects browsers to target
+func (a *authController) finishTwoFactor(rw http.ResponseWriter, req *http.Request, user *core.User, target string) {
+	if !a.completeTwoFactor(rw, req) {
+		return
+	}
+
+	if target != "" && !wantsJSON(req) {
+		http.Redirect(rw, req, target, http.StatusSeeOther)
+		return
+	}
+
+	a.Serializer.JSON(rw, http.StatusOK, user)
+}
+
+// disableTwoFactor needs the code, so stolen session can't turn it off
+func (a *authController) disableTwoFactor(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	if a.twoFactorRequired(user) {
+		a.Serializer.JSON(rw, http.StatusForbidden, core.ErrTwoFactorRequired)
+		return
+	}
+
+	twoFactor, err := a.TwoFactor.GetTwoFactor(user.Email)
+	if err == nil && twoFactor.Enabled {
+		err = a.checkCode(user.Email, twoFactor, req)
+	}
+	if err == nil {
+		err = a.TwoFactor.DisableTwoFactor(user.Email)
+	}
+	if err != nil {
+		a.writeTwoFactorResult(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// completeTwoFactor lets pending session be used, with full session timeout.
+// Responds with error and returns false when it fails
+func (a *authController) completeTwoFactor(rw http.ResponseWriter, req *http.Request) bool {
+	sessionId := a.twoFactorSessionId(req)
+	now := time.Now()
+	session := core.Session{ID: sessionId, Created: now, Expires: now.Add(a.SessionTimeout)}
+	if err := a.TwoFactor.SetTwoFactorPending(sessionId, false, session.Expires); err != nil {
+		a.writeTwoFactorResult(rw, err)
+		return false
+	}
+
+	cookieValue := sessionId
+	if a.TokenSigner != nil {
+		token, err := a.issueToken(session)
+		if err != nil {
+			a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+			return false
+		}
+		cookieValue = token
+	}
+
+	a.setSessionCookie(rw, cookieValue, session.Expires)
+	return true
+}
+
+// checkCode accepts current code from the app, or unused recovery code. Each
+// works once
+func (a *authController) checkCode(email string, twoFactor *core.TwoFactor, req *http.Request) error {
+	if recoveryCode := req.PostFormValue("recovery_code"); recoveryCode != "" {
+		return a.TwoFactor.UseRecoveryCode(email, hashSecret(normalizeRecoveryCode(recoveryCode)))
+	}
+
+	step, ok := totp.Verify(twoFactor.Secret, strings.TrimSpace(req.PostFormValue("code")), time.Now())
+	if !ok {
+		return core.ErrInvalidCode
+	}
+	return a.TwoFactor.UseTwoFactorStep(email, step)
+}
+
+// newRecoveryCodes generates codes to show to the user, and their hashes to store
+func newRecoveryCodes() (codes []string, hashes []string) {
+	for i := 0; i < RecoveryCodes; i++ {
+		data := make([]byte, 10)
+		rand.Read(data)
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(data))
+
+		codes = append(codes, code[:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:])
+		hashes = append(hashes, hashSecret(code))
+	}
+	return codes, hashes
+}
+
+// normalizeRecoveryCode makes code typed in any case, with or without dashes, match
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func (a *authController) writeTwoFactorResult(rw http.ResponseWriter, err error) {
+	switch err {
+	case core.ErrInvalidCode, core.ErrTwoFactorRequired:
+		a.Serializer.JSON(rw, http.StatusForbidden, err)
+	case core.ErrTwoFactorEnabled, core.ErrTwoFactorNotEnabled:
+		a.Serializer.JSON(rw, http.StatusConflict, err)
+	case core.ErrSessionNotFound:
+		a.Serializer.JSON(rw, http.StatusUnauthorized, core.ErrUserNotLoggedIn)
+	default:
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,241 @@
+package webapp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/jwt"
+	"github.com/hashtock/auth/storage"
+	"github.com/hashtock/auth/totp"
+	"github.com/hashtock/auth/webapp"
+)
+
+type twoFactorChallenge struct {
+	Verify string `json:"verify"`
+	Enroll string `json:"enroll"`
+	Next   string `json:"next"`
+}
+
+func makeTwoFactorHandler(t *testing.T, options webapp.Options) (http.Handler, *storage.MemStorage) {
+	options.Providers = []conf.Provider{{Name: conf.LocalProvider}}
+	handler, memStorage := makeAdminHandlerWithOptions(t, options)
+	passwordRequest(handler, "/login/local/register", "", url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}})
+	return handler, memStorage
+}
+
+// twoFactorCode is the code app shows offset steps from now
+func twoFactorCode(secret string, offset int64) string {
+	code, _ := totp.Code(secret, totp.Step(time.Now())+offset)
+	return code
+}
+
+// enrollTwoFactor sets up two-factor authentication for user of the session
+// and returns secret and recovery codes
+func enrollTwoFactor(t *testing.T, handler http.Handler, sessionId string) (string, []string) {
+	enrollment := map[string]string{}
+	w := passwordRequest(handler, "/2fa/enroll", sessionId, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &enrollment)
+	secret := enrollment["secret"]
+
+	recovery := map[string][]string{}
+	w = passwordRequest(handler, "/2fa/enable", sessionId, url.Values{"code": {twoFactorCode(secret, 0)}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &recovery)
+	return secret, recovery["recovery_codes"]
+}
+
+// pendingLogin logs in with password and returns session waiting for the code
+func pendingLogin(t *testing.T, handler http.Handler) (string, twoFactorChallenge) {
+	challenge := twoFactorChallenge{}
+	w := passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	return sessionIdFrom(w), challenge
+}
+
+func TestTwoFactorEnrollAndLogin(t *testing.T) {
+	handler, _ := makeTwoFactorHandler(t, webapp.Options{})
+	sessionId := sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse"))
+
+	enrollment := map[string]string{}
+	w := passwordRequest(handler, "/2fa/enroll", sessionId, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &enrollment)
+	assert.True(t, strings.HasPrefix(enrollment["uri"], "otpauth://totp/"), enrollment["uri"])
+	assert.Equal(t, "http://localhost:1234/2fa/qr.png", enrollment["qr"])
+
+	w = tokenRequest(handler, "GET", "/2fa/qr.png", sessionId, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.HeaderMap.Get("Content-Type"))
+	assert.Equal(t, "no-store", w.HeaderMap.Get("Cache-Control"))
+
+	// Not enabled until app is known to work
+	w = passwordRequest(handler, "/2fa/enable", sessionId, url.Values{"code": {"000000"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	recovery := map[string][]string{}
+	w = passwordRequest(handler, "/2fa/enable", sessionId, url.Values{"code": {twoFactorCode(enrollment["secret"], 0)}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &recovery)
+	assert.Len(t, recovery["recovery_codes"], webapp.RecoveryCodes)
+
+	// Secret is not shown again
+	w = tokenRequest(handler, "GET", "/2fa/qr.png", sessionId, "", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = passwordRequest(handler, "/2fa/enroll", sessionId, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	pendingId, challenge := pendingLogin(t, handler)
+	assert.Equal(t, "http://localhost:1234/2fa/verify", challenge.Verify)
+
+	// Session can't be used before code is given
+	w = tokenRequest(handler, "GET", "/who/", pendingId, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = tokenRequest(handler, "GET", "/sessions/", pendingId, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = tokenRequest(handler, "GET", "/2fa/", pendingId, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = passwordRequest(handler, "/2fa/verify", pendingId, url.Values{"code": {twoFactorCode(enrollment["secret"], 1)}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, pendingId, sessionIdFrom(w))
+	w = tokenRequest(handler, "GET", "/who/", pendingId, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	status := map[string]interface{}{}
+	w = tokenRequest(handler, "GET", "/2fa/", pendingId, "", "")
+	json.Unmarshal(w.Body.Bytes(), &status)
+	assert.Equal(t, map[string]interface{}{"enabled": true, "required": false, "recovery_codes": float64(webapp.RecoveryCodes)}, status)
+
+	// Complete session has nothing to verify
+	w = passwordRequest(handler, "/2fa/verify", pendingId, url.Values{"code": {twoFactorCode(enrollment["secret"], 1)}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestTwoFactorWrongCodeEndsSession(t *testing.T) {
+	handler, _ := makeTwoFactorHandler(t, webapp.Options{})
+	secret, _ := enrollTwoFactor(t, handler, sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse")))
+
+	pendingId, _ := pendingLogin(t, handler)
+	w := passwordRequest(handler, "/2fa/verify", pendingId, url.Values{"code": {"000000"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = passwordRequest(handler, "/2fa/verify", pendingId, url.Values{"code": {twoFactorCode(secret, 1)}})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Code used for enabling can't be used again
+	pendingId, _ = pendingLogin(t, handler)
+	w = passwordRequest(handler, "/2fa/verify", pendingId, url.Values{"code": {twoFactorCode(secret, 0)}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestTwoFactorRecoveryCode(t *testing.T) {
+	handler, _ := makeTwoFactorHandler(t, webapp.Options{})
+	_, codes := enrollTwoFactor(t, handler, sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse")))
+
+	// Case and dashes don't matter
+	typed := strings.ToUpper(strings.Replace(codes[0], "-", "", -1))
+	pendingId, _ := pendingLogin(t, handler)
+	w := passwordRequest(handler, "/2fa/verify", pendingId, url.Values{"recovery_code": {typed}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	pendingId, _ = pendingLogin(t, handler)
+	w = passwordRequest(handler, "/2fa/verify", pendingId, url.Values{"recovery_code": {codes[0]}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestTwoFactorVerifyRedirect(t *testing.T) {
+	handler, _ := makeTwoFactorHandler(t, webapp.Options{})
+	secret, _ := enrollTwoFactor(t, handler, sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse")))
+
+	w := passwordRequest(handler, "/login/local/?next=/welcome", "", url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	challenge := twoFactorChallenge{}
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	assert.Equal(t, "/welcome", challenge.Next)
+
+	w = passwordRequest(handler, "/2fa/verify?next="+url.QueryEscape(challenge.Next), sessionIdFrom(w), url.Values{"code": {twoFactorCode(secret, 1)}})
+	assert.Equal(t, http.StatusSeeOther, w.Code, w.Body.String())
+	assert.Equal(t, "/welcome", w.HeaderMap.Get("Location"))
+}
+
+func TestTwoFactorWithTokenSessions(t *testing.T) {
+	handler, _ := makeTwoFactorHandler(t, webapp.Options{TokenSigner: jwt.NewHMACSigner([]byte("secret"))})
+	secret, _ := enrollTwoFactor(t, handler, sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse")))
+
+	// No token is issued until the code is given
+	pendingId, _ := pendingLogin(t, handler)
+	w := tokenRequest(handler, "GET", "/who/", pendingId, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = passwordRequest(handler, "/2fa/verify", pendingId, url.Values{"code": {twoFactorCode(secret, 1)}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	token := sessionIdFrom(w)
+	assert.NotEqual(t, pendingId, token)
+	w = tokenRequest(handler, "GET", "/who/", token, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestTwoFactorRequiredForAdmins(t *testing.T) {
+	handler, memStorage := makeTwoFactorHandler(t, webapp.Options{RequireAdminTwoFactor: true})
+	sessionId := sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse"))
+	memStorage.MakeUserAnAdmin("carol@example.com")
+
+	// Session started before can't use admin API until it is set up
+	w := tokenRequest(handler, "GET", "/admin/users/", sessionId, "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), core.ErrTwoFactorRequired.Error())
+
+	// Admin has to set it up on next login
+	pendingId, challenge := pendingLogin(t, handler)
+	assert.Equal(t, "http://localhost:1234/2fa/enroll", challenge.Enroll)
+	w = tokenRequest(handler, "GET", "/who/", pendingId, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Code of secret being enrolled doesn't complete login until it is enabled
+	enrollment := map[string]string{}
+	w = passwordRequest(handler, "/2fa/enroll", pendingId, nil)
+	json.Unmarshal(w.Body.Bytes(), &enrollment)
+	w = passwordRequest(handler, "/2fa/verify", pendingId, url.Values{"code": {twoFactorCode(enrollment["secret"], 0)}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	enrollTwoFactor(t, handler, pendingId)
+	w = tokenRequest(handler, "GET", "/who/", pendingId, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// And can't turn it off
+	w = passwordRequest(handler, "/2fa/disable", pendingId, url.Values{"code": {"000000"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Session started before is not affected
+	w = tokenRequest(handler, "GET", "/who/", sessionId, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = tokenRequest(handler, "GET", "/admin/users/", sessionId, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	handler, _ := makeTwoFactorHandler(t, webapp.Options{})
+	sessionId := sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse"))
+	secret, _ := enrollTwoFactor(t, handler, sessionId)
+
+	w := passwordRequest(handler, "/2fa/disable", sessionId, url.Values{"code": {"000000"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = passwordRequest(handler, "/2fa/disable", sessionId, url.Values{"code": {twoFactorCode(secret, 1)}})
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = passwordRequest(handler, "/2fa/disable", sessionId, url.Values{"code": {twoFactorCode(secret, 1)}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,341 @@
+package webapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/webauthn"
+)
+
+// Ceremonies challenges are issued for
+const (
+	ceremonyRegister  = "register"
+	ceremonyLogin     = "login"
+	ceremonyTwoFactor = "2fa"
+)
+
+// DefaultPasskeyName is given to passkeys registered without name
+const DefaultPasskeyName = "Passkey"
+
+type passkeyRegistration struct {
+	Name       string                       `json:"name"`
+	Credential webauthn.PublicKeyCredential `json:"credential"`
+}
+
+// newRelyingParty accepts passkeys made on pages of origins users can be
+// redirected to
+func newRelyingParty(options Options, redirects redirectPolicy) *webauthn.RelyingParty {
+	rp := &webauthn.RelyingParty{
+		ID:   options.WebAuthnRPID,
+		Name: options.AppAddress.Host,
+	}
+	if rp.ID == "" {
+		rp.ID = options.AppAddress.Hostname()
+	}
+	for origin := range redirects.origins {
+		rp.Origins = append(rp.Origins, origin)
+	}
+	return rp
+}
+
+func (a *authController) hasPasskeys(user *core.User) (bool, error) {
+	if a.WebAuthn == nil {
+		return false, nil
+	}
+
+	passkeys, err := a.WebAuthn.ListCredentials(user.Email)
+	return len(passkeys) > 0, err
+}
+
+// passkeyIds lists ids of passkeys of the user
+func (a *authController) passkeyIds(email string) ([]string, error) {
+	passkeys, err := a.WebAuthn.ListCredentials(email)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(passkeys))
+	for i, passkey := range passkeys {
+		ids[i] = passkey.ID
+	}
+	return ids, nil
+}
+
+// newChallenge stores challenge of ceremony for user with given email
+func (a *authController) newChallenge(email string, ceremony string) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+
+	stored := core.WebAuthnChallenge{
+		Hash:     hashSecret(challenge),
+		Email:    email,
+		Ceremony: ceremony,
+		Expires:  time.Now().Add(webauthn.Timeout),
+	}
+	return challenge, a.WebAuthn.AddWebAuthnChallenge(stored)
+}
+
+// takeChallenge finds challenge credential answers, which has to be issued
+// for the ceremony and user with given email. It can be used once
+func (a *authController) takeChallenge(credential *webauthn.PublicKeyCredential, email string, ceremony string) (string, error) {
+	clientData, err := credential.ClientData()
+	if err != nil {
+		return "", err
+	}
+
+	stored, err := a.WebAuthn.TakeWebAuthnChallenge(hashSecret(clientData.Challenge))
+	if err != nil {
+		return "", err
+	} else if stored.Ceremony != ceremony || stored.Email != email {
+		return "", core.ErrChallengeNotFound
+	}
+	return clientData.Challenge, nil
+}
+
+// beginPasskeyRegistration responds with options for navigator.credentials.create
+func (a *authController) beginPasskeyRegistration(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool) {
+	exclude, err := a.passkeyIds(user.Email)
+	if err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	challenge, err := a.newChallenge(user.Email, ceremonyRegister)
+	if err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	displayName := user.Name
+	if displayName == "" {
+		displayName = user.Email
+	}
+	options := a.relyingParty.CreationOptions(challenge, []byte(user.ID), user.Email, displayName, exclude)
+	a.Serializer.JSON(rw, http.StatusOK, options)
+}
+
+// registerPasskey stores passkey made with options of beginPasskeyRegistration.
+// Admin who has to set up second factor completes login with it
+func (a *authController) registerPasskey(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool) {
+	registration := passkeyRegistration{}
+	if err := json.NewDecoder(req.Body).Decode(&registration); err != nil {
+		a.Serializer.JSON(rw, http.StatusBadRequest, webauthn.ErrMalformed)
+		return
+	}
+
+	challenge, err := a.takeChallenge(&registration.Credential, user.Email, ceremonyRegister)
+	if err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	credential, err := a.relyingParty.VerifyRegistration(&registration.Credential, challenge, false)
+	if err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	passkey := core.WebAuthnCredential{
+		ID:        credential.ID,
+		Name:      strings.TrimSpace(registration.Name),
+		PublicKey: credential.PublicKey,
+		SignCount: int64(credential.SignCount),
+		Created:   time.Now(),
+	}
+	if passkey.Name == "" {
+		passkey.Name = DefaultPasskeyName
+	}
+	if err := a.WebAuthn.AddCredential(user.Email, passkey); err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	if pending && !a.completeTwoFactor(rw, req) {
+		return
+	}
+
+	a.Serializer.JSON(rw, http.StatusCreated, passkey)
+}
+
+func (a *authController) listPasskeys(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	passkeys, err := a.WebAuthn.ListCredentials(user.Email)
+	if err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	a.Serializer.JSON(rw, http.StatusOK, passkeys)
+}
+
+// deletePasskey keeps the last passkey of admin who has to use second
+// factor and has no other one
+func (a *authController) deletePasskey(rw http.ResponseWriter, req *http.Request, user *core.User) {
+	id, _ := url.QueryUnescape(req.URL.Query().Get(":id"))
+
+	if a.twoFactorRequired(user) {
+		passkeys, err := a.WebAuthn.ListCredentials(user.Email)
+		if err != nil {
+			a.writeWebAuthnResult(rw, err)
+			return
+		}
+		twoFactor, err := a.TwoFactor.GetTwoFactor(user.Email)
+		if err != nil && err != core.ErrTwoFactorNotEnabled {
+			a.writeWebAuthnResult(rw, err)
+			return
+		}
+		if len(passkeys) == 1 && passkeys[0].ID == id && (twoFactor == nil || !twoFactor.Enabled) {
+			a.Serializer.JSON(rw, http.StatusForbidden, core.ErrTwoFactorRequired)
+			return
+		}
+	}
+
+	if err := a.WebAuthn.DeleteCredential(user.Email, id); err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// beginPasskeyLogin responds with options for navigator.credentials.get,
+// which let user pick any passkey registered for the service
+func (a *authController) beginPasskeyLogin(rw http.ResponseWriter, req *http.Request) {
+	challenge, err := a.newChallenge("", ceremonyLogin)
+	if err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	a.Serializer.JSON(rw, http.StatusOK, a.relyingParty.RequestOptions(challenge, nil, "required"))
+}
+
+// passkeyLogin logs in owner of passkey. Authenticator has to verify the
+// user, so passkey counts as two factors
+func (a *authController) passkeyLogin(rw http.ResponseWriter, req *http.Request) {
+	target := redirectTarget(req)
+	if target != "" && !a.Redirects.Allowed(target) {
+		a.Serializer.JSON(rw, http.StatusBadRequest, ErrRedirectNotAllowed)
+		return
+	}
+
+	credential := webauthn.PublicKeyCredential{}
+	if err := json.NewDecoder(req.Body).Decode(&credential); err != nil {
+		a.Serializer.JSON(rw, http.StatusBadRequest, webauthn.ErrMalformed)
+		return
+	}
+
+	email, err := a.verifyPasskey(&credential, "", ceremonyLogin, true)
+	if err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+	// Passkeys are added only by users who logged in some other way
+	if !a.admitted(rw, req, conf.WebAuthnProvider, email, true) {
+		return
+	}
+
+	a.startSession(rw, req, conf.WebAuthnProvider, target, http.StatusOK, a.userSession(email))
+}
+
+// beginPasskeyVerify responds with options for navigator.credentials.get,
+// for user who has to give second factor
+func (a *authController) beginPasskeyVerify(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool) {
+	if !pending {
+		a.Serializer.JSON(rw, http.StatusConflict, ErrTwoFactorNotPending)
+		return
+	}
+
+	allow, err := a.passkeyIds(user.Email)
+	if err == nil && len(allow) == 0 {
+		err = core.ErrCredentialNotFound
+	}
+	if err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	challenge, err := a.newChallenge(user.Email, ceremonyTwoFactor)
+	if err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	a.Serializer.JSON(rw, http.StatusOK, a.relyingParty.RequestOptions(challenge, allow, "preferred"))
+}
+
+// passkeyVerify completes login with passkey as second factor
+func (a *authController) passkeyVerify(rw http.ResponseWriter, req *http.Request, user *core.User, pending bool) {
+	if !pending {
+		a.Serializer.JSON(rw, http.StatusConflict, ErrTwoFactorNotPending)
+		return
+	}
+
+	target := redirectTarget(req)
+	if target != "" && !a.Redirects.Allowed(target) {
+		a.Serializer.JSON(rw, http.StatusBadRequest, ErrRedirectNotAllowed)
+		return
+	}
+
+	credential := webauthn.PublicKeyCredential{}
+	if err := json.NewDecoder(req.Body).Decode(&credential); err != nil {
+		a.Serializer.JSON(rw, http.StatusBadRequest, webauthn.ErrMalformed)
+		return
+	}
+
+	if _, err := a.verifyPasskey(&credential, user.Email, ceremonyTwoFactor, false); err != nil {
+		a.writeWebAuthnResult(rw, err)
+		return
+	}
+
+	a.finishTwoFactor(rw, req, user, target)
+}
+
+// verifyPasskey checks assertion answers challenge of the ceremony and
+// records its use. Returns email of passkey owner, which has to be the
+// given one, unless it is empty
+func (a *authController) verifyPasskey(credential *webauthn.PublicKeyCredential, email string, ceremony string, requireUV bool) (string, error) {
+	challenge, err := a.takeChallenge(credential, email, ceremony)
+	if err != nil {
+		return "", err
+	}
+
+	owner, passkey, err := a.WebAuthn.GetCredential(credential.ID)
+	if err != nil {
+		return "", err
+	} else if email != "" && owner != email {
+		return "", core.ErrCredentialNotFound
+	}
+
+	signCount, err := a.relyingParty.VerifyAssertion(credential, challenge, passkey.PublicKey, requireUV)
+	if err != nil {
+		return "", err
+	}
+
+	// Counter going back means passkey was cloned
+	return owner, a.WebAuthn.UseCredential(credential.ID, int64(signCount), time.Now())
+}
+
+// writeWebAuthnResult responds with 403 for credentials which failed verification
+func (a *authController) writeWebAuthnResult(rw http.ResponseWriter, err error) {
+	switch err {
+	case webauthn.ErrMalformed, webauthn.ErrUnsupportedAlgorithm:
+		a.Serializer.JSON(rw, http.StatusBadRequest, err)
+	case core.ErrChallengeNotFound, core.ErrSignCount,
+		webauthn.ErrInvalidClientData, webauthn.ErrInvalidRelyingParty, webauthn.ErrInvalidSignature,
+		webauthn.ErrUserNotPresent, webauthn.ErrUserNotVerified:
+		a.Serializer.JSON(rw, http.StatusForbidden, err)
+	case core.ErrCredentialNotFound, core.ErrUserNotFound:
+		a.Serializer.JSON(rw, http.StatusNotFound, err)
+	case core.ErrCredentialExists:
+		a.Serializer.JSON(rw, http.StatusConflict, err)
+	default:
+		a.Serializer.JSON(rw, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,275 @@
+package webapp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/conf"
+	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/storage"
+	"github.com/hashtock/auth/webapp"
+	"github.com/hashtock/auth/webauthn"
+	"github.com/hashtock/auth/webauthn/webauthntest"
+)
+
+type webAuthnChallenge struct {
+	Verify   string `json:"verify"`
+	Enroll   string `json:"enroll"`
+	WebAuthn string `json:"webauthn"`
+}
+
+func makeWebAuthnHandler(t *testing.T, options webapp.Options) (http.Handler, *storage.MemStorage) {
+	options.Providers = []conf.Provider{{Name: conf.LocalProvider}, {Name: conf.WebAuthnProvider}}
+	handler, memStorage := makeAdminHandlerWithOptions(t, options)
+	passwordRequest(handler, "/login/local/register", "", url.Values{"email": {"carol@example.com"}, "password": {"correct horse"}})
+	return handler, memStorage
+}
+
+func jsonBody(obj interface{}) string {
+	body, _ := json.Marshal(obj)
+	return string(body)
+}
+
+// registerPasskey makes passkey with the authenticator for user of the session
+func registerPasskey(t *testing.T, handler http.Handler, sessionId string, authenticator *webauthntest.Authenticator) core.WebAuthnCredential {
+	options := webauthn.CreationOptions{}
+	w := tokenRequest(handler, "POST", "/webauthn/register/begin", sessionId, "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &options)
+
+	credential, err := authenticator.Create(options)
+	if !assert.NoError(t, err) {
+		return core.WebAuthnCredential{}
+	}
+
+	passkey := core.WebAuthnCredential{}
+	body := jsonBody(map[string]interface{}{"name": "Laptop", "credential": credential})
+	w = tokenRequest(handler, "POST", "/webauthn/register", sessionId, "", body)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &passkey)
+	return passkey
+}
+
+// passkeyAssertion signs challenge of begin endpoint with the authenticator
+func passkeyAssertion(t *testing.T, handler http.Handler, path string, sessionId string, authenticator *webauthntest.Authenticator) *webauthn.PublicKeyCredential {
+	options := webauthn.RequestOptions{}
+	w := tokenRequest(handler, "POST", path, sessionId, "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &options)
+
+	credential, err := authenticator.Get(options)
+	assert.NoError(t, err)
+	return credential
+}
+
+func newAuthenticator() *webauthntest.Authenticator {
+	return webauthntest.New("localhost", "http://localhost:1234")
+}
+
+func TestWebAuthnProviderListed(t *testing.T) {
+	handler, _ := makeWebAuthnHandler(t, webapp.Options{})
+
+	providers := map[string]string{}
+	w := tokenRequest(handler, "GET", "/providers/", "", "", "")
+	json.Unmarshal(w.Body.Bytes(), &providers)
+	assert.Equal(t, "/login/webauthn/", providers["webauthn"])
+}
+
+func TestPasskeyRegisterAndLogin(t *testing.T) {
+	handler, _ := makeWebAuthnHandler(t, webapp.Options{})
+	sessionId := sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse"))
+	authenticator := newAuthenticator()
+
+	passkey := registerPasskey(t, handler, sessionId, authenticator)
+	assert.Equal(t, "Laptop", passkey.Name)
+
+	passkeys := []core.WebAuthnCredential{}
+	w := tokenRequest(handler, "GET", "/webauthn/credentials/", sessionId, "", "")
+	json.Unmarshal(w.Body.Bytes(), &passkeys)
+	if assert.Len(t, passkeys, 1) {
+		assert.Equal(t, passkey.ID, passkeys[0].ID)
+	}
+	assert.NotContains(t, w.Body.String(), "public")
+
+	// The same authenticator can't register it twice
+	options := webauthn.CreationOptions{}
+	w = tokenRequest(handler, "POST", "/webauthn/register/begin", sessionId, "", "")
+	json.Unmarshal(w.Body.Bytes(), &options)
+	_, err := authenticator.Create(options)
+	assert.Equal(t, webauthntest.ErrCredentialExcluded, err)
+
+	// Passkey counts as two factors, so no email or password is needed
+	assertion := passkeyAssertion(t, handler, "/login/webauthn/begin", "", authenticator)
+	w = tokenRequest(handler, "POST", "/login/webauthn/", "", "", jsonBody(assertion))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	loggedIn := sessionIdFrom(w)
+	assert.NotEmpty(t, loggedIn)
+
+	user := core.User{}
+	w = tokenRequest(handler, "GET", "/who/", loggedIn, "", "")
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, "carol@example.com", user.Email)
+
+	// Challenge can be answered once
+	w = tokenRequest(handler, "POST", "/login/webauthn/", "", "", jsonBody(assertion))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestPasskeyLoginChecks(t *testing.T) {
+	handler, _ := makeWebAuthnHandler(t, webapp.Options{})
+	sessionId := sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse"))
+	authenticator := newAuthenticator()
+	registerPasskey(t, handler, sessionId, authenticator)
+
+	// Login requires user verification
+	authenticator.UserVerified = false
+	assertion := passkeyAssertion(t, handler, "/login/webauthn/begin", "", authenticator)
+	w := tokenRequest(handler, "POST", "/login/webauthn/", "", "", jsonBody(assertion))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, sessionIdFrom(w))
+	authenticator.UserVerified = true
+
+	// Unknown passkey
+	other := newAuthenticator()
+	options := webauthn.CreationOptions{}
+	w = tokenRequest(handler, "POST", "/webauthn/register/begin", sessionId, "", "")
+	json.Unmarshal(w.Body.Bytes(), &options)
+	other.Create(options)
+	assertion = passkeyAssertion(t, handler, "/login/webauthn/begin", "", other)
+	w = tokenRequest(handler, "POST", "/login/webauthn/", "", "", jsonBody(assertion))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Made on other site
+	authenticator.Origin = "https://evil.example.com"
+	assertion = passkeyAssertion(t, handler, "/login/webauthn/begin", "", authenticator)
+	w = tokenRequest(handler, "POST", "/login/webauthn/", "", "", jsonBody(assertion))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Challenge of other ceremony
+	authenticator.Origin = "http://localhost:1234"
+	options = webauthn.CreationOptions{}
+	w = tokenRequest(handler, "POST", "/webauthn/register/begin", sessionId, "", "")
+	json.Unmarshal(w.Body.Bytes(), &options)
+	assertion, _ = authenticator.Get(webauthn.RequestOptions{Challenge: options.Challenge, RPID: "localhost"})
+	w = tokenRequest(handler, "POST", "/login/webauthn/", "", "", jsonBody(assertion))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = tokenRequest(handler, "POST", "/login/webauthn/", "", "", "{")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPasskeyCloneRejected(t *testing.T) {
+	handler, _ := makeWebAuthnHandler(t, webapp.Options{})
+	sessionId := sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse"))
+	authenticator := newAuthenticator()
+	registerPasskey(t, handler, sessionId, authenticator)
+
+	// Clone has copy of the key, but its counter falls behind
+	clone := *authenticator
+	assertion := passkeyAssertion(t, handler, "/login/webauthn/begin", "", authenticator)
+	w := tokenRequest(handler, "POST", "/login/webauthn/", "", "", jsonBody(assertion))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	assertion = passkeyAssertion(t, handler, "/login/webauthn/begin", "", &clone)
+	w = tokenRequest(handler, "POST", "/login/webauthn/", "", "", jsonBody(assertion))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, sessionIdFrom(w))
+}
+
+func TestPasskeyAsSecondFactor(t *testing.T) {
+	handler, _ := makeWebAuthnHandler(t, webapp.Options{})
+	sessionId := sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse"))
+	authenticator := newAuthenticator()
+	registerPasskey(t, handler, sessionId, authenticator)
+
+	// Passkey alone doesn't turn on second factor for password logins
+	w := passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	enrollTwoFactor(t, handler, sessionId)
+	challenge := webAuthnChallenge{}
+	w = passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	pendingId := sessionIdFrom(w)
+	assert.Equal(t, "http://localhost:1234/2fa/verify", challenge.Verify)
+	assert.Equal(t, "http://localhost:1234/2fa/webauthn/begin", challenge.WebAuthn)
+
+	// Passkey of other user doesn't do
+	bobsAuthenticator := newAuthenticator()
+	registerPasskey(t, handler, "bob@example.com", bobsAuthenticator)
+	options := webauthn.RequestOptions{}
+	w = tokenRequest(handler, "POST", "/2fa/webauthn/begin", pendingId, "", "")
+	json.Unmarshal(w.Body.Bytes(), &options)
+	options.AllowCredentials = nil
+	assertion, _ := bobsAuthenticator.Get(options)
+	w = tokenRequest(handler, "POST", "/2fa/webauthn", pendingId, "", jsonBody(assertion))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Nor does registering a new one
+	w = tokenRequest(handler, "POST", "/webauthn/register/begin", pendingId, "", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Second factor doesn't need user verification
+	authenticator.UserVerified = false
+	assertion = passkeyAssertion(t, handler, "/2fa/webauthn/begin", pendingId, authenticator)
+	w = tokenRequest(handler, "POST", "/2fa/webauthn", pendingId, "", jsonBody(assertion))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = tokenRequest(handler, "GET", "/who/", pendingId, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Complete session has nothing to verify
+	w = tokenRequest(handler, "POST", "/2fa/webauthn/begin", pendingId, "", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestPasskeyForAdminTwoFactor(t *testing.T) {
+	handler, memStorage := makeWebAuthnHandler(t, webapp.Options{RequireAdminTwoFactor: true})
+	memStorage.MakeUserAnAdmin("carol@example.com")
+	authenticator := newAuthenticator()
+
+	// Admin may set up passkey instead of app
+	challenge := webAuthnChallenge{}
+	w := passwordLogin(handler, "carol@example.com", "correct horse")
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	pendingId := sessionIdFrom(w)
+	assert.Equal(t, "http://localhost:1234/2fa/enroll", challenge.Enroll)
+	passkey := registerPasskey(t, handler, pendingId, authenticator)
+	w = tokenRequest(handler, "GET", "/who/", pendingId, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// And uses it on next login
+	challenge = webAuthnChallenge{}
+	w = passwordLogin(handler, "carol@example.com", "correct horse")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	assert.Equal(t, webAuthnChallenge{WebAuthn: "http://localhost:1234/2fa/webauthn/begin"}, challenge)
+
+	// Last second factor can't be removed
+	w = tokenRequest(handler, "DELETE", "/webauthn/credentials/"+passkey.ID+"/", pendingId, "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestDeletePasskey(t *testing.T) {
+	handler, _ := makeWebAuthnHandler(t, webapp.Options{})
+	sessionId := sessionIdFrom(passwordLogin(handler, "carol@example.com", "correct horse"))
+	authenticator := newAuthenticator()
+	passkey := registerPasskey(t, handler, sessionId, authenticator)
+
+	// Only owner can remove it
+	w := tokenRequest(handler, "DELETE", "/webauthn/credentials/"+passkey.ID+"/", "bob@example.com", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = tokenRequest(handler, "DELETE", "/webauthn/credentials/"+passkey.ID+"/", sessionId, "", "")
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = tokenRequest(handler, "DELETE", "/webauthn/credentials/"+passkey.ID+"/", sessionId, "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assertion := passkeyAssertion(t, handler, "/login/webauthn/begin", "", authenticator)
+	w = tokenRequest(handler, "POST", "/login/webauthn/", "", "", jsonBody(assertion))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
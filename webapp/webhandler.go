@@ -1,8 +1,10 @@
 package webapp
 
 import (
+	"log"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/pat"
@@ -10,30 +12,149 @@ import (
 	"github.com/hashtock/service-tools/serialize"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
+	"github.com/markbates/goth/providers/facebook"
+	"github.com/markbates/goth/providers/github"
 	"github.com/markbates/goth/providers/gplus"
+	"github.com/markbates/goth/providers/lastfm"
+	"github.com/markbates/goth/providers/linkedin"
+	"github.com/markbates/goth/providers/spotify"
+	"github.com/markbates/goth/providers/twitter"
+	"golang.org/x/crypto/bcrypt"
 
+	"github.com/hashtock/auth/conf"
 	"github.com/hashtock/auth/core"
+	"github.com/hashtock/auth/jwt"
+	"github.com/hashtock/auth/mailer"
+	"github.com/hashtock/auth/oidc"
 )
 
 const (
-	loginRoute    = "login"
-	callbackRoute = "callback"
+	loginRoute     = "login"
+	callbackRoute  = "callback"
+	loginLinkRoute = "loginLink"
+	twoFactorRoute = "twoFactor"
 )
 
+// oauthRoutes names OAuth endpoints listed in OpenID Connect discovery document
+var oauthRoutes = []string{"authorize", "token", "userinfo", "introspect", "revoke", "jwks"}
+
 type Options struct {
-	Serializer         serialize.Serializer
-	Storage            core.UserSessioner
-	AppAddress         *url.URL
-	GoogleClientID     string
-	GoogleClientSecret string
-	SessionSecret      string
+	Serializer    serialize.Serializer
+	Storage       core.UserSessioner
+	AppAddress    *url.URL
+	Providers     []conf.Provider
+	SessionSecret string
+
+	// SessionTimeout defaults to SessionTimout
+	SessionTimeout time.Duration
+	// SlidingSessions extends session on each request to /who/
+	SlidingSessions bool
+	// Administrator enables admin API under /admin/ when set
+	Administrator core.Administrator
+	// AccessTokens enables personal access tokens, managed under /tokens/
+	AccessTokens core.AccessTokenStorer
+	// Identities links users to their accounts at providers, so email
+	// reported by provider is trusted only when verified. Linked accounts
+	// are managed under /identities/
+	Identities core.IdentityStorer
+	// Passwords stores passwords of users registered with local provider.
+	// It is used only when conf.LocalProvider is one of Providers
+	Passwords core.PasswordStorer
+	// LoginLinks and Mailer let users log in with single use links sent
+	// by email. They are used only when conf.EmailProvider is one of Providers
+	LoginLinks core.LoginLinkStorer
+	Mailer     mailer.Mailer
+	// TwoFactor lets users protect their accounts with TOTP codes, managed
+	// under /2fa/. RequireAdminTwoFactor makes admins set it up on login
+	TwoFactor             core.TwoFactorStorer
+	RequireAdminTwoFactor bool
+	// WebAuthn stores passkeys users register under /webauthn/, to log in
+	// with them, or use them as second factor. It is used only when
+	// conf.WebAuthnProvider is one of Providers. Passkeys are registered
+	// for WebAuthnRPID domain, AppAddress host by default
+	WebAuthn     core.WebAuthnStorer
+	WebAuthnRPID string
+	// OAuth makes the service OAuth2 authorization server for clients
+	// registered under /admin/oauth/clients/. Requires Administrator
+	OAuth core.OAuthStorer
+	// Admission decides who can log in. Per provider allowed domains are
+	// taken from Providers. InviteOnly needs Administrator
+	Admission AdmissionPolicy
+	// RedirectOrigins are origins, besides AppAddress, users can be sent
+	// back to after login with next or return_to parameter
+	RedirectOrigins []string
+	// Cookie configures session cookie, see CookieOptions for defaults
+	Cookie CookieOptions
+	// TrustProxy takes client IP recorded with session from X-Forwarded-For
+	// header. Set it only when service is reachable through proxy alone
+	TrustProxy bool
+	// LogoutWithGet keeps GET /logout/ working for old clients. Any site
+	// can log users out through it, POST should be used instead
+	LogoutWithGet bool
+	// TokenSigner, when set, makes session cookie a signed token which
+	// services can verify with keys published at /.well-known/jwks.json
+	TokenSigner *jwt.Signer
+	// IDTokenSigner makes OAuth server an OpenID Connect provider. RS256 or
+	// EdDSA signer is needed, so clients can verify tokens with published
+	// keys. Its key has to differ from key of TokenSigner
+	IDTokenSigner *jwt.Signer
+}
+
+type providerBuilder func(provider conf.Provider, callbackURL string) (goth.Provider, error)
+
+// providerBuilders knows how to build each of built-in providers
+var providerBuilders = map[string]providerBuilder{
+	"facebook": func(p conf.Provider, callbackURL string) (goth.Provider, error) {
+		return facebook.New(p.ClientID, p.Secret, callbackURL, p.Scopes...), nil
+	},
+	"github": func(p conf.Provider, callbackURL string) (goth.Provider, error) {
+		return github.New(p.ClientID, p.Secret, callbackURL, p.Scopes...), nil
+	},
+	"gplus": func(p conf.Provider, callbackURL string) (goth.Provider, error) {
+		return gplus.New(p.ClientID, p.Secret, callbackURL, p.Scopes...), nil
+	},
+	"lastfm": func(p conf.Provider, callbackURL string) (goth.Provider, error) {
+		return lastfm.New(p.ClientID, p.Secret, callbackURL), nil
+	},
+	"linkedin": func(p conf.Provider, callbackURL string) (goth.Provider, error) {
+		return linkedin.New(p.ClientID, p.Secret, callbackURL, p.Scopes...), nil
+	},
+	"spotify": func(p conf.Provider, callbackURL string) (goth.Provider, error) {
+		return spotify.New(p.ClientID, p.Secret, callbackURL, p.Scopes...), nil
+	},
+	"twitter": func(p conf.Provider, callbackURL string) (goth.Provider, error) {
+		return twitter.New(p.ClientID, p.Secret, callbackURL), nil
+	},
+}
+
+func buildOIDCProvider(p conf.Provider, callbackURL string) (goth.Provider, error) {
+	return oidc.New(p.Name, p.Issuer, p.ClientID, p.Secret, callbackURL, p.Scopes...)
 }
 
 // Handlers build http handler for service API
 func Handlers(options Options) http.Handler {
 	auth := authController{
-		Serializer: options.Serializer,
-		Storage:    options.Storage,
+		Serializer:      options.Serializer,
+		Storage:         options.Storage,
+		SessionTimeout:  options.SessionTimeout,
+		SlidingSessions: options.SlidingSessions,
+		TokenSigner:     options.TokenSigner,
+		Issuer:          options.AppAddress.String(),
+		sessionIdCipher: newSessionIdCipher(options.SessionSecret),
+		Redirects:       newRedirectPolicy(options.AppAddress, options.RedirectOrigins),
+		Cookie:          options.Cookie.withDefaults(),
+		AccessTokens:    options.AccessTokens,
+		Identities:      options.Identities,
+		TrustProxy:      options.TrustProxy,
+		TrustedEmails:   make(map[string]bool),
+		Admission:       newAdmissionPolicy(options.Admission, options.Providers, options.Administrator),
+		appName:         options.AppAddress.Host,
+	}
+	if auth.SessionTimeout == 0 {
+		auth.SessionTimeout = SessionTimout
+	}
+	if auth.Cookie.SameSite == http.SameSiteNoneMode && !auth.Cookie.Secure {
+		log.Printf("Browsers reject SameSite=None cookies which are not Secure.")
 	}
 
 	m := pat.New()
@@ -43,26 +164,234 @@ func Handlers(options Options) http.Handler {
 		m.Router = *sub
 	}
 
+	tokens := accessTokenController{
+		Serializer: options.Serializer,
+		Storage:    options.AccessTokens,
+		Users:      options.Administrator,
+		Auth:       &auth,
+	}
+
+	oauth := oauthController{
+		Serializer: options.Serializer,
+		Storage:    options.OAuth,
+		Users:      options.Administrator,
+		Auth:       &auth,
+	}
+	if options.OAuth != nil && options.Administrator != nil {
+		auth.oauth = &oauth
+		oauth.IDTokens = idTokenSigner(options)
+	} else if options.OAuth != nil {
+		log.Printf("OAuth server needs Administrator storage. Skipping.")
+	}
+
+	if options.Administrator != nil {
+		admin := adminController{
+			Serializer: options.Serializer,
+			Storage:    options.Administrator,
+			Auth:       &auth,
+		}
+
+		if options.AccessTokens != nil {
+			m.Delete("/admin/users/{email}/tokens/{token}/", admin.admin(tokens.adminRevoke))
+			m.Get("/admin/users/{email}/tokens/", admin.admin(tokens.adminList))
+			m.Post("/admin/users/{email}/tokens/", admin.admin(tokens.adminCreate))
+		}
+
+		if auth.oauth != nil {
+			m.Delete("/admin/oauth/clients/{client}/", admin.admin(oauth.deleteClient))
+			m.Get("/admin/oauth/clients/", admin.admin(oauth.listClients))
+			m.Post("/admin/oauth/clients/", admin.admin(oauth.createClient))
+		}
+
+		m.Get("/admin/users/{email}/sessions/", admin.admin(admin.listSessions))
+		m.Delete("/admin/users/{email}/sessions/{session}/", admin.admin(admin.revokeSession))
+		m.Delete("/admin/users/{email}/sessions/", admin.admin(admin.revokeSessions))
+		m.Put("/admin/users/{email}/admin/", admin.admin(admin.grantAdmin))
+		m.Delete("/admin/users/{email}/admin/", admin.admin(admin.revokeAdmin))
+		m.Post("/admin/users/{email}/disable/", admin.admin(admin.disableUser))
+		m.Get("/admin/users/{email}/", admin.admin(admin.getUser))
+		m.Get("/admin/users/", admin.admin(admin.listUsers))
+	}
+
+	if options.AccessTokens != nil {
+		m.Delete("/tokens/{token}/", auth.csrfProtected(auth.sessionUser(tokens.revoke)))
+		m.Get("/tokens/", auth.sessionUser(tokens.list))
+		m.Post("/tokens/", auth.csrfProtected(auth.sessionUser(tokens.create)))
+	}
+
+	if options.Passwords != nil && hasProvider(options.Providers, conf.LocalProvider) {
+		auth.Passwords = options.Passwords
+		auth.dummyHash, _ = bcrypt.GenerateFromPassword([]byte(randomString(16)), PasswordCost)
+
+		m.Post("/login/local/register", auth.csrfProtected(auth.register))
+		m.Post("/login/local/", auth.csrfProtected(auth.passwordLogin))
+		m.Post("/password/", auth.csrfProtected(auth.sessionUser(auth.changePassword)))
+	}
+
+	if options.LoginLinks != nil && options.Mailer != nil && hasProvider(options.Providers, conf.EmailProvider) {
+		auth.LoginLinks = options.LoginLinks
+		auth.Mailer = options.Mailer
+
+		m.Get("/login/email/callback", auth.loginWithLink).Name(loginLinkRoute)
+		m.Post("/login/email/", auth.csrfProtected(auth.sendLoginLink))
+		auth.loginLinkURL = urlForRoute(options.AppAddress, m, loginLinkRoute)
+	}
+
+	if options.TwoFactor != nil {
+		auth.TwoFactor = options.TwoFactor
+		auth.RequireAdminTwoFactor = options.RequireAdminTwoFactor
+
+		m.Post("/2fa/enroll", auth.csrfProtected(auth.enrollingUser(auth.enrollTwoFactor)))
+		m.Post("/2fa/enable", auth.csrfProtected(auth.enrollingUser(auth.enableTwoFactor)))
+		m.Post("/2fa/verify", auth.csrfProtected(auth.twoFactorUser(auth.verifyTwoFactor)))
+		m.Post("/2fa/disable", auth.csrfProtected(auth.sessionUser(auth.disableTwoFactor)))
+		m.Get("/2fa/qr.png", auth.enrollingUser(auth.twoFactorQR))
+		m.Get("/2fa/", auth.sessionUser(auth.twoFactorStatus)).Name(twoFactorRoute)
+		auth.twoFactorURL = urlForRoute(options.AppAddress, m, twoFactorRoute)
+	} else if options.RequireAdminTwoFactor {
+		log.Printf("Two-factor authentication for admins needs storage. Skipping.")
+	}
+
+	if options.WebAuthn != nil && hasProvider(options.Providers, conf.WebAuthnProvider) {
+		auth.WebAuthn = options.WebAuthn
+		auth.relyingParty = newRelyingParty(options, auth.Redirects)
+
+		if auth.TwoFactor != nil {
+			m.Post("/2fa/webauthn/begin", auth.csrfProtected(auth.twoFactorUser(auth.beginPasskeyVerify)))
+			m.Post("/2fa/webauthn", auth.csrfProtected(auth.twoFactorUser(auth.passkeyVerify)))
+		}
+		m.Post("/webauthn/register/begin", auth.csrfProtected(auth.enrollingUser(auth.beginPasskeyRegistration)))
+		m.Post("/webauthn/register", auth.csrfProtected(auth.enrollingUser(auth.registerPasskey)))
+		m.Delete("/webauthn/credentials/{id}/", auth.csrfProtected(auth.sessionUser(auth.deletePasskey)))
+		m.Get("/webauthn/credentials/", auth.sessionUser(auth.listPasskeys))
+		m.Post("/login/webauthn/begin", auth.csrfProtected(auth.beginPasskeyLogin))
+		m.Post("/login/webauthn/", auth.csrfProtected(auth.passkeyLogin))
+	}
+
+	if options.Identities != nil {
+		m.Delete("/identities/{provider}/{id}/", auth.csrfProtected(auth.sessionUser(auth.unlinkIdentity)))
+		m.Post("/identities/{provider}/", auth.csrfProtected(auth.sessionUser(auth.beginLink)))
+		m.Get("/identities/", auth.sessionUser(auth.listIdentities))
+	}
+
+	if auth.oauth != nil {
+		m.Get("/oauth/authorize", oauth.authorize).Name("authorize")
+		m.Post("/oauth/authorize", auth.csrfProtected(oauth.decide))
+		m.Post("/oauth/token", oauth.token).Name("token")
+		m.Post("/oauth/introspect", oauth.introspect).Name("introspect")
+		m.Post("/oauth/revoke", oauth.revoke).Name("revoke")
+	}
+	if oauth.IDTokens != nil {
+		m.Get("/oauth/userinfo", oauth.userinfo).Name("userinfo")
+		m.Post("/oauth/userinfo", oauth.userinfo)
+		m.Get("/.well-known/openid-configuration", oauth.openIDConfiguration)
+	}
+
+	m.Delete("/sessions/{session}/", auth.csrfProtected(auth.sessionUser(auth.revokeSession)))
+	m.Delete("/sessions/", auth.csrfProtected(auth.sessionUser(auth.revokeOtherSessions)))
+	m.Get("/sessions/", auth.sessionUser(auth.listSessions))
 	m.Get("/who/", auth.who)
 	m.Get("/providers/", auth.providers)
-	m.Get("/logout/", auth.logout)
+	m.Get("/.well-known/jwks.json", auth.jwks).Name("jwks")
+	m.Post("/logout/", auth.csrfProtected(auth.logout))
+	if options.LogoutWithGet {
+		m.Get("/logout/", auth.logout)
+	}
 	m.Get("/login/{provider}/callback", auth.authCallback).Name(callbackRoute)
-	m.Get("/login/{provider}/", gothic.BeginAuthHandler).Name(loginRoute)
+	m.Get("/login/{provider}/", auth.beginAuth).Name(loginRoute)
+
+	if oauth.IDTokens != nil {
+		oauth.Endpoints = make(map[string]string, len(oauthRoutes))
+		for _, name := range oauthRoutes {
+			oauth.Endpoints[name] = urlForRoute(options.AppAddress, m, name)
+		}
+	}
 
 	// Use our secret key
 	gothic.AppKey = options.SessionSecret
-	gothic.Store = sessions.NewCookieStore([]byte(gothic.AppKey))
+	store := sessions.NewCookieStore([]byte(gothic.AppKey))
+	store.Options.Domain = auth.Cookie.Domain
+	store.Options.Secure = auth.Cookie.Secure
+	store.Options.HttpOnly = true
+	gothic.Store = store
+	gothic.GetState = loginState
 
 	// Set up the provider(s)
-	gplusLogin, gplusCallback := urlForProvider(options.AppAddress, m, "gplus")
-	auth.Providers = map[string]string{"gplus": gplusLogin}
-	goth.UseProviders(
-		gplus.New(options.GoogleClientID, options.GoogleClientSecret, gplusCallback),
-	)
+	auth.Providers = make(map[string]string, len(options.Providers))
+	// Providers implemented by the service, enabled when their options are set
+	builtIn := map[string]bool{
+		conf.LocalProvider:    auth.Passwords != nil,
+		conf.EmailProvider:    auth.Mailer != nil,
+		conf.WebAuthnProvider: auth.WebAuthn != nil,
+	}
+	for _, provider := range options.Providers {
+		if enabled, ok := builtIn[provider.Name]; ok {
+			if !enabled {
+				log.Printf("Auth provider %#v needs storage or mailer it was not given. Skipping.", provider.Name)
+				continue
+			}
+
+			login, _ := urlForProvider(options.AppAddress, m, provider.Name)
+			auth.Providers[provider.Name] = login
+			continue
+		}
+
+		build, ok := providerBuilders[provider.Name]
+		if provider.Issuer != "" {
+			build, ok = buildOIDCProvider, true
+		}
+		if !ok {
+			log.Printf("Auth provider %#v not supported. Skipping.", provider.Name)
+			continue
+		}
+
+		login, callback := urlForProvider(options.AppAddress, m, provider.Name)
+		gothProvider, err := build(provider, callback)
+		if err != nil {
+			log.Printf("Could not set up auth provider %#v. Skipping. Err: %v", provider.Name, err)
+			continue
+		}
+
+		auth.Providers[provider.Name] = login
+		auth.TrustedEmails[provider.Name] = provider.TrustEmail
+		goth.UseProviders(gothProvider)
+	}
 
 	return m
 }
 
+func hasProvider(providers []conf.Provider, name string) bool {
+	for _, provider := range providers {
+		if provider.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// idTokenSigner picks signer for ID tokens, if one with public keys was
+// configured. Its key has to differ from key of session tokens, so the
+// service never takes ID token given to OAuth client for session
+func idTokenSigner(options Options) *jwt.Signer {
+	signer := options.IDTokenSigner
+	switch {
+	case signer == nil:
+		return nil
+	case signer.Algorithm == jwt.AlgHS256:
+		log.Printf("ID tokens can't be signed with shared secret. Skipping OpenID Connect.")
+		return nil
+	case options.TokenSigner != nil && options.TokenSigner.KeyID == signer.KeyID:
+		log.Printf("ID tokens can't be signed with key of session tokens. Skipping OpenID Connect.")
+		return nil
+	}
+	return signer
+}
+
+func urlForRoute(appUrl *url.URL, router *pat.Router, name string) string {
+	routeUrl, _ := router.GetRoute(name).URL()
+	return appUrl.ResolveReference(routeUrl).String()
+}
+
 func urlForProvider(appUrl *url.URL, router *pat.Router, providerName string) (login string, callback string) {
 	loginUrl, _ := router.GetRoute(loginRoute).URL("provider", providerName)
 	callbackUrl, _ := router.GetRoute(callbackRoute).URL("provider", providerName)
@@ -0,0 +1,156 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// maxDepth limits nesting of decoded items, so malicious input can't
+// exhaust the stack
+const maxDepth = 16
+
+// Major types of CBOR (RFC 8949) items
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborSimple = 7
+)
+
+// decodeCBOR decodes the subset of CBOR authenticators use, returning item
+// at the beginning of data and data following it. Integers are int64, byte
+// and text strings []byte and string, arrays []interface{} and maps
+// map[interface{}]interface{} with int64 or string keys
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeItem(data, 0)
+}
+
+func decodeItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxDepth || len(data) == 0 {
+		return nil, nil, ErrMalformed
+	}
+
+	major := data[0] >> 5
+	if major == cborSimple {
+		return decodeSimple(data)
+	}
+
+	arg, rest, err := decodeArgument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case cborUint:
+		if arg > math.MaxInt64 {
+			return nil, nil, ErrMalformed
+		}
+		return int64(arg), rest, nil
+	case cborNegint:
+		if arg > math.MaxInt64 {
+			return nil, nil, ErrMalformed
+		}
+		return -1 - int64(arg), rest, nil
+	case cborBytes, cborText:
+		if arg > uint64(len(rest)) {
+			return nil, nil, ErrMalformed
+		}
+		if major == cborText {
+			return string(rest[:arg]), rest[arg:], nil
+		}
+		return append([]byte(nil), rest[:arg]...), rest[arg:], nil
+	case cborArray:
+		// Each item takes at least a byte
+		if arg > uint64(len(rest)) {
+			return nil, nil, ErrMalformed
+		}
+		items := make([]interface{}, arg)
+		for i := range items {
+			if items[i], rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, rest, nil
+	case cborMap:
+		if arg > uint64(len(rest)) {
+			return nil, nil, ErrMalformed
+		}
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			if key, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, ErrMalformed
+			}
+			if _, ok := items[key]; ok {
+				return nil, nil, ErrMalformed
+			}
+			if value, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, rest, nil
+	}
+	// Tags are not used by authenticators
+	return nil, nil, ErrMalformed
+}
+
+// decodeArgument reads length or value following major type. Indefinite
+// lengths are not allowed in CTAP2 canonical encoding
+func decodeArgument(data []byte) (uint64, []byte, error) {
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	size := 0
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, nil, ErrMalformed
+	}
+	if len(data) < size {
+		return 0, nil, ErrMalformed
+	}
+
+	var arg uint64
+	switch size {
+	case 1:
+		arg = uint64(data[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(data))
+	case 8:
+		arg = binary.BigEndian.Uint64(data)
+	}
+	return arg, data[size:], nil
+}
+
+// decodeSimple decodes false, true and null. Floats are not used by authenticators
+func decodeSimple(data []byte) (interface{}, []byte, error) {
+	switch data[0] & 0x1f {
+	case 20:
+		return false, data[1:], nil
+	case 21:
+		return true, data[1:], nil
+	case 22:
+		return nil, data[1:], nil
+	}
+	return nil, nil, ErrMalformed
+}
@@ -0,0 +1,109 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+)
+
+// Algorithms of credential public keys, as registered for COSE (RFC 9053)
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Labels and values of COSE_Key (RFC 9052) parameters
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrv  = -1
+	coseX    = -2
+	coseY    = -3
+	coseRSAN = -1
+	coseRSAE = -2
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+// SupportedAlgorithms are offered to authenticators, preferred first
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// publicKey verifies signatures of one credential
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes COSE encoded credential public key
+func parsePublicKey(data []byte) (*publicKey, error) {
+	item, rest, err := decodeCBOR(data)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrMalformed
+	}
+	params, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrMalformed
+	}
+
+	kty, _ := params[int64(coseKty)].(int64)
+	alg, _ := params[int64(coseAlg)].(int64)
+	crv, _ := params[int64(coseCrv)].(int64)
+
+	switch {
+	case alg == AlgES256 && kty == coseKtyEC2 && crv == coseCrvP256:
+		x, _ := params[int64(coseX)].([]byte)
+		y, _ := params[int64(coseY)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, ErrMalformed
+		}
+		point := append(append([]byte{4}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		return &publicKey{alg, key}, nil
+	case alg == AlgEdDSA && kty == coseKtyOKP && crv == coseCrvEd25519:
+		x, _ := params[int64(coseX)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrMalformed
+		}
+		return &publicKey{alg, ed25519.PublicKey(x)}, nil
+	case alg == AlgRS256 && kty == coseKtyRSA:
+		n, _ := params[int64(coseRSAN)].([]byte)
+		e, _ := params[int64(coseRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrMalformed
+		}
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return &publicKey{alg, key}, nil
+	}
+	return nil, ErrUnsupportedAlgorithm
+}
+
+func (k *publicKey) verify(data []byte, signature []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
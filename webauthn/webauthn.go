@@ -0,0 +1,354 @@
+// Package webauthn implements the subset of Web Authentication (WebAuthn
+// Level 2) specification relying party needs to register passkeys and
+// verify assertions they make. Attestation is not verified, as any
+// authenticator is accepted
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Timeout is how long user has to complete the ceremony
+const Timeout = 5 * time.Minute
+
+// Types of client data for each ceremony
+const (
+	typeCreate = "webauthn.create"
+	typeGet    = "webauthn.get"
+)
+
+// Flags of authenticator data
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+var (
+	ErrMalformed            = errors.New("Malformed WebAuthn credential")
+	ErrUnsupportedAlgorithm = errors.New("Unsupported credential algorithm")
+	ErrInvalidClientData    = errors.New("Credential was made for another ceremony or origin")
+	ErrInvalidRelyingParty  = errors.New("Credential was made for another relying party")
+	ErrUserNotPresent       = errors.New("Authenticator did not confirm user presence")
+	ErrUserNotVerified      = errors.New("Authenticator did not verify the user")
+	ErrInvalidSignature     = errors.New("Invalid credential signature")
+)
+
+var encoding = base64.RawURLEncoding
+
+// RelyingParty is the service credentials are registered with. ID is its
+// domain, which pages of Origins have to be in
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// NewChallenge generates random challenge for the ceremony
+func NewChallenge() (string, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(challenge), nil
+}
+
+// CredentialDescriptor identifies credential registered before
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type entity struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are passed to navigator.credentials.create, once decoded
+// with PublicKeyCredential.parseCreationOptionsFromJSON
+type CreationOptions struct {
+	RP                     entity                 `json:"rp"`
+	User                   entity                 `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are passed to navigator.credentials.get, once decoded
+// with PublicKeyCredential.parseRequestOptionsFromJSON
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions asks for discoverable credential, so user can log in
+// without giving email. Credentials user has already are excluded
+func (rp *RelyingParty) CreationOptions(challenge string, userId []byte, name string, displayName string, exclude []string) CreationOptions {
+	options := CreationOptions{
+		RP:                 entity{ID: rp.ID, Name: rp.Name},
+		User:               entity{ID: encoding.EncodeToString(userId), Name: name, DisplayName: displayName},
+		Challenge:          challenge,
+		Timeout:            int64(Timeout / time.Millisecond),
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	}
+	for _, alg := range SupportedAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, credentialParameter{Type: "public-key", Alg: alg})
+	}
+	return options
+}
+
+// RequestOptions asks for one of allowed credentials, or any discoverable
+// one when none are given
+func (rp *RelyingParty) RequestOptions(challenge string, allow []string, userVerification string) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          int64(Timeout / time.Millisecond),
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: userVerification,
+	}
+}
+
+func descriptors(ids []string) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, len(ids))
+	for i, id := range ids {
+		list[i] = CredentialDescriptor{Type: "public-key", ID: id}
+	}
+	return list
+}
+
+// PublicKeyCredential is response of authenticator, as serialized by
+// PublicKeyCredential.toJSON in browser
+type PublicKeyCredential struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject,omitempty"`
+		AuthenticatorData string `json:"authenticatorData,omitempty"`
+		Signature         string `json:"signature,omitempty"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// ClientData is what browser tells about the ceremony
+type ClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// ClientData decodes client data, so challenge it answers can be found.
+// It is not verified yet
+func (c *PublicKeyCredential) ClientData() (*ClientData, error) {
+	raw, err := encoding.DecodeString(c.Response.ClientDataJSON)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	clientData := &ClientData{}
+	if err := json.Unmarshal(raw, clientData); err != nil {
+		return nil, ErrMalformed
+	}
+	return clientData, nil
+}
+
+// Credential is public key credential registered by user
+type Credential struct {
+	// ID is base64url encoded credential id
+	ID        string
+	PublicKey []byte
+	SignCount uint32
+}
+
+// VerifyRegistration checks response to creation options with given
+// challenge and returns credential to store
+func (rp *RelyingParty) VerifyRegistration(c *PublicKeyCredential, challenge string, requireUV bool) (*Credential, error) {
+	if _, err := rp.verifyClientData(c, typeCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	raw, err := encoding.DecodeString(c.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	item, rest, err := decodeCBOR(raw)
+	attestation, ok := item.(map[interface{}]interface{})
+	if err != nil || !ok || len(rest) != 0 {
+		return nil, ErrMalformed
+	}
+
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	data, err := rp.parseAuthData(authData, requireUV)
+	if err != nil {
+		return nil, err
+	} else if data.flags&flagAttested == 0 {
+		return nil, ErrMalformed
+	}
+
+	if encoding.EncodeToString(data.credentialId) != c.ID {
+		return nil, ErrMalformed
+	}
+	if _, err := parsePublicKey(data.publicKey); err != nil {
+		return nil, err
+	}
+
+	credential := &Credential{
+		ID:        c.ID,
+		PublicKey: data.publicKey,
+		SignCount: data.signCount,
+	}
+	return credential, nil
+}
+
+// VerifyAssertion checks response to request options with given challenge
+// was signed with the public key. Returns signature counter, which should
+// grow with each assertion of authenticators which count them
+func (rp *RelyingParty) VerifyAssertion(c *PublicKeyCredential, challenge string, publicKey []byte, requireUV bool) (uint32, error) {
+	clientData, err := rp.verifyClientData(c, typeGet, challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	authData, err := encoding.DecodeString(c.Response.AuthenticatorData)
+	if err != nil {
+		return 0, ErrMalformed
+	}
+	data, err := rp.parseAuthData(authData, requireUV)
+	if err != nil {
+		return 0, err
+	}
+
+	signature, err := encoding.DecodeString(c.Response.Signature)
+	if err != nil {
+		return 0, ErrMalformed
+	}
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientData)
+	if !key.verify(append(authData, clientDataHash[:]...), signature) {
+		return 0, ErrInvalidSignature
+	}
+	return data.signCount, nil
+}
+
+// verifyClientData checks client data is of the ceremony, from allowed
+// origin, and returns it raw, as signature covers its hash
+func (rp *RelyingParty) verifyClientData(c *PublicKeyCredential, ceremony string, challenge string) ([]byte, error) {
+	if c.Type != "public-key" {
+		return nil, ErrMalformed
+	}
+
+	clientData, err := c.ClientData()
+	if err != nil {
+		return nil, err
+	}
+	if clientData.Type != ceremony || subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(challenge)) != 1 {
+		return nil, ErrInvalidClientData
+	}
+	if !rp.allowedOrigin(clientData.Origin) {
+		return nil, ErrInvalidClientData
+	}
+
+	return encoding.DecodeString(c.Response.ClientDataJSON)
+}
+
+func (rp *RelyingParty) allowedOrigin(origin string) bool {
+	for _, allowed := range rp.Origins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+type authData struct {
+	flags        byte
+	signCount    uint32
+	credentialId []byte
+	publicKey    []byte
+}
+
+// parseAuthData decodes authenticator data and checks it was made for the
+// relying party, with user present and verified, when required
+func (rp *RelyingParty) parseAuthData(raw []byte, requireUV bool) (*authData, error) {
+	// rpIdHash, flags and signCount
+	if len(raw) < 37 {
+		return nil, ErrMalformed
+	}
+
+	rpIdHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(raw[:32], rpIdHash[:]) {
+		return nil, ErrInvalidRelyingParty
+	}
+
+	data := &authData{
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if data.flags&flagUserPresent == 0 {
+		return nil, ErrUserNotPresent
+	} else if requireUV && data.flags&flagUserVerified == 0 {
+		return nil, ErrUserNotVerified
+	}
+
+	if data.flags&flagAttested == 0 {
+		return data, nil
+	}
+
+	// aaguid and length of credential id
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, ErrMalformed
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, ErrMalformed
+	}
+	data.credentialId = rest[:idLength]
+
+	// Public key is followed by extensions, if there are any
+	_, extensions, err := decodeCBOR(rest[idLength:])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	data.publicKey = rest[idLength : len(rest)-len(extensions)]
+	return data, nil
+}
@@ -0,0 +1,134 @@
+package webauthn_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hashtock/auth/webauthn"
+	"github.com/hashtock/auth/webauthn/webauthntest"
+)
+
+var rp = &webauthn.RelyingParty{
+	ID:      "example.com",
+	Name:    "Example",
+	Origins: []string{"https://example.com"},
+}
+
+func register(t *testing.T, authenticator *webauthntest.Authenticator) *webauthn.Credential {
+	options := rp.CreationOptions("challenge-1", []byte("user-1"), "bob@example.com", "Bob", nil)
+	response, err := authenticator.Create(options)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+
+	credential, err := rp.VerifyRegistration(response, "challenge-1", true)
+	assert.NoError(t, err)
+	return credential
+}
+
+func TestRegisterAndAssert(t *testing.T) {
+	for _, alg := range webauthn.SupportedAlgorithms {
+		authenticator := webauthntest.New("example.com", "https://example.com")
+		authenticator.Algorithm = alg
+
+		credential := register(t, authenticator)
+		if credential == nil {
+			continue
+		}
+
+		response, err := authenticator.Get(rp.RequestOptions("challenge-2", []string{credential.ID}, "required"))
+		assert.NoError(t, err)
+		signCount, err := rp.VerifyAssertion(response, "challenge-2", credential.PublicKey, true)
+		assert.NoError(t, err, "alg %d", alg)
+		assert.True(t, signCount > credential.SignCount)
+	}
+}
+
+func TestRegistrationChecks(t *testing.T) {
+	authenticator := webauthntest.New("example.com", "https://example.com")
+	options := rp.CreationOptions("challenge-1", []byte("user-1"), "bob@example.com", "Bob", nil)
+	response, _ := authenticator.Create(options)
+
+	_, err := rp.VerifyRegistration(response, "challenge-2", false)
+	assert.Equal(t, webauthn.ErrInvalidClientData, err)
+
+	// Credential made for assertion can't be registered
+	credential := register(t, authenticator)
+	assertion, _ := authenticator.Get(rp.RequestOptions("challenge-1", []string{credential.ID}, "preferred"))
+	_, err = rp.VerifyRegistration(assertion, "challenge-1", false)
+	assert.Equal(t, webauthn.ErrInvalidClientData, err)
+
+	authenticator.Origin = "https://evil.example.com"
+	response, _ = authenticator.Create(options)
+	_, err = rp.VerifyRegistration(response, "challenge-1", false)
+	assert.Equal(t, webauthn.ErrInvalidClientData, err)
+
+	// Evil site may ask its own authenticator for credential of our domain
+	evil := webauthntest.New("evil.com", "https://example.com")
+	options.RP.ID = "evil.com"
+	response, _ = evil.Create(options)
+	_, err = rp.VerifyRegistration(response, "challenge-1", false)
+	assert.Equal(t, webauthn.ErrInvalidRelyingParty, err)
+
+	authenticator = webauthntest.New("example.com", "https://example.com")
+	authenticator.UserVerified = false
+	response, _ = authenticator.Create(rp.CreationOptions("challenge-1", []byte("user-1"), "bob@example.com", "Bob", nil))
+	_, err = rp.VerifyRegistration(response, "challenge-1", true)
+	assert.Equal(t, webauthn.ErrUserNotVerified, err)
+	_, err = rp.VerifyRegistration(response, "challenge-1", false)
+	assert.NoError(t, err)
+
+	// Credential id has to match the one in authenticator data
+	response.ID = base64.RawURLEncoding.EncodeToString([]byte("other"))
+	_, err = rp.VerifyRegistration(response, "challenge-1", false)
+	assert.Equal(t, webauthn.ErrMalformed, err)
+
+	response.Response.AttestationObject = base64.RawURLEncoding.EncodeToString([]byte{0xbf, 0xff})
+	_, err = rp.VerifyRegistration(response, "challenge-1", false)
+	assert.Equal(t, webauthn.ErrMalformed, err)
+}
+
+func TestExcludedCredentials(t *testing.T) {
+	authenticator := webauthntest.New("example.com", "https://example.com")
+	credential := register(t, authenticator)
+
+	options := rp.CreationOptions("challenge-1", []byte("user-1"), "bob@example.com", "Bob", []string{credential.ID})
+	_, err := authenticator.Create(options)
+	assert.Equal(t, webauthntest.ErrCredentialExcluded, err)
+}
+
+func TestAssertionChecks(t *testing.T) {
+	authenticator := webauthntest.New("example.com", "https://example.com")
+	credential := register(t, authenticator)
+	options := rp.RequestOptions("challenge-2", nil, "required")
+
+	response, _ := authenticator.Get(options)
+	_, err := rp.VerifyAssertion(response, "challenge-3", credential.PublicKey, false)
+	assert.Equal(t, webauthn.ErrInvalidClientData, err)
+
+	// Signature covers client data
+	other, _ := authenticator.Get(rp.RequestOptions("challenge-3", nil, "required"))
+	response.Response.Signature = other.Response.Signature
+	_, err = rp.VerifyAssertion(response, "challenge-2", credential.PublicKey, false)
+	assert.Equal(t, webauthn.ErrInvalidSignature, err)
+
+	// Key of other credential doesn't verify it
+	otherCredential := register(t, webauthntest.New("example.com", "https://example.com"))
+	response, _ = authenticator.Get(options)
+	_, err = rp.VerifyAssertion(response, "challenge-2", otherCredential.PublicKey, false)
+	assert.Equal(t, webauthn.ErrInvalidSignature, err)
+
+	authenticator.UserVerified = false
+	response, _ = authenticator.Get(options)
+	_, err = rp.VerifyAssertion(response, "challenge-2", credential.PublicKey, true)
+	assert.Equal(t, webauthn.ErrUserNotVerified, err)
+	_, err = rp.VerifyAssertion(response, "challenge-2", credential.PublicKey, false)
+	assert.NoError(t, err)
+
+	_, err = rp.VerifyAssertion(response, "challenge-2", []byte{0xa0}, false)
+	assert.Equal(t, webauthn.ErrUnsupportedAlgorithm, err)
+	_, err = rp.VerifyAssertion(response, "challenge-2", []byte{0x81}, false)
+	assert.Equal(t, webauthn.ErrMalformed, err)
+}
@@ -0,0 +1,259 @@
+// Package webauthntest provides software authenticator, so WebAuthn
+// ceremonies can be driven in tests without browser
+package webauthntest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/hashtock/auth/webauthn"
+)
+
+var (
+	ErrWrongRelyingParty   = errors.New("Options are for another relying party")
+	ErrAlgorithmNotOffered = errors.New("Authenticator algorithm not offered")
+	ErrCredentialExcluded  = errors.New("Authenticator holds excluded credential")
+	ErrNoCredential        = errors.New("Authenticator holds no allowed credential")
+)
+
+var encoding = base64.RawURLEncoding
+
+type credential struct {
+	id         []byte
+	userHandle string
+	key        crypto.Signer
+}
+
+// Authenticator makes credentials with the key algorithm, and signs with
+// them as if the user confirmed each ceremony
+type Authenticator struct {
+	RPID   string
+	Origin string
+	// Algorithm of new credentials, webauthn.AlgES256 by default
+	Algorithm int
+	// UserVerified reports user was verified, with PIN or biometrics
+	UserVerified bool
+	// SignCount is incremented with each signature, unless it is zero
+	SignCount uint32
+
+	credentials []credential
+}
+
+// New returns authenticator which verifies users and counts signatures
+func New(rpId string, origin string) *Authenticator {
+	return &Authenticator{
+		RPID:         rpId,
+		Origin:       origin,
+		Algorithm:    webauthn.AlgES256,
+		UserVerified: true,
+		SignCount:    1,
+	}
+}
+
+// Create makes new credential, like navigator.credentials.create
+func (a *Authenticator) Create(options webauthn.CreationOptions) (*webauthn.PublicKeyCredential, error) {
+	if options.RP.ID != a.RPID {
+		return nil, ErrWrongRelyingParty
+	}
+	offered := false
+	for _, param := range options.PubKeyCredParams {
+		offered = offered || param.Alg == a.Algorithm
+	}
+	if !offered {
+		return nil, ErrAlgorithmNotOffered
+	}
+	for _, excluded := range options.ExcludeCredentials {
+		if a.find(excluded.ID) != nil {
+			return nil, ErrCredentialExcluded
+		}
+	}
+
+	key, publicKey, err := newKey(a.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	cred := credential{id: make([]byte, 16), userHandle: options.User.ID, key: key}
+	rand.Read(cred.id)
+	a.credentials = append(a.credentials, cred)
+
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(cred.id)))
+	attested = append(append(attested, cred.id...), publicKey...)
+	authData := append(a.authData(0x40), attested...)
+
+	attestation := cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", authData},
+	}
+
+	response := &webauthn.PublicKeyCredential{ID: encoding.EncodeToString(cred.id), Type: "public-key"}
+	response.Response.ClientDataJSON = a.clientData("webauthn.create", options.Challenge)
+	response.Response.AttestationObject = encoding.EncodeToString(encodeCBOR(attestation))
+	return response, nil
+}
+
+// Get signs assertion with one of allowed credentials, or the first one
+// made when none are listed, like navigator.credentials.get
+func (a *Authenticator) Get(options webauthn.RequestOptions) (*webauthn.PublicKeyCredential, error) {
+	if options.RPID != a.RPID {
+		return nil, ErrWrongRelyingParty
+	}
+
+	var cred *credential
+	for _, allowed := range options.AllowCredentials {
+		if cred = a.find(allowed.ID); cred != nil {
+			break
+		}
+	}
+	if len(options.AllowCredentials) == 0 && len(a.credentials) > 0 {
+		cred = &a.credentials[0]
+	}
+	if cred == nil {
+		return nil, ErrNoCredential
+	}
+
+	response := &webauthn.PublicKeyCredential{ID: encoding.EncodeToString(cred.id), Type: "public-key"}
+	response.Response.ClientDataJSON = a.clientData("webauthn.get", options.Challenge)
+	response.Response.UserHandle = cred.userHandle
+
+	authData := a.authData(0)
+	clientData, _ := encoding.DecodeString(response.Response.ClientDataJSON)
+	clientDataHash := sha256.Sum256(clientData)
+	signature, err := sign(cred.key, append(authData, clientDataHash[:]...))
+	if err != nil {
+		return nil, err
+	}
+
+	response.Response.AuthenticatorData = encoding.EncodeToString(authData)
+	response.Response.Signature = encoding.EncodeToString(signature)
+	return response, nil
+}
+
+func (a *Authenticator) find(id string) *credential {
+	for i, cred := range a.credentials {
+		if encoding.EncodeToString(cred.id) == id {
+			return &a.credentials[i]
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge string) string {
+	clientData, _ := json.Marshal(webauthn.ClientData{
+		Type:      ceremony,
+		Challenge: challenge,
+		Origin:    a.Origin,
+	})
+	return encoding.EncodeToString(clientData)
+}
+
+// authData builds authenticator data with user present, and given flags
+func (a *Authenticator) authData(flags byte) []byte {
+	flags |= 0x01
+	if a.UserVerified {
+		flags |= 0x04
+	}
+	if a.SignCount > 0 {
+		a.SignCount++
+	}
+
+	rpIdHash := sha256.Sum256([]byte(a.RPID))
+	data := append(rpIdHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.SignCount)
+	return data
+}
+
+// newKey generates key pair, returning COSE encoded public key
+func newKey(alg int) (crypto.Signer, []byte, error) {
+	switch alg {
+	case webauthn.AlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		point, _ := key.PublicKey.Bytes()
+		return key, encodeCBOR(cborMap{
+			{1, 2}, {3, alg}, {-1, 1}, {-2, point[1:33]}, {-3, point[33:]},
+		}), nil
+	case webauthn.AlgEdDSA:
+		public, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, encodeCBOR(cborMap{
+			{1, 1}, {3, alg}, {-1, 6}, {-2, []byte(public)},
+		}), nil
+	case webauthn.AlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, err
+		}
+		e := big.NewInt(int64(key.E)).Bytes()
+		return key, encodeCBOR(cborMap{
+			{1, 3}, {3, alg}, {-1, key.N.Bytes()}, {-2, e},
+		}), nil
+	}
+	return nil, nil, ErrAlgorithmNotOffered
+}
+
+func sign(key crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// cborMap keeps order of map entries, as authenticators do
+type cborMap []struct {
+	key   interface{}
+	value interface{}
+}
+
+// encodeCBOR encodes the subset of CBOR authenticators use
+func encodeCBOR(value interface{}) []byte {
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case cborMap:
+		data := cborHead(5, uint64(len(v)))
+		for _, entry := range v {
+			data = append(data, encodeCBOR(entry.key)...)
+			data = append(data, encodeCBOR(entry.value)...)
+		}
+		return data
+	}
+	panic("webauthntest: can't encode value of unsupported type")
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return []byte{major<<5 | 25, byte(arg >> 8), byte(arg)}
+	}
+	data := []byte{major<<5 | 26, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(data[1:], uint32(arg))
+	return data
+}